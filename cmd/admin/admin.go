@@ -230,6 +230,7 @@ func Cmd() *cobra.Command {
 	)
 	ctls.AddCommand(tlsCreate)
 	admin.AddCommand(ctls)
+	admin.AddCommand(ontapSetupCmd())
 	return admin
 }
 
@@ -7,6 +7,7 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"log"
 	"math/big"
@@ -65,33 +66,63 @@ func GenerateAdminCerts(opts *tlsOptions, flavor string) {
 		}
 	}
 
-	// Create self-signed certificate.
-	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey)
+	if err := writeSelfSigned(&template, privateKey, certPath, keyPath); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("wrote %s\n", certPath)
+	log.Printf("wrote %s\n", keyPath)
+}
+
+// GenerateClientCert creates a self-signed client certificate whose common name is the ONTAP user it authenticates
+func GenerateClientCert(user string, days int, certPath string, keyPath string) error {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate private key: %w", err)
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
 	if err != nil {
-		log.Fatalf("Failed to create certificate: %v", err)
+		return fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName:   user,
+			Organization: []string{"Harvest"},
+		},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Duration(days*24) * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		// ONTAP installs client certificates as client-ca, which must be a CA
+		IsCA: true,
 	}
+	return writeSelfSigned(&template, privateKey, certPath, keyPath)
+}
 
+// writeSelfSigned signs template with privateKey and writes the PEM encoded certificate and key
+func writeSelfSigned(template *x509.Certificate, privateKey *ecdsa.PrivateKey, certPath string, keyPath string) error {
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		return fmt.Errorf("failed to create certificate: %w", err)
+	}
 	pemCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
 	if pemCert == nil {
-		log.Fatal("Failed to encode certificate to PEM")
+		return errors.New("failed to encode certificate to PEM")
 	}
 	if err := os.WriteFile(certPath, pemCert, 0600); err != nil {
-		log.Fatal(err)
+		return err
 	}
-	log.Printf("wrote %s\n", certPath)
 
 	privBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
 	if err != nil {
-		log.Fatalf("Unable to marshal private key: %v", err)
+		return fmt.Errorf("unable to marshal private key: %w", err)
 	}
 	pemKey := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privBytes})
 	if pemKey == nil {
-		log.Fatal("Failed to encode key to PEM")
+		return errors.New("failed to encode key to PEM")
 	}
-	if err := os.WriteFile(keyPath, pemKey, 0600); err != nil {
-		log.Fatal(err)
-	}
-	log.Printf("wrote %s\n", keyPath)
+	return os.WriteFile(keyPath, pemKey, 0600)
 }
 
 func (a *Admin) verifyAuth(user string, pass string) bool {
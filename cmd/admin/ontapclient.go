@@ -0,0 +1,291 @@
+package admin
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/netapp/harvest/v2/pkg/auth"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ontapClient calls the ONTAP REST API as the admin user. When dryRun is true, only GET requests are sent
+type ontapClient struct {
+	baseURL  string
+	username string
+	password string
+	client   *http.Client
+	dryRun   bool
+}
+
+// ontapError is the error body of a failed ONTAP REST request
+type ontapError struct {
+	StatusCode int
+	Message    string `json:"message"`
+	Code       string `json:"code"`
+}
+
+func (e ontapError) Error() string {
+	return fmt.Sprintf("statusCode=%d code=%s message=%s", e.StatusCode, e.Code, e.Message)
+}
+
+type role struct {
+	Name  string `json:"name"`
+	Owner struct {
+		Name string `json:"name"`
+		UUID string `json:"uuid"`
+	} `json:"owner"`
+	Privileges []privilege `json:"privileges"`
+}
+
+type privilege struct {
+	Path   string `json:"path"`
+	Access string `json:"access"`
+}
+
+// login is a security login of a user, as returned by the private CLI
+type login struct {
+	User                 string `json:"user_or_group_name"`
+	Application          string `json:"application"`
+	AuthenticationMethod string `json:"authentication_method"`
+	Role                 string `json:"role"`
+}
+
+type records[T any] struct {
+	Records    []T `json:"records"`
+	NumRecords int `json:"num_records"`
+}
+
+// newOntapClient returns a client that connects to the poller's cluster like the poller does, honoring its
+// ca_cert, use_insecure_tls, tls_min_version, and recorder, but authenticates as username
+func newOntapClient(poller *conf.Poller, username string, password string) (*ontapClient, error) {
+	admin := *poller
+	admin.AuthStyle = conf.BasicAuth
+	admin.Username = username
+	admin.Password = password
+	transport, err := auth.NewCredentials(&admin, slog.Default()).Transport(nil, &admin)
+	if err != nil {
+		return nil, err
+	}
+	return &ontapClient{
+		baseURL:  "https://" + poller.Addr,
+		username: username,
+		password: password,
+		client:   &http.Client{Transport: transport, Timeout: time.Minute},
+	}, nil
+}
+
+func (c *ontapClient) do(method string, apiPath string, query url.Values, body any, out any) error {
+	if method != http.MethodGet && c.dryRun {
+		return nil
+	}
+	u := c.baseURL + "/" + strings.TrimPrefix(apiPath, "/")
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, u, reader)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.username, c.password)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		var e struct {
+			Error ontapError `json:"error"`
+		}
+		_ = json.Unmarshal(data, &e)
+		e.Error.StatusCode = resp.StatusCode
+		return fmt.Errorf("%s %s failed: %w", method, apiPath, e.Error)
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+func (c *ontapClient) version() (string, error) {
+	var cluster struct {
+		Version struct {
+			Generation int `json:"generation"`
+			Major      int `json:"major"`
+			Minor      int `json:"minor"`
+		} `json:"version"`
+	}
+	if err := c.do(http.MethodGet, "api/cluster", url.Values{"fields": {"version"}}, nil, &cluster); err != nil {
+		return "", err
+	}
+	v := cluster.Version
+	return fmt.Sprintf("%d.%d.%d", v.Generation, v.Major, v.Minor), nil
+}
+
+func (c *ontapClient) adminSVM() (string, error) {
+	var svms records[struct {
+		Vserver string `json:"vserver"`
+	}]
+	err := c.do(http.MethodGet, "api/private/cli/vserver", url.Values{"type": {"admin"}, "fields": {"vserver"}}, nil, &svms)
+	if err != nil {
+		return "", err
+	}
+	if len(svms.Records) == 0 {
+		return "", errors.New("admin SVM not found")
+	}
+	return svms.Records[0].Vserver, nil
+}
+
+// role returns the role owned by svm, or nil when it does not exist
+func (c *ontapClient) role(name string, svm string) (*role, error) {
+	var roles records[role]
+	query := url.Values{"name": {name}, "owner.name": {svm}, "fields": {"privileges,owner"}}
+	if err := c.do(http.MethodGet, "api/security/roles", query, nil, &roles); err != nil {
+		return nil, err
+	}
+	if len(roles.Records) == 0 {
+		return nil, nil
+	}
+	return &roles.Records[0], nil
+}
+
+func (c *ontapClient) createRole(name string, paths []string) error {
+	r := role{Name: name}
+	for _, p := range paths {
+		r.Privileges = append(r.Privileges, privilege{Path: p, Access: "readonly"})
+	}
+	body := map[string]any{"name": r.Name, "privileges": r.Privileges}
+	return c.do(http.MethodPost, "api/security/roles", nil, body, nil)
+}
+
+func (c *ontapClient) addPrivilege(r *role, p string) error {
+	apiPath := "api/security/roles/" + url.PathEscape(r.Owner.UUID) + "/" + url.PathEscape(r.Name) + "/privileges"
+	return c.do(http.MethodPost, apiPath, nil, privilege{Path: p, Access: "readonly"}, nil)
+}
+
+func (c *ontapClient) hasWebAccess(role string, service string) (bool, error) {
+	var access records[json.RawMessage]
+	query := url.Values{"role": {role}, "name": {service}}
+	if err := c.do(http.MethodGet, "api/private/cli/vserver/services/web/access", query, nil, &access); err != nil {
+		return false, err
+	}
+	return access.NumRecords > 0, nil
+}
+
+func (c *ontapClient) createWebAccess(svm string, role string, service string) error {
+	body := map[string]string{"vserver": svm, "name": service, "role": role}
+	return c.do(http.MethodPost, "api/private/cli/vserver/services/web/access", nil, body, nil)
+}
+
+// hasClientCA returns true when pemCert is installed as a client-ca certificate
+func (c *ontapClient) hasClientCA(commonName string, pemCert string) (bool, error) {
+	var certs records[struct {
+		PublicCertificate string `json:"public_certificate"`
+	}]
+	query := url.Values{"type": {"client_ca"}, "common_name": {commonName}, "fields": {"public_certificate"}}
+	if err := c.do(http.MethodGet, "api/security/certificates", query, nil, &certs); err != nil {
+		return false, err
+	}
+	for _, cert := range certs.Records {
+		if strings.TrimSpace(cert.PublicCertificate) == strings.TrimSpace(pemCert) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (c *ontapClient) installClientCA(pemCert string) error {
+	body := map[string]string{"type": "client_ca", "public_certificate": pemCert}
+	return c.do(http.MethodPost, "api/security/certificates", nil, body, nil)
+}
+
+func (c *ontapClient) logins(svm string, user string) ([]login, error) {
+	var logins records[login]
+	query := url.Values{"vserver": {svm}, "user_or_group_name": {user}, "fields": {"application,authentication_method,role"}}
+	if err := c.do(http.MethodGet, "api/private/cli/security/login", query, nil, &logins); err != nil {
+		return nil, err
+	}
+	return logins.Records, nil
+}
+
+// createAccount creates a user with a single login. Additional logins are added with createLogin
+func (c *ontapClient) createAccount(l login, password string) error {
+	method := "password"
+	if l.AuthenticationMethod == "cert" {
+		method = "certificate"
+	}
+	body := map[string]any{
+		"name": l.User,
+		"applications": []map[string]any{
+			{"application": l.Application, "authentication_methods": []string{method}},
+		},
+		"role": map[string]string{"name": l.Role},
+	}
+	if password != "" {
+		body["password"] = password
+	}
+	return c.do(http.MethodPost, "api/security/accounts", nil, body, nil)
+}
+
+func (c *ontapClient) createLogin(l login) error {
+	err := c.do(http.MethodPost, "api/private/cli/security/login", nil, l, nil)
+	var oe ontapError
+	if errors.As(err, &oe) && oe.StatusCode == http.StatusConflict {
+		// duplicate entry, the login exists
+		return nil
+	}
+	return err
+}
+
+// randomPassword returns a password with upper and lower case letters, digits, and punctuation, as ONTAP's
+// default password rules require letters and digits
+func randomPassword() (string, error) {
+	const (
+		length  = 24
+		letters = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ"
+		digits  = "23456789"
+		symbols = "-_.+"
+	)
+	alphabet := letters + digits + symbols
+	b := make([]byte, length)
+	for i := range b {
+		set := alphabet
+		// guarantee at least one digit and one letter
+		switch i {
+		case 0:
+			set = letters
+		case 1:
+			set = digits
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(set))))
+		if err != nil {
+			return "", err
+		}
+		b[i] = set[n.Int64()]
+	}
+	return string(b), nil
+}
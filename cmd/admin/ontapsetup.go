@@ -0,0 +1,504 @@
+package admin
+
+import (
+	"cmp"
+	"fmt"
+	"github.com/netapp/harvest/v2/cmd/collectors"
+	"github.com/netapp/harvest/v2/cmd/poller/collector"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/tree/yamlnode"
+	"github.com/netapp/harvest/v2/third_party/go-version"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+	"gopkg.in/yaml.v3"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	passwordAuth    = "password"
+	certificateAuth = "certificate"
+	// adminPasswordEnv is read instead of prompting for the admin password
+	adminPasswordEnv = "HARVEST_ADMIN_PASSWORD"
+)
+
+type ontapSetupOptions struct {
+	user      string
+	role      string
+	restRole  string
+	auth      string
+	password  string
+	adminUser string
+	output    string
+	days      int
+	dryRun    bool
+}
+
+var setupOpts = &ontapSetupOptions{}
+
+// zapiCommands are the command directories of the least-privilege ZAPI role in docs/prepare-cdot-clusters.md.
+// ZAPIs do not map one-to-one to command directories, so ZAPI pollers get this fixed list. It covers the directories of
+// the ZAPIs collectors and their plugins call, see collectors.PluginEndpoints
+var zapiCommands = []string{
+	"cluster", "event notification destination show", "event notification destination", "event log",
+	"event catalog show", "lun", "metrocluster configuration-settings mediator add", "metrocluster",
+	"network connections active show", "network fcp adapter show", "network interface", "network port show",
+	"network port ifgrp show", "network route show", "qos adaptive-policy-group", "qos policy-group",
+	"qos workload show", "security", "snapmirror", "statistics", "storage aggregate", "storage disk",
+	"storage encryption disk", "storage failover show", "storage iscsi-initiator show", "storage shelf",
+	"system chassis fru show", "system controller fru show", "system health alert show",
+	"system health status show", "system health subsystem show", "system license show", "system node",
+	"system node environment sensors show", "system service-processor show", "version", "volume", "vserver",
+}
+
+func ontapSetupCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ontap-setup POLLER",
+		Short: "Create the ONTAP role and user a poller needs",
+		Long: `Create the ONTAP role and user a poller needs, using a one-time admin credential.
+The REST role is read-only and covers the endpoints of the poller's enabled templates.
+ZAPI pollers get the read-only command directories of the least-privilege ZAPI role.
+The user authenticates with a password or a client certificate.
+The poller stanza with the new credentials is printed, or written to --output.
+Running the command again only creates what is missing.
+The admin password is read from ` + adminPasswordEnv + ` or prompted for.`,
+		Args: cobra.ExactArgs(1),
+		Run:  doOntapSetup,
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&setupOpts.user, "user", "", "ONTAP user Harvest logs in as, defaults to the poller's username or harvest2")
+	flags.StringVar(&setupOpts.role, "role", "harvest2-role", "Name of the ZAPI role")
+	flags.StringVar(&setupOpts.restRole, "rest-role", "harvest2-rest-role", "Name of the REST role")
+	flags.StringVar(&setupOpts.auth, "auth", "", "password or certificate, defaults to certificate when the poller uses certificate_auth")
+	flags.StringVar(&setupOpts.password, "password", "", "Password of a new user, a random password is generated when empty")
+	flags.StringVar(&setupOpts.adminUser, "admin-user", "admin", "ONTAP admin user that creates the role and user")
+	flags.StringVarP(&setupOpts.output, "output", "o", "", "Write the poller stanza to this file instead of stdout")
+	flags.IntVarP(&setupOpts.days, "days", "d", 365, "Number of days the client certificate is valid")
+	flags.BoolVar(&setupOpts.dryRun, "dry-run", false, "Print what would be created without changing the cluster")
+	return cmd
+}
+
+func doOntapSetup(cmd *cobra.Command, args []string) {
+	configPath := conf.ConfigPath(cmd.Root().PersistentFlags().Lookup("config").Value.String())
+	confPath := cmd.Root().PersistentFlags().Lookup("confpath").Value.String()
+
+	if _, err := conf.LoadHarvestConfig(configPath); err != nil {
+		fmt.Printf("error reading config %s err=%+v\n", configPath, err)
+		os.Exit(1)
+	}
+	pollerName := args[0]
+	poller, err := conf.PollerNamed(pollerName)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+
+	adminPassword, err := readAdminPassword(setupOpts.adminUser, poller.Addr)
+	if err != nil {
+		fmt.Printf("failed to read admin password err=%v\n", err)
+		os.Exit(1)
+	}
+
+	client, err := newOntapClient(poller, setupOpts.adminUser, adminPassword)
+	if err != nil {
+		fmt.Printf("failed to create ONTAP client err=%v\n", err)
+		os.Exit(1)
+	}
+	s := newOntapSetup(poller, setupOpts, client, os.Stderr)
+	s.confPath = confPath
+	if err := s.run(); err != nil {
+		fmt.Printf("ontap-setup failed err=%v\n", err)
+		os.Exit(1)
+	}
+
+	stanza, err := s.stanza(configPath)
+	if err != nil {
+		fmt.Printf("failed to create poller stanza err=%v\n", err)
+		os.Exit(1)
+	}
+	if setupOpts.output == "" || setupOpts.dryRun {
+		fmt.Print(stanza)
+		return
+	}
+	if err := os.WriteFile(setupOpts.output, []byte(stanza), 0600); err != nil {
+		fmt.Printf("failed to write %s err=%v\n", setupOpts.output, err)
+		os.Exit(1)
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "wrote poller stanza to %s\n", setupOpts.output)
+}
+
+func readAdminPassword(user string, addr string) (string, error) {
+	if password := os.Getenv(adminPasswordEnv); password != "" {
+		return password, nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("set %s or run from a terminal", adminPasswordEnv)
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "Password of %s@%s: ", user, addr)
+	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	_, _ = fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return string(password), nil
+}
+
+// ontapSetup creates the roles, user, and certificate of a poller. Each step checks what exists first,
+// so running it again only creates what is missing
+type ontapSetup struct {
+	poller     *conf.Poller
+	opts       *ontapSetupOptions
+	client     *ontapClient
+	out        io.Writer // progress is written here
+	confPath   string
+	user       string
+	auth       string
+	password   string // the password of a new user, empty when the user exists
+	certPath   string
+	keyPath    string
+	adminSVM   string
+	version    string
+	userExists bool
+}
+
+func newOntapSetup(poller *conf.Poller, opts *ontapSetupOptions, client *ontapClient, out io.Writer) *ontapSetup {
+	s := &ontapSetup{
+		poller:   poller,
+		opts:     opts,
+		client:   client,
+		out:      out,
+		confPath: conf.DefaultConfPath,
+		user:     cmp.Or(opts.user, poller.Username, "harvest2"),
+		auth:     opts.auth,
+	}
+	client.dryRun = opts.dryRun
+	if s.auth == "" {
+		s.auth = passwordAuth
+		if poller.AuthStyle == conf.CertificateAuth {
+			s.auth = certificateAuth
+		}
+	}
+	certName := strings.ReplaceAll(poller.Name, string(filepath.Separator), "_")
+	s.certPath = cmp.Or(poller.SslCert, path.Join("cert", certName+".pem"))
+	s.keyPath = cmp.Or(poller.SslKey, path.Join("cert", certName+".key"))
+	return s
+}
+
+func (s *ontapSetup) run() error {
+	if s.auth != passwordAuth && s.auth != certificateAuth {
+		return fmt.Errorf("invalid auth=%s, use password or certificate", s.auth)
+	}
+	var err error
+	if s.version, err = s.client.version(); err != nil {
+		return fmt.Errorf("failed to connect to %s err=%w", s.poller.Addr, err)
+	}
+	if s.adminSVM, err = s.client.adminSVM(); err != nil {
+		return err
+	}
+	s.logf("connected to %s, ONTAP %s, admin SVM %s", s.poller.Addr, s.version, s.adminSVM)
+
+	restPaths, zapiPaths, err := s.privileges()
+	if err != nil {
+		return err
+	}
+
+	// the first application's role is the role of the account, the second application is added as a login
+	var logins []login
+	if len(restPaths) > 0 {
+		if err := s.ensureRole(s.opts.restRole, restPaths); err != nil {
+			return err
+		}
+		if err := s.ensureWebAccess(s.opts.restRole, "rest", "docs-api"); err != nil {
+			return err
+		}
+		logins = append(logins, login{Application: "http", Role: s.opts.restRole})
+	}
+	if len(zapiPaths) > 0 {
+		if err := s.ensureRole(s.opts.role, zapiPaths); err != nil {
+			return err
+		}
+		if err := s.ensureWebAccess(s.opts.role, "ontapi"); err != nil {
+			return err
+		}
+		logins = append(logins, login{Application: "ontapi", Role: s.opts.role})
+	}
+
+	if s.auth == certificateAuth {
+		if err := s.ensureCertificate(); err != nil {
+			return err
+		}
+	}
+	return s.ensureUser(logins)
+}
+
+// privileges returns the REST endpoints and ZAPI command directories of the poller's collectors
+func (s *ontapSetup) privileges() ([]string, []string, error) {
+	var (
+		objects []collector.ObjectTemplate
+		hasRest bool
+		hasZapi bool
+	)
+	confPaths := filepath.SplitList(cmp.Or(s.poller.ConfPath, s.confPath))
+	for _, col := range s.poller.Collectors {
+		switch {
+		case col.Name == "Zapi" || col.Name == "ZapiPerf":
+			hasZapi = true
+			continue
+		case !collectors.IsRestCollector(col.Name):
+			continue
+		}
+		hasRest = true
+		all, err := collector.ObjectTemplates(col, confPaths, s.confPath, s.version)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load %s templates err=%w", col.Name, err)
+		}
+		objects = append(objects, all...)
+	}
+
+	var restPaths []string
+	if hasRest {
+		restPaths = restEndpoints(objects)
+		// least-privilege REST roles need ONTAP 9.14 or later, see docs/prepare-cdot-clusters.md
+		if v, err := version.NewVersion(s.version); err == nil && v.LessThan(version.Must(version.NewVersion("9.14.0"))) {
+			s.logf("ONTAP %s does not support least-privilege REST roles, %s has read-only access to /api", s.version, s.opts.restRole)
+			restPaths = []string{"/api"}
+		}
+	}
+	var zapiPaths []string
+	if hasZapi {
+		zapiPaths = zapiCommands
+	}
+	return restPaths, zapiPaths, nil
+}
+
+// restEndpoints returns the sorted role paths that cover the endpoints of the templates, their plugins, and their
+// collectors. Paths covered by a shorter path are dropped
+func restEndpoints(objects []collector.ObjectTemplate) []string {
+	set := make(map[string]bool)
+	for _, e := range collectors.Endpoints(objects) {
+		if collectors.IsRestCollector(e.Collector) {
+			set[collectors.RolePath(e.Query)] = true
+		}
+	}
+	delete(set, "")
+
+	paths := slices.Sorted(maps.Keys(set))
+	covered := func(p string) bool {
+		for parent := path.Dir(p); parent != "/" && parent != "."; parent = path.Dir(parent) {
+			if set[parent] {
+				return true
+			}
+		}
+		return false
+	}
+	return slices.DeleteFunc(paths, covered)
+}
+
+func (s *ontapSetup) ensureRole(name string, paths []string) error {
+	role, err := s.client.role(name, s.adminSVM)
+	if err != nil {
+		return err
+	}
+	if role == nil {
+		s.logf("create role %s with %d read-only privileges", name, len(paths))
+		return s.client.createRole(name, paths)
+	}
+	existing := make(map[string]string, len(role.Privileges))
+	for _, p := range role.Privileges {
+		existing[p.Path] = p.Access
+	}
+	missing := 0
+	for _, p := range paths {
+		access, ok := existing[p]
+		switch {
+		case !ok:
+			missing++
+			s.logf("add read-only privilege %s to role %s", p, name)
+			if err := s.client.addPrivilege(role, p); err != nil {
+				return err
+			}
+		case access != "readonly":
+			s.logf("role %s has %s access to %s, expected readonly, leaving it unchanged", name, access, p)
+		}
+	}
+	if missing == 0 {
+		s.logf("role %s has all %d privileges", name, len(paths))
+	}
+	return nil
+}
+
+func (s *ontapSetup) ensureWebAccess(role string, services ...string) error {
+	for _, service := range services {
+		ok, err := s.client.hasWebAccess(role, service)
+		if err != nil {
+			return err
+		}
+		if ok {
+			continue
+		}
+		s.logf("allow role %s to use web service %s", role, service)
+		if err := s.client.createWebAccess(s.adminSVM, role, service); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureCertificate creates the user's client certificate, unless it exists, and installs it on the cluster
+func (s *ontapSetup) ensureCertificate() error {
+	_, certErr := os.Stat(s.certPath)
+	_, keyErr := os.Stat(s.keyPath)
+	switch {
+	case certErr == nil && keyErr == nil:
+		s.logf("using existing client certificate %s", s.certPath)
+	case s.opts.dryRun:
+		s.logf("create client certificate %s and key %s with common name %s", s.certPath, s.keyPath, s.user)
+		return nil
+	default:
+		s.logf("create client certificate %s and key %s with common name %s", s.certPath, s.keyPath, s.user)
+		if err := os.MkdirAll(filepath.Dir(s.certPath), 0750); err != nil {
+			return err
+		}
+		if err := GenerateClientCert(s.user, s.opts.days, s.certPath, s.keyPath); err != nil {
+			return err
+		}
+	}
+
+	pemCert, err := os.ReadFile(s.certPath)
+	if err != nil {
+		return err
+	}
+	installed, err := s.client.hasClientCA(s.user, string(pemCert))
+	if err != nil {
+		return err
+	}
+	if installed {
+		s.logf("client certificate %s is installed", s.certPath)
+		return nil
+	}
+	s.logf("install client certificate %s as client-ca", s.certPath)
+	return s.client.installClientCA(string(pemCert))
+}
+
+// ensureUser creates the user with the first login, and adds the other logins
+func (s *ontapSetup) ensureUser(logins []login) error {
+	method := "password"
+	if s.auth == certificateAuth {
+		method = "cert"
+	}
+	for i := range logins {
+		logins[i].User = s.user
+		logins[i].AuthenticationMethod = method
+	}
+
+	existing, err := s.client.logins(s.adminSVM, s.user)
+	if err != nil {
+		return err
+	}
+	s.userExists = len(existing) > 0
+	if !s.userExists && len(logins) > 0 {
+		if s.auth == passwordAuth {
+			s.password = s.opts.password
+			if s.password == "" && !s.opts.dryRun {
+				if s.password, err = randomPassword(); err != nil {
+					return err
+				}
+			}
+		}
+		s.logf("create user %s with %s authentication and role %s", s.user, s.auth, logins[0].Role)
+		if err := s.client.createAccount(logins[0], s.password); err != nil {
+			return err
+		}
+		existing = append(existing, logins[0])
+	} else if s.userExists && s.auth == passwordAuth {
+		s.logf("user %s exists, its password is unchanged", s.user)
+	}
+
+	for _, l := range logins {
+		i := slices.IndexFunc(existing, func(e login) bool {
+			return e.Application == l.Application && e.AuthenticationMethod == l.AuthenticationMethod
+		})
+		if i >= 0 {
+			if existing[i].Role != l.Role {
+				s.logf("user %s uses role %s for %s, expected %s, leaving it unchanged",
+					s.user, existing[i].Role, l.Application, l.Role)
+			}
+			continue
+		}
+		s.logf("allow user %s to log in to %s with %s and role %s", s.user, l.Application, s.auth, l.Role)
+		if err := s.client.createLogin(l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stanza returns the poller's harvest.yml stanza with the new credentials
+func (s *ontapSetup) stanza(configPath string) (string, error) {
+	pollerNode := &yaml.Node{Kind: yaml.MappingNode}
+	contents, err := os.ReadFile(configPath)
+	if err != nil {
+		return "", err
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(contents, &root); err != nil {
+		return "", err
+	}
+	if len(root.Content) > 0 {
+		if n := yamlnode.Value(yamlnode.Value(root.Content[0], "Pollers"), s.poller.Name); n != nil && n.Kind == yaml.MappingNode {
+			pollerNode = n
+		}
+	}
+	if yamlnode.Value(pollerNode, "addr") == nil {
+		yamlnode.Set(pollerNode, "addr", s.poller.Addr)
+	}
+	for _, key := range []string{"username", "password", "auth_style", "ssl_cert", "ssl_key", "credentials_file",
+		"credentials_script", "credentials_store"} {
+		yamlnode.Delete(pollerNode, key)
+	}
+
+	yamlnode.Set(pollerNode, "username", s.user)
+	switch {
+	case s.auth == certificateAuth:
+		yamlnode.Set(pollerNode, "auth_style", conf.CertificateAuth)
+		yamlnode.Set(pollerNode, "ssl_cert", s.certPath)
+		yamlnode.Set(pollerNode, "ssl_key", s.keyPath)
+	case s.password != "":
+		yamlnode.Set(pollerNode, "password", s.password)
+	case s.poller.Password != "" && s.user == s.poller.Username:
+		yamlnode.Set(pollerNode, "password", s.poller.Password)
+	case s.opts.dryRun && !s.userExists:
+		yamlnode.Set(pollerNode, "password", "GENERATED_PASSWORD")
+	default:
+		s.logf("add the password of %s to the poller stanza", s.user)
+	}
+
+	pollers := &yaml.Node{Kind: yaml.MappingNode}
+	pollers.Content = append(pollers.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: s.poller.Name}, pollerNode)
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	doc.Content = append(doc.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "Pollers"}, pollers)
+
+	var b strings.Builder
+	encoder := yaml.NewEncoder(&b)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return "", err
+	}
+	if err := encoder.Close(); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func (s *ontapSetup) logf(format string, args ...any) {
+	prefix := ""
+	if s.opts.dryRun {
+		prefix = "[dry-run] "
+	}
+	_, _ = fmt.Fprintf(s.out, prefix+format+"\n", args...)
+}
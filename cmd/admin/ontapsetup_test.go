@@ -0,0 +1,317 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/netapp/harvest/v2/cmd/collectors"
+	"github.com/netapp/harvest/v2/cmd/poller/collector"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRestEndpoints(t *testing.T) {
+	var objects []collector.ObjectTemplate
+	for _, name := range []string{"Rest", "RestPerf"} {
+		all, err := collector.ObjectTemplates(conf.NewCollector(name), []string{"../../conf"}, "../../conf", "9.15.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		objects = append(objects, all...)
+	}
+	paths := restEndpoints(objects)
+
+	for _, want := range []string{"/api/cluster", "/api/storage/volumes", "/api/storage/aggregates", "/api/private/cli/aggr"} {
+		if !slices.Contains(paths, want) {
+			t.Errorf("missing %s", want)
+		}
+	}
+	for _, p := range paths {
+		if strings.HasPrefix(p, "/api/cluster/counter/tables/") {
+			t.Errorf("counter table %s should be covered by /api/cluster/counter/tables", p)
+		}
+		if p == "/api/cluster/nodes" {
+			t.Errorf("/api/cluster/nodes is covered by /api/cluster")
+		}
+	}
+	if !slices.IsSorted(paths) {
+		t.Errorf("paths are not sorted")
+	}
+}
+
+// fakeOntap implements the ONTAP REST endpoints ontap-setup calls
+type fakeOntap struct {
+	mu       sync.Mutex
+	roles    map[string][]privilege
+	logins   []login
+	access   map[string]bool // role + service
+	certs    []string
+	accounts map[string]string // user to password
+	posts    []string
+}
+
+func newFakeOntap() *fakeOntap {
+	return &fakeOntap{
+		roles:    map[string][]privilege{},
+		access:   map[string]bool{},
+		accounts: map[string]string{},
+	}
+}
+
+func (f *fakeOntap) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	q := r.URL.Query()
+	var body map[string]any
+	if r.Method == http.MethodPost {
+		f.posts = append(f.posts, r.URL.Path)
+		_ = json.NewDecoder(r.Body).Decode(&body)
+	}
+	str := func(key string) string {
+		s, _ := body[key].(string)
+		return s
+	}
+	write := func(records any, num int) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"records": records, "num_records": num})
+	}
+
+	switch {
+	case r.URL.Path == "/api/cluster":
+		_, _ = w.Write([]byte(`{"version": {"generation": 9, "major": 15, "minor": 1}}`))
+	case r.URL.Path == "/api/private/cli/vserver":
+		write([]map[string]string{{"vserver": "cluster1"}}, 1)
+	case r.URL.Path == "/api/security/roles" && r.Method == http.MethodGet:
+		privileges, ok := f.roles[q.Get("name")]
+		if !ok {
+			write([]role{}, 0)
+			return
+		}
+		ro := role{Name: q.Get("name"), Privileges: privileges}
+		ro.Owner.UUID = "svm-uuid"
+		ro.Owner.Name = "cluster1"
+		write([]role{ro}, 1)
+	case r.URL.Path == "/api/security/roles":
+		var privileges []privilege
+		for _, p := range body["privileges"].([]any) {
+			m := p.(map[string]any)
+			privileges = append(privileges, privilege{Path: m["path"].(string), Access: m["access"].(string)})
+		}
+		f.roles[str("name")] = privileges
+	case strings.HasPrefix(r.URL.Path, "/api/security/roles/svm-uuid/"):
+		name := strings.Split(r.URL.Path, "/")[5]
+		f.roles[name] = append(f.roles[name], privilege{Path: str("path"), Access: str("access")})
+	case r.URL.Path == "/api/private/cli/vserver/services/web/access" && r.Method == http.MethodGet:
+		if f.access[q.Get("role")+q.Get("name")] {
+			write([]map[string]string{{"vserver": "cluster1"}}, 1)
+			return
+		}
+		write([]any{}, 0)
+	case r.URL.Path == "/api/private/cli/vserver/services/web/access":
+		f.access[str("role")+str("name")] = true
+	case r.URL.Path == "/api/private/cli/security/login" && r.Method == http.MethodGet:
+		var logins []login
+		for _, l := range f.logins {
+			if l.User == q.Get("user_or_group_name") {
+				logins = append(logins, l)
+			}
+		}
+		write(logins, len(logins))
+	case r.URL.Path == "/api/private/cli/security/login":
+		f.logins = append(f.logins, login{User: str("user_or_group_name"), Application: str("application"),
+			AuthenticationMethod: str("authentication_method"), Role: str("role")})
+	case r.URL.Path == "/api/security/accounts":
+		app := body["applications"].([]any)[0].(map[string]any)
+		method := "password"
+		if app["authentication_methods"].([]any)[0] == "certificate" {
+			method = "cert"
+		}
+		f.accounts[str("name")] = str("password")
+		f.logins = append(f.logins, login{User: str("name"), Application: app["application"].(string),
+			AuthenticationMethod: method, Role: body["role"].(map[string]any)["name"].(string)})
+	case r.URL.Path == "/api/security/certificates" && r.Method == http.MethodGet:
+		var certs []map[string]string
+		for _, c := range f.certs {
+			certs = append(certs, map[string]string{"public_certificate": c})
+		}
+		write(certs, len(certs))
+	case r.URL.Path == "/api/security/certificates":
+		f.certs = append(f.certs, str("public_certificate"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error": {"message": "not found", "code": "4"}}`))
+	}
+}
+
+// TestOntapClientCaCert checks that the admin client verifies the cluster with the poller's ca_cert
+func TestOntapClientCaCert(t *testing.T) {
+	server := httptest.NewTLSServer(newFakeOntap())
+	defer server.Close()
+	caCert := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caCert, cert, 0600); err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(server.URL)
+
+	tests := []struct {
+		name    string
+		caCert  string
+		wantErr bool
+	}{
+		{name: "ca_cert", caCert: caCert},
+		{name: "untrusted", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := newOntapClient(&conf.Poller{Name: "sar", Addr: u.Host, CaCertPath: tt.caCert}, "admin", "admin-password")
+			if err != nil {
+				t.Fatal(err)
+			}
+			version, err := client.version()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("version() err=%v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && version != "9.15.1" {
+				t.Errorf("version() got %s, want 9.15.1", version)
+			}
+		})
+	}
+}
+
+// TestZapiCommands checks that the fixed ZAPI role covers the ZAPIs the Zapi and ZapiPerf collectors and their plugins call
+func TestZapiCommands(t *testing.T) {
+	for key, queries := range collectors.PluginEndpoints {
+		class, _, _ := strings.Cut(key, ":")
+		if class != "Zapi" && class != "ZapiPerf" {
+			continue
+		}
+		for _, q := range append(queries, collectors.CollectorEndpoints[class]...) {
+			dir := collectors.ZapiDirectory(collectors.Endpoint{Collector: class, Query: q})
+			if !slices.Contains(zapiCommands, dir) {
+				t.Errorf("%s %s needs %q, which is not in zapiCommands", key, q, dir)
+			}
+		}
+	}
+}
+
+func TestOntapSetup(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "harvest.yml")
+	err := os.WriteFile(configPath, []byte(`
+Pollers:
+  sar:
+    addr: localhost
+    datacenter: dc1
+    username: old
+    password: old-password
+    collectors:
+      - Rest
+      - ZapiPerf
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		auth string
+	}{
+		{name: "password", auth: passwordAuth},
+		{name: "certificate", auth: certificateAuth},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeOntap()
+			server := httptest.NewTLSServer(fake)
+			defer server.Close()
+			u, _ := url.Parse(server.URL)
+			insecure := true
+			poller := &conf.Poller{
+				Name:           "sar",
+				Addr:           u.Host,
+				UseInsecureTLS: &insecure,
+				Collectors:     []conf.Collector{conf.NewCollector("Rest"), conf.NewCollector("ZapiPerf")},
+				SslCert:        filepath.Join(dir, tt.name+".pem"),
+				SslKey:         filepath.Join(dir, tt.name+".key"),
+			}
+			opts := &ontapSetupOptions{user: "harvest2", role: "harvest2-role", restRole: "harvest2-rest-role",
+				auth: tt.auth, days: 1}
+
+			setup := func(dryRun bool) (*ontapSetup, []string) {
+				t.Helper()
+				opts.dryRun = dryRun
+				var out bytes.Buffer
+				client, err := newOntapClient(poller, "admin", "admin-password")
+				if err != nil {
+					t.Fatal(err)
+				}
+				s := newOntapSetup(poller, opts, client, &out)
+				s.confPath = "../../conf"
+				fake.posts = nil
+				if err := s.run(); err != nil {
+					t.Fatalf("run failed err=%v\n%s", err, out.String())
+				}
+				return s, fake.posts
+			}
+
+			if _, posts := setup(true); len(posts) > 0 {
+				t.Fatalf("dry run changed the cluster %v", posts)
+			}
+			s, posts := setup(false)
+			if len(posts) == 0 {
+				t.Fatal("nothing was created")
+			}
+			if _, posts := setup(false); len(posts) > 0 {
+				t.Errorf("second run is not idempotent, it sent %v", posts)
+			}
+
+			if !slices.ContainsFunc(fake.roles["harvest2-rest-role"], func(p privilege) bool {
+				return p.Path == "/api/storage/volumes" && p.Access == "readonly"
+			}) {
+				t.Errorf("rest role is missing /api/storage/volumes got=%v", fake.roles["harvest2-rest-role"])
+			}
+			if len(fake.roles["harvest2-role"]) != len(zapiCommands) {
+				t.Errorf("zapi role got=%d privileges want=%d", len(fake.roles["harvest2-role"]), len(zapiCommands))
+			}
+			var apps []string
+			for _, l := range fake.logins {
+				apps = append(apps, l.Application+"/"+l.AuthenticationMethod+"/"+l.Role)
+			}
+			method := "password"
+			if tt.auth == certificateAuth {
+				method = "cert"
+			}
+			want := []string{"http/" + method + "/harvest2-rest-role", "ontapi/" + method + "/harvest2-role"}
+			if !slices.Equal(apps, want) {
+				t.Errorf("logins got=%v want=%v", apps, want)
+			}
+
+			stanza, err := s.stanza(configPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(stanza, "datacenter: dc1") || !strings.Contains(stanza, "username: harvest2") ||
+				strings.Contains(stanza, "old-password") {
+				t.Errorf("unexpected stanza\n%s", stanza)
+			}
+			switch tt.auth {
+			case passwordAuth:
+				if fake.accounts["harvest2"] == "" || !strings.Contains(stanza, "password: "+fake.accounts["harvest2"]) {
+					t.Errorf("stanza is missing the generated password\n%s", stanza)
+				}
+			case certificateAuth:
+				if len(fake.certs) != 1 || !strings.Contains(stanza, "auth_style: certificate_auth") {
+					t.Errorf("certs=%d stanza\n%s", len(fake.certs), stanza)
+				}
+			}
+		})
+	}
+}
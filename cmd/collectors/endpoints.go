@@ -0,0 +1,246 @@
+package collectors
+
+import (
+	"cmp"
+	"github.com/netapp/harvest/v2/cmd/poller/collector"
+	"slices"
+	"strings"
+)
+
+// Endpoint is an ONTAP API a collector calls. For Rest, RestPerf, KeyPerf, and Ems, Query is a REST path.
+// For Zapi, Query is the name of a ZAPI, and for ZapiPerf, the name of a perf object
+type Endpoint struct {
+	Collector string
+	Query     string
+	UsedBy    []string // objects and plugins that call the endpoint, empty when the collector calls it
+}
+
+// CollectorEndpoints are the APIs collectors call outside their templates
+var CollectorEndpoints = map[string][]string{
+	"Rest":     {"api/cluster", "api/cluster/nodes"},
+	"RestPerf": {"api/cluster", "api/cluster/counter/tables", "api/storage/qos/workloads"},
+	"KeyPerf":  {"api/cluster"},
+	"Ems":      {"api/cluster", "api/support/ems/messages"},
+	"Zapi":     {"system-get-version", "cluster-identity-get"},
+	"ZapiPerf": {"system-get-version", "cluster-identity-get"},
+}
+
+// PluginEndpoints are the APIs plugins call outside their templates, keyed by collector and plugin name.
+// Every plugin has an entry, plugins that do not call an API have none
+var PluginEndpoints = map[string][]string{
+	"Rest:Aggregate":                    {"api/private/cli/aggr/show-space"},
+	"Rest:Certificate":                  {"api/private/cli/security/ssl", "api/private/cli/vserver"},
+	"Rest:Cluster":                      nil,
+	"Rest:ClusterSchedule":              nil,
+	"Rest:ClusterSoftware":              nil,
+	"Rest:Disk":                         nil,
+	"Rest:Health":                       {"api/cluster/licensing/licenses", "api/network/ethernet/ports", "api/network/fc/ports", "api/network/ip/interfaces", "api/private/cli/node", "api/private/cli/storage/failover", "api/private/cli/storage/shelf", "api/private/support/alerts", "api/storage/disks", "api/storage/volumes", "api/support/ems/events"},
+	"Rest:MetroclusterCheck":            nil,
+	"Rest:NetRoute":                     nil,
+	"Rest:OntapS3Service":               {"api/protocols/s3/services"},
+	"Rest:QosPolicyAdaptive":            nil,
+	"Rest:QosPolicyFixed":               nil,
+	"Rest:Quota":                        nil,
+	"Rest:SecurityAccount":              {"api/security/accounts"},
+	"Rest:Shelf":                        nil,
+	"Rest:Snapmirror":                   {"api/cluster/peers", "api/svm/peers"},
+	"Rest:SnapshotPolicy":               nil,
+	"Rest:SVM":                          {"api/protocols/fpolicy", "api/protocols/nfs/kerberos/interfaces", "api/protocols/san/iscsi/credentials", "api/protocols/san/iscsi/services"},
+	"Rest:SystemNode":                   nil,
+	"Rest:Volume":                       {"api/storage/disks", "api/storage/volumes"},
+	"Rest:VolumeAnalytics":              {"api/storage/volumes"},
+	"Rest:Workload":                     nil,
+	"RestPerf:Disk":                     {"api/private/cli/aggr", "api/storage/disks", "api/storage/shelves"},
+	"RestPerf:FabricPool":               nil,
+	"RestPerf:Fcp":                      nil,
+	"RestPerf:FCVI":                     {"api/private/cli/metrocluster/interconnect/adapter"},
+	"RestPerf:Headroom":                 nil,
+	"RestPerf:Nic":                      {"api/private/cli/network/port/ifgrp"},
+	"RestPerf:Volume":                   {"api/private/cli/volume"},
+	"RestPerf:VolumeTag":                {"api/storage/volumes"},
+	"RestPerf:VolumeTopClients":         {"api/storage/volumes"},
+	"RestPerf:Vscan":                    nil,
+	"KeyPerf:VolumeTopClients":          {"api/storage/volumes"},
+	"Zapi:Aggregate":                    {"aggr-object-store-get-iter", "aggr-space-get-iter"},
+	"Zapi:Certificate":                  {"security-ssl-get-iter", "vserver-get-iter"},
+	"Zapi:QosPolicyAdaptive":            nil,
+	"Zapi:QosPolicyFixed":               nil,
+	"Zapi:Qtree":                        {"quota-report-iter"},
+	"Zapi:Security":                     {"security-config-get", "security-protocol-get"},
+	"Zapi:Shelf":                        {"storage-shelf-environment-list-info"},
+	"Zapi:Snapmirror":                   {"vserver-peer-get-iter"},
+	"Zapi:SnapshotPolicy":               nil,
+	"Zapi:SVM":                          {"cifs-security-get-iter", "cifs-server-get-iter", "fileservice-audit-config-get-iter", "fpolicy-policy-status-get-iter", "iscsi-initiator-auth-get-iter", "iscsi-service-get-iter", "kerberos-config-get-iter", "ldap-client-get-iter", "nameservice-nsswitch-get-iter", "nfs-service-get-iter", "nis-get-iter", "security-ssh-get-iter"},
+	"Zapi:SystemNode":                   {"service-processor-get-iter", "system-get-node-info-iter"},
+	"Zapi:Volume":                       {"aggr-status-get-iter", "disk-encrypt-get-iter", "volume-clone-get-iter", "volume-footprint-get-iter"},
+	"Zapi:Workload":                     nil,
+	"ZapiPerf:Disk":                     {"aggr-get-iter", "storage-disk-get-iter", "storage-shelf-info-get-iter"},
+	"ZapiPerf:ExternalServiceOperation": nil,
+	"ZapiPerf:FabricPool":               nil,
+	"ZapiPerf:Fcp":                      nil,
+	"ZapiPerf:FCVI":                     {"metrocluster-interconnect-adapter-get-iter"},
+	"ZapiPerf:FlexCache":                {"flexcache-get-iter"},
+	"ZapiPerf:Headroom":                 nil,
+	"ZapiPerf:Nic":                      {"net-port-get-iter"},
+	"ZapiPerf:Volume":                   {"volume-get-iter"},
+	"ZapiPerf:VolumeTag":                {"volume-get-iter"},
+	"ZapiPerf:Vscan":                    nil,
+	"StorageGrid:Bucket":                {"grid/accounts"},
+	"StorageGrid:JoinRest":              nil, // the queries are set in the template
+}
+
+// zapiDirectories maps ZAPI name prefixes to the command directory that grants them, see docs/prepare-cdot-clusters.md.
+// The longest matching prefix wins
+var zapiDirectories = map[string]string{
+	"aggr-":                     "storage aggregate",
+	"cf-":                       "storage failover show",
+	"cifs-":                     "vserver",
+	"cluster-":                  "cluster",
+	"diagnosis-":                "system health alert show",
+	"disk-":                     "storage disk",
+	"ems-":                      "event log",
+	"environment-sensors-":      "system node environment sensors show",
+	"fcp-":                      "vserver",
+	"fileservice-audit-":        "vserver",
+	"flexcache-":                "volume",
+	"fpolicy-":                  "vserver",
+	"iscsi-":                    "vserver",
+	"kerberos-":                 "vserver",
+	"ldap-":                     "vserver",
+	"license-":                  "system license show",
+	"lun-":                      "lun",
+	"metrocluster-":             "metrocluster",
+	"nameservice-":              "vserver",
+	"net-connections-":          "network connections active show",
+	"net-interface-":            "network interface",
+	"net-port-":                 "network port show",
+	"net-port-ifgrp-":           "network port ifgrp show",
+	"net-routes-":               "network route show",
+	"nfs-":                      "vserver",
+	"nis-":                      "vserver",
+	"perf-":                     "statistics",
+	"qos-adaptive-policy-group": "qos adaptive-policy-group",
+	"qos-policy-group":          "qos policy-group",
+	"qos-workload":              "qos workload show",
+	"qtree-":                    "volume",
+	"quota-":                    "volume",
+	"security-":                 "security",
+	"service-processor-":        "system service-processor show",
+	"snapmirror-":               "snapmirror",
+	"snapshot-":                 "volume",
+	"storage-disk-":             "storage disk",
+	"storage-shelf-":            "storage shelf",
+	"system-":                   "system node",
+	"system-get-version":        "version",
+	"volume-":                   "volume",
+	"vserver-":                  "vserver",
+}
+
+// IsRestCollector returns true for collectors that call the ONTAP REST API
+func IsRestCollector(name string) bool {
+	switch name {
+	case "Rest", "RestPerf", "KeyPerf", "Ems":
+		return true
+	}
+	return false
+}
+
+// Endpoints returns the APIs the objects' templates, their endpoints, their plugins, and the Health plugin's checks call,
+// and the APIs their collectors call, sorted by collector and query
+func Endpoints(objects []collector.ObjectTemplate) []Endpoint {
+	type key struct{ collector, query string }
+	byKey := make(map[key]*Endpoint)
+	add := func(class string, query string, usedBy string) {
+		if query == "" {
+			return
+		}
+		k := key{class, query}
+		e, ok := byKey[k]
+		if !ok {
+			e = &Endpoint{Collector: class, Query: query}
+			byKey[k] = e
+		}
+		if usedBy != "" && !slices.Contains(e.UsedBy, usedBy) {
+			e.UsedBy = append(e.UsedBy, usedBy)
+		}
+	}
+
+	for _, o := range objects {
+		for _, q := range CollectorEndpoints[o.Class] {
+			add(o.Class, q, "")
+		}
+		if o.Template == nil {
+			continue
+		}
+		add(o.Class, o.Template.GetChildContentS("query"), o.Object)
+		if endpoints := o.Template.GetChildS("endpoints"); endpoints != nil {
+			for _, e := range endpoints.GetChildren() {
+				add(o.Class, e.GetChildContentS("query"), o.Object)
+			}
+		}
+		if plugins := o.Template.GetChildS("plugins"); plugins != nil {
+			for _, p := range plugins.GetChildren() {
+				name := cmp.Or(p.GetNameS(), p.GetContentS())
+				for _, q := range PluginEndpoints[o.Class+":"+name] {
+					add(o.Class, q, o.Object+" "+name+" plugin")
+				}
+				// custom checks of the Health plugin
+				if checks := p.GetChildS("checks"); checks != nil && name == "Health" {
+					for _, c := range checks.GetChildren() {
+						add(o.Class, c.GetChildContentS("query"), o.Object+" "+name+" plugin")
+					}
+				}
+			}
+		}
+	}
+
+	endpoints := make([]Endpoint, 0, len(byKey))
+	for _, e := range byKey {
+		endpoints = append(endpoints, *e)
+	}
+	slices.SortFunc(endpoints, func(a, b Endpoint) int {
+		return cmp.Or(cmp.Compare(a.Collector, b.Collector), cmp.Compare(a.Query, b.Query))
+	})
+	return endpoints
+}
+
+// IsPerfObject returns true when the endpoint is a ZapiPerf perf object, instead of a ZAPI.
+// Perf object names do not have dashes, ZAPI names do
+func (e Endpoint) IsPerfObject() bool {
+	return e.Collector == "ZapiPerf" && !strings.Contains(e.Query, "-")
+}
+
+// ZapiDirectory returns the command directory that grants the endpoint's ZAPI, or "" when it is not known
+func ZapiDirectory(e Endpoint) string {
+	if e.IsPerfObject() {
+		return "statistics"
+	}
+	var longest string
+	for prefix := range zapiDirectories {
+		if strings.HasPrefix(e.Query, prefix) && len(prefix) > len(longest) {
+			longest = prefix
+		}
+	}
+	return zapiDirectories[longest]
+}
+
+// RolePath converts a REST query to the path of a role privilege. Counter tables are covered by
+// api/cluster/counter/tables and path parameters are dropped. Queries that are not REST paths return ""
+func RolePath(query string) string {
+	query = "/" + strings.Trim(query, "/")
+	if strings.HasPrefix(query, "/api/cluster/counter/tables/") {
+		return "/api/cluster/counter/tables"
+	}
+	var parts []string
+	for _, part := range strings.Split(query, "/") {
+		if strings.ContainsAny(part, "{}*:") {
+			break
+		}
+		parts = append(parts, part)
+	}
+	p := strings.Join(parts, "/")
+	if p == "/api" || !strings.HasPrefix(p, "/api/") {
+		return ""
+	}
+	return p
+}
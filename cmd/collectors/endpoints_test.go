@@ -0,0 +1,192 @@
+package collectors
+
+import (
+	"cmp"
+	"github.com/netapp/harvest/v2/cmd/poller/collector"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/tree"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRolePath(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{query: "api/storage/volumes", want: "/api/storage/volumes"},
+		{query: "api/cluster/counter/tables/volume:node", want: "/api/cluster/counter/tables"},
+		{query: "api/storage/volumes/{uuid}/snapshots", want: "/api/storage/volumes"},
+		{query: "api/private/cli/volume/efficiency/stat", want: "/api/private/cli/volume/efficiency/stat"},
+		{query: "api", want: ""},
+		{query: "volume-get-iter", want: ""},
+	}
+	for _, tt := range tests {
+		if got := RolePath(tt.query); got != tt.want {
+			t.Errorf("RolePath(%s) got=%s want=%s", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestEndpoints(t *testing.T) {
+	var objects []collector.ObjectTemplate
+	for _, name := range []string{"Rest", "RestPerf"} {
+		all, err := collector.ObjectTemplates(conf.NewCollector(name), []string{"../../conf"}, "../../conf", "9.15.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		objects = append(objects, all...)
+	}
+	endpoints := Endpoints(objects)
+
+	find := func(class string, query string) *Endpoint {
+		i := slices.IndexFunc(endpoints, func(e Endpoint) bool { return e.Collector == class && e.Query == query })
+		if i < 0 {
+			t.Fatalf("missing %s %s", class, query)
+		}
+		return &endpoints[i]
+	}
+	// called by the collector, not a template
+	if e := find("RestPerf", "api/storage/qos/workloads"); len(e.UsedBy) != 0 {
+		t.Errorf("api/storage/qos/workloads usedBy=%v", e.UsedBy)
+	}
+	if e := find("Rest", "api/cluster/peers"); !slices.Contains(e.UsedBy, "SnapMirror Snapmirror plugin") {
+		t.Errorf("api/cluster/peers usedBy=%v", e.UsedBy)
+	}
+	if e := find("Rest", "api/storage/volumes"); len(e.UsedBy) < 2 {
+		t.Errorf("api/storage/volumes should be used by the template and plugins, got usedBy=%v", e.UsedBy)
+	}
+	if e := find("RestPerf", "api/private/cli/aggr"); !slices.Contains(e.UsedBy, "Disk Disk plugin") {
+		t.Errorf("api/private/cli/aggr usedBy=%v", e.UsedBy)
+	}
+	find("RestPerf", "api/cluster/counter/tables/volume")
+
+	if !slices.IsSortedFunc(endpoints, func(a, b Endpoint) int {
+		return cmp.Or(cmp.Compare(a.Collector, b.Collector), cmp.Compare(a.Query, b.Query))
+	}) {
+		t.Errorf("endpoints are not sorted")
+	}
+}
+
+func TestEndpointsHealthChecks(t *testing.T) {
+	template, err := tree.LoadYaml([]byte(`
+name: Health
+query: api/cluster
+object: health
+plugins:
+  - Health:
+      checks:
+        snapmirror_unhealthy:
+          query: api/snapmirror/relationships
+        aggr_full:
+          query: api/storage/aggregates
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoints := Endpoints([]collector.ObjectTemplate{{Class: "Rest", Object: "health", Template: template}})
+	for _, query := range []string{"api/snapmirror/relationships", "api/storage/aggregates", "api/support/ems/events"} {
+		i := slices.IndexFunc(endpoints, func(e Endpoint) bool { return e.Query == query })
+		if i < 0 {
+			t.Errorf("missing %s", query)
+			continue
+		}
+		if !slices.Equal(endpoints[i].UsedBy, []string{"health Health plugin"}) {
+			t.Errorf("%s usedBy=%v", query, endpoints[i].UsedBy)
+		}
+	}
+}
+
+// loadPluginRe matches the cases of a collector's LoadPlugin, e.g. case "Volume":\n return volume.New(abc)
+var loadPluginRe = regexp.MustCompile(`case "(\w+)":\s+return (\w+)\.New\(`)
+
+// TestPluginEndpoints checks that every plugin under cmd/collectors/*/plugins has an entry in PluginEndpoints
+func TestPluginEndpoints(t *testing.T) {
+	sources := map[string]string{
+		"Rest":        "rest/rest.go",
+		"RestPerf":    "restperf/restperf.go",
+		"KeyPerf":     "keyperf/keyperf.go",
+		"Zapi":        "zapi/collector/zapi.go",
+		"ZapiPerf":    "zapiperf/zapiperf.go",
+		"StorageGrid": "storagegrid/storagegrid.go",
+	}
+
+	// packages of the plugins each collector loads, and the plugin names they are loaded with
+	loaded := make(map[string][]string)
+	for class, source := range sources {
+		data, err := os.ReadFile(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range loadPluginRe.FindAllStringSubmatch(string(data), -1) {
+			key := class + ":" + m[1]
+			if _, ok := PluginEndpoints[key]; !ok {
+				t.Errorf("PluginEndpoints is missing %s", key)
+			}
+			// the directory of the plugin is in the import of its package
+			imported := regexp.MustCompile(`"github.com/netapp/harvest/v2/cmd/collectors/(\S+/plugins/` + m[2] + `)"`).FindStringSubmatch(string(data))
+			if imported == nil {
+				continue
+			}
+			loaded[imported[1]] = append(loaded[imported[1]], key)
+		}
+	}
+
+	dirs, err := filepath.Glob("*/plugins/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) == 0 {
+		t.Fatal("no plugins found")
+	}
+	for _, dir := range dirs {
+		if len(loaded[dir]) == 0 {
+			t.Errorf("%s is not loaded by a collector, add it to the LoadPlugin sources of this test", dir)
+		}
+	}
+
+	for key := range PluginEndpoints {
+		if !slices.ContainsFunc(slices.Collect(maps.Values(loaded)), func(keys []string) bool { return slices.Contains(keys, key) }) {
+			t.Errorf("PluginEndpoints has %s, which no collector loads", key)
+		}
+	}
+}
+
+func TestZapiDirectory(t *testing.T) {
+	tests := []struct {
+		endpoint Endpoint
+		want     string
+	}{
+		{endpoint: Endpoint{Collector: "Zapi", Query: "volume-get-iter"}, want: "volume"},
+		{endpoint: Endpoint{Collector: "Zapi", Query: "net-port-ifgrp-get"}, want: "network port ifgrp show"},
+		{endpoint: Endpoint{Collector: "Zapi", Query: "net-port-get-iter"}, want: "network port show"},
+		{endpoint: Endpoint{Collector: "ZapiPerf", Query: "system-get-version"}, want: "version"},
+		{endpoint: Endpoint{Collector: "ZapiPerf", Query: "volume"}, want: "statistics"},
+		{endpoint: Endpoint{Collector: "ZapiPerf", Query: "volume-get-iter"}, want: "volume"},
+		{endpoint: Endpoint{Collector: "Zapi", Query: "service-processor-get-iter"}, want: "system service-processor show"},
+		{endpoint: Endpoint{Collector: "Zapi", Query: "unknown-get-iter"}, want: ""},
+	}
+	for _, tt := range tests {
+		if got := ZapiDirectory(tt.endpoint); got != tt.want {
+			t.Errorf("ZapiDirectory(%s) got=%q want=%q", tt.endpoint.Query, got, tt.want)
+		}
+	}
+
+	// every ZAPI of the Zapi and ZapiPerf collectors and their plugins has a command directory
+	for key, queries := range PluginEndpoints {
+		class, _, _ := strings.Cut(key, ":")
+		if class != "Zapi" && class != "ZapiPerf" {
+			continue
+		}
+		for _, q := range append(queries, CollectorEndpoints[class]...) {
+			if ZapiDirectory(Endpoint{Collector: class, Query: q}) == "" {
+				t.Errorf("%s %s has no command directory", key, q)
+			}
+		}
+	}
+}
@@ -0,0 +1,411 @@
+// Package eseries collects NetApp E-Series arrays via the SANtricity Web Services REST API
+package eseries
+
+import (
+	"github.com/netapp/harvest/v2/cmd/collectors/eseries/rest"
+	srest "github.com/netapp/harvest/v2/cmd/collectors/rest"
+	"github.com/netapp/harvest/v2/cmd/poller/collector"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/slogx"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"github.com/netapp/harvest/v2/pkg/util"
+	"github.com/netapp/harvest/v2/third_party/tidwall/gjson"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+type prop struct {
+	Object         string
+	Query          string
+	TemplatePath   string
+	InstanceKeys   []string
+	InstanceLabels map[string]string
+	Metrics        map[string]*Metric
+	Counters       map[string]string
+}
+
+type Metric struct {
+	Label      string
+	Name       string
+	MetricType string
+	Exportable bool
+}
+
+type ESeries struct {
+	*collector.AbstractCollector
+	client *rest.Client
+	Props  *prop
+}
+
+func init() {
+	plugin.RegisterModule(&ESeries{})
+}
+
+func (e *ESeries) HarvestModule() plugin.ModuleInfo {
+	return plugin.ModuleInfo{
+		ID:  "harvest.collector.eseries",
+		New: func() plugin.Module { return new(ESeries) },
+	}
+}
+
+func (e *ESeries) Init(a *collector.AbstractCollector) error {
+	var err error
+	e.AbstractCollector = a
+	e.InitProp()
+
+	if err := e.initClient(); err != nil {
+		return err
+	}
+	if e.Props.TemplatePath, err = e.LoadTemplate(); err != nil {
+		return err
+	}
+	if err := collector.Init(e); err != nil {
+		return err
+	}
+
+	if err := e.InitCache(); err != nil {
+		return err
+	}
+
+	e.InitMatrix()
+
+	e.Logger.Debug("initialized")
+	return nil
+}
+
+func (e *ESeries) InitMatrix() {
+	mat := e.Matrix[e.Object]
+	// overwrite from abstract collector
+	mat.Object = e.Props.Object
+	// Add array name
+	mat.SetGlobalLabel("cluster", e.client.Remote.Name)
+
+	if e.Params.HasChildS("labels") {
+		for _, l := range e.Params.GetChildS("labels").GetChildren() {
+			mat.SetGlobalLabel(l.GetNameS(), l.GetContentS())
+		}
+	}
+}
+
+func (e *ESeries) InitCache() error {
+	var counters *node.Node
+
+	if e.Props.Object = e.Params.GetChildContentS("object"); e.Props.Object == "" {
+		return errs.New(errs.ErrMissingParam, "object")
+	}
+
+	if x := e.Params.GetChildS("export_options"); x != nil {
+		e.Matrix[e.Object].SetExportOptions(x)
+	}
+
+	if e.Props.Query = e.Params.GetChildContentS("query"); e.Props.Query == "" {
+		return errs.New(errs.ErrMissingParam, "query")
+	}
+
+	if counters = e.Params.GetChildS("counters"); counters == nil {
+		return errs.New(errs.ErrMissingParam, "counters")
+	}
+	e.ParseCounters(counters, e.Props)
+
+	e.Logger.Debug(
+		"Initialized metric cache",
+		slog.Any("extracted Instance Keys", e.Props.InstanceKeys),
+		slog.Int("numMetrics", len(e.Props.Metrics)),
+		slog.Int("numLabels", len(e.Props.InstanceLabels)),
+	)
+
+	return nil
+}
+
+func (e *ESeries) PollData() (map[string]*matrix.Matrix, error) {
+	var (
+		count        uint64
+		apiD, parseD time.Duration
+		startTime    time.Time
+		records      []gjson.Result
+	)
+
+	e.client.Metadata.Reset()
+	e.Matrix[e.Object].Reset()
+	startTime = time.Now()
+
+	if err := e.client.Fetch(e.Props.Query, &records); err != nil {
+		return nil, err
+	}
+
+	apiD = time.Since(startTime)
+
+	if len(records) == 0 {
+		return nil, errs.New(errs.ErrNoInstance, "no "+e.Object+" instances on array")
+	}
+
+	startTime = time.Now()
+	count = e.handleResults(records)
+	parseD = time.Since(startTime)
+
+	numRecords := len(e.Matrix[e.Object].GetInstances())
+
+	_ = e.Metadata.LazySetValueInt64("api_time", "data", apiD.Microseconds())
+	_ = e.Metadata.LazySetValueInt64("parse_time", "data", parseD.Microseconds())
+	_ = e.Metadata.LazySetValueUint64("metrics", "data", count)
+	_ = e.Metadata.LazySetValueInt64("instances", "data", int64(numRecords))
+	_ = e.Metadata.LazySetValueUint64("bytesRx", "data", e.client.Metadata.BytesRx)
+	_ = e.Metadata.LazySetValueUint64("numCalls", "data", e.client.Metadata.NumCalls)
+
+	e.AddCollectCount(count)
+
+	return e.Matrix, nil
+}
+
+func (e *ESeries) handleResults(result []gjson.Result) uint64 {
+	var (
+		err   error
+		count uint64
+	)
+
+	mat := e.Matrix[e.Object]
+
+	// Keep track of old instances
+	oldInstances := make(map[string]bool)
+	for key := range mat.GetInstances() {
+		oldInstances[key] = true
+	}
+
+	for _, instanceData := range result {
+		var (
+			instanceKey string
+			instance    *matrix.Instance
+		)
+
+		if !instanceData.IsObject() {
+			e.Logger.Warn("Instance data is not object, skipping", slog.String("type", instanceData.Type.String()))
+			continue
+		}
+
+		// extract instance key(s)
+		for _, k := range e.Props.InstanceKeys {
+			value := instanceData.Get(k)
+			if !value.Exists() {
+				e.Logger.Warn("skip instance, missing key", slog.String("key", k))
+				instanceKey = ""
+				break
+			}
+			instanceKey += value.ClonedString()
+		}
+
+		if instanceKey == "" {
+			continue
+		}
+
+		if instance = mat.GetInstance(instanceKey); instance == nil {
+			if instance, err = mat.NewInstance(instanceKey); err != nil {
+				e.Logger.Error("", slogx.Err(err), slog.String("instanceKey", instanceKey))
+				continue
+			}
+		}
+
+		delete(oldInstances, instanceKey)
+
+		for label, display := range e.Props.InstanceLabels {
+			value := instanceData.Get(label)
+			if !value.Exists() {
+				continue
+			}
+			if value.IsArray() {
+				var labelArray []string
+				for _, r := range value.Array() {
+					labelArray = append(labelArray, r.ClonedString())
+				}
+				instance.SetLabel(display, strings.Join(labelArray, ","))
+			} else {
+				instance.SetLabel(display, value.ClonedString())
+			}
+			count++
+		}
+
+		for _, metric := range e.Props.Metrics {
+			metr := mat.GetMetric(metric.Name)
+			if metr == nil {
+				if metr, err = mat.NewMetricFloat64(metric.Name, metric.Label); err != nil {
+					e.Logger.Error("NewMetricFloat64", slogx.Err(err), slog.String("name", metric.Name))
+					continue
+				}
+			}
+			f := instanceData.Get(metric.Name)
+			if !f.Exists() {
+				continue
+			}
+			// Web Services returns capacities as strings, e.g. "capacity": "107374182400", which gjson parses
+			var floatValue float64
+			switch metric.MetricType {
+			case "":
+				floatValue = f.Float()
+			case "bool":
+				if f.Bool() {
+					floatValue = 1
+				}
+			default:
+				e.Logger.Warn(
+					"unknown metric type",
+					slog.String("type", metric.MetricType),
+					slog.String("metric", metric.Name),
+				)
+				continue
+			}
+
+			if err = metr.SetValueFloat64(instance, floatValue); err != nil {
+				e.Logger.Error(
+					"Unable to set float key on metric",
+					slogx.Err(err),
+					slog.String("key", metric.Name),
+					slog.String("metric", metric.Label),
+				)
+				continue
+			}
+			count++
+		}
+	}
+
+	// Remove instances not present in the new set
+	for key := range oldInstances {
+		mat.RemoveInstance(key)
+		e.Logger.Debug("removed instance", slog.String("key", key))
+	}
+	return count
+}
+
+func (e *ESeries) initClient() error {
+	var err error
+
+	if e.client, err = rest.NewClientFunc(e.Options.Poller, e.Params.GetChildContentS("client_timeout"), e.Auth); err != nil {
+		return err
+	}
+	if id := e.Params.GetChildContentS("system_id"); id != "" {
+		e.client.SystemID = id
+	}
+
+	if e.Options.IsTest {
+		return nil
+	}
+
+	if err := e.client.Init(5, e.Remote); err != nil {
+		return err
+	}
+	e.Remote = e.client.Remote
+	e.client.TraceLogSet(e.Name, e.Params)
+
+	return nil
+}
+
+func (e *ESeries) ParseCounters(counter *node.Node, prop *prop) {
+	var display, name, kind, metricType string
+
+	for _, c := range counter.GetAllChildContentS() {
+		if c == "" {
+			continue
+		}
+		name, display, kind, metricType = util.ParseMetric(c)
+		e.Logger.Debug(
+			"Collected",
+			slog.String("kind", kind),
+			slog.String("name", name),
+			slog.String("display", display),
+		)
+
+		prop.Counters[name] = display
+		switch kind {
+		case "key":
+			prop.InstanceLabels[name] = display
+			prop.InstanceKeys = append(prop.InstanceKeys, name)
+		case "label":
+			prop.InstanceLabels[name] = display
+		case "float":
+			prop.Metrics[name] = &Metric{Label: display, Name: name, MetricType: metricType, Exportable: true}
+		}
+	}
+}
+
+func (e *ESeries) InitProp() {
+	e.Props = &prop{
+		InstanceKeys:   make([]string, 0),
+		InstanceLabels: make(map[string]string),
+		Counters:       make(map[string]string),
+		Metrics:        make(map[string]*Metric),
+	}
+}
+
+func (e *ESeries) LoadTemplate() (string, error) {
+	jitter := e.Params.GetChildContentS("jitter")
+
+	template, path, err := e.ImportSubTemplate("", srest.TemplateFn(e.Params, e.Object), jitter, e.client.Remote.Version)
+	if err != nil {
+		return "", err
+	}
+
+	e.Params.Union(template)
+	return path, nil
+}
+
+func (e *ESeries) LoadPlugin(kind string, _ *plugin.AbstractPlugin) plugin.Plugin {
+	e.Logger.Warn("plugin not found", slog.String("kind", kind))
+	return nil
+}
+
+func (e *ESeries) CollectAutoSupport(p *collector.Payload) {
+	exporterTypes := make([]string, 0, len(e.Exporters))
+	for _, exporter := range e.Exporters {
+		exporterTypes = append(exporterTypes, exporter.GetClass())
+	}
+
+	counters := make([]string, 0, len(e.Props.Counters))
+	for k := range e.Props.Counters {
+		counters = append(counters, k)
+	}
+	slices.Sort(counters)
+
+	schedules := make([]collector.Schedule, 0)
+	if tasks := e.Params.GetChildS("schedule"); tasks != nil {
+		for _, task := range tasks.GetChildren() {
+			schedules = append(schedules, collector.Schedule{
+				Name:     task.GetNameS(),
+				Schedule: task.GetContentS(),
+			})
+		}
+	}
+
+	md := e.GetMetadata()
+	info := collector.InstanceInfo{
+		Count:      md.LazyValueInt64("instances", "data"),
+		DataPoints: md.LazyValueInt64("metrics", "data"),
+		PollTime:   md.LazyValueInt64("poll_time", "data"),
+		APITime:    md.LazyValueInt64("api_time", "data"),
+		ParseTime:  md.LazyValueInt64("parse_time", "data"),
+		PluginTime: md.LazyValueInt64("plugin_time", "data"),
+	}
+
+	p.AddCollectorAsup(collector.AsupCollector{
+		Name:      e.Name,
+		Query:     e.Props.Query,
+		Exporters: exporterTypes,
+		Counters: collector.Counters{
+			Count: len(counters),
+			List:  counters,
+		},
+		Schedules:     schedules,
+		ClientTimeout: e.client.Timeout.String(),
+		InstanceInfo:  &info,
+	})
+
+	p.Target.Version = e.client.Remote.Version
+	p.Target.Model = "eseries"
+	p.Target.ClusterUUID = e.client.Remote.UUID
+}
+
+// Interface guards
+var (
+	_ collector.Collector = (*ESeries)(nil)
+)
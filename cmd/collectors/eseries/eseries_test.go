@@ -0,0 +1,146 @@
+package eseries
+
+import (
+	"github.com/netapp/harvest/v2/cmd/collectors"
+	"github.com/netapp/harvest/v2/cmd/collectors/eseries/rest"
+	"github.com/netapp/harvest/v2/cmd/poller/collector"
+	"github.com/netapp/harvest/v2/cmd/poller/options"
+	"github.com/netapp/harvest/v2/pkg/auth"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/third_party/tidwall/gjson"
+	"os"
+	"testing"
+)
+
+const (
+	pollerName = "test"
+)
+
+// newESeries initializes an ESeries collector that uses the templates in conf and a dummy client
+func newESeries(object string, path string) (*ESeries, error) {
+	opts := options.New(options.WithConfPath("../../../conf"))
+	opts.Poller = pollerName
+	opts.HomePath = "testdata"
+	opts.IsTest = true
+	e := ESeries{}
+	rest.NewClientFunc = func(_ string, _ string, _ *auth.Credentials) (*rest.Client, error) {
+		return rest.NewDummyClient(), nil
+	}
+	ac := collector.New("ESeries", object, opts, collectors.Params(object, path), nil, conf.Remote{})
+	if err := e.Init(ac); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// records parses a recorded response the way rest.Client.Fetch does
+func records(t *testing.T, filename string) []gjson.Result {
+	t.Helper()
+	output, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	parsed := gjson.ParseBytes(output)
+	if parsed.IsArray() {
+		return parsed.Array()
+	}
+	return []gjson.Result{parsed}
+}
+
+func TestESeries_Objects(t *testing.T) {
+	conf.TestLoadHarvestConfig("testdata/config.yml")
+
+	tests := []struct {
+		object    string
+		template  string
+		response  string
+		instances int
+		// instance key => metric => value
+		want map[string]map[string]float64
+		// instance key => label => value
+		labels map[string]map[string]string
+	}{
+		{
+			object: "System", template: "system.yaml", response: "testdata/system.json", instances: 1,
+			want: map[string]map[string]float64{
+				"600A098000F63714000000005E79C17C": {"driveCount": 24, "usedPoolSpace": 21474836480000},
+			},
+			labels: map[string]map[string]string{
+				"600A098000F63714000000005E79C17C": {"array": "eseries-01", "firmware_version": "08.80.00.00"},
+			},
+		},
+		{
+			object: "Controller", template: "controller.yaml", response: "testdata/controllers.json", instances: 2,
+			want: map[string]map[string]float64{
+				"070000000000000000000002": {"active": 1, "cacheMemorySize": 32768},
+			},
+			labels: map[string]map[string]string{
+				"070000000000000000000002": {"controller": "B", "status": "optimal"},
+			},
+		},
+		{
+			object: "Drive", template: "drive.yaml", response: "testdata/drives.json", instances: 4,
+			want: map[string]map[string]float64{
+				"0100000050000396DC8A0003": {"hotSpare": 1, "offline": 0, "rawCapacity": 960197124096},
+				"0100000050000396DC8A0004": {"hotSpare": 0, "offline": 1},
+			},
+			labels: map[string]map[string]string{
+				"0100000050000396DC8A0004": {"slot": "4", "status": "failed", "interface_type": "sas"},
+			},
+		},
+		{
+			object: "Volume", template: "volume.yaml", response: "testdata/volumes.json", instances: 3,
+			want: map[string]map[string]float64{
+				"0200000060080E50001F6D3800000002": {"capacity": 2199023255552, "mapped": 1},
+				"0200000060080E50001F6D3800000003": {"mapped": 0},
+			},
+			labels: map[string]map[string]string{
+				"0200000060080E50001F6D3800000002": {"volume": "db_logs", "raid_level": "raid6"},
+			},
+		},
+		{
+			object: "VolumePerf", template: "volume_perf.yaml", response: "testdata/analysed-volume-statistics.json", instances: 3,
+			want: map[string]map[string]float64{
+				"0200000060080E50001F6D3800000003": {"readIOps": 361.5, "readResponseTime": 0.42},
+			},
+			labels: map[string]map[string]string{
+				"0200000060080E50001F6D3800000003": {"volume": "backup"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.object, func(t *testing.T) {
+			e, err := newESeries(tt.object, tt.template)
+			if err != nil {
+				t.Fatalf("failed to create new ESeries: %v", err)
+			}
+			e.handleResults(records(t, tt.response))
+
+			mat := e.Matrix[e.Object]
+			if got := len(mat.GetInstances()); got != tt.instances {
+				t.Errorf("got %d instances, want %d", got, tt.instances)
+			}
+			for key, metrics := range tt.want {
+				instance := mat.GetInstance(key)
+				if instance == nil {
+					t.Fatalf("instance %s not found", key)
+				}
+				for name, want := range metrics {
+					got, ok := mat.GetMetric(name).GetValueFloat64(instance)
+					if !ok || got != want {
+						t.Errorf("instance=%s metric=%s got %f ok=%t, want %f", key, name, got, ok, want)
+					}
+				}
+			}
+			for key, labels := range tt.labels {
+				instance := mat.GetInstance(key)
+				for name, want := range labels {
+					if got := instance.GetLabel(name); got != want {
+						t.Errorf("instance=%s label=%s got %s, want %s", key, name, got, want)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,343 @@
+// Package rest is a client for the SANtricity Web Services REST API of NetApp E-Series arrays
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/netapp/harvest/v2/pkg/auth"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/requests"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"github.com/netapp/harvest/v2/pkg/util"
+	"github.com/netapp/harvest/v2/third_party/tidwall/gjson"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultTimeout  = "1m"
+	DefaultSystemID = "1" // the ID of the local array when Web Services is embedded in the controllers
+	APIPath         = "/devmgr/v2"
+	loginPath       = "/devmgr/utils/login"
+)
+
+var NewClientFunc = NewClient
+
+type Client struct {
+	client   *http.Client
+	request  *http.Request
+	Logger   *slog.Logger
+	baseURL  string
+	Remote   conf.Remote
+	Timeout  time.Duration
+	logRest  bool // used to log Rest request/response
+	SystemID string
+	auth     *auth.Credentials
+	Metadata *util.Metadata
+}
+
+func NewClient(pollerName string, clientTimeout string, c *auth.Credentials) (*Client, error) {
+	var (
+		poller  *conf.Poller
+		err     error
+		client  *Client
+		timeout time.Duration
+	)
+
+	if poller, err = conf.PollerNamed(pollerName); err != nil {
+		return nil, fmt.Errorf("poller [%s] does not exist. err: %w", pollerName, err)
+	}
+	if poller.Addr == "" {
+		return nil, errs.New(errs.ErrMissingParam, "addr")
+	}
+
+	timeout, err = time.ParseDuration(clientTimeout)
+	if err != nil {
+		timeout, _ = time.ParseDuration(DefaultTimeout)
+	}
+	if client, err = New(poller, timeout, c); err != nil {
+		return nil, fmt.Errorf("unable to create poller [%s]. err: %w", pollerName, err)
+	}
+
+	return client, err
+}
+
+func New(poller *conf.Poller, timeout time.Duration, c *auth.Credentials) (*Client, error) {
+	var (
+		client    Client
+		transport http.RoundTripper
+		jar       *cookiejar.Jar
+		err       error
+	)
+
+	client = Client{
+		auth:     c,
+		SystemID: DefaultSystemID,
+		Metadata: &util.Metadata{},
+	}
+	client.Logger = slog.Default().With(slog.String("ESeries", "Client"))
+
+	if poller.Addr == "" {
+		return nil, errs.New(errs.ErrMissingParam, "addr")
+	}
+
+	client.baseURL = "https://" + poller.Addr
+	client.Timeout = timeout
+
+	if transport, err = c.Transport(nil, poller); err != nil {
+		return nil, err
+	}
+	// Web Services keeps the login session in a cookie
+	if jar, err = cookiejar.New(nil); err != nil {
+		return nil, err
+	}
+	client.client = &http.Client{Transport: transport, Timeout: timeout, Jar: jar}
+
+	return &client, nil
+}
+
+func (c *Client) TraceLogSet(collectorName string, config *node.Node) {
+	// check for log sets and enable Rest request logging if collectorName is in the set
+	if llogs := config.GetChildS("log"); llogs != nil {
+		for _, log := range llogs.GetAllChildContentS() {
+			if strings.EqualFold(log, collectorName) {
+				c.logRest = true
+			}
+		}
+	}
+}
+
+func (c *Client) printRequestAndResponse(response []byte) {
+	if c.logRest {
+		res := "<nil>"
+		if response != nil {
+			res = string(response)
+		}
+		c.Logger.Info(
+			"",
+			slog.String("Request", c.request.URL.String()),
+			slog.String("Response", res),
+		)
+	}
+}
+
+// Fetch makes a REST request to the array and stores the parsed JSON in result.
+// Web Services returns collections as JSON arrays and single objects as JSON objects, both are supported.
+// {system_id} in request is replaced with the ID of the storage system
+func (c *Client) Fetch(request string, result *[]gjson.Result) error {
+	fetched, err := c.GetRest(request)
+	if err != nil {
+		return fmt.Errorf("error making request %w", err)
+	}
+
+	output := gjson.ParseBytes(fetched)
+	if output.IsArray() {
+		*result = append(*result, output.Array()...)
+	} else if output.IsObject() {
+		*result = append(*result, output)
+	}
+	return nil
+}
+
+// GetRest makes a GET request relative to the API path and returns the json response as a []byte
+func (c *Client) GetRest(request string) ([]byte, error) {
+	request = strings.ReplaceAll(request, "{system_id}", url.PathEscape(c.SystemID))
+	u, err := url.JoinPath(c.baseURL, APIPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to join URL %s err: %w", request, err)
+	}
+	u += "/" + strings.TrimPrefix(request, "/")
+
+	if c.request, err = requests.New(http.MethodGet, u, nil); err != nil {
+		return nil, err
+	}
+	c.request.Header.Set("Accept", "application/json")
+	return c.invoke()
+}
+
+// invoke sends the request and logs in again when the session has expired
+func (c *Client) invoke() ([]byte, error) {
+	resp, err := c.fetch()
+	if err != nil && isAuthErr(err) {
+		if err2 := c.login(); err2 != nil {
+			return nil, err2
+		}
+		return c.fetch()
+	}
+	return resp, err
+}
+
+func (c *Client) fetch() ([]byte, error) {
+	var (
+		response *http.Response
+		body     []byte
+		err      error
+	)
+
+	// The cookie jar adds the session cookie to the request, remove it in case the request is retried after a login
+	c.request.Header.Del("Cookie")
+	if response, err = c.client.Do(c.request); err != nil {
+		return nil, fmt.Errorf("connection error %w", err)
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer response.Body.Close()
+
+	if body, err = io.ReadAll(response.Body); err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return nil, errs.NewRest().
+			StatusCode(response.StatusCode).
+			Message(errorMessage(body)).
+			API(util.GetURLWithoutHost(c.request)).
+			Build()
+	}
+	defer c.printRequestAndResponse(body)
+
+	c.Metadata.BytesRx += uint64(len(body))
+	c.Metadata.NumCalls++
+
+	return body, nil
+}
+
+// errorMessage returns the message of a Web Services error response, e.g.
+// {"errorMessage":"The object does not exist","localizedMessage":"...","retcode":"...","codeType":"symbol"}
+func errorMessage(body []byte) string {
+	if msg := gjson.GetBytes(body, "errorMessage").ClonedString(); msg != "" {
+		return msg
+	}
+	return string(body)
+}
+
+func isAuthErr(err error) bool {
+	var restErr *errs.RestError
+	if !errors.As(err, &restErr) {
+		return false
+	}
+	return restErr.StatusCode == http.StatusUnauthorized || restErr.StatusCode == http.StatusForbidden
+}
+
+type loginBody struct {
+	UserID        string `json:"userId"`
+	Password      string `json:"password"`
+	XsrfProtected bool   `json:"xsrfProtected"`
+}
+
+// login creates a Web Services session. The session cookie is stored in the client's cookie jar
+func (c *Client) login() error {
+	login := func() error {
+		pollerAuth, err := c.auth.GetPollerAuth()
+		if err != nil {
+			return err
+		}
+		postBody, err := json.Marshal(loginBody{UserID: pollerAuth.Username, Password: pollerAuth.Password})
+		if err != nil {
+			return err
+		}
+		req, err := requests.New(http.MethodPost, c.baseURL+loginPath, bytes.NewBuffer(postBody))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Content-Type", "application/json")
+
+		response, err := c.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("connection error %w", err)
+		}
+		//goland:noinspection GoUnhandledErrorResult
+		defer response.Body.Close()
+
+		body, err := io.ReadAll(response.Body)
+		if err != nil {
+			return err
+		}
+		if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusNoContent {
+			return errs.NewRest().
+				StatusCode(response.StatusCode).
+				Message(errorMessage(body)).
+				API(loginPath).
+				Build()
+		}
+		return nil
+	}
+
+	err := login()
+	if err != nil && isAuthErr(err) {
+		// If the client is using refreshable credentials, expire the current credentials,
+		// call the script again, and try again
+		pollerAuth, err2 := c.auth.GetPollerAuth()
+		if err2 != nil {
+			return err2
+		}
+		if pollerAuth.IsRefreshable() {
+			c.auth.Expire()
+			return login()
+		}
+		return errs.New(errs.ErrAuthFailed, err.Error())
+	}
+	return err
+}
+
+// Init logs in and determines the name, WWN, and firmware version of the storage system
+func (c *Client) Init(retries int, remote conf.Remote) error {
+	var (
+		err     error
+		content []byte
+	)
+
+	c.Remote = remote
+	if !remote.IsZero() {
+		return nil
+	}
+
+	for range retries {
+		if err = c.login(); err != nil {
+			if errors.Is(err, errs.ErrAuthFailed) {
+				return err
+			}
+			continue
+		}
+		if content, err = c.GetRest("storage-systems/{system_id}"); err != nil {
+			continue
+		}
+		system := gjson.ParseBytes(content)
+		c.Remote.Name = system.Get("name").ClonedString()
+		c.Remote.UUID = system.Get("wwn").ClonedString()
+		c.Remote.Model = "eseries"
+		if c.Remote.Version, err = ReleaseVersion(system.Get("fwVersion").ClonedString()); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	return err
+}
+
+// ReleaseVersion converts the firmware version of the controllers, e.g. 08.80.00.00, into the SANtricity OS
+// release, e.g. 11.80.0, which is how the templates are versioned. SANtricity OS 11.x ships firmware 08.x
+func ReleaseVersion(fwVersion string) (string, error) {
+	parts := strings.Split(fwVersion, ".")
+	if len(parts) < 2 {
+		return "", fmt.Errorf("failed to parse firmware version %s", fwVersion)
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return "", fmt.Errorf("failed to parse firmware version %s err: %w", fwVersion, err)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("failed to parse firmware version %s err: %w", fwVersion, err)
+	}
+	return strconv.Itoa(major+3) + "." + strconv.Itoa(minor) + ".0", nil
+}
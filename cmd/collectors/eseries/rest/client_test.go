@@ -0,0 +1,98 @@
+package rest
+
+import (
+	"github.com/netapp/harvest/v2/pkg/auth"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/third_party/tidwall/gjson"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClient_SessionAndFetch(t *testing.T) {
+	var logins int
+	session := ""
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+loginPath, func(w http.ResponseWriter, r *http.Request) {
+		body := make([]byte, r.ContentLength)
+		_, _ = r.Body.Read(body)
+		if gjson.GetBytes(body, "userId").String() != "monitor" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		logins++
+		session = "session" + string(rune('0'+logins))
+		http.SetCookie(w, &http.Cookie{Name: "JSESSIONID", Value: session, Path: "/"})
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET "+APIPath+"/storage-systems/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie("JSESSIONID"); err != nil || c.Value != session {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"` + r.PathValue("id") + `","name":"eseries-01","wwn":"600A0980","fwVersion":"08.73.00.00"}`))
+	})
+	mux.HandleFunc("GET "+APIPath+"/storage-systems/{id}/volumes", func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie("JSESSIONID"); err != nil || c.Value != session {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`[{"id":"v1"},{"id":"v2"}]`))
+	})
+	server := httptest.NewTLSServer(mux)
+	defer server.Close()
+
+	insecure := true
+	poller := &conf.Poller{
+		Addr:           strings.TrimPrefix(server.URL, "https://"),
+		Username:       "monitor",
+		Password:       "password",
+		UseInsecureTLS: &insecure,
+	}
+	client, err := New(poller, 10*time.Second, auth.NewCredentials(poller, slog.Default()))
+	if err != nil {
+		t.Fatalf("expected no error got %+v", err)
+	}
+	client.SystemID = "a1"
+
+	if err := client.Init(1, conf.Remote{}); err != nil {
+		t.Fatalf("expected no error got %+v", err)
+	}
+	if client.Remote.Name != "eseries-01" || client.Remote.UUID != "600A0980" || client.Remote.Version != "11.73.0" {
+		t.Errorf("got remote=%+v", client.Remote)
+	}
+
+	// Simulate an expired session, the client logs in again
+	session = "expired"
+	var result []gjson.Result
+	if err := client.Fetch("storage-systems/{system_id}/volumes", &result); err != nil {
+		t.Fatalf("expected no error got %+v", err)
+	}
+	if len(result) != 2 || logins != 2 {
+		t.Errorf("got %d volumes after %d logins, want 2 and 2", len(result), logins)
+	}
+}
+
+func TestReleaseVersion(t *testing.T) {
+	tests := []struct {
+		fw      string
+		want    string
+		wantErr bool
+	}{
+		{fw: "08.80.00.00", want: "11.80.0"},
+		{fw: "08.73.01.00", want: "11.73.0"},
+		{fw: "8", wantErr: true},
+		{fw: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fw, func(t *testing.T) {
+			got, err := ReleaseVersion(tt.fw)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ReleaseVersion(%s) got %s err=%v, want %s", tt.fw, got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package rest
+
+import (
+	"github.com/netapp/harvest/v2/pkg/auth"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/util"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// NewDummyClient creates a new dummy client
+func NewDummyClient() *Client {
+	httpRequest, _ := http.NewRequest(http.MethodGet, "http://example.com", http.NoBody)
+
+	remote := conf.Remote{
+		Name:    "TestArray",
+		UUID:    "600A098000F63714000000005E79C17C",
+		Version: "11.80.0",
+		Model:   "eseries",
+	}
+
+	client := &Client{
+		client:   &http.Client{Timeout: time.Second * 10},
+		request:  httpRequest,
+		Logger:   slog.Default(),
+		baseURL:  "http://example.com",
+		Remote:   remote,
+		Timeout:  time.Second * 10,
+		logRest:  true,
+		SystemID: DefaultSystemID,
+		auth:     &auth.Credentials{},
+		Metadata: &util.Metadata{},
+	}
+
+	return client
+}
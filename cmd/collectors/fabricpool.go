@@ -4,7 +4,6 @@ import (
 	"github.com/netapp/harvest/v2/pkg/matrix"
 	"github.com/netapp/harvest/v2/pkg/slogx"
 	"log/slog"
-	"maps"
 	"regexp"
 	"strings"
 )
@@ -47,7 +46,7 @@ func GetFlexGroupFabricPoolMetrics(dataMap map[string]*matrix.Matrix, object str
 			key := i.GetLabel("svm") + "." + match[1] + "." + i.GetLabel("cloud_target")
 			if cache.GetInstance(key) == nil {
 				fg, _ := cache.NewInstance(key)
-				fg.SetLabels(maps.Clone(i.GetLabels()))
+				fg.SetLabels(i.Copy())
 				fg.SetLabel("volume", match[1])
 			}
 			i.SetExportable(includeConstituents)
@@ -28,6 +28,7 @@ type KeyPerf struct {
 	perfProp      *perfProp
 	pollDataCalls int
 	recordsToSave int // Number of records to save when using the recorder
+	counterState  *collector.CounterState
 }
 
 type counter struct {
@@ -97,6 +98,10 @@ func (kp *KeyPerf) Init(a *collector.AbstractCollector) error {
 
 	kp.recordsToSave = collector.RecordKeepLast(kp.Params, kp.Logger)
 
+	if kp.counterState, err = collector.NewCounterState(kp.AbstractCollector); err != nil {
+		return err
+	}
+
 	kp.Logger.Debug(
 		"initialized cache",
 		slog.Int("numMetrics", len(kp.Prop.Metrics)),
@@ -362,17 +367,31 @@ func (kp *KeyPerf) validateMatrix(prevMat *matrix.Matrix, curMat *matrix.Matrix)
 	return nil
 }
 
+// rawCounter returns how a counter is cooked, used when raw counters are exported
+func (kp *KeyPerf) rawCounter(key string, _ *matrix.Metric) (collector.RawCounter, bool) {
+	c := kp.perfProp.counterInfo[key]
+	if c == nil {
+		return collector.RawCounter{}, false
+	}
+	return collector.RawCounter{Property: c.counterType, Base: c.denominator}, true
+}
+
 func (kp *KeyPerf) cookCounters(curMat *matrix.Matrix, prevMat *matrix.Matrix) (map[string]*matrix.Matrix, error) {
 	var (
 		err   error
 		skips int
 	)
-	// skip calculating from delta if no data from previous poll
+	// skip calculating from delta if no data from previous poll, unless it was restored from disk
 	if kp.perfProp.isCacheEmpty {
-		kp.Logger.Debug("skip postprocessing until next poll (previous cache empty)")
-		kp.Matrix[kp.Object] = curMat
 		kp.perfProp.isCacheEmpty = false
-		return nil, nil
+		restored := kp.counterState.Restore(curMat)
+		if restored == nil {
+			kp.Logger.Debug("skip postprocessing until next poll (previous cache empty)")
+			kp.Matrix[kp.Object] = curMat
+			kp.counterState.Save(curMat)
+			return nil, nil
+		}
+		prevMat = restored
 	}
 
 	calcStart := time.Now()
@@ -380,6 +399,12 @@ func (kp *KeyPerf) cookCounters(curMat *matrix.Matrix, prevMat *matrix.Matrix) (
 	// cache raw data for next poll
 	cachedData := curMat.Clone(matrix.With{Data: true, Metrics: true, Instances: true, ExportInstances: true, PartialInstances: true})
 
+	// export raw counters, before they are cooked
+	var rawMat *matrix.Matrix
+	if kp.ExportRaw() {
+		rawMat = collector.RawCounters(curMat, kp.rawCounter)
+	}
+
 	orderedNonDenominatorMetrics := make([]*matrix.Metric, 0, len(curMat.GetMetrics()))
 	orderedNonDenominatorKeys := make([]string, 0, len(orderedNonDenominatorMetrics))
 
@@ -550,9 +575,13 @@ func (kp *KeyPerf) cookCounters(curMat *matrix.Matrix, prevMat *matrix.Matrix) (
 
 	// store cache for next poll
 	kp.Matrix[kp.Object] = cachedData
+	kp.counterState.Save(cachedData)
 
 	newDataMap := make(map[string]*matrix.Matrix)
 	newDataMap[kp.Object] = curMat
+	if rawMat != nil {
+		newDataMap[kp.Object+collector.RawCounterIdentifier] = rawMat
+	}
 	return newDataMap, nil
 }
 
@@ -0,0 +1,260 @@
+package health
+
+import (
+	"github.com/netapp/harvest/v2/cmd/collectors"
+	"github.com/netapp/harvest/v2/cmd/tools/rest"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/slogx"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"github.com/netapp/harvest/v2/third_party/tidwall/gjson"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+var checkNameRe = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// check is a health check declared in the checks section of the template
+type check struct {
+	name     string
+	matrix   string
+	query    string
+	filter   []string
+	key      []string
+	labels   []labelField
+	severity AlertSeverity
+	// pending is how long a record must match the filter before it is an alert
+	pending time.Duration
+	// resolve is a filter that an alert must match to be resolved, when empty an alert is resolved when it no
+	// longer matches filter
+	resolve []string
+	// firstSeen is when each record that matches filter was first seen
+	firstSeen map[string]time.Time
+}
+
+// labelField is a field of the REST response exported as a label
+type labelField struct {
+	field string
+	label string
+}
+
+func parseChecks(checks *node.Node) ([]*check, error) {
+	if checks == nil {
+		return nil, nil
+	}
+	builtIn := []string{diskHealthMatrix, shelfHealthMatrix, supportHealthMatrix, nodeHealthMatrix,
+		networkEthernetPortHealthMatrix, networkFCPortHealthMatrix, lifHealthMatrix, volumeRansomwareHealthMatrix,
+		volumeMoveHealthMatrix, licenseHealthMatrix, haHealthMatrix, emsHealthMatrix}
+
+	parsed := make([]*check, 0, len(checks.GetChildren()))
+	for _, n := range checks.GetChildren() {
+		c := &check{
+			name:      n.GetNameS(),
+			query:     n.GetChildContentS("query"),
+			severity:  AlertSeverity(n.GetChildContentS("severity")),
+			firstSeen: make(map[string]time.Time),
+		}
+		if !checkNameRe.MatchString(c.name) {
+			return nil, errs.New(errs.ErrInvalidParam, "check name "+c.name+", use lowercase letters, digits, and _")
+		}
+		c.matrix = "health_" + c.name
+		if slices.Contains(builtIn, c.matrix) {
+			return nil, errs.New(errs.ErrInvalidParam, "check "+c.name+" has the name of a built-in check")
+		}
+		if c.query == "" {
+			return nil, errs.New(errs.ErrMissingParam, "query of check "+c.name)
+		}
+		switch c.severity {
+		case "":
+			c.severity = warning
+		case warning, errr:
+		default:
+			return nil, errs.New(errs.ErrInvalidParam, "severity of check "+c.name+", use warning or error")
+		}
+		if f := n.GetChildS("filter"); f != nil {
+			c.filter = f.GetAllChildContentS()
+		}
+		if r := n.GetChildS("resolve"); r != nil {
+			c.resolve = r.GetAllChildContentS()
+		}
+		if l := n.GetChildS("labels"); l != nil {
+			for _, line := range l.GetAllChildContentS() {
+				field, label, ok := strings.Cut(line, "=>")
+				field = strings.TrimSpace(field)
+				label = strings.TrimSpace(label)
+				if !ok {
+					label = strings.ReplaceAll(field, ".", "_")
+				}
+				if field == "" || label == "" {
+					return nil, errs.New(errs.ErrInvalidParam, "label "+line+" of check "+c.name)
+				}
+				c.labels = append(c.labels, labelField{field: field, label: label})
+			}
+		}
+		if len(c.labels) == 0 {
+			return nil, errs.New(errs.ErrMissingParam, "labels of check "+c.name)
+		}
+		if k := n.GetChildS("key"); k != nil {
+			c.key = k.GetAllChildContentS()
+			if len(c.key) == 0 && k.GetContentS() != "" {
+				c.key = []string{k.GetContentS()}
+			}
+		}
+		if len(c.key) == 0 {
+			for _, l := range c.labels {
+				c.key = append(c.key, l.field)
+			}
+		}
+		if d := n.GetChildContentS("for"); d != "" {
+			var err error
+			if c.pending, err = time.ParseDuration(d); err != nil {
+				return nil, errs.New(errs.ErrInvalidParam, "for of check "+c.name+": "+err.Error())
+			}
+		}
+		parsed = append(parsed, c)
+	}
+	return parsed, nil
+}
+
+// fields returns the fields to request, the key and label fields
+func (c *check) fields() []string {
+	fields := slices.Clone(c.key)
+	for _, l := range c.labels {
+		if !slices.Contains(fields, l.field) {
+			fields = append(fields, l.field)
+		}
+	}
+	return fields
+}
+
+func (c *check) recordKey(record gjson.Result) string {
+	values := make([]string, 0, len(c.key))
+	for _, k := range c.key {
+		values = append(values, record.Get(k).ClonedString())
+	}
+	return strings.Join(values, ".")
+}
+
+func (h *Health) getCheckRecords(c *check, filter []string) ([]gjson.Result, error) {
+	href := rest.NewHrefBuilder().
+		APIPath(c.query).
+		Fields(c.fields()).
+		MaxRecords(collectors.DefaultBatchSize).
+		Filter(filter).
+		Build()
+
+	return collectors.InvokeRestCall(h.client, href)
+}
+
+// collectCheckAlerts runs a check declared in the template and returns the number of alerts
+func (h *Health) collectCheckAlerts(c *check, now time.Time) int {
+	records, err := h.fetchCheck(c, c.filter)
+	if err != nil {
+		if errs.IsRestErr(err, errs.APINotFound) {
+			h.SLogger.Debug("API not found", slogx.Err(err), slog.String("check", c.name))
+		} else {
+			h.SLogger.Error("Failed to collect check", slogx.Err(err), slog.String("check", c.name))
+		}
+		// keep the alerts of the previous poll, they are neither raised again nor resolved
+		h.keepAlerts(c, nil)
+		return len(h.data[c.matrix].GetInstances())
+	}
+
+	mat := h.data[c.matrix]
+	seen := make(map[string]time.Time, len(records))
+	alertCount := 0
+	for _, record := range records {
+		key := c.recordKey(record)
+		first, ok := c.firstSeen[key]
+		if !ok {
+			first = now
+		}
+		seen[key] = first
+		if now.Sub(first) < c.pending {
+			continue
+		}
+		instance, err := mat.NewInstance(key)
+		if err != nil {
+			h.SLogger.Warn("error while creating instance", slog.String("key", key), slog.String("check", c.name))
+			continue
+		}
+		alertCount++
+		for _, l := range c.labels {
+			instance.SetLabel(l.label, record.Get(l.field).ClonedString())
+		}
+		instance.SetLabel(severityLabel, string(c.severity))
+		h.setAlertMetric(mat, instance, 1)
+	}
+	c.firstSeen = seen
+
+	if len(c.resolve) > 0 {
+		alertCount += h.keepUnresolvedAlerts(c)
+	}
+	return alertCount
+}
+
+// keepUnresolvedAlerts keeps the alerts of the previous poll that no longer match the filter of the check,
+// but do not match its resolve filter yet
+func (h *Health) keepUnresolvedAlerts(c *check) int {
+	prevMat := h.previousData[c.matrix]
+	if prevMat == nil {
+		return 0
+	}
+	curMat := h.data[c.matrix]
+	missing := false
+	for key := range prevMat.GetInstances() {
+		if curMat.GetInstance(key) == nil {
+			missing = true
+			break
+		}
+	}
+	if !missing {
+		return 0
+	}
+
+	records, err := h.fetchCheck(c, c.resolve)
+	if err != nil {
+		h.SLogger.Error("Failed to collect resolved records", slogx.Err(err), slog.String("check", c.name))
+		return h.keepAlerts(c, nil)
+	}
+	resolved := make(map[string]bool, len(records))
+	for _, record := range records {
+		resolved[c.recordKey(record)] = true
+	}
+	return h.keepAlerts(c, resolved)
+}
+
+// keepAlerts copies the alerts of the previous poll, that are not in the current poll nor resolved,
+// into the current poll. It returns the number of copied alerts.
+func (h *Health) keepAlerts(c *check, resolved map[string]bool) int {
+	prevMat := h.previousData[c.matrix]
+	if prevMat == nil {
+		return 0
+	}
+	curMat := h.data[c.matrix]
+	kept := 0
+	for key, prevInstance := range prevMat.GetInstances() {
+		if curMat.GetInstance(key) != nil || resolved[key] {
+			continue
+		}
+		instance, err := curMat.NewInstance(key)
+		if err != nil {
+			continue
+		}
+		instance.SetLabels(prevInstance.GetLabels())
+		h.setAlertMetric(curMat, instance, 1)
+		kept++
+	}
+	return kept
+}
+
+// checkMatrices returns the names of the matrices of the checks
+func checkMatrices(checks []*check) []string {
+	names := make([]string, 0, len(checks))
+	for _, c := range checks {
+		names = append(names, c.matrix)
+	}
+	return names
+}
@@ -48,6 +48,9 @@ type Health struct {
 	previousData   map[string]*matrix.Matrix
 	resolutionData map[string]*matrix.Matrix
 	emsSeverity    []string
+	checks         []*check
+	// fetchCheck returns the records of a check that match a filter
+	fetchCheck func(c *check, filter []string) ([]gjson.Result, error)
 }
 
 func New(p *plugin.AbstractPlugin) plugin.Plugin {
@@ -66,6 +69,11 @@ func (h *Health) Init(remote conf.Remote) error {
 		return err
 	}
 
+	if h.checks, err = parseChecks(h.Params.GetChildS("checks")); err != nil {
+		return err
+	}
+	h.fetchCheck = h.getCheckRecords
+
 	if err := h.InitAllMatrix(); err != nil {
 		return err
 	}
@@ -98,6 +106,7 @@ func (h *Health) InitAllMatrix() error {
 	mats := []string{diskHealthMatrix, shelfHealthMatrix, supportHealthMatrix, nodeHealthMatrix,
 		networkEthernetPortHealthMatrix, networkFCPortHealthMatrix, lifHealthMatrix,
 		volumeRansomwareHealthMatrix, volumeMoveHealthMatrix, licenseHealthMatrix, haHealthMatrix}
+	mats = append(mats, checkMatrices(h.checks)...)
 	for _, m := range mats {
 		if err := h.initMatrix(m, "", h.data); err != nil {
 			return err
@@ -185,6 +194,11 @@ func (h *Health) Run(dataMap map[string]*matrix.Matrix) ([]*matrix.Matrix, *util
 	volumeMoveAlertCount := h.collectVolumeMoveAlerts()
 	licenseAlertCount := h.collectLicenseAlerts()
 	emsAlertCount := h.collectEmsAlerts(emsMat)
+	checkAlertCount := 0
+	now := time.Now()
+	for _, c := range h.checks {
+		checkAlertCount += h.collectCheckAlerts(c, now)
+	}
 
 	resolutionInstancesCount := h.generateResolutionMetrics()
 
@@ -213,12 +227,13 @@ func (h *Health) Run(dataMap map[string]*matrix.Matrix) ([]*matrix.Matrix, *util
 		slog.Int("numShelfAlerts", shelfAlertCount),
 		slog.Int("numDiskAlerts", diskAlertCount),
 		slog.Int("numEmsAlerts", emsAlertCount),
+		slog.Int("numCheckAlerts", checkAlertCount),
 		slog.Int("numResolutionInstanceCount", resolutionInstancesCount),
 	)
 
 	//nolint:gosec
 	h.client.Metadata.PluginInstances = uint64(diskAlertCount + shelfAlertCount + supportAlertCount + nodeAlertCount + HAAlertCount + networkEthernetPortAlertCount + networkFcpPortAlertCount +
-		networkInterfaceAlertCount + volumeRansomwareAlertCount + volumeMoveAlertCount + licenseAlertCount + emsAlertCount + resolutionInstancesCount)
+		networkInterfaceAlertCount + volumeRansomwareAlertCount + volumeMoveAlertCount + licenseAlertCount + emsAlertCount + checkAlertCount + resolutionInstancesCount)
 
 	return result, h.client.Metadata, nil
 }
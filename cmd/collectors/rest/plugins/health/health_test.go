@@ -3,8 +3,12 @@ package health
 import (
 	"github.com/netapp/harvest/v2/cmd/poller/plugin"
 	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/tree"
+	"github.com/netapp/harvest/v2/third_party/tidwall/gjson"
 	"log/slog"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestEndPoll(t *testing.T) {
@@ -60,3 +64,114 @@ func TestEndPoll(t *testing.T) {
 		}
 	}
 }
+
+func newCheckHealth(t *testing.T, template string) *Health {
+	t.Helper()
+	params, err := tree.LoadYaml([]byte(template))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &Health{AbstractPlugin: plugin.New("health", nil, params, nil, "health", nil)}
+	h.SLogger = slog.Default()
+	if h.checks, err = parseChecks(params.GetChildS("checks")); err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// poll runs the checks with the records of each filter, and returns the alert and resolution matrices
+func poll(t *testing.T, h *Health, now time.Time, records map[string]string) (*matrix.Matrix, *matrix.Matrix) {
+	t.Helper()
+	h.fetchCheck = func(_ *check, filter []string) ([]gjson.Result, error) {
+		return gjson.Parse(records[strings.Join(filter, ",")]).Array(), nil
+	}
+	if err := h.InitAllMatrix(); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range h.checks {
+		h.collectCheckAlerts(c, now)
+	}
+	h.generateResolutionMetrics()
+	return h.previousData["health_snapmirror"], h.resolutionData["health_snapmirror"]
+}
+
+func TestChecks(t *testing.T) {
+	h := newCheckHealth(t, `
+checks:
+  snapmirror:
+    query: api/snapmirror/relationships
+    filter:
+      - healthy=false
+    key: uuid
+    labels:
+      - source.path => source
+      - destination.path => destination
+      - state
+    severity: error
+    for: 1h
+    resolve:
+      - healthy=true
+`)
+	const (
+		unhealthy = "healthy=false"
+		healthy   = "healthy=true"
+		rel1      = `{"uuid":"1","source":{"path":"svm1:vol1"},"destination":{"path":"svm2:vol1"},"state":"snapmirrored"}`
+		rel2      = `{"uuid":"2","source":{"path":"svm1:vol2"},"destination":{"path":"svm2:vol2"},"state":"broken_off"}`
+	)
+	start := time.Now()
+
+	// unhealthy for less than an hour, no alerts yet
+	alerts, _ := poll(t, h, start, map[string]string{unhealthy: "[" + rel1 + "," + rel2 + "]"})
+	if n := len(alerts.GetInstances()); n != 0 {
+		t.Errorf("got %d alerts before the for duration, want=0", n)
+	}
+
+	alerts, _ = poll(t, h, start.Add(time.Hour), map[string]string{unhealthy: "[" + rel1 + "," + rel2 + "]"})
+	if n := len(alerts.GetInstances()); n != 2 {
+		t.Fatalf("got %d alerts, want=2", n)
+	}
+	instance := alerts.GetInstance("2")
+	if instance.GetLabel("source") != "svm1:vol2" || instance.GetLabel("state") != "broken_off" ||
+		instance.GetLabel(severityLabel) != "error" {
+		t.Errorf("got labels %v", instance.GetLabels())
+	}
+
+	// relationship 2 is no longer unhealthy, but is not healthy yet
+	alerts, resolutions := poll(t, h, start.Add(2*time.Hour), map[string]string{unhealthy: "[" + rel1 + "]", healthy: "[]"})
+	if n := len(alerts.GetInstances()); n != 2 {
+		t.Errorf("got %d alerts, want=2 until relationship 2 is healthy", n)
+	}
+	if n := len(resolutions.GetInstances()); n != 0 {
+		t.Errorf("got %d resolutions, want=0", n)
+	}
+
+	// relationship 2 is healthy
+	alerts, resolutions = poll(t, h, start.Add(3*time.Hour), map[string]string{unhealthy: "[" + rel1 + "]", healthy: "[" + rel2 + "]"})
+	if n := len(alerts.GetInstances()); n != 1 {
+		t.Errorf("got %d alerts, want=1", n)
+	}
+	if resolutions.GetInstance("2") == nil {
+		t.Fatal("relationship 2 is not resolved")
+	}
+	if v, _ := resolutions.GetMetric("alerts").GetValueFloat64(resolutions.GetInstance("2")); v != 0 {
+		t.Errorf("resolution alerts got=%f, want=0", v)
+	}
+}
+
+func TestInvalidChecks(t *testing.T) {
+	for _, template := range []string{
+		"checks:\n  Bad-Name:\n    query: api/storage/aggregates\n    labels:\n      - name\n",
+		"checks:\n  disk:\n    query: api/storage/disks\n    labels:\n      - name\n",
+		"checks:\n  aggr:\n    labels:\n      - name\n",
+		"checks:\n  aggr:\n    query: api/storage/aggregates\n",
+		"checks:\n  aggr:\n    query: api/storage/aggregates\n    severity: critical\n    labels:\n      - name\n",
+	} {
+		params, err := tree.LoadYaml([]byte(template))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parseChecks(params.GetChildS("checks")); err == nil {
+			t.Errorf("expected an error for\n%s", template)
+		}
+	}
+}
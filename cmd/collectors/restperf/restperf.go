@@ -73,6 +73,7 @@ type RestPerf struct {
 	pollInstanceCalls   int
 	pollDataCalls       int
 	recordsToSave       int // Number of records to save when using the recorder
+	counterState        *collector.CounterState
 }
 
 type counter struct {
@@ -151,6 +152,10 @@ func (r *RestPerf) Init(a *collector.AbstractCollector) error {
 
 	r.recordsToSave = collector.RecordKeepLast(r.Params, r.Logger)
 
+	if r.counterState, err = collector.NewCounterState(r.AbstractCollector); err != nil {
+		return err
+	}
+
 	r.Logger.Debug(
 		"initialized cache",
 		slog.Int("numMetrics", len(r.Prop.Metrics)),
@@ -1175,12 +1180,17 @@ func (r *RestPerf) cookCounters(curMat *matrix.Matrix, prevMat *matrix.Matrix) (
 		skips int
 	)
 
-	// skip calculating from delta if no data from previous poll
+	// skip calculating from delta if no data from previous poll, unless it was restored from disk
 	if r.perfProp.isCacheEmpty {
-		r.Logger.Debug("skip postprocessing until next poll (previous cache empty)")
-		r.Matrix[r.Object] = curMat
 		r.perfProp.isCacheEmpty = false
-		return nil, nil
+		restored := r.counterState.Restore(curMat)
+		if restored == nil {
+			r.Logger.Debug("skip postprocessing until next poll (previous cache empty)")
+			r.Matrix[r.Object] = curMat
+			r.counterState.Save(curMat)
+			return nil, nil
+		}
+		prevMat = restored
 	}
 
 	calcStart := time.Now()
@@ -1188,6 +1198,12 @@ func (r *RestPerf) cookCounters(curMat *matrix.Matrix, prevMat *matrix.Matrix) (
 	// cache raw data for next poll
 	cachedData := curMat.Clone(matrix.With{Data: true, Metrics: true, Instances: true, ExportInstances: true, PartialInstances: true})
 
+	// export raw counters, before they are cooked
+	var rawMat *matrix.Matrix
+	if r.ExportRaw() {
+		rawMat = collector.RawCounters(curMat, r.rawCounter)
+	}
+
 	orderedNonDenominatorMetrics := make([]*matrix.Metric, 0, len(curMat.GetMetrics()))
 	orderedNonDenominatorKeys := make([]string, 0, len(orderedNonDenominatorMetrics))
 
@@ -1363,9 +1379,13 @@ func (r *RestPerf) cookCounters(curMat *matrix.Matrix, prevMat *matrix.Matrix) (
 
 	// store cache for next poll
 	r.Matrix[r.Object] = cachedData
+	r.counterState.Save(cachedData)
 
 	newDataMap := make(map[string]*matrix.Matrix)
 	newDataMap[r.Object] = curMat
+	if rawMat != nil {
+		newDataMap[r.Object+collector.RawCounterIdentifier] = rawMat
+	}
 	return newDataMap, nil
 }
 
@@ -1484,6 +1504,15 @@ func (r *RestPerf) counterLookup(metric *matrix.Metric, metricKey string) *count
 	return c
 }
 
+// rawCounter returns how a counter is cooked, used when raw counters are exported
+func (r *RestPerf) rawCounter(key string, metric *matrix.Metric) (collector.RawCounter, bool) {
+	c := r.counterLookup(metric, key)
+	if c == nil {
+		return collector.RawCounter{}, false
+	}
+	return collector.RawCounter{Property: c.counterType, Base: c.denominator}, true
+}
+
 func (r *RestPerf) LoadPlugin(kind string, p *plugin.AbstractPlugin) plugin.Plugin {
 	switch kind {
 	case "Nic":
@@ -0,0 +1,152 @@
+package storagegrid
+
+import (
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/slogx"
+	"github.com/netapp/harvest/v2/third_party/tidwall/gjson"
+	"log/slog"
+	"time"
+)
+
+// Alerts are StorageGRID's equivalent of ONTAP EMS events. An object whose template sets `alerts: true` is
+// polled like a REST object, but each alert is exported with an alerts metric that is 1 while the alert is active.
+// Like the bookends of the Ems collector, a resolved alert is exported once with a value of 0 and then removed.
+// An alert is resolved when StorageGRID reports it as resolved, or when it is no longer returned.
+const (
+	alertMetric         = "alerts"
+	alertStatusField    = "status"
+	alertResolvedField  = "resolvedTime"
+	alertResolvedStatus = "resolved"
+)
+
+func (s *StorageGrid) isAlerts() bool {
+	return s.Params.GetChildContentS("alerts") == "true"
+}
+
+func (s *StorageGrid) pollAlerts() (map[string]*matrix.Matrix, error) {
+	var (
+		apiD, parseD time.Duration
+		records      []gjson.Result
+	)
+
+	startTime := time.Now()
+	// Unlike other objects, no alerts is a healthy grid, not an error
+	if err := s.getRest(s.Props.Query, &records); err != nil {
+		return nil, err
+	}
+	apiD = time.Since(startTime)
+
+	startTime = time.Now()
+	count := s.handleAlerts(records)
+	parseD = time.Since(startTime)
+
+	numRecords := len(s.Matrix[s.Object].GetInstances())
+
+	_ = s.Metadata.LazySetValueInt64("api_time", "data", apiD.Microseconds())
+	_ = s.Metadata.LazySetValueInt64("parse_time", "data", parseD.Microseconds())
+	_ = s.Metadata.LazySetValueUint64("metrics", "data", count)
+	_ = s.Metadata.LazySetValueInt64("instances", "data", int64(numRecords))
+	_ = s.Metadata.LazySetValueUint64("bytesRx", "data", s.client.Metadata.BytesRx)
+	_ = s.Metadata.LazySetValueUint64("numCalls", "data", s.client.Metadata.NumCalls)
+
+	s.AddCollectCount(count)
+
+	return s.Matrix, nil
+}
+
+// handleAlerts updates the alert matrix with the alerts returned by StorageGRID and returns the number of
+// labels and metrics collected. Instances are not reset between polls since resolving an alert requires
+// knowing it was active.
+func (s *StorageGrid) handleAlerts(records []gjson.Result) uint64 {
+	var count uint64
+
+	mat := s.Matrix[s.Object]
+	metric := mat.GetMetric(alertMetric)
+	if metric == nil {
+		var err error
+		if metric, err = mat.NewMetricFloat64(alertMetric); err != nil {
+			s.Logger.Error("NewMetricFloat64", slogx.Err(err), slog.String("name", alertMetric))
+			return 0
+		}
+	}
+
+	// Alerts that were exported as resolved during the previous poll are done
+	active := make(map[string]bool)
+	for key, instance := range mat.GetInstances() {
+		if v, ok := metric.GetValueFloat64(instance); ok && v == 0 {
+			mat.RemoveInstance(key)
+			s.Logger.Debug("removed resolved alert", slog.String("key", key))
+			continue
+		}
+		active[key] = true
+	}
+
+	for _, record := range records {
+		if !record.IsObject() {
+			s.Logger.Warn("Alert is not object, skipping", slog.String("type", record.Type.String()))
+			continue
+		}
+
+		var instanceKey string
+		for _, k := range s.Props.InstanceKeys {
+			value := record.Get(k)
+			if !value.Exists() {
+				s.Logger.Warn("skip alert, missing key", slog.String("key", k))
+				instanceKey = ""
+				break
+			}
+			instanceKey += value.ClonedString()
+		}
+		if instanceKey == "" {
+			continue
+		}
+
+		resolved := record.Get(alertStatusField).ClonedString() == alertResolvedStatus ||
+			record.Get(alertResolvedField).ClonedString() != ""
+
+		instance := mat.GetInstance(instanceKey)
+		if instance == nil {
+			// There is nothing to flip for an alert that was resolved before Harvest saw it
+			if resolved {
+				continue
+			}
+			var err error
+			if instance, err = mat.NewInstance(instanceKey); err != nil {
+				s.Logger.Error("", slogx.Err(err), slog.String("instanceKey", instanceKey))
+				continue
+			}
+		}
+		delete(active, instanceKey)
+
+		for label, display := range s.Props.InstanceLabels {
+			if value := record.Get(label); value.Exists() {
+				instance.SetLabel(display, value.ClonedString())
+				count++
+			}
+		}
+
+		value := 1.0
+		if resolved {
+			value = 0
+			instance.SetLabel(alertStatusField, alertResolvedStatus)
+		}
+		if err := metric.SetValueFloat64(instance, value); err != nil {
+			s.Logger.Error("Unable to set alert", slogx.Err(err), slog.String("instanceKey", instanceKey))
+			continue
+		}
+		count++
+	}
+
+	// Active alerts that StorageGRID no longer returns have been resolved
+	for key := range active {
+		instance := mat.GetInstance(key)
+		instance.SetLabel(alertStatusField, alertResolvedStatus)
+		if err := metric.SetValueFloat64(instance, 0); err != nil {
+			s.Logger.Error("Unable to resolve alert", slogx.Err(err), slog.String("instanceKey", key))
+			continue
+		}
+		count++
+	}
+
+	return count
+}
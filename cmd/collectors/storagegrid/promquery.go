@@ -0,0 +1,301 @@
+package storagegrid
+
+import (
+	srest "github.com/netapp/harvest/v2/cmd/collectors/storagegrid/rest"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/slogx"
+	"github.com/netapp/harvest/v2/third_party/tidwall/gjson"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultMaxQueryLength is the default maximum length of an encoded PromQL query. It keeps the request URL
+	// well below the limits of proxies and load balancers in front of the admin node
+	defaultMaxQueryLength = 2000
+	// defaultMaxConcurrentQueries is the default number of PromQL queries sent to the admin node at the same time
+	defaultMaxConcurrentQueries = 4
+)
+
+// metricNameRe matches the names that can be combined into a single {__name__=~"a|b"} query.
+// Anything else, e.g. an expression, is queried on its own
+var metricNameRe = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// promQuery is a PromQL query and the template metrics it returns
+type promQuery struct {
+	query   string
+	metrics []string
+}
+
+// promResult is the outcome of a promQuery
+type promResult struct {
+	query    promQuery
+	body     []byte
+	duration time.Duration
+	err      error
+	retries  []promResult // when the combined query failed, the results of its metrics queried one by one
+}
+
+// promSummary totals the results of the queries of a poll
+type promSummary struct {
+	count        uint64
+	numRecords   int
+	sent         int // queries sent, including retries
+	queries      int // queries whose data was used, retried combined queries are not counted
+	failed       int // queries whose metrics were lost
+	maxQueryTime time.Duration
+}
+
+// promQueries combines metric names into as few queries as possible, keeping the encoded length of each
+// query at or below maxLength. A name that does not fit into a combined query is queried on its own
+func promQueries(names []string, maxLength int) []promQuery {
+	var (
+		queries []promQuery
+		batch   []string
+	)
+
+	flush := func() {
+		switch len(batch) {
+		case 0:
+			return
+		case 1:
+			queries = append(queries, promQuery{query: batch[0], metrics: batch})
+		default:
+			queries = append(queries, promQuery{query: nameSelector(batch), metrics: batch})
+		}
+		batch = nil
+	}
+
+	for _, name := range names {
+		if !metricNameRe.MatchString(name) {
+			queries = append(queries, promQuery{query: name, metrics: []string{name}})
+			continue
+		}
+		if len(batch) > 0 && len(url.QueryEscape(nameSelector(append(slices.Clip(batch), name)))) > maxLength {
+			flush()
+		}
+		batch = append(batch, name)
+	}
+	flush()
+	return queries
+}
+
+func nameSelector(names []string) string {
+	return `{__name__=~"` + strings.Join(names, "|") + `"}`
+}
+
+func (s *StorageGrid) initPromQueries() error {
+	s.maxQueryLength = defaultMaxQueryLength
+	if v := s.Params.GetChildContentS("max_query_length"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return errs.New(errs.ErrInvalidParam, "max_query_length="+v)
+		}
+		s.maxQueryLength = n
+	}
+	s.maxConcurrentQueries = defaultMaxConcurrentQueries
+	if v := s.Params.GetChildContentS("max_concurrent_queries"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return errs.New(errs.ErrInvalidParam, "max_concurrent_queries="+v)
+		}
+		s.maxConcurrentQueries = n
+	}
+	for _, name := range []string{"queries", "failed_queries", "max_query_time", "query_time", "query_failed", "query_retried"} {
+		if _, err := s.Metadata.NewMetricUint64(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runPromQueries sends the queries to the admin node with at most maxConcurrentQueries in flight.
+// The results are in the order of queries. When a combined query fails, its metrics are queried one by one,
+// so a single bad metric does not discard the others
+func (s *StorageGrid) runPromQueries(queries []promQuery) []promResult {
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+
+	results := make([]promResult, len(queries))
+	work := make(chan int)
+	workers := min(s.maxConcurrentQueries, len(queries))
+	for range workers {
+		client := s.client.Fork()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				q := queries[i]
+				r := runPromQuery(client, q)
+				if r.err != nil && len(q.metrics) > 1 {
+					for _, name := range q.metrics {
+						r.retries = append(r.retries, runPromQuery(client, promQuery{query: name, metrics: []string{name}}))
+					}
+				}
+				results[i] = r
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			s.client.Join(client)
+		}()
+	}
+	for i := range queries {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	return results
+}
+
+func runPromQuery(client *srest.Client, q promQuery) promResult {
+	start := time.Now()
+	body, err := client.QueryMetrics(q.query)
+	return promResult{query: q, body: body, duration: time.Since(start), err: err}
+}
+
+// promMatrices splits the series of a successful query by metric name and converts each metric into a matrix
+func (s *StorageGrid) promMatrices(r promResult) (map[string]*matrix.Matrix, error) {
+	data := gjson.GetBytes(r.body, "data")
+	resultType := data.Get("resultType").ClonedString()
+	series := make(map[string][]gjson.Result, len(r.query.metrics))
+	all := data.Get("result").Array()
+	for _, one := range all {
+		name := one.Get(`metric.__name__`).ClonedString()
+		series[name] = append(series[name], one)
+	}
+	// A query for a single metric, which may be an expression, has no name to split by
+	if len(r.query.metrics) == 1 {
+		series[r.query.metrics[0]] = all
+	}
+
+	mats := make(map[string]*matrix.Matrix, len(r.query.metrics))
+	for _, name := range r.query.metrics {
+		display := name
+		if metric, ok := s.Props.Metrics[name]; ok && metric.Label != "" {
+			display = metric.Label
+		}
+		mat, err := s.makePromMetrics(display, resultType, series[name], nil)
+		if err != nil {
+			return nil, err
+		}
+		mats[name] = mat
+	}
+	return mats, nil
+}
+
+// processPromResults converts the results of the queries into matrices, keyed by metric name, and records the
+// duration and outcome of each query in the collector's metadata. A query only counts as failed when its metrics are
+// lost, i.e. not when it is a combined query whose metrics were queried one by one
+func (s *StorageGrid) processPromResults(results []promResult) (map[string]*matrix.Matrix, promSummary) {
+	var summary promSummary
+	metrics := make(map[string]*matrix.Matrix)
+
+	s.resetQueryMetadata()
+	for i, r := range results {
+		key := strconv.Itoa(i)
+		if len(r.retries) == 0 {
+			s.processPromResult(key, r, metrics, &summary)
+			continue
+		}
+		s.logPromResult(r)
+		summary.sent++
+		summary.maxQueryTime = max(summary.maxQueryTime, r.duration)
+		s.setQueryMetadata(key, r, false)
+		for j, retry := range r.retries {
+			s.processPromResult(key+"-"+strconv.Itoa(j), retry, metrics, &summary)
+		}
+	}
+	return metrics, summary
+}
+
+func (s *StorageGrid) processPromResult(key string, r promResult, metrics map[string]*matrix.Matrix, summary *promSummary) {
+	s.logPromResult(r)
+	summary.sent++
+	summary.queries++
+	summary.maxQueryTime = max(summary.maxQueryTime, r.duration)
+	if r.err != nil {
+		summary.failed++
+		s.setQueryMetadata(key, r, true)
+		return
+	}
+	metricsByName, err := s.promMatrices(r)
+	if err != nil {
+		s.Logger.Error("failed to parse metrics", slogx.Err(err), slog.Any("metrics", r.query.metrics))
+		summary.failed++
+		s.setQueryMetadata(key, r, true)
+		return
+	}
+	s.setQueryMetadata(key, r, false)
+	for name, mat := range metricsByName {
+		metrics[name] = mat
+		numInstances := len(mat.GetInstances())
+		if numInstances == 0 {
+			s.Logger.Warn("no instances on storagegrid", slog.String("metric", name))
+			continue
+		}
+		summary.count += uint64(numInstances)
+		summary.numRecords += numInstances
+	}
+}
+
+// queryInstancePrefix is the key prefix of the metadata instances of the queries of the last poll.
+// They are keyed by the position of the query, and of the retry, which is the same on every poll of a template
+const queryInstancePrefix = "query-"
+
+func (s *StorageGrid) resetQueryMetadata() {
+	for key := range s.Metadata.GetInstances() {
+		if strings.HasPrefix(key, queryInstancePrefix) {
+			s.Metadata.RemoveInstance(key)
+		}
+	}
+}
+
+// setQueryMetadata adds a metadata instance for the query with its duration, whether its metrics were lost, and
+// whether it was retried metric by metric. The metrics label identifies the query, the query itself can be too long
+// for a label
+func (s *StorageGrid) setQueryMetadata(key string, r promResult, lost bool) {
+	instance, err := s.Metadata.NewInstance(queryInstancePrefix + key)
+	if err != nil {
+		s.Logger.Error("failed to add query metadata", slogx.Err(err))
+		return
+	}
+	instance.SetLabel("task", "data")
+	instance.SetLabel("metrics", strings.Join(r.query.metrics, ","))
+	_ = s.Metadata.GetMetric("query_time").SetValueUint64(instance, uint64(r.duration.Microseconds())) //nolint:gosec
+	_ = s.Metadata.GetMetric("query_failed").SetValueUint64(instance, boolToUint64(lost))
+	_ = s.Metadata.GetMetric("query_retried").SetValueUint64(instance, boolToUint64(len(r.retries) > 0))
+}
+
+func boolToUint64(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (s *StorageGrid) logPromResult(r promResult) {
+	if r.err != nil {
+		s.Logger.Error(
+			"failed to query metrics",
+			slogx.Err(r.err),
+			slog.Any("metrics", r.query.metrics),
+			slog.Duration("duration", r.duration),
+		)
+		return
+	}
+	s.Logger.Debug(
+		"queried metrics",
+		slog.Int("metrics", len(r.query.metrics)),
+		slog.Int("queryLength", len(r.query.query)),
+		slog.Duration("duration", r.duration),
+	)
+}
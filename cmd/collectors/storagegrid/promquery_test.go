@@ -0,0 +1,223 @@
+package storagegrid
+
+import (
+	"github.com/google/go-cmp/cmp"
+	srest "github.com/netapp/harvest/v2/cmd/collectors/storagegrid/rest"
+	"github.com/netapp/harvest/v2/pkg/auth"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPromQueries(t *testing.T) {
+	names := []string{"aa_bytes", "bb_bytes", "cc_bytes", "sum(dd_bytes)", "ee_bytes"}
+
+	tests := []struct {
+		name      string
+		maxLength int
+		want      [][]string
+	}{
+		{name: "one batch", maxLength: 2000, want: [][]string{{"sum(dd_bytes)"}, {"aa_bytes", "bb_bytes", "cc_bytes", "ee_bytes"}}},
+		{name: "split", maxLength: len(url.QueryEscape(nameSelector([]string{"aa_bytes", "bb_bytes"}))), want: [][]string{
+			{"aa_bytes", "bb_bytes"}, {"sum(dd_bytes)"}, {"cc_bytes", "ee_bytes"},
+		}},
+		{name: "too long", maxLength: 1, want: [][]string{{"aa_bytes"}, {"bb_bytes"}, {"sum(dd_bytes)"}, {"cc_bytes"}, {"ee_bytes"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got [][]string
+			for _, q := range promQueries(names, tt.maxLength) {
+				got = append(got, q.metrics)
+				if len(q.metrics) > 1 && len(url.QueryEscape(q.query)) > tt.maxLength {
+					t.Errorf("query=%s is longer than %d", q.query, tt.maxLength)
+				}
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("queries mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRunPromQueries(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		query := r.URL.Query().Get("query")
+		// The admin node rejects queries that include the broken metric
+		if strings.Contains(query, "broken") {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"status":"error","message":{"text":"bad query"}}`))
+			return
+		}
+		var series []string
+		for _, name := range []string{"aa_bytes", "bb_bytes", "cc_bytes", "dd_bytes"} {
+			if strings.Contains(query, name) {
+				series = append(series, `{"metric":{"__name__":"`+name+`","instance":"DC1-S1"},"value":[1700000000,"42"]}`)
+			}
+		}
+		_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[` + strings.Join(series, ",") + `]}}`))
+	}))
+	defer server.Close()
+
+	insecure := true
+	poller := &conf.Poller{
+		Addr:           strings.TrimPrefix(server.URL, "https://"),
+		Username:       "admin",
+		Password:       "password",
+		UseInsecureTLS: &insecure,
+	}
+	client, err := srest.New(poller, 10*time.Second, auth.NewCredentials(poller, slog.Default()))
+	if err != nil {
+		t.Fatalf("expected no error got %+v", err)
+	}
+
+	conf.TestLoadHarvestConfig("testdata/config.yml")
+	sg, err := newStorageGrid("Tenant", "tenant.yaml")
+	if err != nil {
+		t.Fatalf("failed to create new StorageGrid: %v", err)
+	}
+	sg.client = client
+	if err := sg.initPromQueries(); err != nil {
+		t.Fatalf("expected no error got %+v", err)
+	}
+	sg.maxConcurrentQueries = 2
+	names := []string{"aa_bytes", "bb_bytes", "broken_bytes", "cc_bytes", "dd_bytes"}
+	// Three queries: a batch with the broken metric, and two single metrics
+	queries := promQueries(names[:3], 1000)
+	queries = append(queries, promQuery{query: "cc_bytes", metrics: []string{"cc_bytes"}}, promQuery{query: "dd_bytes", metrics: []string{"dd_bytes"}})
+
+	results := sg.runPromQueries(queries)
+
+	// The results are in the order of the queries, whichever worker ran them
+	if len(results) != len(queries) {
+		t.Fatalf("got %d results, want %d", len(results), len(queries))
+	}
+	var all []promResult
+	for i, r := range results {
+		if r.query.query != queries[i].query {
+			t.Errorf("result %d got query=%s, want %s", i, r.query.query, queries[i].query)
+		}
+		all = append(all, r)
+		all = append(all, r.retries...)
+	}
+
+	var ok, failed []string
+	for _, r := range all {
+		if r.err != nil {
+			failed = append(failed, strings.Join(r.query.metrics, ","))
+			continue
+		}
+		mats, err := sg.promMatrices(r)
+		if err != nil {
+			t.Fatalf("expected no error got %+v", err)
+		}
+		for name, mat := range mats {
+			if len(mat.GetInstances()) != 1 {
+				t.Errorf("metric=%s got %d instances, want 1", name, len(mat.GetInstances()))
+			}
+			ok = append(ok, name)
+		}
+	}
+	slices.Sort(ok)
+	slices.Sort(failed)
+
+	if diff := cmp.Diff([]string{"aa_bytes", "bb_bytes", "cc_bytes", "dd_bytes"}, ok); diff != "" {
+		t.Errorf("metrics mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"aa_bytes,bb_bytes,broken_bytes", "broken_bytes"}, failed); diff != "" {
+		t.Errorf("failed queries mismatch (-want +got):\n%s", diff)
+	}
+	if maxInFlight.Load() > 2 {
+		t.Errorf("got %d concurrent queries, want at most 2", maxInFlight.Load())
+	}
+	// Only successful calls are counted
+	if client.Metadata.NumCalls != 4 {
+		t.Errorf("got numCalls=%d, want 4", client.Metadata.NumCalls)
+	}
+
+	// The combined query with the broken metric is retried metric by metric, only broken_bytes is lost
+	_, summary := sg.processPromResults(results)
+	if summary.queries != 5 || summary.failed != 1 {
+		t.Errorf("got queries=%d failed=%d, want queries=5 failed=1", summary.queries, summary.failed)
+	}
+	var lost, retried []string
+	numQueries := 0
+	for key, instance := range sg.Metadata.GetInstances() {
+		if !strings.HasPrefix(key, queryInstancePrefix) {
+			continue
+		}
+		numQueries++
+		if v, _ := sg.Metadata.GetMetric("query_failed").GetValueUint64(instance); v == 1 {
+			lost = append(lost, instance.GetLabel("metrics"))
+		}
+		if v, _ := sg.Metadata.GetMetric("query_retried").GetValueUint64(instance); v == 1 {
+			retried = append(retried, instance.GetLabel("metrics"))
+		}
+		if _, ok := sg.Metadata.GetMetric("query_time").GetValueUint64(instance); !ok || instance.GetLabel("metrics") == "" {
+			t.Errorf("query metadata %s is missing its time or metrics", key)
+		}
+		if instance.GetLabel("query") != "" {
+			t.Errorf("query metadata %s has a query label", key)
+		}
+	}
+	if numQueries != 6 {
+		t.Errorf("got %d query metadata instances, want 6", numQueries)
+	}
+	if diff := cmp.Diff([]string{"broken_bytes"}, lost); diff != "" {
+		t.Errorf("lost queries mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"aa_bytes,bb_bytes,broken_bytes"}, retried); diff != "" {
+		t.Errorf("retried queries mismatch (-want +got):\n%s", diff)
+	}
+	if summary.sent != 6 {
+		t.Errorf("got sent=%d, want 6", summary.sent)
+	}
+
+	// Each query keeps its metadata instance from poll to poll
+	wantKeys := map[string]string{
+		"query-0":   "aa_bytes,bb_bytes,broken_bytes",
+		"query-0-0": "aa_bytes",
+		"query-0-1": "bb_bytes",
+		"query-0-2": "broken_bytes",
+		"query-1":   "cc_bytes",
+		"query-2":   "dd_bytes",
+	}
+	for range 2 {
+		sg.processPromResults(sg.runPromQueries(queries))
+		for key, metrics := range wantKeys {
+			if instance := sg.Metadata.GetInstance(key); instance == nil || instance.GetLabel("metrics") != metrics {
+				t.Errorf("query metadata %s does not have metrics=%s", key, metrics)
+			}
+		}
+	}
+
+	// The query metadata of the previous poll is replaced
+	sg.processPromResults(results[1:2])
+	numQueries = 0
+	for key := range sg.Metadata.GetInstances() {
+		if strings.HasPrefix(key, queryInstancePrefix) {
+			numQueries++
+		}
+	}
+	if numQueries != 1 {
+		t.Errorf("got %d query metadata instances after the second poll, want 1", numQueries)
+	}
+}
@@ -181,6 +181,39 @@ func (c *Client) GetMetricQuery(metric string, result *[]gjson.Result) error {
 	return nil
 }
 
+// QueryMetrics makes a metrics API request with the given PromQL query and returns a json response as a []byte.
+// Unlike GetMetricQuery, the query is URL encoded, so it may contain selectors such as {__name__=~"a|b"}
+func (c *Client) QueryMetrics(query string) ([]byte, error) {
+	u, err := url.JoinPath(c.baseURL, "/metrics/api/v1/query")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s err: %w", query, err)
+	}
+	c.request, err = requests.New("GET", u+"?"+url.Values{"query": {query}}.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	c.request.Header.Set("Accept", "application/json")
+	c.request.Header.Set("Authorization", "Bearer "+c.token)
+	return c.invoke()
+}
+
+// Fork returns a client that shares c's connection pool and credentials, but has its own request state and
+// metadata. A Client is not safe for concurrent use, forks are. Call Join to merge a fork back into c
+func (c *Client) Fork() *Client {
+	fork := *c
+	fork.request = nil
+	fork.buffer = new(bytes.Buffer)
+	fork.Metadata = &util.Metadata{}
+	return &fork
+}
+
+// Join adds the metadata of fork to c and keeps the auth token, in case fork refreshed it
+func (c *Client) Join(fork *Client) {
+	c.Metadata.BytesRx += fork.Metadata.BytesRx
+	c.Metadata.NumCalls += fork.Metadata.NumCalls
+	c.token = fork.token
+}
+
 // getRest makes a request to the cluster and returns a json response as a []byte
 // see also Fetch
 func (c *Client) getRest(request string) ([]byte, error) {
@@ -423,14 +456,14 @@ func (c *Client) fetchTokenWithAuthRetry() error {
 	if err != nil {
 		var storageGridErr errs.StorageGridError
 		if errors.As(err, &storageGridErr) {
-			// If this is an auth failure and the client is using a credential script,
+			// If this is an auth failure and the client is using refreshable credentials,
 			// expire the current credentials, call the script again, and try again
 			if storageGridErr.IsAuthErr() {
 				pollerAuth, err2 := c.auth.GetPollerAuth()
 				if err2 != nil {
 					return err2
 				}
-				if pollerAuth.HasCredentialScript {
+				if pollerAuth.IsRefreshable() {
 					c.auth.Expire()
 					return fetchToken()
 				}
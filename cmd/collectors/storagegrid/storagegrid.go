@@ -44,8 +44,10 @@ type Metric struct {
 
 type StorageGrid struct {
 	*collector.AbstractCollector
-	client *srest.Client
-	Props  *prop
+	client               *srest.Client
+	Props                *prop
+	maxQueryLength       int
+	maxConcurrentQueries int
 }
 
 func init() {
@@ -83,6 +85,12 @@ func (s *StorageGrid) Init(a *collector.AbstractCollector) error {
 		return err
 	}
 
+	if s.Props.Query == "prometheus" {
+		if err := s.initPromQueries(); err != nil {
+			return err
+		}
+	}
+
 	s.Logger.Debug("initialized")
 	return nil
 }
@@ -142,52 +150,56 @@ func (s *StorageGrid) PollData() (map[string]*matrix.Matrix, error) {
 	if s.Props.Query == "prometheus" {
 		return s.pollPrometheusMetrics()
 	}
+	if s.isAlerts() {
+		return s.pollAlerts()
+	}
 	return s.pollRest()
 }
 
 func (s *StorageGrid) pollPrometheusMetrics() (map[string]*matrix.Matrix, error) {
 	var (
-		count      uint64
-		numRecords int
-		startTime  time.Time
-		apiD       time.Duration
+		startTime time.Time
+		apiD      time.Duration
+		parseD    time.Duration
+		names     []string
 	)
 
-	metrics := make(map[string]*matrix.Matrix)
 	s.Matrix[s.Object].Reset()
-	startTime = time.Now()
 
-	for _, metric := range s.Props.Metrics {
-		mat, err := s.GetMetric(metric.Name, metric.Label, nil)
-		if err != nil {
-			s.Logger.Error("failed to get metric", slogx.Err(err), slog.String("metric", metric.Name))
-			continue
-		}
-		metrics[metric.Name] = mat
-		numInstances := len(mat.GetInstances())
-		if numInstances == 0 {
-			s.Logger.Warn("no instances on storagegrid", slog.String("metric", metric.Name))
-			continue
-		}
-		count += uint64(numInstances)
-		numRecords += numInstances
+	for name := range s.Props.Metrics {
+		names = append(names, name)
 	}
+	slices.Sort(names)
+	queries := promQueries(names, s.maxQueryLength)
 
+	startTime = time.Now()
+	promResults := s.runPromQueries(queries)
 	apiD = time.Since(startTime)
 
+	startTime = time.Now()
+	metrics, summary := s.processPromResults(promResults)
+	parseD = time.Since(startTime)
+
 	_ = s.Metadata.LazySetValueInt64("api_time", "data", apiD.Microseconds())
-	_ = s.Metadata.LazySetValueInt64("parse_time", "data", 0)
-	_ = s.Metadata.LazySetValueUint64("metrics", "data", count)
-	_ = s.Metadata.LazySetValueInt64("instances", "data", int64(numRecords))
+	_ = s.Metadata.LazySetValueInt64("parse_time", "data", parseD.Microseconds())
+	_ = s.Metadata.LazySetValueUint64("metrics", "data", summary.count)
+	_ = s.Metadata.LazySetValueInt64("instances", "data", int64(summary.numRecords))
 	_ = s.Metadata.LazySetValueUint64("bytesRx", "data", s.client.Metadata.BytesRx)
 	_ = s.Metadata.LazySetValueUint64("numCalls", "data", s.client.Metadata.NumCalls)
+	_ = s.Metadata.LazySetValueUint64("queries", "data", uint64(summary.sent))                               //nolint:gosec
+	_ = s.Metadata.LazySetValueUint64("failed_queries", "data", uint64(summary.failed))                      //nolint:gosec
+	_ = s.Metadata.LazySetValueUint64("max_query_time", "data", uint64(summary.maxQueryTime.Microseconds())) //nolint:gosec
 
-	s.AddCollectCount(count)
+	s.AddCollectCount(summary.count)
+
+	if summary.queries > 0 && summary.failed == summary.queries {
+		return nil, errs.New(errs.ErrConnection, "all "+strconv.Itoa(summary.queries)+" metric queries failed")
+	}
 
 	return metrics, nil
 }
 
-func (s *StorageGrid) makePromMetrics(metricName string, result *[]gjson.Result, tenantNamesByID map[string]string) (*matrix.Matrix, error) {
+func (s *StorageGrid) makePromMetrics(metricName string, resultType string, instances []gjson.Result, tenantNamesByID map[string]string) (*matrix.Matrix, error) {
 	var (
 		metric   *matrix.Metric
 		instance *matrix.Instance
@@ -199,14 +211,11 @@ func (s *StorageGrid) makePromMetrics(metricName string, result *[]gjson.Result,
 	mat.Object = s.Props.Object
 	mat.UUID += "." + metricName
 
-	r := (*result)[0]
-	resultType := r.Get("resultType").ClonedString()
 	if resultType != "vector" {
 		return nil, fmt.Errorf("unexpected resultType=[%s]", resultType)
 	}
 
-	results := r.Get("result").Array()
-	if len(results) == 0 {
+	if len(instances) == 0 {
 		return mat, nil
 	}
 
@@ -214,7 +223,6 @@ func (s *StorageGrid) makePromMetrics(metricName string, result *[]gjson.Result,
 		return nil, fmt.Errorf("failed to create newMetric float64 metric=[%s]", metricName)
 	}
 
-	instances := r.Get("result").Array()
 	for i, rr := range instances {
 		if instance, err = mat.NewInstance(metricName + "-" + strconv.Itoa(i)); err != nil {
 			s.Logger.Error(
@@ -642,7 +650,8 @@ func (s *StorageGrid) GetMetric(metric string, display string, tenantNamesByID m
 	if display != "" {
 		nameOfMetric = display
 	}
-	return s.makePromMetrics(nameOfMetric, &records, tenantNamesByID)
+	r := records[0]
+	return s.makePromMetrics(nameOfMetric, r.Get("resultType").ClonedString(), r.Get("result").Array(), tenantNamesByID)
 }
 
 // Interface guards
@@ -1,6 +1,7 @@
 package storagegrid
 
 import (
+	"github.com/google/go-cmp/cmp"
 	"github.com/netapp/harvest/v2/cmd/collectors"
 	"github.com/netapp/harvest/v2/cmd/collectors/storagegrid/rest"
 	"github.com/netapp/harvest/v2/cmd/poller/collector"
@@ -61,3 +62,44 @@ func testFile(t *testing.T, sg *StorageGrid, filename string, expectedLen int) {
 		t.Errorf("length of matrix = %v, want %v", got, expectedLen)
 	}
 }
+
+func Test_SGAlerts(t *testing.T) {
+	conf.TestLoadHarvestConfig("testdata/config.yml")
+
+	sg, err := newStorageGrid("Alert", "alert.yaml")
+	if err != nil {
+		t.Fatalf("failed to create new StorageGrid: %v", err)
+	}
+
+	// id suffix => alerts metric value, resolved alerts flip to 0 once and are then removed
+	testAlerts(t, sg, "testdata/alerts.json", map[string]float64{"0A": 1, "0B": 1, "0C": 1})
+	testAlerts(t, sg, "testdata/alerts_resolved.json", map[string]float64{"0A": 1, "0B": 0, "0C": 0})
+	testAlerts(t, sg, "testdata/alerts_resolved.json", map[string]float64{"0A": 1})
+}
+
+func testAlerts(t *testing.T, sg *StorageGrid, filename string, want map[string]float64) {
+	t.Helper()
+	output, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+
+	data := gjson.Get(string(output), "data")
+	_ = sg.handleAlerts(data.Array())
+
+	mat := sg.Matrix[sg.Object]
+	got := make(map[string]float64)
+	for key, instance := range mat.GetInstances() {
+		v, _ := mat.GetMetric(alertMetric).GetValueFloat64(instance)
+		got[key[len(key)-2:]] = v
+		if v == 0 && instance.GetLabel("status") != alertResolvedStatus {
+			t.Errorf("%s: expected alert %s to have status=resolved", filename, key)
+		}
+		if instance.GetLabel("site") == "" || instance.GetLabel("rule") == "" {
+			t.Errorf("%s: expected alert %s to have site and rule labels, got %v", filename, key, instance.GetLabels())
+		}
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("%s: alerts mismatch (-want +got):\n%s", filename, diff)
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"github.com/netapp/harvest/v2/pkg/slogx"
 	"github.com/netapp/harvest/v2/pkg/util"
 	"log/slog"
-	"maps"
 	"regexp"
 	"sort"
 	"strings"
@@ -47,7 +46,7 @@ func ProcessFlexGroupData(logger *slog.Logger, data *matrix.Matrix, style string
 			key := svmName + "." + match[1]
 			if cache.GetInstance(key) == nil {
 				fg, _ := cache.NewInstance(key)
-				fg.SetLabels(maps.Clone(i.GetLabels()))
+				fg.SetLabels(i.Copy())
 				fg.SetLabel("volume", match[1])
 				fg.SetLabel("node", "")
 				fg.SetLabel("uuid", "")
@@ -57,7 +56,7 @@ func ProcessFlexGroupData(logger *slog.Logger, data *matrix.Matrix, style string
 
 			if volumeAggrmetric.GetInstance(key) == nil {
 				flexgroupInstance, _ := volumeAggrmetric.NewInstance(key)
-				flexgroupInstance.SetLabels(maps.Clone(i.GetLabels()))
+				flexgroupInstance.SetLabels(i.Copy())
 				flexgroupInstance.SetLabel("volume", match[1])
 				flexgroupInstance.SetLabel("node", "")
 				flexgroupInstance.SetLabel("uuid", "")
@@ -79,7 +78,7 @@ func ProcessFlexGroupData(logger *slog.Logger, data *matrix.Matrix, style string
 				logger.Error("Failed to create new instance", slogx.Err(err), slog.String("key", key))
 				continue
 			}
-			flexvolInstance.SetLabels(maps.Clone(i.GetLabels()))
+			flexvolInstance.SetLabels(i.Copy())
 			flexvolInstance.SetLabel(style, "flexvol")
 			if err := metric.SetValueFloat64(flexvolInstance, 1); err != nil {
 				logger.Error("Unable to set value on metric", slogx.Err(err), slog.String("metric", metricName))
@@ -255,14 +255,11 @@ func (z *Zapi) PollInstance() (map[string]*matrix.Matrix, error) {
 
 func (z *Zapi) PollData() (map[string]*matrix.Matrix, error) {
 	var (
-		request, response *node.Node
-		count, skipped    uint64
-		tag               string
-		err               error
-		apiD, parseD      time.Duration // Request/API time, Parse time, Fetch time
-		ad, pd            time.Duration // Request/API time, Parse time, Fetch time
-		fetch             func(*matrix.Instance, *node.Node, []string, bool)
-		instances         []*node.Node
+		request        *node.Node
+		count, skipped uint64
+		tag            string
+		apiD, parseD   time.Duration // Request/API time, Parse time, Fetch time
+		fetch          func(*matrix.Instance, *node.Node, []string, bool)
 	)
 
 	oldInstances := set.New()
@@ -336,64 +333,71 @@ func (z *Zapi) PollData() (map[string]*matrix.Matrix, error) {
 		}
 	}
 
-	tag = "initial"
-
-	for {
-		response, tag, ad, pd, err = z.Client.InvokeBatchWithTimers(request, tag)
-
-		if err != nil {
-			return nil, err
-		}
-
-		if response == nil {
-			break
-		}
+	onlyClusterInstance := z.Params.GetChildContentS("only_cluster_instance") == "true"
+	clusterFetched := false
 
-		apiD += ad
-		parseD += pd
-
-		instances = response.SearchChildren(z.shortestPathPrefix)
-
-		if len(instances) == 0 {
-			break
-		}
-
-		if z.Params.GetChildContentS("only_cluster_instance") == "true" {
+	// instances are built and fetched one at a time, without building the tree of the response
+	handle := func(instanceElem *node.Node) error {
+		if onlyClusterInstance {
+			// only the first instance is fetched
+			if clusterFetched {
+				return nil
+			}
+			clusterFetched = true
 			instance := mat.GetInstance("cluster")
 			if instance == nil {
+				var err error
 				if instance, err = mat.NewInstance("cluster"); err != nil {
-					return nil, err
+					return err
 				}
 			}
-			fetch(instance, instances[0], make([]string, 0), false)
+			fetch(instance, instanceElem, make([]string, 0), false)
 			oldInstances.Remove("cluster")
-			break
+			return nil
 		}
 
-		for _, instanceElem := range instances {
-			keys, found := instanceElem.SearchContent(z.shortestPathPrefix, z.instanceKeyPaths)
+		keys, found := instanceElem.SearchContent(z.shortestPathPrefix, z.instanceKeyPaths)
+
+		if !found {
+			return nil
+		}
 
-			if !found {
-				continue
+		key := strings.Join(keys, ".")
+		instance := mat.GetInstance(key)
+
+		if instance == nil {
+			var err error
+			if instance, err = mat.NewInstance(key); err != nil {
+				z.Logger.Error(
+					"Failed to create new missing instance",
+					slogx.Err(err),
+					slog.String("instKey", key),
+				)
+				return nil
 			}
+		}
+		oldInstances.Remove(key)
+		// clear all instance labels as there are some fields which may be missing between polls
+		instance.ClearLabels()
+		fetch(instance, instanceElem, make([]string, 0), false)
+		return nil
+	}
 
-			key := strings.Join(keys, ".")
-			instance := mat.GetInstance(key)
+	tag = "initial"
 
-			if instance == nil {
-				if instance, err = mat.NewInstance(key); err != nil {
-					z.Logger.Error(
-						"Failed to create new missing instance",
-						slogx.Err(err),
-						slog.String("instKey", key),
-					)
-					continue
-				}
-			}
-			oldInstances.Remove(key)
-			// clear all instance labels as there are some fields which may be missing between polls
-			instance.ClearLabels()
-			fetch(instance, instanceElem, make([]string, 0), false)
+	for {
+		response, err := z.Client.InvokeBatchStream(request, tag, "", z.shortestPathPrefix, handle)
+
+		if err != nil {
+			return nil, err
+		}
+
+		tag = response.Tag
+		apiD += response.Rd
+		parseD += response.Pd
+
+		if response.Records == 0 || onlyClusterInstance {
+			break
 		}
 	}
 
@@ -54,6 +54,7 @@ import (
 	"time"
 
 	zapi "github.com/netapp/harvest/v2/cmd/collectors/zapi/collector"
+	client "github.com/netapp/harvest/v2/pkg/api/ontapi/zapi"
 )
 
 const (
@@ -93,6 +94,7 @@ type ZapiPerf struct {
 	recordsToSave     int    // Number of records to save when using the recorder
 	pollDataCalls     int
 	pollInstanceCalls int
+	counterState      *collector.CounterState
 }
 
 func init() {
@@ -130,6 +132,11 @@ func (z *ZapiPerf) Init(a *collector.AbstractCollector) error {
 
 	z.recordsToSave = collector.RecordKeepLast(z.Params, z.Logger)
 
+	var err error
+	if z.counterState, err = collector.NewCounterState(z.AbstractCollector); err != nil {
+		return err
+	}
+
 	z.Logger.Debug("initialized")
 	return nil
 }
@@ -356,21 +363,6 @@ func (z *ZapiPerf) loadParamInt(name string, defaultValue int) int {
 	return defaultValue
 }
 
-func (z *ZapiPerf) isPartialAggregation(instance *node.Node) bool {
-	aggregation := instance.GetChildS("aggregation")
-	if aggregation != nil {
-		aggregationData := aggregation.GetChildS("aggregation-data")
-		if aggregationData != nil {
-			result := aggregationData.GetChildS("result")
-			if result != nil {
-				r := result.GetContentS()
-				return r == "partial_aggregation"
-			}
-		}
-	}
-	return false
-}
-
 // PollData updates the data cache of the collector. During first poll, no data will
 // be emitted. Afterward, final metric values will be calculated from previous poll.
 func (z *ZapiPerf) PollData() (map[string]*matrix.Matrix, error) {
@@ -507,51 +499,20 @@ func (z *ZapiPerf) PollData() (map[string]*matrix.Matrix, error) {
 			}
 		}
 
-		response, rd, pd, err := z.Client.InvokeWithTimers(z.testFilePath, headers)
-
-		if err != nil {
-			errMsg := strings.ToLower(err.Error())
-			// if ONTAP complains about batch size, use a smaller batch size
-			if strings.Contains(errMsg, "resource limit exceeded") && z.batchSize > 100 {
-				z.Logger.Error(
-					"Changed batch_size",
-					slogx.Err(err),
-					slog.Int("oldBatchSize", z.batchSize),
-					slog.Int("newBatchSize", z.batchSize-100),
-				)
-				z.batchSize -= 100
-				return nil, nil
-			} else if strings.Contains(errMsg, "timeout: operation") && z.batchSize > 100 {
-				z.Logger.Error(
-					"ONTAP timeout, reducing batch size",
-					slogx.Err(err),
-					slog.Int("oldBatchSize", z.batchSize),
-					slog.Int("newBatchSize", z.batchSize-100),
-				)
-				z.batchSize -= 100
-				return nil, nil
-			}
-			return nil, err
-		}
-
-		apiT += rd
-		parseT += pd
-		batchCount++
-
-		// fetch instances
-		instances := response.GetChildS("instances")
-		if instances == nil || len(instances.GetChildren()) == 0 {
-			break
-		}
-
 		// timestamp for batch instances
 		// ignore timestamp from ZAPI which is always integer
 		// we want float, since our poll interval can be a float
-		ts := float64(time.Now().UnixNano()) / BILLION
-
-		for instIndex, i := range instances.GetChildren() {
+		var ts float64
+		instIndex := -1
+
+		// instances are decoded one at a time and written to the matrix, without building the tree of the response
+		handle := func(i *client.PerfInstance) error {
+			instIndex++
+			if instIndex == 0 {
+				ts = float64(time.Now().UnixNano()) / BILLION
+			}
 
-			key := z.buildKeyValue(i, z.instanceKeys)
+			key := z.buildKeyValue(i.Field, z.instanceKeys)
 
 			var layer = "" // latency layer (resource) for workloads
 
@@ -564,7 +525,7 @@ func (z *ZapiPerf) PollData() (map[string]*matrix.Matrix, error) {
 					layer = x[1]
 				} else {
 					z.Logger.Warn("Instance key has unexpected format", slog.String("key", key))
-					continue
+					return nil
 				}
 
 				for _, wm := range workloadDetailMetrics {
@@ -581,20 +542,20 @@ func (z *ZapiPerf) PollData() (map[string]*matrix.Matrix, error) {
 					z.Logger.Debug(
 						"Skip instance, key is empty",
 						slog.Any("instanceKey", z.instanceKeys),
-						slog.String("name", i.GetChildContentS("name")),
-						slog.String("uuid", i.GetChildContentS("uuid")),
+						slog.String("name", i.Field("name")),
+						slog.String("uuid", i.Field("uuid")),
 					)
 				}
-				continue
+				return nil
 			}
 
 			instance := curMat.GetInstance(key)
 			if instance == nil {
 				z.Logger.Debug("Skip instance key, not found in cache", slog.String("key", key))
-				continue
+				return nil
 			}
 
-			if z.isPartialAggregation(i) {
+			if i.Aggregation == "partial_aggregation" {
 				instance.SetPartial(true)
 				instance.SetExportable(false)
 				numPartials++
@@ -603,10 +564,9 @@ func (z *ZapiPerf) PollData() (map[string]*matrix.Matrix, error) {
 				instance.SetExportable(true)
 			}
 
-			counters := i.GetChildS("counters")
-			if counters == nil {
+			if !i.HasCounters {
 				z.Logger.Debug("Skip instance key, no data counters", slog.String("key", key))
-				continue
+				return nil
 			}
 
 			// add batch timestamp as custom counter
@@ -614,10 +574,10 @@ func (z *ZapiPerf) PollData() (map[string]*matrix.Matrix, error) {
 				z.Logger.Error("set timestamp value", slogx.Err(err))
 			}
 
-			for _, cnt := range counters.GetChildren() {
+			for _, cnt := range i.Counters {
 
-				name := cnt.GetChildContentS("name")
-				value := cnt.GetChildContentS("value")
+				name := cnt.Name
+				value := cnt.Value
 
 				// validation
 				if name == "" || value == "" {
@@ -630,7 +590,8 @@ func (z *ZapiPerf) PollData() (map[string]*matrix.Matrix, error) {
 
 				// store as instance label
 				if display, has := z.instanceLabels[name]; has {
-					instance.SetLabel(display, value)
+					// values are substrings of the response, clone to not keep it in memory
+					instance.SetLabel(display, strings.Clone(value))
 					continue
 				}
 
@@ -748,7 +709,43 @@ func (z *ZapiPerf) PollData() (map[string]*matrix.Matrix, error) {
 					slog.String("value", value),
 				)
 			} // end loop over counters
-		} // end loop over instances
+			return nil
+		}
+
+		numInstances, rd, pd, err := z.Client.InvokePerfWithTimers(z.testFilePath, handle, headers)
+
+		if err != nil {
+			errMsg := strings.ToLower(err.Error())
+			// if ONTAP complains about batch size, use a smaller batch size
+			if strings.Contains(errMsg, "resource limit exceeded") && z.batchSize > 100 {
+				z.Logger.Error(
+					"Changed batch_size",
+					slogx.Err(err),
+					slog.Int("oldBatchSize", z.batchSize),
+					slog.Int("newBatchSize", z.batchSize-100),
+				)
+				z.batchSize -= 100
+				return nil, nil
+			} else if strings.Contains(errMsg, "timeout: operation") && z.batchSize > 100 {
+				z.Logger.Error(
+					"ONTAP timeout, reducing batch size",
+					slogx.Err(err),
+					slog.Int("oldBatchSize", z.batchSize),
+					slog.Int("newBatchSize", z.batchSize-100),
+				)
+				z.batchSize -= 100
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		apiT += rd
+		parseT += pd
+		batchCount++
+
+		if numInstances == 0 {
+			break
+		}
 	} // end batch request
 
 	if z.Query == objWorkloadDetail || z.Query == objWorkloadDetailVolume {
@@ -772,12 +769,17 @@ func (z *ZapiPerf) PollData() (map[string]*matrix.Matrix, error) {
 
 	z.AddCollectCount(count)
 
-	// skip calculating from delta if no data from previous poll
+	// skip calculating from delta if no data from previous poll, unless it was restored from disk
 	if z.isCacheEmpty {
-		z.Logger.Debug("skip postprocessing until next poll (previous cache empty)")
-		z.Matrix[z.Object] = curMat
 		z.isCacheEmpty = false
-		return nil, nil
+		restored := z.counterState.Restore(curMat)
+		if restored == nil {
+			z.Logger.Debug("skip postprocessing until next poll (previous cache empty)")
+			z.Matrix[z.Object] = curMat
+			z.counterState.Save(curMat)
+			return nil, nil
+		}
+		prevMat = restored
 	}
 
 	calcStart := time.Now()
@@ -785,6 +787,12 @@ func (z *ZapiPerf) PollData() (map[string]*matrix.Matrix, error) {
 	// cache raw data for next poll
 	cachedData := curMat.Clone(matrix.With{Data: true, Metrics: true, Instances: true, ExportInstances: true, PartialInstances: true}) // @TODO implement copy data
 
+	// export raw counters, before they are cooked
+	var rawMat *matrix.Matrix
+	if z.ExportRaw() {
+		rawMat = collector.RawCounters(curMat, z.rawCounter)
+	}
+
 	// order metrics, such that those requiring base counters are processed last
 	orderedMetrics := make([]*matrix.Metric, 0, len(curMat.GetMetrics()))
 	orderedKeys := make([]string, 0, len(orderedMetrics))
@@ -926,12 +934,22 @@ func (z *ZapiPerf) PollData() (map[string]*matrix.Matrix, error) {
 
 	// store cache for next poll
 	z.Matrix[z.Object] = cachedData
+	z.counterState.Save(cachedData)
 
 	newDataMap := make(map[string]*matrix.Matrix)
 	newDataMap[z.Object] = curMat
+	if rawMat != nil {
+		newDataMap[z.Object+collector.RawCounterIdentifier] = rawMat
+	}
 	return newDataMap, nil
 }
 
+// rawCounter returns how a counter is cooked, used when raw counters are exported.
+// The property of a counter is stored as the metric's property and the key of its base counter as the metric's comment
+func (z *ZapiPerf) rawCounter(_ string, metric *matrix.Metric) (collector.RawCounter, bool) {
+	return collector.RawCounter{Property: metric.GetProperty(), Base: metric.GetComment()}, true
+}
+
 // Poll counter "ops" of the related/parent object, required for objects
 // workload_detail and workload_detail_volume. This counter is already
 // collected by the other ZapiPerf collectors, so this poll is redundant
@@ -1007,55 +1025,39 @@ func (z *ZapiPerf) getParentOpsCounters(data *matrix.Matrix, keyAttr string) (ti
 			return apiT, parseT, err
 		}
 
-		response, rt, pt, err := z.Client.InvokeWithTimers("")
-		if err != nil {
-			return apiT, parseT, err
-		}
-
-		apiT += rt
-		parseT += pt
-
-		// fetch instances
-		instances := response.GetChildS("instances")
-		if instances == nil || len(instances.GetChildren()) == 0 {
-			return apiT, parseT, err
-		}
-
-		for _, i := range instances.GetChildren() {
-
-			key := z.buildKeyValue(i, z.instanceKeys)
+		handle := func(i *client.PerfInstance) error {
+			key := z.buildKeyValue(i.Field, z.instanceKeys)
 
 			if key == "" {
 				if z.Logger.Enabled(context.Background(), slog.LevelDebug) {
 					z.Logger.Debug(
 						"skip instance",
 						slog.Any("key", z.instanceKeys),
-						slog.String("name", i.GetChildContentS("name")),
-						slog.String("uuid", i.GetChildContentS("uuid")),
+						slog.String("name", i.Field("name")),
+						slog.String("uuid", i.Field("uuid")),
 					)
 				}
-				continue
+				return nil
 			}
 
 			instance := data.GetInstance(key)
 			if instance == nil {
 				z.Logger.Warn("skip instance, not found in cache", slog.String("key", key))
-				continue
+				return nil
 			}
 
-			counters := i.GetChildS("counters")
-			if counters == nil {
+			if !i.HasCounters {
 				z.Logger.Debug("skip instance, no data counters", slog.String("key", key))
-				continue
+				return nil
 			}
 
-			for _, cnt := range counters.GetChildren() {
+			for _, cnt := range i.Counters {
 
-				name := cnt.GetChildContentS("name")
-				value := cnt.GetChildContentS("value")
+				name := cnt.Name
+				value := cnt.Value
 
 				if name == "ops" {
-					if err = ops.SetValueString(instance, value); err != nil {
+					if err := ops.SetValueString(instance, value); err != nil {
 						z.Logger.Error(
 							"set metric value",
 							slogx.Err(err),
@@ -1072,6 +1074,19 @@ func (z *ZapiPerf) getParentOpsCounters(data *matrix.Matrix, keyAttr string) (ti
 					)
 				}
 			}
+			return nil
+		}
+
+		numInstances, rt, pt, err := z.Client.InvokePerfWithTimers("", handle)
+		if err != nil {
+			return apiT, parseT, err
+		}
+
+		apiT += rt
+		parseT += pt
+
+		if numInstances == 0 {
+			return apiT, parseT, nil
 		}
 	}
 	z.Logger.Debug(
@@ -1588,13 +1603,13 @@ func (z *ZapiPerf) PollInstance() (map[string]*matrix.Matrix, error) {
 
 	var (
 		err                               error
-		request, results                  *node.Node
+		request                           *node.Node
 		oldInstances                      *set.Set
 		newSize                           int
 		instancesAttr, nameAttr, uuidAttr string
 		keyAttrs                          []string
 		apiD, parseD                      time.Duration
-		apiT, parseT                      time.Time
+		apiT                              time.Time
 	)
 
 	oldInstances = set.New()
@@ -1668,45 +1683,8 @@ func (z *ZapiPerf) PollInstance() (map[string]*matrix.Matrix, error) {
 			}
 		}
 
-		responseData, err := z.Client.InvokeBatchRequest(request, batchTag, z.testFilePath, headers)
-
-		if err != nil {
-			if errors.Is(err, errs.ErrAPIRequestRejected) {
-				z.Logger.Info(
-					err.Error(),
-					slog.String("request", request.GetNameS()),
-					slog.String("batchTag", batchTag),
-				)
-			} else {
-				z.Logger.Error(
-					"InvokeBatchRequest failed",
-					slogx.Err(err),
-					slog.String("request", request.GetNameS()),
-					slog.String("batchTag", batchTag),
-				)
-			}
-			apiD += time.Since(apiT)
-			break
-		}
-
-		results = responseData.Result
-		batchTag = responseData.Tag
-		apiD += time.Since(apiT)
-		parseT = time.Now()
-
-		if results == nil {
-			break
-		}
-
-		// fetch instances
-		instances := results.GetChildS(instancesAttr)
-		if instances == nil || len(instances.GetChildren()) == 0 {
-			break
-		}
-
-		for _, i := range instances.GetChildren() {
-
-			key := z.buildKeyValue(i, keyAttrs)
+		handle := func(i *node.Node) error {
+			key := z.buildKeyValue(i.GetChildContentS, keyAttrs)
 			if key == "" {
 				// instance key missing
 				name := i.GetChildContentS(nameAttr)
@@ -1724,14 +1702,42 @@ func (z *ZapiPerf) PollInstance() (map[string]*matrix.Matrix, error) {
 				oldInstances.Remove(key)
 				instance := mat.GetInstance(key)
 				z.updateQosLabels(i, instance, key)
-				continue
 			} else if instance, err := mat.NewInstance(key); err != nil {
 				z.Logger.Error("add instance", slogx.Err(err))
 			} else {
 				z.updateQosLabels(i, instance, key)
 			}
+			return nil
+		}
+
+		responseData, err := z.Client.InvokeBatchStream(request, batchTag, z.testFilePath, []string{instancesAttr, "*"}, handle, headers)
+
+		if err != nil {
+			if errors.Is(err, errs.ErrAPIRequestRejected) {
+				z.Logger.Info(
+					err.Error(),
+					slog.String("request", request.GetNameS()),
+					slog.String("batchTag", batchTag),
+				)
+			} else {
+				z.Logger.Error(
+					"InvokeBatchStream failed",
+					slogx.Err(err),
+					slog.String("request", request.GetNameS()),
+					slog.String("batchTag", batchTag),
+				)
+			}
+			apiD += time.Since(apiT)
+			break
+		}
+
+		batchTag = responseData.Tag
+		apiD += responseData.Rd
+		parseD += responseData.Pd
+
+		if responseData.Records == 0 {
+			break
 		}
-		parseD += time.Since(parseT)
 	}
 
 	for key := range oldInstances.Iter() {
@@ -1772,13 +1778,14 @@ func (z *ZapiPerf) updateQosLabels(qos *node.Node, instance *matrix.Instance, ke
 	}
 }
 
-func (z *ZapiPerf) buildKeyValue(i *node.Node, keys []string) string {
+// buildKeyValue joins the values of keys, content returns the value of a key of the instance
+func (z *ZapiPerf) buildKeyValue(content func(string) string, keys []string) string {
 	if len(keys) == 1 {
-		return i.GetChildContentS(keys[0])
+		return content(keys[0])
 	}
 	var values []string
 	for _, k := range keys {
-		value := i.GetChildContentS(k)
+		value := content(k)
 		if value != "" {
 			values = append(values, value)
 		} else {
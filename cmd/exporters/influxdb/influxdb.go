@@ -235,6 +235,7 @@ func (e *InfluxDB) Render(data *matrix.Matrix) ([][]byte, exporter.Stats, error)
 		count, countTmp, instancesExported uint64
 	)
 
+	data = e.ApplyBudget(e.Relabel(data))
 	rendered := make([][]byte, 0)
 
 	object := data.Object
@@ -276,14 +277,14 @@ func (e *InfluxDB) Render(data *matrix.Matrix) ([][]byte, exporter.Stats, error)
 
 		// tag set
 		if includeAll {
-			for label, value := range instance.GetLabels() {
+			for label, value := range instance.Labels() {
 				if value != "" {
 					m.AddTag(label, value)
 				}
 			}
 		} else {
 			for _, key := range keysToInclude {
-				if value, has := instance.GetLabels()[key]; has && value != "" {
+				if value := instance.GetLabel(key); value != "" {
 					m.AddTag(key, value)
 				}
 			}
@@ -302,7 +303,7 @@ func (e *InfluxDB) Render(data *matrix.Matrix) ([][]byte, exporter.Stats, error)
 
 		// strings
 		for _, label := range labelsToInclude {
-			if value, has := instance.GetLabels()[label]; has && value != "" {
+			if value := instance.GetLabel(label); value != "" {
 				if value == "true" || value == "false" {
 					m.AddField(label, value)
 				} else {
@@ -133,3 +133,49 @@ func TestWhiteSpaceInParameter(t *testing.T) {
 		t.Fatalf("FAIL - expected [%s]\n                             got [%s]", expectedURL, influx.url)
 	}
 }
+
+// test that the exporter's relabel_configs are applied before rendering
+func TestRenderRelabel(t *testing.T) {
+	opts := options.New()
+	opts.IsTest = true
+	url := "http://localhost:8086/api/v2/write?org=harvest&bucket=harvest&precision=s"
+	token := "token"
+	drop := "test_instance_2"
+	params := conf.Exporter{
+		URL:   &url,
+		Token: &token,
+		RelabelConfigs: []conf.Relabel{
+			{SourceLabels: []string{"test_label"}, Regex: &drop, Action: "drop"},
+		},
+	}
+	influx := &InfluxDB{AbstractExporter: exporter.New("InfluxDB", "influx-relabel", opts, params, nil)}
+	if err := influx.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := matrix.New("test_exporter", "influxd_test_data", "influxd_test_data")
+	data.SetExportOptions(matrix.DefaultExportOptions())
+	m, err := data.NewMetricInt64("test_metric")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"test_instance_1", "test_instance_2"} {
+		i, err := data.NewInstance(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		i.SetLabel("test_label", name)
+		if err := m.SetValueInt64(i, 42); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rendered, _, err := influx.Render(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "influxd_test_data,test_label=test_instance_1 test_metric=42"
+	if len(rendered) != 1 || string(rendered[0]) != want {
+		t.Errorf("got %q, want [%s]", rendered, want)
+	}
+}
@@ -277,11 +277,14 @@ func (p *Prometheus) render(data *matrix.Matrix) ([][]byte, exporter.Stats) {
 		buf               bytes.Buffer // shared buffer for rendering
 	)
 
+	data = p.ApplyBudget(p.Relabel(data))
+
 	buf.Grow(4096)
 	globalLabels := make([]string, 0, len(data.GetGlobalLabels()))
 	normalizedLabels = make(map[string][]string)
 
-	if p.addMetaTags {
+	// Raw counters always include their metadata, since their type is what distinguishes them from gauges
+	if p.addMetaTags || hasCounters(data) {
 		tagged = set.New()
 	}
 
@@ -337,7 +340,7 @@ func (p *Prometheus) render(data *matrix.Matrix) ([][]byte, exporter.Stats) {
 	}
 
 	numMetrics += exportableInstances * exportableMetrics
-	if p.addMetaTags {
+	if tagged != nil {
 		numMetrics += exportableMetrics * 2 // for help and type
 	}
 
@@ -352,7 +355,7 @@ func (p *Prometheus) render(data *matrix.Matrix) ([][]byte, exporter.Stats) {
 
 		moreKeys := 0
 		if includeAllLabels {
-			moreKeys = len(instance.GetLabels())
+			moreKeys = instance.NumLabels()
 		}
 
 		instanceKeys := make([]string, 0, len(globalLabels)+len(keysToInclude)+moreKeys)
@@ -365,17 +368,15 @@ func (p *Prometheus) render(data *matrix.Matrix) ([][]byte, exporter.Stats) {
 		// For example, it might indicate that 'volume_size_total' has been updated.
 		// If a global prefix for the exporter is defined, we need to amend the metric name with this prefix.
 		if p.globalPrefix != "" && data.Object == changelog.ObjectChangeLog {
-			if categoryValue, ok := instance.GetLabels()[changelog.Category]; ok {
-				if categoryValue == changelog.Metric {
-					if tracked, ok := instance.GetLabels()[changelog.Track]; ok {
-						instance.GetLabels()[changelog.Track] = p.globalPrefix + tracked
-					}
+			if instance.GetLabel(changelog.Category) == changelog.Metric {
+				if tracked := instance.GetLabel(changelog.Track); tracked != "" {
+					instance.SetLabel(changelog.Track, p.globalPrefix+tracked)
 				}
 			}
 		}
 
 		if includeAllLabels {
-			for label, value := range instance.GetLabels() {
+			for label, value := range instance.Labels() {
 				// temporary fix for the rarely happening duplicate labels
 				// known case is: ZapiPerf -> 7mode -> disk.yaml
 				// actual cause is the Aggregator plugin, which is adding node as
@@ -544,8 +545,21 @@ func (p *Prometheus) render(data *matrix.Matrix) ([][]byte, exporter.Stats) {
 						buf.Reset()
 						buf.WriteString("# HELP ")
 						buf.WriteString(prefixedName)
-						buf.WriteString(" Metric for ")
-						buf.WriteString(data.Object)
+						if metric.IsCounter() {
+							buf.WriteString(" Raw counter for ")
+							buf.WriteString(data.Object)
+							buf.WriteString(". property=")
+							buf.WriteString(metric.GetProperty())
+							if base := metric.GetComment(); base != "" {
+								buf.WriteString(" base=")
+								buf.WriteString(prefix)
+								buf.WriteString("_")
+								buf.WriteString(base)
+							}
+						} else {
+							buf.WriteString(" Metric for ")
+							buf.WriteString(data.Object)
+						}
 
 						xbr := buf.Bytes()
 						helpB := make([]byte, len(xbr))
@@ -557,7 +571,11 @@ func (p *Prometheus) render(data *matrix.Matrix) ([][]byte, exporter.Stats) {
 						buf.Reset()
 						buf.WriteString("# TYPE ")
 						buf.WriteString(prefixedName)
-						buf.WriteString(" gauge")
+						if metric.IsCounter() {
+							buf.WriteString(" counter")
+						} else {
+							buf.WriteString(" gauge")
+						}
 
 						tbr := buf.Bytes()
 						typeB := make([]byte, len(tbr))
@@ -678,6 +696,15 @@ func (p *Prometheus) normalizeHistogram(ontap string) string {
 	return strconv.FormatFloat(normal, 'f', -1, 64)
 }
 
+func hasCounters(data *matrix.Matrix) bool {
+	for _, metric := range data.GetMetrics() {
+		if metric.IsCounter() && metric.IsExportable() {
+			return true
+		}
+	}
+	return false
+}
+
 func histogramFromBucket(histograms map[string]*histogram, metric *matrix.Metric) *histogram {
 	h, ok := histograms[metric.GetName()]
 	if ok {
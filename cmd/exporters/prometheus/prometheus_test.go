@@ -225,3 +225,96 @@ func setUpPrometheusExporter(prefix string) (exporter.Exporter, error) {
 	err := p.Init()
 	return p, err
 }
+
+func TestRenderRelabel(t *testing.T) {
+	keep := "A"
+	absExp := exporter.New(
+		"Prometheus",
+		"prom1",
+		&options.Options{PromPort: 1},
+		conf.Exporter{
+			IsTest:     true,
+			SortLabels: true,
+			RelabelConfigs: []conf.Relabel{
+				{SourceLabels: []string{"bike"}, Regex: &keep, Action: "keep"},
+				{SourceLabels: []string{"bike"}, TargetLabel: "rider"},
+			},
+		},
+		nil,
+	)
+	p := New(absExp)
+	if err := p.Init(); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	m := setUpMatrix("bike")
+	for key, instance := range m.GetInstances() {
+		instance.SetLabel("bike", key)
+	}
+	options := m.GetExportOptions().Copy()
+	options.PopChildS("include_all_labels")
+	options.NewChildS("instance_keys", "").NewChildS("", "bike")
+	m.SetExportOptions(options)
+
+	if _, err := p.Export(m); err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+
+	prom := p.(*Prometheus)
+	var lines []string
+	for _, metrics := range prom.cache.Get() {
+		for _, metric := range metrics {
+			lines = append(lines, string(metric))
+		}
+	}
+
+	want := `bike_max_speed{bike="A",rider="A"} 3`
+	if strings.Join(lines, "\n") != want {
+		t.Errorf("got = [%s], want = [%s]", strings.Join(lines, "\n"), want)
+	}
+	if m.GetInstance("B").IsExportable() != true || m.GetInstance("A").GetLabel("rider") != "" {
+		t.Errorf("expected the exported matrix to be unchanged")
+	}
+}
+
+func TestRenderRawCounters(t *testing.T) {
+	p, err := setUpPrometheusExporter("")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	m := matrix.New("volume", "volume", "volume_raw")
+	latency, _ := m.NewMetricFloat64("read_latency", "read_latency_total")
+	latency.SetCounter(true)
+	latency.SetProperty("average")
+	latency.SetComment("read_ops_total")
+	ops, _ := m.NewMetricFloat64("read_ops", "read_ops_total")
+	ops.SetCounter(true)
+	ops.SetProperty("rate")
+	instance, _ := m.NewInstance("vol1")
+	_ = latency.SetValueFloat64(instance, 5000)
+	_ = ops.SetValueFloat64(instance, 100)
+
+	if _, err := p.Export(m); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	prom := p.(*Prometheus)
+	var lines []string
+	for _, metrics := range prom.cache.Get() {
+		for _, metric := range metrics {
+			lines = append(lines, string(metric))
+		}
+	}
+	slices.Sort(lines)
+
+	want := `# HELP volume_read_latency_total Raw counter for volume. property=average base=volume_read_ops_total
+# HELP volume_read_ops_total Raw counter for volume. property=rate
+# TYPE volume_read_latency_total counter
+# TYPE volume_read_ops_total counter
+volume_read_latency_total{} 5000
+volume_read_ops_total{} 100`
+	if strings.Join(lines, "\n") != want {
+		t.Errorf("got = [%s], want = [%s]", strings.Join(lines, "\n"), want)
+	}
+}
@@ -28,6 +28,8 @@ import (
 	"github.com/netapp/harvest/v2/cmd/tools/generate"
 	"github.com/netapp/harvest/v2/cmd/tools/grafana"
 	"github.com/netapp/harvest/v2/cmd/tools/rest"
+	"github.com/netapp/harvest/v2/cmd/tools/support"
+	"github.com/netapp/harvest/v2/cmd/tools/template"
 	"github.com/netapp/harvest/v2/cmd/tools/zapi"
 	"github.com/netapp/harvest/v2/pkg/conf"
 	"github.com/netapp/harvest/v2/pkg/set"
@@ -541,7 +543,9 @@ func init() {
 	rootCmd.AddCommand(manageCmd("kill", true))
 	rootCmd.AddCommand(zapi.Cmd, rest.Cmd, grafana.Cmd)
 	rootCmd.AddCommand(generate.Cmd)
+	rootCmd.AddCommand(template.Cmd)
 	rootCmd.AddCommand(doctor.Cmd)
+	rootCmd.AddCommand(support.Cmd)
 	rootCmd.AddCommand(version.Cmd())
 	rootCmd.AddCommand(admin.Cmd())
 
@@ -438,6 +438,24 @@ func GetOSName() string {
 	return info.OS
 }
 
+// PayloadPath returns the path of the last AutoSupport payload written by a poller
+func PayloadPath(pollerName string) string {
+	return path.Join(workingDir, "payload", pollerName+"_payload.json")
+}
+
+// ReadPayload reads the last AutoSupport payload written by a poller
+func ReadPayload(pollerName string) (*Payload, error) {
+	data, err := os.ReadFile(PayloadPath(pollerName))
+	if err != nil {
+		return nil, err
+	}
+	var payload Payload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", PayloadPath(pollerName), err)
+	}
+	return &payload, nil
+}
+
 // Gives asup payload json file path based on input.
 // Ex. asupDir = asup, pollerName = poller-1
 // o/p would be asup/payload/poller-1_payload.json
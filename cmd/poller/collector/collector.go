@@ -40,6 +40,7 @@ import (
 	"github.com/netapp/harvest/v2/cmd/poller/exporter"
 	"github.com/netapp/harvest/v2/cmd/poller/options"
 	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/cmd/poller/registry"
 	"github.com/netapp/harvest/v2/cmd/poller/schedule"
 )
 
@@ -65,6 +66,7 @@ type Collector interface {
 	SetSchedule(*schedule.Schedule)
 	SetMatrix(map[string]*matrix.Matrix)
 	SetMetadata(*matrix.Matrix)
+	SetBudget(matrix.Budget)
 	WantedExporters([]string) []string
 	LinkExporter(exporter.Exporter)
 	LoadPlugins(*node.Node, Collector, string) error
@@ -103,6 +105,7 @@ type AbstractCollector struct {
 	Schedule     *schedule.Schedule         // schedule of the collector
 	Matrix       map[string]*matrix.Matrix  // the data storage of the collector
 	Metadata     *matrix.Matrix             // metadata of the collector, such as poll duration, collected data points etc.
+	Budget       matrix.Budget              // optional cardinality budget applied to each matrix before it is exported
 	Exporters    []exporter.Exporter        // the exporters that the collector will emit data to
 	Plugins      map[string][]plugin.Plugin // built-in or custom plugins
 	collectCount uint64                     // count of collected data points
@@ -217,6 +220,12 @@ func Init(c Collector) error {
 		}
 	}
 
+	budget, err := parseBudget(params.GetChildS("cardinality"))
+	if err != nil {
+		return err
+	}
+	c.SetBudget(budget)
+
 	// Some data should not be exported and is only used for plugins
 	if params.GetChildContentS("export_data") == "false" {
 		mx.SetExportable(false)
@@ -262,6 +271,11 @@ func Init(c Collector) error {
 	_, _ = md.NewMetricUint64("bytesRx")
 	_, _ = md.NewMetricUint64("numCalls")
 	_, _ = md.NewMetricUint64("pluginInstances")
+	_, _ = md.NewMetricUint64("overflow_instances")
+	_, _ = md.NewMetricUint64("overflow_series")
+	_, _ = md.NewMetricUint64("join_hits")
+	_, _ = md.NewMetricUint64("join_misses")
+	_, _ = md.NewMetricUint64("join_stale")
 
 	// Used by collector logging but not exported
 	loggingOnly := []string{begin, "export_time"}
@@ -481,10 +495,20 @@ func (c *AbstractCollector) Start(wg *sync.WaitGroup) {
 								_ = c.Metadata.LazyAddValueUint64("bytesRx", task.Name, pluginMetadata.BytesRx)
 								_ = c.Metadata.LazyAddValueUint64("numCalls", task.Name, pluginMetadata.NumCalls)
 								_ = c.Metadata.LazySetValueUint64("pluginInstances", task.Name, pluginMetadata.PluginInstances)
+								if pluginMetadata.JoinHits+pluginMetadata.JoinMisses+pluginMetadata.JoinStale > 0 {
+									_ = c.Metadata.LazyAddValueUint64("join_hits", task.Name, pluginMetadata.JoinHits)
+									_ = c.Metadata.LazyAddValueUint64("join_misses", task.Name, pluginMetadata.JoinMisses)
+									_ = c.Metadata.LazyAddValueUint64("join_stale", task.Name, pluginMetadata.JoinStale)
+								}
 							}
 						}
 					}
 
+					// share the data with the plugins of other collectors
+					for object, m := range data {
+						registry.Default.Publish(c.Name, object, m)
+					}
+
 					pluginTime = time.Since(pluginStart)
 					_ = c.Metadata.LazySetValueInt64("plugin_time", task.Name, pluginTime.Microseconds())
 				}
@@ -501,6 +525,10 @@ func (c *AbstractCollector) Start(wg *sync.WaitGroup) {
 			}
 		}
 
+		if len(results) > 0 && !c.Budget.IsZero() {
+			c.applyBudget(results)
+		}
+
 		// pass results to exporters
 
 		exportStart = time.Now()
@@ -566,6 +594,58 @@ func (c *AbstractCollector) Start(wg *sync.WaitGroup) {
 	}
 }
 
+// applyBudget truncates each result that exceeds the collector's cardinality budget
+// and records how many instances and series did not fit
+func (c *AbstractCollector) applyBudget(results []*matrix.Matrix) {
+	var overflow matrix.Overflow
+	for i, data := range results {
+		truncated, o := data.ApplyBudget(c.Budget)
+		results[i] = truncated
+		overflow.Instances += o.Instances
+		overflow.Series += o.Series
+	}
+	_ = c.Metadata.LazySetValueUint64("overflow_instances", "data", uint64(overflow.Instances))
+	_ = c.Metadata.LazySetValueUint64("overflow_series", "data", uint64(overflow.Series))
+	if overflow.Instances > 0 {
+		c.Logger.Warn(
+			"cardinality budget exceeded",
+			slog.Int("overflowInstances", overflow.Instances),
+			slog.Int("overflowSeries", overflow.Series),
+		)
+	}
+}
+
+// parseBudget reads the optional cardinality section of a template
+func parseBudget(n *node.Node) (matrix.Budget, error) {
+	var c conf.CardinalityBudget
+	if n == nil {
+		return matrix.Budget{}, nil
+	}
+	for _, child := range n.GetChildren() {
+		name := child.GetNameS()
+		value := child.GetContentS()
+		var err error
+		switch name {
+		case "max_instances":
+			c.MaxInstances, err = strconv.Atoi(value)
+		case "max_series":
+			c.MaxSeries, err = strconv.Atoi(value)
+		case "sort_by":
+			c.SortBy = value
+		case "other":
+			var other bool
+			other, err = strconv.ParseBool(value)
+			c.Other = &other
+		default:
+			return matrix.Budget{}, errs.New(errs.ErrInvalidParam, "cardinality: unknown parameter "+name)
+		}
+		if err != nil {
+			return matrix.Budget{}, errs.New(errs.ErrInvalidParam, "cardinality "+name+"="+value)
+		}
+	}
+	return exporter.NewBudget(c), nil
+}
+
 func (c *AbstractCollector) logMetadata(taskName string, stats exporter.Stats) {
 	metrics := c.Metadata.GetMetrics()
 	inst := c.Metadata.GetInstance(taskName)
@@ -711,6 +791,11 @@ func (c *AbstractCollector) SetMatrix(m map[string]*matrix.Matrix) {
 	c.Matrix = m
 }
 
+// SetBudget sets the cardinality budget of the collector
+func (c *AbstractCollector) SetBudget(b matrix.Budget) {
+	c.Budget = b
+}
+
 // SetMetadata set the metadata Matrix m as a field of the collector
 func (c *AbstractCollector) SetMetadata(m *matrix.Matrix) {
 	c.Metadata = m
@@ -0,0 +1,182 @@
+package collector
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/slogx"
+	"github.com/netapp/harvest/v2/pkg/state"
+	"log/slog"
+	"math"
+	"strings"
+	"time"
+)
+
+// defaultCounterStateMaxAge is used when the data task has no schedule
+const defaultCounterStateMaxAge = 10 * time.Minute
+
+// CounterState persists the previous raw matrix of a perf collector, and its timestamps, to disk.
+// After a restart, the snapshot is used as the previous poll so the first data poll produces rates,
+// instead of being spent priming the cache.
+// A nil CounterState is valid and does nothing, which is the case when counter_state is not configured.
+type CounterState struct {
+	path     string
+	schema   string
+	logger   *slog.Logger
+	snapshot *counterSnapshot
+}
+
+type counterSnapshot struct {
+	Schema    string                        `json:"schema"`
+	Timestamp time.Time                     `json:"timestamp"`
+	Partial   []string                      `json:"partial,omitempty"`
+	Metrics   map[string]map[string]float64 `json:"metrics"`
+}
+
+// NewCounterState reads the counter_state section of the collector's parameters and loads the snapshot of
+// the last data poll, when it is younger than max_age and was written by a collector with the same schema.
+// It returns nil when counter_state is not configured.
+func NewCounterState(c *AbstractCollector) (*CounterState, error) {
+	dir := state.Dir(c.Params)
+	if dir == "" {
+		return nil, nil
+	}
+	params := c.Params.GetChildS(state.Key)
+
+	maxAge := defaultCounterStateMaxAge
+	if c.Schedule != nil {
+		if task := c.Schedule.GetTask("data"); task != nil {
+			maxAge = 2 * task.GetInterval()
+		}
+	}
+	if s := params.GetChildContentS("max_age"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return nil, errs.New(errs.ErrInvalidParam, "counter_state max_age="+s)
+		}
+		maxAge = d
+	}
+
+	s := &CounterState{
+		path:   state.Path(dir, c.Options.Poller, strings.ToLower(c.Name)+"_"+c.Object),
+		schema: counterSchema(c),
+		logger: c.Logger,
+	}
+	s.load(maxAge)
+	return s, nil
+}
+
+// counterSchema identifies what a snapshot contains. A snapshot is ignored when the cluster, its ONTAP version,
+// the query, or the template's counters change
+func counterSchema(c *AbstractCollector) string {
+	h := sha256.New()
+	parts := []string{c.Name, c.Object, c.Params.GetChildContentS("query"), c.Remote.UUID, c.Remote.Name, c.Remote.Version}
+	if counters := c.Params.GetChildS("counters"); counters != nil {
+		parts = append(parts, counters.Print(0))
+	}
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (s *CounterState) load(maxAge time.Duration) {
+	var snapshot counterSnapshot
+	if err := state.Load(s.path, &snapshot); err != nil {
+		s.logger.Warn("Ignoring counter state", slogx.Err(err), slog.String("path", s.path))
+		return
+	}
+	// no counter state was saved yet
+	if snapshot.Schema == "" {
+		return
+	}
+	if snapshot.Schema != s.schema {
+		s.logger.Info("Ignoring counter state, the counter schema changed", slog.String("path", s.path))
+		return
+	}
+	if age := time.Since(snapshot.Timestamp); age > maxAge {
+		s.logger.Info(
+			"Ignoring counter state, it is too old",
+			slog.String("path", s.path),
+			slog.Duration("age", age),
+			slog.Duration("maxAge", maxAge),
+		)
+		return
+	}
+	s.snapshot = &snapshot
+}
+
+// Restore returns the previous raw matrix loaded from disk, with the same metrics and instances as curMat.
+// Cells that are missing from the snapshot are left empty, so Delta skips them.
+// It returns nil when there is no usable snapshot. A snapshot is restored at most once.
+func (s *CounterState) Restore(curMat *matrix.Matrix) *matrix.Matrix {
+	if s == nil || s.snapshot == nil {
+		return nil
+	}
+	snapshot := s.snapshot
+	s.snapshot = nil
+
+	prevMat := curMat.Clone(matrix.With{Data: false, Metrics: true, Instances: true, ExportInstances: true})
+	prevMat.Reset()
+	for _, key := range snapshot.Partial {
+		if instance := prevMat.GetInstance(key); instance != nil {
+			instance.SetPartial(true)
+		}
+	}
+	restored := 0
+	for key, values := range snapshot.Metrics {
+		metric := prevMat.GetMetric(key)
+		if metric == nil {
+			continue
+		}
+		for instanceKey, value := range values {
+			if instance := prevMat.GetInstance(instanceKey); instance != nil {
+				_ = metric.SetValueFloat64(instance, value)
+				restored++
+			}
+		}
+	}
+
+	s.logger.Info(
+		"Restored counter state",
+		slog.String("path", s.path),
+		slog.Int("values", restored),
+		slog.Duration("age", time.Since(snapshot.Timestamp)),
+	)
+	return prevMat
+}
+
+// Save writes rawMat to disk. The file is replaced atomically, so a crash while saving leaves the previous snapshot.
+// Errors are logged since a missing snapshot only costs a data poll after the next restart.
+func (s *CounterState) Save(rawMat *matrix.Matrix) {
+	if s == nil {
+		return
+	}
+	snapshot := counterSnapshot{
+		Schema:    s.schema,
+		Timestamp: time.Now(),
+		Metrics:   make(map[string]map[string]float64, len(rawMat.GetMetrics())),
+	}
+	for key, instance := range rawMat.GetInstances() {
+		if instance.IsPartial() {
+			snapshot.Partial = append(snapshot.Partial, key)
+		}
+	}
+	for key, metric := range rawMat.GetMetrics() {
+		values := make(map[string]float64)
+		for instanceKey, instance := range rawMat.GetInstances() {
+			if v, ok := metric.GetValueFloat64(instance); ok && !math.IsNaN(v) && !math.IsInf(v, 0) {
+				values[instanceKey] = v
+			}
+		}
+		if len(values) > 0 {
+			snapshot.Metrics[key] = values
+		}
+	}
+
+	if err := state.Save(s.path, snapshot); err != nil {
+		s.logger.Warn("Unable to save counter state", slogx.Err(err), slog.String("path", s.path))
+	}
+}
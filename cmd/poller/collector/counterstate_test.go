@@ -0,0 +1,146 @@
+package collector
+
+import (
+	"encoding/json"
+	"github.com/netapp/harvest/v2/cmd/poller/options"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"os"
+	"testing"
+	"time"
+)
+
+func newCounterStateCollector(dir string, counters ...string) *AbstractCollector {
+	params := node.NewS("")
+	params.NewChildS("query", "volume")
+	state := params.NewChildS("counter_state", "")
+	state.NewChildS("dir", dir)
+	state.NewChildS("max_age", "5m")
+	c := params.NewChildS("counters", "")
+	for _, counter := range counters {
+		c.NewChildS("", counter)
+	}
+	return New("ZapiPerf", "Volume", &options.Options{Poller: "p1"}, params, nil, conf.Remote{UUID: "uuid1"})
+}
+
+func newRawMatrix(timestamp float64, ops float64) *matrix.Matrix {
+	m := matrix.New("ZapiPerf", "volume", "volume")
+	ts, _ := m.NewMetricFloat64("timestamp")
+	readOps, _ := m.NewMetricFloat64("read_ops")
+	for _, key := range []string{"vol1", "vol2"} {
+		instance, _ := m.NewInstance(key)
+		_ = ts.SetValueFloat64(instance, timestamp)
+		_ = readOps.SetValueFloat64(instance, ops)
+	}
+	m.GetInstance("vol2").SetPartial(true)
+	return m
+}
+
+func TestCounterState_SaveRestore(t *testing.T) {
+	dir := t.TempDir()
+
+	state, err := NewCounterState(newCounterStateCollector(dir, "read_ops"))
+	if err != nil {
+		t.Fatalf("expected no error got %+v", err)
+	}
+	if state.Restore(newRawMatrix(0, 0)) != nil {
+		t.Fatalf("expected nothing to restore before the first save")
+	}
+	state.Save(newRawMatrix(100, 10))
+
+	// Simulate a restart
+	state, err = NewCounterState(newCounterStateCollector(dir, "read_ops"))
+	if err != nil {
+		t.Fatalf("expected no error got %+v", err)
+	}
+
+	curMat := newRawMatrix(160, 40)
+	_, _ = curMat.NewInstance("vol3")
+	prevMat := state.Restore(curMat)
+	if prevMat == nil {
+		t.Fatalf("expected the saved matrix to be restored")
+	}
+	if v, ok := prevMat.GetMetric("read_ops").GetValueFloat64(prevMat.GetInstance("vol1")); !ok || v != 10 {
+		t.Errorf("got read_ops=%f ok=%t, want 10", v, ok)
+	}
+	if v, _ := prevMat.GetMetric("timestamp").GetValueFloat64(prevMat.GetInstance("vol1")); v != 100 {
+		t.Errorf("got timestamp=%f, want 100", v)
+	}
+	if !prevMat.GetInstance("vol2").IsPartial() {
+		t.Errorf("expected vol2 to be partial")
+	}
+	if _, ok := prevMat.GetMetric("read_ops").GetValueFloat64(prevMat.GetInstance("vol3")); ok {
+		t.Errorf("expected vol3, which is new, to have no previous value")
+	}
+	if state.Restore(curMat) != nil {
+		t.Errorf("expected the snapshot to be restored once")
+	}
+}
+
+func TestCounterState_Ignored(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(t *testing.T, path string)
+		c      func(dir string) *AbstractCollector
+	}{
+		{
+			name: "counters changed",
+			c:    func(dir string) *AbstractCollector { return newCounterStateCollector(dir, "read_ops", "write_ops") },
+		},
+		{
+			name: "too old",
+			modify: func(t *testing.T, path string) {
+				var snapshot counterSnapshot
+				data, _ := os.ReadFile(path)
+				if err := json.Unmarshal(data, &snapshot); err != nil {
+					t.Fatalf("expected no error got %+v", err)
+				}
+				snapshot.Timestamp = snapshot.Timestamp.Add(-time.Hour)
+				data, _ = json.Marshal(snapshot)
+				_ = os.WriteFile(path, data, 0600)
+			},
+		},
+		{
+			name: "corrupt",
+			modify: func(_ *testing.T, path string) {
+				_ = os.WriteFile(path, []byte("{"), 0600)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			state, _ := NewCounterState(newCounterStateCollector(dir, "read_ops"))
+			state.Save(newRawMatrix(100, 10))
+			if tt.modify != nil {
+				tt.modify(t, state.path)
+			}
+			c := newCounterStateCollector(dir, "read_ops")
+			if tt.c != nil {
+				c = tt.c(dir)
+			}
+			state, err := NewCounterState(c)
+			if err != nil {
+				t.Fatalf("expected no error got %+v", err)
+			}
+			if state.Restore(newRawMatrix(160, 40)) != nil {
+				t.Errorf("expected the snapshot to be ignored")
+			}
+		})
+	}
+}
+
+func TestCounterState_Disabled(t *testing.T) {
+	c := New("ZapiPerf", "Volume", &options.Options{Poller: "p1"}, node.NewS(""), nil, conf.Remote{})
+	state, err := NewCounterState(c)
+	if err != nil || state != nil {
+		t.Fatalf("got state=%v err=%v, want nil", state, err)
+	}
+	// A nil state does nothing
+	state.Save(newRawMatrix(100, 10))
+	if state.Restore(newRawMatrix(100, 10)) != nil {
+		t.Errorf("expected nil")
+	}
+}
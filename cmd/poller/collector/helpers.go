@@ -10,9 +10,15 @@ package collector
 import (
 	"errors"
 	"fmt"
+	"github.com/netapp/harvest/v2/cmd/poller/options"
 	"github.com/netapp/harvest/v2/cmd/poller/plugin"
 	"github.com/netapp/harvest/v2/cmd/poller/plugin/aggregator"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin/anomaly"
 	"github.com/netapp/harvest/v2/cmd/poller/plugin/changelog"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin/exec"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin/forecast"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin/inventory"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin/join"
 	"github.com/netapp/harvest/v2/cmd/poller/plugin/labelagent"
 	"github.com/netapp/harvest/v2/cmd/poller/plugin/maxplugin"
 	"github.com/netapp/harvest/v2/cmd/poller/plugin/metricagent"
@@ -203,7 +209,7 @@ func (c *AbstractCollector) findBestFit(homePath string, confPath string, name s
 	sort.Sort(version.Collection(versions))
 
 	// get closest index
-	idx := getClosestIndex(versions, ontapVersion)
+	idx := GetClosestIndex(versions, ontapVersion)
 	if idx >= 0 && idx < len(versions) {
 		selectedVersion = versions[idx].String()
 	}
@@ -211,10 +217,10 @@ func (c *AbstractCollector) findBestFit(homePath string, confPath string, name s
 	return filepath.Join(pathPrefix, selectedVersion), nil
 }
 
-// getClosestIndex returns the closest left match to the sorted list of input versions
+// GetClosestIndex returns the closest left match to the sorted list of input versions
 // returns -1 when the version's list is empty
 // returns equal or closest match to the left
-func getClosestIndex(versions []*version.Version, aVersion *version.Version) int {
+func GetClosestIndex(versions []*version.Version, aVersion *version.Version) int {
 	if len(versions) == 0 {
 		return -1
 	}
@@ -257,6 +263,26 @@ func GetBuiltinPlugin(name string, abc *plugin.AbstractPlugin) plugin.Plugin {
 		return changelog.New(abc)
 	}
 
+	if name == "Join" {
+		return join.New(abc)
+	}
+
+	if name == "Inventory" {
+		return inventory.New(abc)
+	}
+
+	if name == "Exec" {
+		return exec.New(abc)
+	}
+
+	if name == "Forecast" {
+		return forecast.New(abc)
+	}
+
+	if name == "Anomaly" {
+		return anomaly.New(abc)
+	}
+
 	return nil
 }
 
@@ -285,3 +311,65 @@ func RecordKeepLast(n *node.Node, logger *slog.Logger) int {
 
 	return DefaultRecordsToSave
 }
+
+// ObjectTemplate is the effective template of an object of a poller's collector
+type ObjectTemplate struct {
+	Class    string
+	Object   string
+	FileName string     // empty for collectors with a single object
+	Params   *node.Node // the collector's merged default.yaml and custom.yaml
+	Template *node.Node
+	Path     string
+}
+
+// ObjectTemplates merges the templates of a collector, as the poller does, and finds the best-fit template of each
+// of its objects
+func ObjectTemplates(col conf.Collector, confPaths []string, confPath string, ontapVersion string) ([]ObjectTemplate, error) {
+	var params *node.Node
+	if col.Templates != nil {
+		for _, t := range *col.Templates {
+			sub, err := ImportTemplate(confPaths, t, col.Name)
+			if err != nil {
+				continue
+			}
+			if params == nil {
+				params = sub
+			} else if col.Name == "Zapi" || col.Name == "ZapiPerf" {
+				params.Merge(sub, []string{"objects"})
+			} else {
+				params.Merge(sub, []string{""})
+			}
+		}
+	}
+	if params == nil {
+		return nil, fmt.Errorf("no templates loaded for %s", col.Name)
+	}
+
+	if object := params.GetChildContentS("object"); object != "" {
+		return []ObjectTemplate{{Class: col.Name, Object: object, Params: params, Template: params,
+			Path: strings.ToLower(col.Name) + "/default.yaml"}}, nil
+	}
+	objects := params.GetChildS("objects")
+	if objects == nil {
+		return nil, errs.New(errs.ErrMissingParam, "collector object")
+	}
+
+	model := ""
+	if col.Name == "Zapi" || col.Name == "ZapiPerf" {
+		model = "cdot"
+	}
+	opts := options.New(options.WithConfPath(confPath))
+	var all []ObjectTemplate
+	for _, o := range objects.GetChildren() {
+		ot := ObjectTemplate{Class: col.Name, Object: o.GetNameS(), FileName: o.GetContentS(), Params: params}
+		ac := New(col.Name, ot.Object, opts, params, nil, conf.Remote{})
+		ac.Logger = slog.New(slog.DiscardHandler)
+		template, templatePath, err := ac.ImportSubTemplate(model, ot.FileName, "", ontapVersion)
+		if err == nil {
+			ot.Template = template
+		}
+		ot.Path = templatePath
+		all = append(all, ot)
+	}
+	return all, nil
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/netapp/harvest/v2/pkg/conf"
 	"github.com/netapp/harvest/v2/third_party/go-version"
 	"sort"
+	"strings"
 	"testing"
 )
 
@@ -20,7 +21,7 @@ func buildVersion(ver string) *version.Version {
 	v, _ := version.NewVersion(ver)
 	return v
 }
-func Test_getClosestIndex(t *testing.T) {
+func Test_GetClosestIndex(t *testing.T) {
 	type args struct {
 		versions []*version.Version
 		version  *version.Version
@@ -42,8 +43,8 @@ func Test_getClosestIndex(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := getClosestIndex(tt.args.versions, tt.args.version); got != tt.want {
-				t.Errorf("getClosestIndex() = %v, want %v", got, tt.want)
+			if got := GetClosestIndex(tt.args.versions, tt.args.version); got != tt.want {
+				t.Errorf("GetClosestIndex() = %v, want %v", got, tt.want)
 			}
 		})
 	}
@@ -61,3 +62,25 @@ func Test_HARVEST_CONF(t *testing.T) {
 		t.Errorf("collectorName got=%s, want=Test", name)
 	}
 }
+
+func TestObjectTemplates(t *testing.T) {
+	objects, err := ObjectTemplates(conf.NewCollector("Rest"), []string{"../../../conf"}, "../../../conf", "9.14.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var volume *ObjectTemplate
+	for i, o := range objects {
+		if o.Object == "Volume" {
+			volume = &objects[i]
+		}
+	}
+	if volume == nil {
+		t.Fatal("Volume is missing")
+	}
+	if volume.Template == nil || volume.Template.GetChildContentS("object") != "volume" {
+		t.Fatalf("Volume template got=%v", volume.Template)
+	}
+	if !strings.HasSuffix(volume.Path, "volume.yaml") {
+		t.Errorf("Volume path got=%s, want the best-fit volume.yaml", volume.Path)
+	}
+}
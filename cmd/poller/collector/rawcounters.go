@@ -0,0 +1,97 @@
+package collector
+
+import (
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"strings"
+)
+
+// RawCounterSuffix is appended to the name of raw counters, following the Prometheus naming convention for counters
+const RawCounterSuffix = "_total"
+
+// RawCounterIdentifier is appended to the identifier of a perf collector's matrix to create the raw counter matrix
+const RawCounterIdentifier = "_raw"
+
+// RawCounter describes how a perf collector cooks a counter
+type RawCounter struct {
+	Property string // one of rate, delta, average, or percent
+	Base     string // key of the base counter, required by average and percent
+}
+
+// ExportRaw returns true when the collector should export raw counters next to the cooked ones
+func (c *AbstractCollector) ExportRaw() bool {
+	return c.Params.GetChildContentS("export_raw") == "true"
+}
+
+// RawCounters returns a copy of curMat that holds the raw, monotonically increasing value of each counter.
+// It must be called before curMat is cooked.
+// lookup returns how the counter with the given key is cooked, or false when it is not a perf counter.
+// The metrics are renamed with a _total suffix and marked as counters, with their property and the display name
+// of their base counter in the metric's property and comment.
+// Base counters are exported even when the cooked matrix hides them.
+// Raw properties, arrays, and histograms are not exported since they are not cumulative or have no scalar value.
+func RawCounters(curMat *matrix.Matrix, lookup func(key string, metric *matrix.Metric) (RawCounter, bool)) *matrix.Matrix {
+	raw := curMat.Clone(matrix.With{Instances: true, ExportInstances: true})
+	raw.Identifier = curMat.Identifier + RawCounterIdentifier
+
+	// The instance labels are already exported with the cooked matrix
+	if curMat.GetExportOptions().HasChildS("instance_labels") {
+		options := curMat.GetExportOptions().Copy()
+		options.PopChildS("instance_labels")
+		raw.SetExportOptions(options)
+	}
+
+	counters := make(map[string]RawCounter)
+	wanted := make(map[string]bool)
+	for key, metric := range curMat.GetMetrics() {
+		if metric.HasLabels() || metric.IsHistogram() || metric.Buckets() != nil {
+			continue
+		}
+		counter, ok := lookup(key, metric)
+		if !ok {
+			continue
+		}
+		switch counter.Property {
+		case "rate", "delta", "average", "percent":
+		default:
+			continue
+		}
+		counters[key] = counter
+		if metric.IsExportable() {
+			wanted[key] = true
+			if counter.Base != "" {
+				wanted[counter.Base] = true
+			}
+		}
+	}
+
+	for key := range wanted {
+		metric := curMat.GetMetric(key)
+		counter, ok := counters[key]
+		if metric == nil || !ok {
+			continue
+		}
+		rawMetric, err := raw.NewMetricFloat64(key, RawCounterName(metric.GetName()))
+		if err != nil {
+			continue
+		}
+		rawMetric.SetCounter(true)
+		rawMetric.SetProperty(counter.Property)
+		if _, ok := counters[counter.Base]; ok {
+			rawMetric.SetComment(RawCounterName(curMat.GetMetric(counter.Base).GetName()))
+		}
+		for instanceKey, instance := range curMat.GetInstances() {
+			if v, ok := metric.GetValueFloat64(instance); ok {
+				_ = rawMetric.SetValueFloat64(raw.GetInstance(instanceKey), v)
+			}
+		}
+	}
+	return raw
+}
+
+// RawCounterName returns the name of the raw counter of a cooked metric
+func RawCounterName(name string) string {
+	if strings.HasSuffix(name, RawCounterSuffix) {
+		return name
+	}
+	return name + RawCounterSuffix
+}
@@ -0,0 +1,80 @@
+package collector
+
+import (
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"slices"
+	"testing"
+)
+
+func TestRawCounters(t *testing.T) {
+	m := matrix.New("ZapiPerf", "volume", "volume")
+	options := m.GetExportOptions().Copy()
+	options.NewChildS("instance_labels", "").NewChildS("", "style")
+	m.SetExportOptions(options)
+
+	counters := map[string]RawCounter{
+		"read_latency": {Property: "average", Base: "read_ops"},
+		"read_ops":     {Property: "rate"},
+		"total_ops":    {Property: "rate"},
+		"busy":         {Property: "percent", Base: "total_time"},
+		"total_time":   {Property: "delta"},
+		"instances":    {Property: "raw"},
+		"timestamp":    {Property: "raw"},
+	}
+	keys := make([]string, 0, len(counters))
+	for key := range counters {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		_, _ = m.NewMetricFloat64(key)
+	}
+	// total_ops is neither exported nor a base counter, total_time is hidden but is the base of busy
+	m.GetMetric("total_ops").SetExportable(false)
+	m.GetMetric("total_time").SetExportable(false)
+
+	instance, _ := m.NewInstance("vol1")
+	instance.SetLabel("volume", "vol1")
+	for i, key := range keys {
+		_ = m.GetMetric(key).SetValueFloat64(instance, float64(i+1))
+	}
+	_, _ = m.NewInstance("vol2")
+
+	raw := RawCounters(m, func(key string, _ *matrix.Metric) (RawCounter, bool) {
+		c, ok := counters[key]
+		return c, ok
+	})
+
+	var names []string
+	for _, metric := range raw.GetMetrics() {
+		names = append(names, metric.GetName())
+		if !metric.IsCounter() || !metric.IsExportable() {
+			t.Errorf("expected %s to be an exportable counter", metric.GetName())
+		}
+	}
+	slices.Sort(names)
+	want := []string{"busy_total", "read_latency_total", "read_ops_total", "total_time_total"}
+	if !slices.Equal(names, want) {
+		t.Errorf("got %v, want %v", names, want)
+	}
+
+	latency := raw.GetMetric("read_latency")
+	if latency.GetProperty() != "average" || latency.GetComment() != "read_ops_total" {
+		t.Errorf("got property=%s base=%s", latency.GetProperty(), latency.GetComment())
+	}
+	if v, ok := latency.GetValueFloat64(raw.GetInstance("vol1")); !ok || v != 3 {
+		t.Errorf("got read_latency=%f ok=%t, want 3", v, ok)
+	}
+	if _, ok := latency.GetValueFloat64(raw.GetInstance("vol2")); ok {
+		t.Errorf("expected vol2 to have no value")
+	}
+	if raw.GetInstance("vol1").GetLabel("volume") != "vol1" {
+		t.Errorf("expected instance labels to be copied")
+	}
+	if raw.Identifier != "volume_raw" || raw.GetExportOptions().HasChildS("instance_labels") {
+		t.Errorf("got identifier=%s, want volume_raw without instance_labels", raw.Identifier)
+	}
+	if !m.GetExportOptions().HasChildS("instance_labels") {
+		t.Errorf("expected the cooked matrix to keep its instance_labels")
+	}
+}
@@ -59,6 +59,7 @@ type AbstractExporter struct {
 	*sync.Mutex                // mutex to block exporter during export
 	exportCount uint64         // atomic
 	countMux    *sync.Mutex
+	relabeler   *Relabeler // exporter-level relabel_configs
 }
 
 // New creates an AbstractExporter instance with the given arguments:
@@ -97,6 +98,12 @@ func (e *AbstractExporter) InitAbc() error {
 	e.Metadata.SetGlobalLabel("exporter", e.Class)
 	e.Metadata.SetGlobalLabel("target", e.Name)
 
+	relabeler, err := NewRelabeler(e.Params.RelabelConfigs)
+	if err != nil {
+		return err
+	}
+	e.relabeler = relabeler
+
 	if _, err := e.Metadata.NewMetricInt64("time"); err != nil {
 		return err
 	}
@@ -120,6 +127,55 @@ func (e *AbstractExporter) InitAbc() error {
 	return nil
 }
 
+// Relabel applies the exporter's relabel_configs and returns the matrix to render
+func (e *AbstractExporter) Relabel(data *matrix.Matrix) *matrix.Matrix {
+	return e.relabeler.Apply(data)
+}
+
+// ApplyBudget truncates data to the exporter's cardinality budget for the data's object.
+// The number of series over budget is recorded in the exporter's metadata, per object.
+func (e *AbstractExporter) ApplyBudget(data *matrix.Matrix) *matrix.Matrix {
+	if e.Params.Cardinality == nil || data == e.Metadata {
+		return data
+	}
+	truncated, overflow := data.ApplyBudget(NewBudget(e.Params.Cardinality.Budget(data.Object)))
+
+	key := "overflow_" + data.Object
+	instance := e.Metadata.GetInstance(key)
+	if instance == nil {
+		if overflow.Instances == 0 {
+			return truncated
+		}
+		var err error
+		if instance, err = e.Metadata.NewInstance(key); err != nil {
+			return truncated
+		}
+		instance.SetLabel("task", "overflow")
+		instance.SetLabel("object", data.Object)
+	}
+	_ = e.Metadata.LazySetValueUint64("count", key, uint64(overflow.Series))
+
+	if overflow.Instances > 0 {
+		e.Logger.Warn(
+			"cardinality budget exceeded",
+			slog.String("object", data.Object),
+			slog.Int("overflowInstances", overflow.Instances),
+			slog.Int("overflowSeries", overflow.Series),
+		)
+	}
+	return truncated
+}
+
+// NewBudget converts a cardinality budget from harvest.yml or a template into a matrix.Budget
+func NewBudget(c conf.CardinalityBudget) matrix.Budget {
+	return matrix.Budget{
+		MaxInstances: c.MaxInstances,
+		MaxSeries:    c.MaxSeries,
+		SortBy:       c.SortBy,
+		DropOther:    c.Other != nil && !*c.Other,
+	}
+}
+
 // GetClass returns the class of the AbstractExporter
 func (e *AbstractExporter) GetClass() string {
 	return e.Class
@@ -0,0 +1,373 @@
+package exporter
+
+import (
+	"cmp"
+	"crypto/md5" //nolint:gosec // used for sharding, not security, and matches Prometheus' hashmod
+	"encoding/binary"
+	"fmt"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Relabel actions, see https://prometheus.io/docs/prometheus/latest/configuration/configuration/#relabel_config
+const (
+	RelabelReplace   = "replace"
+	RelabelKeep      = "keep"
+	RelabelDrop      = "drop"
+	RelabelHashMod   = "hashmod"
+	RelabelLabelDrop = "labeldrop"
+	RelabelLabelKeep = "labelkeep"
+)
+
+// MetricNameLabel is the pseudo-label that holds a series' name, i.e. <object>_<metric>, without the global prefix
+const MetricNameLabel = "__name__"
+
+const (
+	defaultRelabelSeparator   = ";"
+	defaultRelabelRegex       = "(.*)"
+	defaultRelabelReplacement = "$1"
+)
+
+type relabelRule struct {
+	sourceLabels []string
+	separator    string
+	regex        *regexp.Regexp
+	modulus      uint64
+	targetLabel  string
+	replacement  string
+	action       string
+	usesName     bool // true when source_labels includes __name__
+}
+
+// Relabeler applies an exporter's relabel_configs to a matrix before it is rendered.
+// Rules that only change labels are evaluated once per instance.
+// keep and drop rules that reference __name__ are evaluated per series, i.e. per instance and metric.
+type Relabeler struct {
+	rules []relabelRule
+}
+
+type matchKey struct {
+	rule  int
+	value string
+}
+
+// nameFilter is a keep or drop rule that references __name__, with the rule's other source label values captured
+// at the point in the pipeline where the rule runs
+type nameFilter struct {
+	rule   int
+	values []string
+}
+
+type relabeled struct {
+	instance *matrix.Instance
+	labels   map[string]string
+	filters  []nameFilter
+}
+
+func NewRelabeler(configs []conf.Relabel) (*Relabeler, error) {
+	r := &Relabeler{}
+	for i, c := range configs {
+		rule := relabelRule{
+			sourceLabels: c.SourceLabels,
+			separator:    defaultRelabelSeparator,
+			modulus:      c.Modulus,
+			targetLabel:  c.TargetLabel,
+			replacement:  defaultRelabelReplacement,
+			action:       cmp.Or(strings.ToLower(c.Action), RelabelReplace),
+		}
+		if c.Separator != nil {
+			rule.separator = *c.Separator
+		}
+		if c.Replacement != nil {
+			rule.replacement = *c.Replacement
+		}
+		expr := defaultRelabelRegex
+		if c.Regex != nil {
+			expr = *c.Regex
+		}
+		regex, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, errs.New(errs.ErrInvalidParam, fmt.Sprintf("relabel_configs[%d] regex=%s err=%v", i, expr, err))
+		}
+		rule.regex = regex
+		rule.usesName = slices.Contains(rule.sourceLabels, MetricNameLabel)
+
+		switch rule.action {
+		case RelabelKeep, RelabelDrop:
+			if len(rule.sourceLabels) == 0 {
+				return nil, errs.New(errs.ErrMissingParam, fmt.Sprintf("relabel_configs[%d] %s requires source_labels", i, rule.action))
+			}
+		case RelabelReplace, RelabelHashMod:
+			if rule.targetLabel == "" {
+				return nil, errs.New(errs.ErrMissingParam, fmt.Sprintf("relabel_configs[%d] %s requires target_label", i, rule.action))
+			}
+			if rule.action == RelabelHashMod && rule.modulus == 0 {
+				return nil, errs.New(errs.ErrMissingParam, fmt.Sprintf("relabel_configs[%d] hashmod requires modulus", i))
+			}
+			// Instances share their labels across all of their metrics, so labels can not depend on the metric name
+			if rule.usesName || rule.targetLabel == MetricNameLabel {
+				return nil, errs.New(errs.ErrInvalidParam, fmt.Sprintf("relabel_configs[%d] %s can not read or write %s", i, rule.action, MetricNameLabel))
+			}
+		case RelabelLabelDrop, RelabelLabelKeep:
+		default:
+			return nil, errs.New(errs.ErrInvalidParam, fmt.Sprintf("relabel_configs[%d] unknown action=%s", i, c.Action))
+		}
+
+		r.rules = append(r.rules, rule)
+	}
+	return r, nil
+}
+
+// Apply returns a relabeled copy of data. data is not modified since it is shared with the poller's other exporters.
+// When there are no rules, data is returned as is.
+func (r *Relabeler) Apply(data *matrix.Matrix) *matrix.Matrix {
+	if r == nil || len(r.rules) == 0 {
+		return data
+	}
+
+	clone := data.Clone(matrix.With{Data: true, Metrics: true, Instances: true, ExportInstances: true, PartialInstances: true})
+	globals := clone.GetGlobalLabels()
+	matches := make(map[matchKey]bool)
+	droppedNames := make(map[string]bool)
+	var results []relabeled
+
+	for _, instance := range clone.GetInstances() {
+		if !instance.IsExportable() {
+			continue
+		}
+		labels := make(map[string]string, len(globals)+instance.NumLabels())
+		maps.Copy(labels, globals)
+		maps.Insert(labels, instance.Labels())
+
+		result, keep := r.relabel(labels, matches, droppedNames)
+		if !keep {
+			instance.SetExportable(false)
+			continue
+		}
+		result.instance = instance
+		results = append(results, result)
+	}
+
+	// A global label that was dropped or changed for any instance is no longer global
+	var demoted []string
+	for key, value := range globals {
+		for _, result := range results {
+			if v, ok := result.labels[key]; !ok || v != value {
+				demoted = append(demoted, key)
+				break
+			}
+		}
+	}
+	for _, key := range demoted {
+		delete(globals, key)
+	}
+
+	present := make(map[string]bool)
+	for _, result := range results {
+		instanceLabels := make(map[string]string, len(result.labels))
+		for key, value := range result.labels {
+			present[key] = true
+			if _, ok := globals[key]; ok {
+				continue
+			}
+			instanceLabels[key] = value
+		}
+		result.instance.SetLabels(instanceLabels)
+	}
+
+	r.filterSeries(clone, results, matches)
+	r.updateExportOptions(clone, demoted, present, droppedNames)
+
+	return clone
+}
+
+// relabel runs the rules over labels. It returns false when the instance is dropped
+func (r *Relabeler) relabel(labels map[string]string, matches map[matchKey]bool, droppedNames map[string]bool) (relabeled, bool) {
+	result := relabeled{labels: labels}
+	for i, rule := range r.rules {
+		switch rule.action {
+		case RelabelKeep, RelabelDrop:
+			if rule.usesName {
+				values := make([]string, len(rule.sourceLabels))
+				for j, name := range rule.sourceLabels {
+					values[j] = labels[name]
+				}
+				result.filters = append(result.filters, nameFilter{rule: i, values: values})
+				continue
+			}
+			if !r.keep(i, rule.source(labels), matches) {
+				return result, false
+			}
+		case RelabelReplace:
+			source := rule.source(labels)
+			indexes := rule.regex.FindStringSubmatchIndex(source)
+			if indexes == nil {
+				continue
+			}
+			value := string(rule.regex.ExpandString(nil, rule.replacement, source, indexes))
+			if value == "" {
+				delete(labels, rule.targetLabel)
+				continue
+			}
+			labels[rule.targetLabel] = value
+		case RelabelHashMod:
+			sum := md5.Sum([]byte(rule.source(labels))) //nolint:gosec
+			labels[rule.targetLabel] = strconv.FormatUint(binary.BigEndian.Uint64(sum[8:])%rule.modulus, 10)
+		case RelabelLabelDrop, RelabelLabelKeep:
+			for name := range labels {
+				if rule.regex.MatchString(name) == (rule.action == RelabelLabelDrop) {
+					delete(labels, name)
+					droppedNames[name] = true
+				}
+			}
+		}
+	}
+	return result, true
+}
+
+// keep evaluates a keep or drop rule. Matches are memoized since many instances share the same label values
+func (r *Relabeler) keep(i int, source string, matches map[matchKey]bool) bool {
+	key := matchKey{rule: i, value: source}
+	matched, ok := matches[key]
+	if !ok {
+		matched = r.rules[i].regex.MatchString(source)
+		matches[key] = matched
+	}
+	if r.rules[i].action == RelabelKeep {
+		return matched
+	}
+	return !matched
+}
+
+func (r *Relabeler) keepSeries(name string, filters []nameFilter, matches map[matchKey]bool) bool {
+	for _, filter := range filters {
+		rule := r.rules[filter.rule]
+		values := slices.Clone(filter.values)
+		for j, label := range rule.sourceLabels {
+			if label == MetricNameLabel {
+				values[j] = name
+			}
+		}
+		if !r.keep(filter.rule, strings.Join(values, rule.separator), matches) {
+			return false
+		}
+	}
+	return true
+}
+
+// filterSeries applies keep and drop rules that reference __name__ to each series
+func (r *Relabeler) filterSeries(clone *matrix.Matrix, results []relabeled, matches map[matchKey]bool) {
+	var filtered []relabeled
+	for _, result := range results {
+		if len(result.filters) > 0 {
+			filtered = append(filtered, result)
+		}
+	}
+	if len(filtered) == 0 {
+		return
+	}
+
+	for _, metric := range clone.GetMetrics() {
+		if !metric.IsExportable() {
+			continue
+		}
+		name := clone.Object + "_" + metric.GetName()
+		kept := false
+		for _, result := range filtered {
+			if r.keepSeries(name, result.filters, matches) {
+				kept = true
+				continue
+			}
+			metric.SetValueNAN(result.instance)
+		}
+		if !kept && len(filtered) == len(results) {
+			metric.SetExportable(false)
+		}
+	}
+
+	// Prometheus renders instance_labels as the <object>_labels series
+	keepLabels := false
+	for _, result := range results {
+		if r.keepSeries(clone.Object+"_labels", result.filters, matches) {
+			keepLabels = true
+			break
+		}
+	}
+	if !keepLabels {
+		options := clone.GetExportOptions().Copy()
+		options.PopChildS("instance_labels")
+		clone.SetExportOptions(options)
+	}
+}
+
+// updateExportOptions exports the labels created by the rules and stops exporting the labels the rules dropped
+func (r *Relabeler) updateExportOptions(clone *matrix.Matrix, demoted []string, present map[string]bool, droppedNames map[string]bool) {
+	options := clone.GetExportOptions()
+	if options.GetChildContentS("include_all_labels") == "true" {
+		return
+	}
+
+	var keys, labels []string
+	if x := options.GetChildS("instance_keys"); x != nil {
+		keys = x.GetAllChildContentS()
+	}
+	if x := options.GetChildS("instance_labels"); x != nil {
+		labels = x.GetAllChildContentS()
+	}
+
+	isDropped := func(name string) bool { return droppedNames[name] && !present[name] }
+	newKeys := slices.DeleteFunc(slices.Clone(keys), isDropped)
+	newLabels := slices.DeleteFunc(slices.Clone(labels), isDropped)
+
+	var added []string
+	for _, rule := range r.rules {
+		if rule.targetLabel != "" {
+			added = append(added, rule.targetLabel)
+		}
+	}
+	added = append(added, demoted...)
+	slices.Sort(added)
+	for _, name := range slices.Compact(added) {
+		if present[name] && !slices.Contains(newKeys, name) && !slices.Contains(newLabels, name) {
+			newKeys = append(newKeys, name)
+		}
+	}
+
+	if slices.Equal(keys, newKeys) && slices.Equal(labels, newLabels) {
+		return
+	}
+
+	options = options.Copy()
+	options.PopChildS("instance_keys")
+	options.PopChildS("instance_labels")
+	if len(newKeys) > 0 {
+		x := options.NewChildS("instance_keys", "")
+		for _, key := range newKeys {
+			x.NewChildS("", key)
+		}
+	}
+	if len(newLabels) > 0 {
+		x := options.NewChildS("instance_labels", "")
+		for _, label := range newLabels {
+			x.NewChildS("", label)
+		}
+	}
+	clone.SetExportOptions(options)
+}
+
+func (rule relabelRule) source(labels map[string]string) string {
+	if len(rule.sourceLabels) == 1 {
+		return labels[rule.sourceLabels[0]]
+	}
+	values := make([]string, len(rule.sourceLabels))
+	for i, name := range rule.sourceLabels {
+		values[i] = labels[name]
+	}
+	return strings.Join(values, rule.separator)
+}
@@ -0,0 +1,201 @@
+package exporter
+
+import (
+	"errors"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"gopkg.in/yaml.v3"
+	"slices"
+	"testing"
+)
+
+func newRelabeler(t *testing.T, rules string) *Relabeler {
+	t.Helper()
+	var configs []conf.Relabel
+	if err := yaml.Unmarshal([]byte(rules), &configs); err != nil {
+		t.Fatalf("expected no error got %+v", err)
+	}
+	r, err := NewRelabeler(configs)
+	if err != nil {
+		t.Fatalf("expected no error got %+v", err)
+	}
+	return r
+}
+
+func setUpVolumes() *matrix.Matrix {
+	m := matrix.New("volume", "volume", "volume")
+	m.SetGlobalLabel("datacenter", "dc1")
+	m.SetGlobalLabel("cluster", "cluster1")
+
+	options := m.GetExportOptions().Copy()
+	options.PopChildS("include_all_labels")
+	keys := options.NewChildS("instance_keys", "")
+	keys.NewChildS("", "volume")
+	keys.NewChildS("", "svm")
+	keys.NewChildS("", "style")
+	m.SetExportOptions(options)
+
+	size, _ := m.NewMetricFloat64("size")
+	used, _ := m.NewMetricFloat64("size_used")
+	volumes := [][]string{
+		{"vol1", "svm1", "flexvol"},
+		{"vol2", "svm1", "flexgroup"},
+		{"vol3", "svm2", "flexvol"},
+	}
+	for i, v := range volumes {
+		instance, _ := m.NewInstance(v[0])
+		instance.SetLabel("volume", v[0])
+		instance.SetLabel("svm", v[1])
+		instance.SetLabel("style", v[2])
+		_ = size.SetValueFloat64(instance, float64(100*(i+1)))
+		_ = used.SetValueFloat64(instance, float64(10*(i+1)))
+	}
+	return m
+}
+
+func exported(m *matrix.Matrix) []string {
+	var series []string
+	for key, instance := range m.GetInstances() {
+		if !instance.IsExportable() {
+			continue
+		}
+		for _, metric := range m.GetMetrics() {
+			if !metric.IsExportable() {
+				continue
+			}
+			if _, ok := metric.GetValueFloat64(instance); ok {
+				series = append(series, key+" "+metric.GetName())
+			}
+		}
+	}
+	slices.Sort(series)
+	return series
+}
+
+func TestRelabel_KeepDrop(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		want  []string
+	}{
+		{
+			name: "keep by label",
+			rules: `
+- source_labels: [svm]
+  regex: svm1
+  action: keep`,
+			want: []string{"vol1 size", "vol1 size_used", "vol2 size", "vol2 size_used"},
+		},
+		{
+			name: "drop by joined labels",
+			rules: `
+- source_labels: [svm, style]
+  regex: svm1;flex.*
+  action: drop`,
+			want: []string{"vol3 size", "vol3 size_used"},
+		},
+		{
+			name: "keep by metric name",
+			rules: `
+- source_labels: [__name__]
+  regex: volume_size
+  action: keep`,
+			want: []string{"vol1 size", "vol2 size", "vol3 size"},
+		},
+		{
+			name: "drop by metric name and label",
+			rules: `
+- source_labels: [__name__, volume]
+  regex: volume_size_used;vol[12]
+  action: drop`,
+			want: []string{"vol1 size", "vol2 size", "vol3 size", "vol3 size_used"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := setUpVolumes()
+			got := exported(newRelabeler(t, tt.rules).Apply(data))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			// The original matrix is shared with other exporters and must not change
+			if n := len(exported(data)); n != 6 {
+				t.Errorf("original matrix changed, got %d series, want 6", n)
+			}
+		})
+	}
+}
+
+func TestRelabel_Labels(t *testing.T) {
+	data := setUpVolumes()
+	r := newRelabeler(t, `
+- source_labels: [svm, volume]
+  separator: /
+  target_label: path
+  replacement: /$1
+- source_labels: [volume]
+  regex: vol(\d+)
+  target_label: id
+  replacement: $1
+- source_labels: [volume]
+  target_label: shard
+  modulus: 4
+  action: hashmod
+- regex: style|datacenter
+  action: labeldrop
+`)
+	got := r.Apply(data)
+
+	vol2 := got.GetInstance("vol2")
+	if vol2.GetLabel("path") != "/svm1/vol2" || vol2.GetLabel("id") != "2" || vol2.GetLabel("style") != "" {
+		t.Errorf("got labels %v", vol2.GetLabels())
+	}
+	if shard := vol2.GetLabel("shard"); shard == "" || len(shard) != 1 {
+		t.Errorf("got shard=%s, want a value in [0,4)", shard)
+	}
+
+	if _, ok := got.GetGlobalLabels()["datacenter"]; ok {
+		t.Errorf("expected datacenter to be dropped from the global labels")
+	}
+	if got.GetGlobalLabels()["cluster"] != "cluster1" {
+		t.Errorf("got cluster=%s, want cluster1", got.GetGlobalLabels()["cluster"])
+	}
+	if data.GetGlobalLabels()["datacenter"] != "dc1" || data.GetInstance("vol2").GetLabel("style") != "flexgroup" {
+		t.Errorf("original matrix changed")
+	}
+
+	keys := got.GetExportOptions().GetChildS("instance_keys").GetAllChildContentS()
+	want := []string{"volume", "svm", "id", "path", "shard"}
+	if !slices.Equal(keys, want) {
+		t.Errorf("got instance_keys %v, want %v", keys, want)
+	}
+}
+
+func TestRelabel_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   string
+		wantErr error
+	}{
+		{name: "unknown action", rules: `[{action: rename}]`, wantErr: errs.ErrInvalidParam},
+		{name: "bad regex", rules: `[{source_labels: [svm], regex: "(", action: keep}]`, wantErr: errs.ErrInvalidParam},
+		{name: "replace without target", rules: `[{source_labels: [svm]}]`, wantErr: errs.ErrMissingParam},
+		{name: "hashmod without modulus", rules: `[{source_labels: [svm], target_label: shard, action: hashmod}]`, wantErr: errs.ErrMissingParam},
+		{name: "rename metric", rules: `[{source_labels: [svm], target_label: __name__}]`, wantErr: errs.ErrInvalidParam},
+		{name: "keep without source", rules: `[{regex: svm1, action: keep}]`, wantErr: errs.ErrMissingParam},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var configs []conf.Relabel
+			if err := yaml.Unmarshal([]byte(tt.rules), &configs); err != nil {
+				t.Fatalf("expected no error got %+v", err)
+			}
+			if _, err := NewRelabeler(configs); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got err=%v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
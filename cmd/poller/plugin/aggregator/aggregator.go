@@ -6,6 +6,7 @@
 package aggregator
 
 import (
+	"cmp"
 	"github.com/netapp/harvest/v2/cmd/poller/plugin"
 	"github.com/netapp/harvest/v2/pkg/conf"
 	"github.com/netapp/harvest/v2/pkg/errs"
@@ -13,23 +14,32 @@ import (
 	"github.com/netapp/harvest/v2/pkg/slogx"
 	"github.com/netapp/harvest/v2/pkg/util"
 	"log/slog"
-	"maps"
 	"regexp"
-	"slices"
 	"strings"
 )
 
 type Aggregator struct {
 	*plugin.AbstractPlugin
 	rules []*rule
+	// max is true when the receiver is the Max plugin, which is an Aggregator whose rules only have max(*)
+	max bool
 }
 
 func New(p *plugin.AbstractPlugin) *Aggregator {
 	return &Aggregator{AbstractPlugin: p}
 }
 
+// NewMax returns an Aggregator that calculates the max of each metric, and copies the labels of the instance
+// with the max value, like the Max plugin
+func NewMax(p *plugin.AbstractPlugin) *Aggregator {
+	return &Aggregator{AbstractPlugin: p, max: true}
+}
+
 type rule struct {
+	line string
+	// label is the first of the labels the rule groups by
 	label         string
+	labels        []string
 	object        string
 	checkLabel    string
 	checkValue    string
@@ -37,6 +47,10 @@ type rule struct {
 	includeLabels []string
 	allLabels     bool
 	counts        map[string]map[string]float64
+	// functions are empty for rules that sum or average all metrics
+	functions []*function
+	// split is true for rules that create a matrix for each metric
+	split bool
 }
 
 func (a *Aggregator) Init(remote conf.Remote) error {
@@ -60,67 +74,171 @@ func (a *Aggregator) parseRules() error {
 
 	for _, line := range a.Params.GetAllChildContentS() {
 
-		r := rule{}
+		r := rule{line: line}
 
 		fields := strings.Fields(line)
-		if len(fields) == 2 || len(fields) == 1 {
-			// parse label, possibly followed by value and object
-			prefix := strings.SplitN(fields[0], "<", 2)
-			r.label = strings.TrimSpace(prefix[0])
-			if len(prefix) == 2 {
-				// rule part in <>
-				suffix := strings.SplitN(prefix[1], ">", 2)
-				value := ""
-				if s := strings.SplitN(suffix[0], "=", 2); len(s) == 2 {
-					r.checkLabel = s[0]
-					value = s[1]
-				} else if s[0] != "" {
-					r.checkLabel = r.label
-					value = s[0]
-				}
+		if len(fields) == 0 {
+			return errs.New(errs.ErrInvalidParam, "invalid rule syntax "+line)
+		}
 
-				if strings.HasPrefix(value, "`") {
-					value = strings.TrimPrefix(strings.TrimSuffix(value, "`"), "`")
-					if r.checkRegex, err = regexp.Compile(value); err != nil {
-						a.SLogger.Error("ignore rule", slogx.Err(err))
-						return err
-					}
-				} else if value != "" {
-					r.checkValue = value
-				}
+		// parse labels, possibly followed by value and object
+		prefix := strings.SplitN(fields[0], "<", 2)
+		for _, label := range strings.Split(prefix[0], ",") {
+			if label = strings.TrimSpace(label); label != "" {
+				r.labels = append(r.labels, label)
+			}
+		}
+		if len(r.labels) == 0 {
+			return errs.New(errs.ErrInvalidParam, "invalid rule syntax "+line)
+		}
+		r.label = r.labels[0]
+		if len(prefix) == 2 {
+			// rule part in <>
+			suffix := strings.SplitN(prefix[1], ">", 2)
+			value := ""
+			if s := strings.SplitN(suffix[0], "=", 2); len(s) == 2 {
+				r.checkLabel = s[0]
+				value = s[1]
+			} else if s[0] != "" {
+				r.checkLabel = r.label
+				value = s[0]
+			}
 
-				if len(suffix) == 2 && suffix[1] != "" {
-					r.object = strings.ToLower(suffix[1])
+			if strings.HasPrefix(value, "`") {
+				value = strings.TrimPrefix(strings.TrimSuffix(value, "`"), "`")
+				if r.checkRegex, err = regexp.Compile(value); err != nil {
+					a.SLogger.Error("ignore rule", slogx.Err(err))
+					return err
 				}
+			} else if value != "" {
+				r.checkValue = value
 			}
-			if len(fields) == 2 {
-				if strings.TrimSpace(fields[1]) == "..." {
-					r.allLabels = true
-				} else {
-					r.includeLabels = strings.Split(fields[1], ",")
+
+			if len(suffix) == 2 && suffix[1] != "" {
+				r.object = strings.ToLower(suffix[1])
+			}
+		}
+
+		// the rest of the rule are the labels to copy and the functions
+		for _, field := range fields[1:] {
+			switch {
+			case field == "...":
+				r.allLabels = true
+			case isFunction(field):
+				f, err := parseFunction(field)
+				if err != nil {
+					return err
 				}
+				r.functions = append(r.functions, f)
+			case r.includeLabels == nil:
+				r.includeLabels = strings.Split(field, ",")
+			default:
+				return errs.New(errs.ErrInvalidParam, "invalid rule syntax "+line)
 			}
-			a.rules = append(a.rules, &r)
-			a.SLogger.Debug("parsed rule", slog.String("label", r.label), slog.String("object", r.object))
-		} else {
-			return errs.New(errs.ErrInvalidParam, "invalid rule syntax "+line)
 		}
+
+		if a.max {
+			if len(r.functions) != 0 {
+				return errs.New(errs.ErrInvalidParam, "Max rules have no functions "+line)
+			}
+			r.functions = []*function{{kind: "max", metric: allMetrics}}
+			r.split = true
+		}
+
+		a.rules = append(a.rules, &r)
+		a.SLogger.Debug("parsed rule", slog.String("label", r.label), slog.String("object", r.object))
 	}
 	return nil
 }
 
+// match returns true if the instance passes the rule's check of a label value
+func (r *rule) match(instance *matrix.Instance) bool {
+	if r.checkLabel == "" {
+		return true
+	}
+	if r.checkRegex != nil {
+		return r.checkRegex.MatchString(instance.GetLabel(r.checkLabel))
+	}
+	return instance.GetLabel(r.checkLabel) == r.checkValue
+}
+
+// groupName returns the values of the labels the rule groups by, joined with a dot, and false if one is missing
+func (r *rule) groupName(instance *matrix.Instance) (string, bool) {
+	values := make([]string, 0, len(r.labels))
+	for _, label := range r.labels {
+		value := instance.GetLabel(label)
+		if value == "" {
+			return "", false
+		}
+		values = append(values, value)
+	}
+	return strings.Join(values, "."), true
+}
+
+// groupKey returns the key of the new instance that an instance is aggregated into
+func (r *rule) groupKey(instance *matrix.Instance) (string, bool) {
+	objName, ok := r.groupName(instance)
+	if !ok {
+		return "", false
+	}
+	switch {
+	case r.allLabels:
+		values := make([]string, 0, instance.NumLabels())
+		for k := range instance.Labels() {
+			values = append(values, k)
+		}
+		return strings.Join(values, "."), true
+	case len(r.includeLabels) != 0:
+		objKey := objName
+		for _, k := range r.includeLabels {
+			objKey += "." + instance.GetLabel(k)
+		}
+		return objKey, true
+	default:
+		return objName, true
+	}
+}
+
+// setLabels copies the labels of instance, that the rule groups by or includes, to the new instance
+func (r *rule) setLabels(objInstance *matrix.Instance, instance *matrix.Instance) {
+	if r.allLabels {
+		objInstance.ClearLabels()
+		for k, v := range instance.Labels() {
+			objInstance.SetLabel(k, v)
+		}
+		return
+	}
+	for _, k := range r.includeLabels {
+		objInstance.SetLabel(k, instance.GetLabel(k))
+	}
+	for _, label := range r.labels {
+		objInstance.SetLabel(label, instance.GetLabel(label))
+	}
+}
+
+// objectName returns the object of the matrix the rule creates
+func (r *rule) objectName(object string) string {
+	if r.object != "" {
+		return r.object
+	}
+	return strings.ToLower(strings.Join(r.labels, "_")) + "_" + object
+}
+
+func slogLabels(r *rule) slog.Attr {
+	return slog.String("label", strings.Join(r.labels, ","))
+}
+
 func (a *Aggregator) Run(dataMap map[string]*matrix.Matrix) ([]*matrix.Matrix, *util.Metadata, error) {
 	data := dataMap[a.Object]
 	matrices := make([]*matrix.Matrix, len(a.rules))
 
-	// initialize cache
+	// initialize cache, rules with functions create their matrices when they run
 	for i, rule := range a.rules {
-		matrices[i] = data.Clone(matrix.With{Data: false, Metrics: true, Instances: false, ExportInstances: true})
-		if rule.object != "" {
-			matrices[i].Object = rule.object
-		} else {
-			matrices[i].Object = strings.ToLower(rule.label) + "_" + data.Object
+		if len(rule.functions) != 0 {
+			continue
 		}
+		matrices[i] = data.Clone(matrix.With{Data: false, Metrics: true, Instances: false, ExportInstances: true})
+		matrices[i].Object = rule.objectName(data.Object)
 		matrices[i].UUID += ".Aggregator"
 		matrices[i].SetExportOptions(matrix.DefaultExportOptions())
 		matrices[i].SetExportable(true)
@@ -147,51 +265,28 @@ func (a *Aggregator) Run(dataMap map[string]*matrix.Matrix) ([]*matrix.Matrix, *
 		}
 
 		for i, rule := range a.rules {
-			if objName = instance.GetLabel(rule.label); objName == "" {
-				a.SLogger.Warn("label missing, skipped", slog.String("label", rule.label))
+			if matrices[i] == nil {
 				continue
 			}
 
-			if rule.checkLabel != "" {
-				if rule.checkRegex != nil {
-					if !rule.checkRegex.MatchString(instance.GetLabel(rule.checkLabel)) {
-						continue
-					}
-				} else if instance.GetLabel(rule.checkLabel) != rule.checkValue {
-					continue
-				}
+			if objName, ok = rule.groupName(instance); !ok {
+				a.SLogger.Warn("label missing, skipped", slogLabels(rule))
+				continue
 			}
 
-			switch {
-			case rule.allLabels:
-				values := slices.Collect(maps.Keys(instance.GetLabels()))
-				objKey = strings.Join(values, ".")
-			case len(rule.includeLabels) != 0:
-				objKey = objName
-				for _, k := range rule.includeLabels {
-					objKey += "." + instance.GetLabel(k)
-				}
-			default:
-				objKey = objName
+			if !rule.match(instance) {
+				continue
 			}
 
+			objKey, _ = rule.groupKey(instance)
+
 			if objInstance = matrices[i].GetInstance(objKey); objInstance == nil {
 				rule.counts[objKey] = make(map[string]float64)
 				if objInstance, err = matrices[i].NewInstance(objKey); err != nil {
 					return nil, nil, err
 				}
 				metadata.PluginInstances++
-				switch {
-				case rule.allLabels:
-					objInstance.SetLabels(instance.GetLabels())
-				case len(rule.includeLabels) != 0:
-					for _, k := range rule.includeLabels {
-						objInstance.SetLabel(k, instance.GetLabel(k))
-					}
-					objInstance.SetLabel(rule.label, objName)
-				default:
-					objInstance.SetLabel(rule.label, objName)
-				}
+				rule.setLabels(objInstance, instance)
 			}
 
 			for key, metric := range data.GetMetrics() {
@@ -248,6 +343,9 @@ func (a *Aggregator) Run(dataMap map[string]*matrix.Matrix) ([]*matrix.Matrix, *
 
 	// normalize values into averages if we are able to identify it as a percentage or average metric
 	for i, m := range matrices {
+		if m == nil {
+			continue
+		}
 		for mk, metric := range m.GetMetrics() {
 			var (
 				v       float64
@@ -297,24 +395,79 @@ func (a *Aggregator) Run(dataMap map[string]*matrix.Matrix) ([]*matrix.Matrix, *
 		}
 	}
 
-	return matrices, metadata, nil
+	results := make([]*matrix.Matrix, 0, len(matrices))
+	for i, m := range matrices {
+		if m != nil {
+			results = append(results, m)
+			continue
+		}
+		created, err := a.runFunctions(i, a.rules[i], data, metadata)
+		if err != nil {
+			return nil, nil, err
+		}
+		results = append(results, created...)
+	}
+
+	return results, metadata, nil
 }
 
 // NewLabels returns the new labels the receiver creates
 func (a *Aggregator) NewLabels() []string {
 	var newLabelNames []string
 	for _, r := range a.rules {
+		newLabelNames = append(newLabelNames, r.labels[1:]...)
 		newLabelNames = append(newLabelNames, r.includeLabels...)
 	}
 
 	return newLabelNames
 }
 
-// NewMetrics returns the new metrics the receiver creates
+// SourceLabels returns the labels the receiver's rules group by, filter on, or copy from the source instances
+func (a *Aggregator) SourceLabels() []string {
+	var labels []string
+	for _, r := range a.rules {
+		labels = append(labels, r.labels...)
+		if r.checkLabel != "" {
+			labels = append(labels, r.checkLabel)
+		}
+		labels = append(labels, r.includeLabels...)
+		for _, f := range r.functions {
+			switch {
+			case f.label != "":
+				labels = append(labels, f.label)
+			case f.cond != nil && f.cond.label != "":
+				labels = append(labels, f.cond.label)
+			}
+		}
+	}
+	return labels
+}
+
+// NewMetrics returns the new metrics the receiver creates. Rules without functions aggregate every metric.
+// Functions of a single metric create one metric of the rule's object, functions of all metrics are not listed.
 func (a *Aggregator) NewMetrics() []plugin.DerivedMetric {
 	derivedMetrics := make([]plugin.DerivedMetric, 0, len(a.rules))
 	for _, r := range a.rules {
-		derivedMetrics = append(derivedMetrics, plugin.DerivedMetric{Name: r.label, Source: r.object})
+		if a.max {
+			derivedMetrics = append(derivedMetrics, plugin.DerivedMetric{Name: r.object, Source: r.label, IsMax: true})
+			continue
+		}
+		if len(r.functions) == 0 {
+			derivedMetrics = append(derivedMetrics, plugin.DerivedMetric{Name: r.label, Source: r.object})
+			continue
+		}
+		object := r.objectName(a.Object)
+		for _, f := range r.functions {
+			switch {
+			case f.metric == allMetrics:
+				continue
+			case f.metric != "":
+				name := cmp.Or(f.name, f.outputName(f.metric))
+				derivedMetrics = append(derivedMetrics, plugin.DerivedMetric{Name: name, Source: f.metric, Object: object})
+			default:
+				derivedMetrics = append(derivedMetrics, plugin.DerivedMetric{Name: f.name, Source: f.kind, Object: object})
+			}
+		}
 	}
 
 	return derivedMetrics
@@ -0,0 +1,479 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package aggregator
+
+import (
+	"fmt"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/util"
+	"maps"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// allMetrics is the argument of a function that applies to every metric
+const allMetrics = "*"
+
+var percentileRe = regexp.MustCompile(`^p(\d{1,2}(\.\d+)?)$`)
+
+// function is an aggregation function of a rule, e.g., p90(read_latency) or online=count_if(state=online)
+type function struct {
+	name       string
+	kind       string
+	metric     string
+	weight     string
+	percentile float64
+	cond       *condition
+	label      string
+}
+
+// condition is the argument of count_if, either a label match or a metric comparison
+type condition struct {
+	label  string
+	value  string
+	regex  *regexp.Regexp
+	negate bool
+	metric string
+	op     string
+	number float64
+}
+
+// isFunction returns true if field is a function of a rule, as opposed to a list of labels
+func isFunction(field string) bool {
+	return strings.Contains(field, "(") || field == "count" || strings.HasSuffix(field, "=count")
+}
+
+func parseFunction(field string) (*function, error) {
+	f := &function{}
+	call := field
+	// an optional name, e.g., online=count_if(state=online). A = inside the parentheses is not a name.
+	if name, rest, ok := strings.Cut(field, "="); ok && !strings.Contains(name, "(") {
+		f.name = name
+		call = rest
+	}
+
+	var args []string
+	kind, rest, hasArgs := strings.Cut(call, "(")
+	if hasArgs {
+		inner, ok := strings.CutSuffix(rest, ")")
+		if !ok {
+			return nil, errs.New(errs.ErrInvalidParam, "function "+field+" is missing )")
+		}
+		if inner != "" {
+			args = strings.Split(inner, ",")
+		}
+	}
+	f.kind = kind
+
+	switch {
+	case kind == "sum" || kind == "avg" || kind == "min" || kind == "max":
+		if len(args) != 1 {
+			return nil, errs.New(errs.ErrInvalidParam, "function "+field+" needs one metric")
+		}
+		f.metric = args[0]
+	case percentileRe.MatchString(kind):
+		if len(args) != 1 {
+			return nil, errs.New(errs.ErrInvalidParam, "function "+field+" needs one metric")
+		}
+		f.metric = args[0]
+		f.percentile, _ = strconv.ParseFloat(percentileRe.FindStringSubmatch(kind)[1], 64)
+		f.kind = "percentile"
+	case kind == "weighted_avg":
+		if len(args) != 2 || args[0] == allMetrics {
+			return nil, errs.New(errs.ErrInvalidParam, "function "+field+" needs a metric and a weight metric")
+		}
+		f.metric = args[0]
+		f.weight = args[1]
+	case kind == "count":
+		if len(args) != 0 {
+			return nil, errs.New(errs.ErrInvalidParam, "function "+field+" has no arguments")
+		}
+		if f.name == "" {
+			f.name = "count"
+		}
+	case kind == "count_if":
+		if len(args) != 1 {
+			return nil, errs.New(errs.ErrInvalidParam, "function "+field+" needs one condition")
+		}
+		if f.name == "" {
+			return nil, errs.New(errs.ErrInvalidParam, "function "+field+" needs a name, e.g., NAME="+field)
+		}
+		cond, err := parseCondition(args[0])
+		if err != nil {
+			return nil, err
+		}
+		f.cond = cond
+	case kind == "count_distinct":
+		if len(args) != 1 {
+			return nil, errs.New(errs.ErrInvalidParam, "function "+field+" needs one label")
+		}
+		f.label = args[0]
+		if f.name == "" {
+			f.name = f.label + "_count"
+		}
+	default:
+		return nil, errs.New(errs.ErrInvalidParam, "unknown function "+field)
+	}
+
+	if f.metric == allMetrics && f.name != "" {
+		return nil, errs.New(errs.ErrInvalidParam, "function "+field+" applies to all metrics and can not be named")
+	}
+	return f, nil
+}
+
+func parseCondition(s string) (*condition, error) {
+	for _, op := range []string{"!=", ">=", "<=", "=", ">", "<"} {
+		left, right, ok := strings.Cut(s, op)
+		if !ok {
+			continue
+		}
+		if left == "" {
+			break
+		}
+		c := &condition{}
+		if op == "=" || op == "!=" {
+			c.label = left
+			c.negate = op == "!="
+			if strings.HasPrefix(right, "`") {
+				var err error
+				if c.regex, err = regexp.Compile(strings.Trim(right, "`")); err != nil {
+					return nil, errs.New(errs.ErrInvalidParam, "condition "+s+": "+err.Error())
+				}
+			} else {
+				c.value = right
+			}
+			return c, nil
+		}
+		number, err := strconv.ParseFloat(right, 64)
+		if err != nil {
+			return nil, errs.New(errs.ErrInvalidParam, "condition "+s+" compares a metric to a number")
+		}
+		c.metric = left
+		c.op = op
+		c.number = number
+		return c, nil
+	}
+	return nil, errs.New(errs.ErrInvalidParam, "invalid condition "+s)
+}
+
+func (c *condition) match(data *matrix.Matrix, instance *matrix.Instance) bool {
+	if c.label != "" {
+		value := instance.GetLabel(c.label)
+		var matched bool
+		if c.regex != nil {
+			matched = c.regex.MatchString(value)
+		} else {
+			matched = value == c.value
+		}
+		return matched != c.negate
+	}
+	metric := data.GetMetric(c.metric)
+	if metric == nil {
+		return false
+	}
+	v, ok := metric.GetValueFloat64(instance)
+	if !ok {
+		return false
+	}
+	switch c.op {
+	case ">":
+		return v > c.number
+	case ">=":
+		return v >= c.number
+	case "<":
+		return v < c.number
+	default:
+		return v <= c.number
+	}
+}
+
+// output is a metric created by a function. Functions of all metrics create an output for each metric.
+type output struct {
+	*function
+	name   string
+	source *matrix.Metric
+	weight *matrix.Metric
+}
+
+// accumulator collects the values of an output for one instance of the new matrix
+type accumulator struct {
+	n        int
+	sum      float64
+	weight   float64
+	extreme  float64
+	values   []float64
+	distinct map[string]struct{}
+	// the instance with the min or max value
+	from *matrix.Instance
+}
+
+// outputName returns the default name of the output of the function for a metric, e.g., read_latency_p90
+func (f *function) outputName(metric string) string {
+	if f.kind == "percentile" {
+		return metric + "_p" + strconv.FormatFloat(f.percentile, 'f', -1, 64)
+	}
+	return metric + "_" + f.kind
+}
+
+// outputs expands the functions of the rule with the metrics of data
+func (r *rule) outputs(data *matrix.Matrix) []output {
+	var outputs []output
+	for _, f := range r.functions {
+		switch {
+		case f.metric == allMetrics:
+			for _, key := range slices.Sorted(maps.Keys(data.GetMetrics())) {
+				name := f.outputName(key)
+				if r.split {
+					name = key
+				}
+				outputs = append(outputs, output{function: f, name: name, source: data.GetMetric(key)})
+			}
+		case f.metric != "":
+			o := output{function: f, name: f.name, source: data.GetMetric(f.metric)}
+			if o.source == nil {
+				continue
+			}
+			if o.name == "" {
+				o.name = f.outputName(f.metric)
+			}
+			if f.weight != "" {
+				if o.weight = data.GetMetric(f.weight); o.weight == nil {
+					continue
+				}
+			}
+			outputs = append(outputs, o)
+		default:
+			outputs = append(outputs, output{function: f, name: f.name})
+		}
+	}
+	return outputs
+}
+
+func (o output) add(acc *accumulator, data *matrix.Matrix, instance *matrix.Instance) {
+	switch o.kind {
+	case "count":
+		acc.n++
+		return
+	case "count_if":
+		if o.cond.match(data, instance) {
+			acc.n++
+		}
+		return
+	case "count_distinct":
+		if value := instance.GetLabel(o.label); value != "" {
+			if acc.distinct == nil {
+				acc.distinct = make(map[string]struct{})
+			}
+			acc.distinct[value] = struct{}{}
+		}
+		return
+	}
+
+	value, ok := o.source.GetValueFloat64(instance)
+	if !ok || math.IsNaN(value) {
+		return
+	}
+	switch o.kind {
+	case "sum", "avg":
+		acc.sum += value
+	case "weighted_avg":
+		w, ok := o.weight.GetValueFloat64(instance)
+		if !ok {
+			return
+		}
+		acc.sum += value * w
+		acc.weight += w
+	case "min":
+		if acc.n == 0 || value < acc.extreme {
+			acc.extreme = value
+			acc.from = instance
+		}
+	case "max":
+		if acc.n == 0 || value > acc.extreme {
+			acc.extreme = value
+			acc.from = instance
+		}
+	case "percentile":
+		acc.values = append(acc.values, value)
+	}
+	acc.n++
+}
+
+// value returns the result of the output, and false when there is none
+func (o output) value(acc *accumulator) (float64, bool) {
+	switch o.kind {
+	case "count", "count_if":
+		return float64(acc.n), true
+	case "count_distinct":
+		return float64(len(acc.distinct)), true
+	}
+	if acc.n == 0 {
+		return 0, false
+	}
+	switch o.kind {
+	case "sum":
+		return acc.sum, true
+	case "avg":
+		return acc.sum / float64(acc.n), true
+	case "weighted_avg":
+		// like latencies of the default rules, the average is 0 when no ops happened
+		if acc.weight == 0 {
+			return 0, true
+		}
+		return acc.sum / acc.weight, true
+	case "min", "max":
+		return acc.extreme, true
+	case "percentile":
+		return percentile(acc.values, o.percentile), true
+	}
+	return 0, false
+}
+
+// percentile returns the p-th percentile of values, interpolated between the closest ranks
+func percentile(values []float64, p float64) float64 {
+	slices.Sort(values)
+	rank := p / 100 * float64(len(values)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	if lower == upper {
+		return values[lower]
+	}
+	return values[lower] + (rank-float64(lower))*(values[upper]-values[lower])
+}
+
+// newMatrix returns an empty matrix for the results of a rule
+func (r *rule) newMatrix(data *matrix.Matrix, uuid string) *matrix.Matrix {
+	m := data.Clone(matrix.With{Data: false, Metrics: false, Instances: false, ExportInstances: true})
+	m.Object = r.objectName(data.Object)
+	m.UUID += uuid
+	m.SetExportOptions(matrix.DefaultExportOptions())
+	m.SetExportable(true)
+	return m
+}
+
+// newMetric adds the metric of an output to m. Metrics of outputs of all metrics keep the labels of their source,
+// e.g., the buckets of histograms, and Max outputs are copies of their source. Unlike Instance.GetLabels,
+// Metric.GetLabels returns the source's map, so it is cloned.
+func (o output) newMetric(m *matrix.Matrix, split bool) (*matrix.Metric, error) {
+	if split {
+		mm, err := m.NewMetricType(o.name, o.source.GetType(), o.source.GetName())
+		if err != nil {
+			return nil, err
+		}
+		mm.SetProperty(o.source.GetProperty())
+		mm.SetComment(o.source.GetComment())
+		mm.SetExportable(o.source.IsExportable())
+		mm.SetHistogram(o.source.IsHistogram())
+		mm.SetLabels(maps.Clone(o.source.GetLabels()))
+		return mm, nil
+	}
+	mm, err := m.NewMetricFloat64(o.name)
+	if err != nil {
+		return nil, err
+	}
+	mm.SetProperty("aggregator")
+	if o.source != nil {
+		if o.metric == allMetrics {
+			mm.SetExportable(o.source.IsExportable())
+		}
+		if o.source.HasLabels() {
+			mm.SetLabels(maps.Clone(o.source.GetLabels()))
+		}
+	}
+	return mm, nil
+}
+
+// runFunctions aggregates data with the functions of rule r. Rules of the Max plugin create a matrix for each
+// metric, since the labels of each instance are copied from the source instance with the max value of the metric.
+func (a *Aggregator) runFunctions(index int, r *rule, data *matrix.Matrix, metadata *util.Metadata) ([]*matrix.Matrix, error) {
+	outputs := r.outputs(data)
+	groups := make(map[string]*matrix.Instance)
+	accs := make(map[string][]*accumulator)
+	order := make([]string, 0)
+
+	for _, instance := range data.GetInstances() {
+		if !instance.IsExportable() || !r.match(instance) {
+			continue
+		}
+		// like the Max plugin, split rules copy labels from an instance instead of grouping by them
+		groupKey := r.groupKey
+		if r.split {
+			groupKey = r.groupName
+		}
+		objKey, ok := groupKey(instance)
+		if !ok {
+			a.SLogger.Warn("label missing, skipped", slogLabels(r))
+			continue
+		}
+		if _, ok := groups[objKey]; !ok {
+			groups[objKey] = instance
+			accs[objKey] = make([]*accumulator, len(outputs))
+			for i := range outputs {
+				accs[objKey][i] = &accumulator{}
+			}
+			order = append(order, objKey)
+		}
+		for i, o := range outputs {
+			o.add(accs[objKey][i], data, instance)
+		}
+	}
+
+	uuid := ".Aggregator." + strconv.Itoa(index)
+	if !r.split {
+		m := r.newMatrix(data, uuid)
+		metrics := make([]*matrix.Metric, len(outputs))
+		for i, o := range outputs {
+			var err error
+			if metrics[i], err = o.newMetric(m, false); err != nil {
+				return nil, fmt.Errorf("rule %s: %w", r.line, err)
+			}
+		}
+		for _, objKey := range order {
+			objInstance, err := m.NewInstance(objKey)
+			if err != nil {
+				return nil, err
+			}
+			metadata.PluginInstances++
+			r.setLabels(objInstance, groups[objKey])
+			for i, o := range outputs {
+				if v, ok := o.value(accs[objKey][i]); ok {
+					_ = metrics[i].SetValueFloat64(objInstance, v)
+				}
+			}
+		}
+		return []*matrix.Matrix{m}, nil
+	}
+
+	matrices := make([]*matrix.Matrix, 0, len(outputs))
+	for i, o := range outputs {
+		m := r.newMatrix(data, uuid+"."+o.name)
+		metric, err := o.newMetric(m, true)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", r.line, err)
+		}
+		for _, objKey := range order {
+			objInstance, err := m.NewInstance(objKey)
+			if err != nil {
+				return nil, err
+			}
+			metadata.PluginInstances++
+			acc := accs[objKey][i]
+			from := acc.from
+			if from == nil {
+				from = groups[objKey]
+			}
+			r.setLabels(objInstance, from)
+			if v, ok := o.value(acc); ok {
+				_ = metric.SetValueFloat64(objInstance, v)
+			}
+		}
+		matrices = append(matrices, m)
+	}
+	return matrices, nil
+}
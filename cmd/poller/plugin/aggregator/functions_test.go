@@ -0,0 +1,196 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package aggregator
+
+import (
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"math"
+	"testing"
+)
+
+type volume struct {
+	name, svm, node, state string
+	latency, ops, size     float64
+}
+
+func newVolumes(t *testing.T) *matrix.Matrix {
+	t.Helper()
+	volumes := []volume{
+		{"vol1", "svm1", "node1", "online", 10, 100, 1},
+		{"vol2", "svm1", "node1", "online", 20, 300, 2},
+		{"vol3", "svm2", "node1", "offline", 30, 0, 3},
+		{"vol4", "svm2", "node1", "online", 40, 100, 4},
+		{"vol5", "svm1", "node2", "online", 50, 10, 5},
+	}
+	m := matrix.New("Rest", "volume", "volume")
+	latency, _ := m.NewMetricFloat64("read_latency")
+	ops, _ := m.NewMetricFloat64("read_ops")
+	size, _ := m.NewMetricUint64("size")
+	for _, v := range volumes {
+		instance, err := m.NewInstance(v.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		instance.SetLabel("volume", v.name)
+		instance.SetLabel("svm", v.svm)
+		instance.SetLabel("node", v.node)
+		instance.SetLabel("state", v.state)
+		_ = latency.SetValueFloat64(instance, v.latency)
+		_ = ops.SetValueFloat64(instance, v.ops)
+		_ = size.SetValueFloat64(instance, v.size)
+	}
+	return m
+}
+
+func runRules(t *testing.T, newPlugin func(*plugin.AbstractPlugin) *Aggregator, rules ...string) []*matrix.Matrix {
+	t.Helper()
+	params := node.NewS("Aggregator")
+	for _, r := range rules {
+		params.NewChildS("", r)
+	}
+	a := newPlugin(plugin.New("Rest", nil, params, nil, "volume", nil))
+	if err := a.Init(conf.Remote{}); err != nil {
+		t.Fatal(err)
+	}
+	data := newVolumes(t)
+	results, _, err := a.Run(map[string]*matrix.Matrix{"volume": data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+func checkValue(t *testing.T, m *matrix.Matrix, metric string, instance string, want float64) {
+	t.Helper()
+	mm := m.GetMetric(metric)
+	if mm == nil {
+		t.Fatalf("metric %s is missing", metric)
+	}
+	i := m.GetInstance(instance)
+	if i == nil {
+		t.Fatalf("instance %s is missing", instance)
+	}
+	got, ok := mm.GetValueFloat64(i)
+	if !ok {
+		t.Errorf("%s of %s has no value, want=%f", metric, instance, want)
+		return
+	}
+	if math.Abs(got-want) > 1e-9 {
+		t.Errorf("%s of %s got=%f, want=%f", metric, instance, got, want)
+	}
+}
+
+func TestFunctions(t *testing.T) {
+	results := runRules(t, New,
+		"node sum(size) avg(read_latency) weighted_avg(read_latency,read_ops) min(read_latency) max(read_latency) "+
+			"p50(read_latency) p90(read_latency) count online=count_if(state=online) busy=count_if(read_ops>=100) "+
+			"count_distinct(svm)",
+	)
+	if len(results) != 1 {
+		t.Fatalf("got %d matrices, want=1", len(results))
+	}
+	m := results[0]
+	if m.Object != "node_volume" {
+		t.Errorf("object got=%s, want=node_volume", m.Object)
+	}
+
+	tests := []struct {
+		metric string
+		node1  float64
+		node2  float64
+	}{
+		{metric: "size_sum", node1: 10, node2: 5},
+		{metric: "read_latency_avg", node1: 25, node2: 50},
+		{metric: "read_latency_weighted_avg", node1: (10*100 + 20*300 + 40*100) / 500.0, node2: 50},
+		{metric: "read_latency_min", node1: 10, node2: 50},
+		{metric: "read_latency_max", node1: 40, node2: 50},
+		{metric: "read_latency_p50", node1: 25, node2: 50},
+		{metric: "read_latency_p90", node1: 37, node2: 50},
+		{metric: "count", node1: 4, node2: 1},
+		{metric: "online", node1: 3, node2: 1},
+		{metric: "busy", node1: 3, node2: 0},
+		{metric: "svm_count", node1: 2, node2: 1},
+	}
+	for _, tt := range tests {
+		checkValue(t, m, tt.metric, "node1", tt.node1)
+		checkValue(t, m, tt.metric, "node2", tt.node2)
+	}
+}
+
+func TestMultiLabelGrouping(t *testing.T) {
+	results := runRules(t, New, "svm,node<state=online> count sum(*)")
+	if len(results) != 1 {
+		t.Fatalf("got %d matrices, want=1", len(results))
+	}
+	m := results[0]
+	if m.Object != "svm_node_volume" {
+		t.Errorf("object got=%s, want=svm_node_volume", m.Object)
+	}
+	if len(m.GetInstances()) != 3 {
+		t.Errorf("got %d instances, want=3", len(m.GetInstances()))
+	}
+	instance := m.GetInstance("svm1.node1")
+	if instance == nil {
+		t.Fatal("instance svm1.node1 is missing")
+	}
+	if instance.GetLabel("svm") != "svm1" || instance.GetLabel("node") != "node1" {
+		t.Errorf("labels got=%v, want svm and node", instance.GetLabels())
+	}
+	checkValue(t, m, "count", "svm1.node1", 2)
+	checkValue(t, m, "size_sum", "svm1.node1", 3)
+	checkValue(t, m, "read_ops_sum", "svm1.node1", 400)
+	// vol3 is offline
+	checkValue(t, m, "count", "svm2.node1", 1)
+}
+
+func TestMax(t *testing.T) {
+	results := runRules(t, NewMax, "node<>node_volume_max volume")
+	if len(results) != 3 {
+		t.Fatalf("got %d matrices, want one for each metric", len(results))
+	}
+	for _, m := range results {
+		if m.Object != "node_volume_max" {
+			t.Errorf("object got=%s, want=node_volume_max", m.Object)
+		}
+		if len(m.GetMetrics()) != 1 {
+			t.Errorf("got %d metrics, want=1", len(m.GetMetrics()))
+		}
+		if size := m.GetMetric("size"); size != nil {
+			if size.GetType() != "uint64" {
+				t.Errorf("size type got=%s, want=uint64", size.GetType())
+			}
+			checkValue(t, m, "size", "node1", 4)
+		}
+		if ops := m.GetMetric("read_ops"); ops != nil {
+			checkValue(t, m, "read_ops", "node1", 300)
+			// the labels are copied from the volume with the max value
+			if got := m.GetInstance("node1").GetLabel("volume"); got != "vol2" {
+				t.Errorf("volume label got=%s, want=vol2", got)
+			}
+		}
+	}
+}
+
+func TestInvalidFunctions(t *testing.T) {
+	for _, rule := range []string{
+		"node sum()",
+		"node p90(read_latency",
+		"node median(read_latency)",
+		"node count_if(state=online)",
+		"node weighted_avg(read_latency)",
+		"node total=sum(*)",
+		"node online=count_if(state)",
+	} {
+		params := node.NewS("Aggregator")
+		params.NewChildS("", rule)
+		a := New(plugin.New("Rest", nil, params, nil, "volume", nil))
+		if err := a.Init(conf.Remote{}); err == nil {
+			t.Errorf("rule %s: expected an error", rule)
+		}
+	}
+}
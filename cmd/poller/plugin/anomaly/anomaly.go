@@ -0,0 +1,356 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+// Package anomaly detects when performance counters behave abnormally for an instance.
+// It keeps a baseline for each instance and metric, an EWMA and an EWMA for each hour of the week,
+// persisted across restarts, and scores each new value by its distance from the baseline.
+package anomaly
+
+import (
+	"fmt"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/slogx"
+	"github.com/netapp/harvest/v2/pkg/state"
+	"github.com/netapp/harvest/v2/pkg/util"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAlpha          = 0.05
+	defaultThreshold      = 4.0
+	defaultWarmup         = 30
+	defaultSeasonalWarmup = 1
+	defaultMinOps         = 10
+	defaultMaxInstances   = 1000
+	defaultSaveInterval   = 15 * time.Minute
+	// baselines of instances that are not seen for expiry are removed
+	expiry = 2 * hoursPerWeek * time.Hour
+	// the standard deviation is at least this fraction of the mean
+	minDeviation = 0.05
+	stateVersion = 1
+)
+
+type params struct {
+	alpha          float64
+	threshold      float64
+	warmup         int
+	seasonalWarmup int
+	minOps         float64
+}
+
+// rule is a metric to watch, optionally gated by an ops metric
+type rule struct {
+	metric string
+	ops    string
+}
+
+type instanceState struct {
+	Seen      int64                `json:"seen"`
+	Baselines map[string]*baseline `json:"baselines"`
+}
+
+type persisted struct {
+	Version   int                       `json:"version"`
+	Instances map[string]*instanceState `json:"instances"`
+}
+
+type Anomaly struct {
+	*plugin.AbstractPlugin
+	rules        []rule
+	params       params
+	maxInstances int
+	saveInterval time.Duration
+	path         string
+
+	instances map[string]*instanceState
+	loaded    bool
+	lastSave  time.Time
+	now       func() time.Time
+}
+
+func New(p *plugin.AbstractPlugin) *Anomaly {
+	return &Anomaly{AbstractPlugin: p, now: time.Now}
+}
+
+func (a *Anomaly) Init(remote conf.Remote) error {
+	if err := a.AbstractPlugin.Init(remote); err != nil {
+		return err
+	}
+
+	metrics := a.Params.GetChildS("metrics")
+	if metrics == nil || len(metrics.GetAllChildContentS()) == 0 {
+		return errs.New(errs.ErrMissingParam, "metrics")
+	}
+	for _, line := range metrics.GetAllChildContentS() {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || len(fields) > 2 {
+			return errs.New(errs.ErrInvalidParam, "metric rule "+line+", use METRIC or METRIC OPS_METRIC")
+		}
+		r := rule{metric: fields[0]}
+		if len(fields) == 2 {
+			r.ops = fields[1]
+		}
+		a.rules = append(a.rules, r)
+	}
+
+	var err error
+	a.params = params{seasonalWarmup: defaultSeasonalWarmup}
+	if a.params.alpha, err = a.floatParam("alpha", defaultAlpha); err != nil {
+		return err
+	}
+	if a.params.alpha <= 0 || a.params.alpha > 1 {
+		return errs.New(errs.ErrInvalidParam, "alpha must be greater than 0 and at most 1")
+	}
+	if a.params.threshold, err = a.floatParam("threshold", defaultThreshold); err != nil {
+		return err
+	}
+	if a.params.minOps, err = a.floatParam("min_ops", defaultMinOps); err != nil {
+		return err
+	}
+	if a.params.warmup, err = a.intParam("warmup", defaultWarmup); err != nil {
+		return err
+	}
+	if a.params.seasonalWarmup, err = a.intParam("seasonal_warmup", defaultSeasonalWarmup); err != nil {
+		return err
+	}
+	if a.maxInstances, err = a.intParam("max_instances", defaultMaxInstances); err != nil {
+		return err
+	}
+
+	a.saveInterval = defaultSaveInterval
+	if s := a.Params.GetChildContentS("save_interval"); s != "" {
+		if a.saveInterval, err = time.ParseDuration(s); err != nil {
+			return errs.New(errs.ErrInvalidParam, "save_interval "+s)
+		}
+	}
+
+	poller := ""
+	if a.Options != nil {
+		poller = a.Options.Poller
+	}
+	a.path = state.Path(state.Dir(a.ParentParams), poller, strings.ToLower(a.Parent+"_"+a.Object+"_anomaly"))
+	if a.path == "" {
+		a.SLogger.Info("Baselines are not persisted, set counter_state dir to keep them across restarts")
+	}
+	a.instances = make(map[string]*instanceState)
+
+	return nil
+}
+
+func (a *Anomaly) floatParam(name string, defaultValue float64) (float64, error) {
+	s := a.Params.GetChildContentS(name)
+	if s == "" {
+		return defaultValue, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, errs.New(errs.ErrInvalidParam, name+" "+s)
+	}
+	return v, nil
+}
+
+func (a *Anomaly) intParam(name string, defaultValue int) (int, error) {
+	s := a.Params.GetChildContentS(name)
+	if s == "" {
+		return defaultValue, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 {
+		return 0, errs.New(errs.ErrInvalidParam, name+" "+s)
+	}
+	return v, nil
+}
+
+// watched are the metrics of a rule in the collected and the created metrics
+type watched struct {
+	rule
+	value   *matrix.Metric
+	ops     *matrix.Metric
+	score   *matrix.Metric
+	anomaly *matrix.Metric
+}
+
+func (a *Anomaly) Run(dataMap map[string]*matrix.Matrix) ([]*matrix.Matrix, *util.Metadata, error) {
+	data := dataMap[a.Object]
+
+	// baselines are loaded on the first run, so that they are not read when the template is only validated
+	if !a.loaded {
+		a.load()
+		a.loaded = true
+	}
+
+	all, err := a.watchedMetrics(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(all) == 0 {
+		return nil, nil, nil
+	}
+
+	now := a.now()
+	untracked := 0
+	for key, instance := range data.GetInstances() {
+		for _, w := range all {
+			w.score.SetValueNAN(instance)
+			w.anomaly.SetValueNAN(instance)
+		}
+		if !instance.IsExportable() {
+			continue
+		}
+
+		is := a.instances[key]
+		if is == nil {
+			if len(a.instances) >= a.maxInstances {
+				untracked++
+				continue
+			}
+			is = &instanceState{Baselines: make(map[string]*baseline)}
+			a.instances[key] = is
+		}
+		is.Seen = now.Unix()
+
+		for _, w := range all {
+			a.observe(is, instance, w, now)
+		}
+	}
+	if untracked > 0 {
+		a.SLogger.Warn(
+			"instances are not tracked, the maximum number of instances is reached",
+			slog.Int("untracked", untracked),
+			slog.Int("max_instances", a.maxInstances),
+		)
+	}
+
+	if now.Sub(a.lastSave) >= a.saveInterval {
+		a.save(now)
+	}
+
+	return nil, nil, nil
+}
+
+func (a *Anomaly) observe(is *instanceState, instance *matrix.Instance, w watched, now time.Time) {
+	value, ok := w.value.GetValueFloat64(instance)
+	if !ok || math.IsNaN(value) || math.IsInf(value, 0) {
+		return
+	}
+	// like latency_io_reqd, values based on too few operations are unreliable and are neither scored nor learned
+	if w.ops != nil {
+		ops, ok := w.ops.GetValueFloat64(instance)
+		if !ok || ops < a.params.minOps {
+			return
+		}
+	}
+
+	b := is.Baselines[w.metric]
+	if b == nil {
+		b = &baseline{}
+		is.Baselines[w.metric] = b
+	}
+	score, ok := b.observe(value, now, a.params)
+	if !ok {
+		return
+	}
+	// scores are capped, so that a metric that was constant does not export +Inf
+	score = math.Max(-1000, math.Min(1000, score))
+	_ = w.score.SetValueFloat64(instance, score)
+	if math.Abs(score) >= a.params.threshold {
+		_ = w.anomaly.SetValueFloat64(instance, 1)
+	} else {
+		_ = w.anomaly.SetValueFloat64(instance, 0)
+	}
+}
+
+func (a *Anomaly) watchedMetrics(data *matrix.Matrix) ([]watched, error) {
+	all := make([]watched, 0, len(a.rules))
+	for _, r := range a.rules {
+		w := watched{rule: r, value: getMetric(data, r.metric)}
+		if w.value == nil {
+			continue
+		}
+		if r.ops != "" {
+			if w.ops = getMetric(data, r.ops); w.ops == nil {
+				continue
+			}
+		}
+		var err error
+		if w.score, err = newMetric(data, r.metric+"_anomaly_score", "anomaly_score", r.metric); err != nil {
+			return nil, err
+		}
+		if w.anomaly, err = newMetric(data, r.metric+"_anomaly", "anomaly", r.metric); err != nil {
+			return nil, err
+		}
+		all = append(all, w)
+	}
+	return all, nil
+}
+
+func newMetric(data *matrix.Matrix, key string, display string, source string) (*matrix.Metric, error) {
+	if m := data.GetMetric(key); m != nil {
+		return m, nil
+	}
+	m, err := data.NewMetricFloat64(key, display)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create metric %s: %w", key, err)
+	}
+	m.SetProperty("anomaly")
+	m.SetLabel("metric", source)
+	return m, nil
+}
+
+func getMetric(m *matrix.Matrix, name string) *matrix.Metric {
+	if metric := m.DisplayMetric(name); metric != nil {
+		return metric
+	}
+	return m.GetMetric(name)
+}
+
+func (a *Anomaly) load() {
+	p := persisted{Instances: make(map[string]*instanceState)}
+	if err := state.Load(a.path, &p); err != nil {
+		a.SLogger.Warn("failed to load anomaly baselines, starting over", slogx.Err(err), slog.String("path", a.path))
+		return
+	}
+	if p.Version != stateVersion && p.Version != 0 {
+		a.SLogger.Warn("unsupported anomaly baselines version, starting over", slog.Int("version", p.Version))
+		return
+	}
+	for key, is := range p.Instances {
+		if is != nil && is.Baselines != nil && len(a.instances) < a.maxInstances {
+			a.instances[key] = is
+		}
+	}
+	a.SLogger.Debug("loaded anomaly baselines", slog.String("path", a.path), slog.Int("instances", len(a.instances)))
+}
+
+func (a *Anomaly) save(now time.Time) {
+	for key, is := range a.instances {
+		if now.Sub(time.Unix(is.Seen, 0)) > expiry {
+			delete(a.instances, key)
+		}
+	}
+	a.lastSave = now
+	if err := state.Save(a.path, persisted{Version: stateVersion, Instances: a.instances}); err != nil {
+		a.SLogger.Warn("failed to save anomaly baselines", slogx.Err(err), slog.String("path", a.path))
+	}
+}
+
+// NewMetrics returns the new metrics the receiver creates
+func (a *Anomaly) NewMetrics() []plugin.DerivedMetric {
+	sources := make([]string, 0, len(a.rules))
+	for _, r := range a.rules {
+		sources = append(sources, r.metric)
+	}
+	source := strings.Join(sources, ", ")
+	return []plugin.DerivedMetric{
+		{Name: "anomaly_score", Source: source},
+		{Name: "anomaly", Source: source},
+	}
+}
@@ -0,0 +1,149 @@
+package anomaly
+
+import (
+	"github.com/netapp/harvest/v2/cmd/poller/options"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"math"
+	"testing"
+	"time"
+)
+
+func newAnomaly(t *testing.T, dir string, now *time.Time, maxInstances string) *Anomaly {
+	t.Helper()
+	parentParams := node.NewS("RestPerf")
+	parentParams.NewChildS("counter_state", "").NewChildS("dir", dir)
+	params := node.NewS("Anomaly")
+	metrics := params.NewChildS("metrics", "")
+	metrics.NewChildS("", "read_latency read_ops")
+	params.NewChildS("warmup", "5")
+	params.NewChildS("seasonal_warmup", "1")
+	params.NewChildS("max_instances", maxInstances)
+	params.NewChildS("save_interval", "0s")
+
+	a := New(plugin.New("RestPerf", &options.Options{Poller: "test"}, params, parentParams, "volume", nil))
+	if err := a.Init(conf.Remote{}); err != nil {
+		t.Fatal(err)
+	}
+	a.now = func() time.Time { return *now }
+	return a
+}
+
+func newVolumes(t *testing.T, latency float64, ops float64, names ...string) *matrix.Matrix {
+	t.Helper()
+	m := matrix.New("RestPerf", "volume", "volume")
+	latencyMetric, _ := m.NewMetricFloat64("read_latency")
+	opsMetric, _ := m.NewMetricFloat64("read_ops")
+	for _, name := range names {
+		instance, err := m.NewInstance(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = latencyMetric.SetValueFloat64(instance, latency)
+		_ = opsMetric.SetValueFloat64(instance, ops)
+	}
+	return m
+}
+
+func value(m *matrix.Matrix, key string, instance string) (float64, bool) {
+	return m.GetMetric(key).GetValueFloat64(m.GetInstance(instance))
+}
+
+func TestAnomaly(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	a := newAnomaly(t, dir, &now, "100")
+
+	run := func(latency float64, ops float64) *matrix.Matrix {
+		t.Helper()
+		data := newVolumes(t, latency, ops, "vol1")
+		if _, _, err := a.Run(map[string]*matrix.Matrix{"volume": data}); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(time.Minute)
+		return data
+	}
+
+	// no score while warming up
+	for i := range 5 {
+		data := run(1000+float64(i%2)*100, 100)
+		if _, ok := value(data, "read_latency_anomaly_score", "vol1"); ok {
+			t.Fatalf("score is recorded during warm-up, sample %d", i)
+		}
+	}
+
+	data := run(1050, 100)
+	if got, _ := value(data, "read_latency_anomaly", "vol1"); got != 0 {
+		t.Errorf("anomaly got=%f for a normal latency, want=0", got)
+	}
+	score := data.GetMetric("read_latency_anomaly_score")
+	if score.GetName() != "anomaly_score" || score.GetLabel("metric") != "read_latency" {
+		t.Errorf("score metric got name=%s metric=%s", score.GetName(), score.GetLabel("metric"))
+	}
+
+	// a latency based on too few ops is ignored
+	data = run(50000, 1)
+	if _, ok := value(data, "read_latency_anomaly", "vol1"); ok {
+		t.Error("anomaly is recorded for a latency below min_ops")
+	}
+
+	data = run(50000, 100)
+	if got, _ := value(data, "read_latency_anomaly", "vol1"); got != 1 {
+		t.Errorf("anomaly got=%f for a high latency, want=1", got)
+	}
+	if got, _ := value(data, "read_latency_anomaly_score", "vol1"); got < defaultThreshold {
+		t.Errorf("score got=%f, want at least %f", got, defaultThreshold)
+	}
+
+	// a restarted poller continues with the persisted baselines
+	restarted := newAnomaly(t, dir, &now, "100")
+	data = newVolumes(t, 1050, 100, "vol1")
+	if _, _, err := restarted.Run(map[string]*matrix.Matrix{"volume": data}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := value(data, "read_latency_anomaly_score", "vol1"); !ok {
+		t.Error("score is not recorded after the restart, want the baseline to be persisted")
+	}
+	if got := restarted.instances["vol1"].Baselines["read_latency"].Overall.N; got != 8 {
+		t.Errorf("baseline has %d samples after the restart, want=8", got)
+	}
+}
+
+func TestMaxInstances(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	a := newAnomaly(t, t.TempDir(), &now, "2")
+	data := newVolumes(t, 1000, 100, "vol1", "vol2", "vol3")
+	if _, _, err := a.Run(map[string]*matrix.Matrix{"volume": data}); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.instances) != 2 {
+		t.Errorf("tracked %d instances, want=2", len(a.instances))
+	}
+}
+
+func TestSeasonalBaseline(t *testing.T) {
+	p := params{alpha: 0.5, warmup: 1000, seasonalWarmup: 1}
+	monday := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	var b baseline
+
+	// Monday 9am is busy, the first week only learns
+	for i := range 10 {
+		if _, ok := b.observe(5000, monday.Add(time.Duration(i)*time.Minute), p); ok {
+			t.Fatal("score is recorded before a week of history")
+		}
+	}
+
+	// the next Monday 9am is compared to the previous one
+	score, ok := b.observe(5000, monday.Add(7*24*time.Hour), p)
+	if !ok {
+		t.Fatal("score is not recorded a week later")
+	}
+	if math.Abs(score) > 1e-9 {
+		t.Errorf("score got=%f, want=0", score)
+	}
+	if score, _ = b.observe(20000, monday.Add(7*24*time.Hour+time.Minute), p); score < 10 {
+		t.Errorf("score got=%f, want a high score", score)
+	}
+}
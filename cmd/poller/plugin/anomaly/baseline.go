@@ -0,0 +1,105 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package anomaly
+
+import (
+	"math"
+	"time"
+)
+
+const hoursPerWeek = 7 * 24
+
+// ewma is an exponentially weighted moving average and variance
+type ewma struct {
+	N        int     `json:"n"`
+	Mean     float64 `json:"m"`
+	Variance float64 `json:"v"`
+}
+
+func (e *ewma) update(x float64, alpha float64) {
+	if e.N == 0 {
+		e.Mean = x
+		e.Variance = 0
+	} else {
+		diff := x - e.Mean
+		incr := alpha * diff
+		e.Mean += incr
+		e.Variance = (1 - alpha) * (e.Variance + diff*incr)
+	}
+	e.N++
+}
+
+// score returns how many standard deviations x is from the mean.
+// The standard deviation has a floor relative to the mean, so that a nearly constant counter does not turn
+// every small change into an anomaly.
+func (e *ewma) score(x float64) float64 {
+	std := math.Max(math.Sqrt(e.Variance), minDeviation*math.Abs(e.Mean))
+	if std == 0 {
+		if x == e.Mean {
+			return 0
+		}
+		return math.Copysign(math.Inf(1), x-e.Mean)
+	}
+	return (x - e.Mean) / std
+}
+
+// bucket is the EWMA of one hour of the week
+type bucket struct {
+	ewma
+	// Weeks is the number of weeks with samples in this hour, and LastWeek the last of them
+	Weeks    int   `json:"w"`
+	LastWeek int64 `json:"l"`
+}
+
+// previousWeeks returns the number of weeks before week with samples in this hour
+func (b *bucket) previousWeeks(week int64) int {
+	if b.Weeks > 0 && b.LastWeek == week {
+		return b.Weeks - 1
+	}
+	return b.Weeks
+}
+
+// baseline is the expected behavior of one metric of one instance: an overall EWMA, and an EWMA for each hour of
+// the week, so that a busy Monday morning is compared to previous Monday mornings
+type baseline struct {
+	Overall  ewma            `json:"o"`
+	Seasonal map[int]*bucket `json:"s,omitempty"`
+}
+
+func hourOfWeek(t time.Time) (int, int64) {
+	_, offset := t.Zone()
+	week := (t.Unix() + int64(offset)) / int64(hoursPerWeek*3600)
+	return int(t.Weekday())*24 + t.Hour(), week
+}
+
+// observe scores x against the baseline and then adds it to the baseline.
+// It returns false while the baseline is warming up.
+func (b *baseline) observe(x float64, t time.Time, p params) (float64, bool) {
+	hour, week := hourOfWeek(t)
+	if b.Seasonal == nil {
+		b.Seasonal = make(map[int]*bucket)
+	}
+	s := b.Seasonal[hour]
+	if s == nil {
+		s = &bucket{}
+		b.Seasonal[hour] = s
+	}
+
+	score, ok := 0.0, false
+	switch {
+	case s.previousWeeks(week) >= p.seasonalWarmup:
+		score, ok = s.score(x), true
+	case b.Overall.N >= p.warmup:
+		score, ok = b.Overall.score(x), true
+	}
+
+	b.Overall.update(x, p.alpha)
+	s.update(x, p.alpha)
+	if s.LastWeek != week || s.Weeks == 0 {
+		s.Weeks++
+		s.LastWeek = week
+	}
+	return score, ok
+}
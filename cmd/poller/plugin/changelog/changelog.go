@@ -353,7 +353,7 @@ func (c *ChangeLog) createChangeLogInstance(mat *matrix.Matrix, change *Change)
 	for k, v := range change.labels {
 		cInstance.SetLabel(k, v)
 	}
-	c.metricsCount += len(cInstance.GetLabels())
+	c.metricsCount += cInstance.NumLabels()
 	m := mat.GetMetric("log")
 	if m == nil {
 		if m, err = mat.NewMetricFloat64("log"); err != nil {
@@ -381,7 +381,9 @@ func (c *ChangeLog) updateChangeLogLabels(object string, instance *matrix.Instan
 			}
 		}
 	case cl.includeAll:
-		maps.Copy(change.labels, instance.GetLabels())
+		for k, v := range instance.Labels() {
+			change.labels[k] = v
+		}
 	default:
 		c.SLogger.Warn("missing publish labels", slog.String("object", object))
 	}
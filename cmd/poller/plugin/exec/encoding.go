@@ -0,0 +1,153 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package exec
+
+import (
+	"cmp"
+	"fmt"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"maps"
+	"math"
+	"slices"
+)
+
+// ProtocolVersion is the version of the messages exchanged with external plugins.
+// It changes when a message changes in a way that is not backwards compatible.
+const ProtocolVersion = 1
+
+// Request is written to the external process, as one line of JSON, each time the plugin runs
+type Request struct {
+	Version   int      `json:"version"`
+	Poller    string   `json:"poller"`
+	Collector string   `json:"collector"`
+	Object    string   `json:"object"`
+	Matrices  []Matrix `json:"matrices"`
+}
+
+// Response is read from the external process, as one line of JSON, for each Request
+type Response struct {
+	Version  int      `json:"version"`
+	Matrices []Matrix `json:"matrices,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// Matrix is the encoding of a matrix.Matrix. The values of each metric are in the order of the instances,
+// and null when the metric has no value for an instance.
+type Matrix struct {
+	UUID         string            `json:"uuid"`
+	Object       string            `json:"object"`
+	Identifier   string            `json:"identifier,omitempty"`
+	GlobalLabels map[string]string `json:"global_labels,omitempty"`
+	Instances    []Instance        `json:"instances"`
+	Metrics      []Metric          `json:"metrics"`
+}
+
+type Instance struct {
+	Key        string            `json:"key"`
+	Labels     map[string]string `json:"labels"`
+	Exportable bool              `json:"exportable"`
+}
+
+type Metric struct {
+	Key        string     `json:"key"`
+	Name       string     `json:"name,omitempty"`
+	Exportable bool       `json:"exportable"`
+	Values     []*float64 `json:"values"`
+}
+
+// encode returns the encoding of m. Instances and metrics are sorted by key so that the encoding is stable.
+func encode(m *matrix.Matrix) Matrix {
+	instances := m.GetInstances()
+	keys := slices.Sorted(maps.Keys(instances))
+
+	e := Matrix{
+		UUID:         m.UUID,
+		Object:       m.Object,
+		Identifier:   m.Identifier,
+		GlobalLabels: m.GetGlobalLabels(),
+		Instances:    make([]Instance, 0, len(keys)),
+		Metrics:      make([]Metric, 0, len(m.GetMetrics())),
+	}
+	for _, key := range keys {
+		instance := instances[key]
+		e.Instances = append(e.Instances, Instance{
+			Key:        key,
+			Labels:     instance.Copy(),
+			Exportable: instance.IsExportable(),
+		})
+	}
+
+	metrics := m.GetMetrics()
+	for _, mKey := range slices.Sorted(maps.Keys(metrics)) {
+		metric := metrics[mKey]
+		em := Metric{
+			Key:        mKey,
+			Name:       metric.GetName(),
+			Exportable: metric.IsExportable(),
+			Values:     make([]*float64, len(keys)),
+		}
+		for i, key := range keys {
+			if v, ok := metric.GetValueFloat64(instances[key]); ok && !math.IsNaN(v) && !math.IsInf(v, 0) {
+				em.Values[i] = &v
+			}
+		}
+		e.Metrics = append(e.Metrics, em)
+	}
+	return e
+}
+
+// apply updates m with the instances and metrics of e. Instances and metrics that are not in e are not changed.
+// The labels of the instances in e replace their labels in m.
+func apply(e Matrix, m *matrix.Matrix) error {
+	instances := make([]*matrix.Instance, len(e.Instances))
+	for i, ei := range e.Instances {
+		instance := m.GetInstance(ei.Key)
+		if instance == nil {
+			var err error
+			if instance, err = m.NewInstance(ei.Key); err != nil {
+				return err
+			}
+		}
+		instance.SetLabels(ei.Labels)
+		instance.SetExportable(ei.Exportable)
+		instances[i] = instance
+	}
+
+	for _, em := range e.Metrics {
+		if len(em.Values) != len(instances) {
+			return fmt.Errorf("metric %s has %d values for %d instances", em.Key, len(em.Values), len(instances))
+		}
+		metric := m.GetMetric(em.Key)
+		if metric == nil {
+			var err error
+			if metric, err = m.NewMetricFloat64(em.Key, cmp.Or(em.Name, em.Key)); err != nil {
+				return err
+			}
+			metric.SetProperty("exec")
+		}
+		metric.SetExportable(em.Exportable)
+		for i, v := range em.Values {
+			if v == nil {
+				metric.SetValueNAN(instances[i])
+				continue
+			}
+			if err := metric.SetValueFloat64(instances[i], *v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// decode returns a new matrix from its encoding
+func decode(e Matrix) (*matrix.Matrix, error) {
+	m := matrix.New(e.UUID, e.Object, e.Identifier)
+	m.SetGlobalLabels(e.GlobalLabels)
+	m.SetExportOptions(matrix.DefaultExportOptions())
+	if err := apply(e, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
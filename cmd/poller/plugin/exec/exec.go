@@ -0,0 +1,283 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+// Package exec runs a plugin as a long-lived external process, so that site-specific plugins can be written in any
+// language and live outside Harvest's source.
+//
+// Each time the plugin runs, Harvest writes a Request with the collector's matrices as one line of JSON to the
+// process's stdin and reads a Response as one line of JSON from its stdout. Matrices in the response with the
+// object of the collector update the collector's data, other matrices are exported as new matrices.
+// The process's stderr is logged. A process that crashes, times out, or breaks the protocol is stopped and
+// started again the next time the plugin runs.
+package exec
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/slogx"
+	"github.com/netapp/harvest/v2/pkg/util"
+	"io"
+	"log/slog"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const defaultTimeout = 30 * time.Second
+
+type Exec struct {
+	*plugin.AbstractPlugin
+	name    string
+	command string
+	args    []string
+	env     []string
+	timeout time.Duration
+
+	proc     *process
+	started  bool
+	restarts uint64
+	failures uint64
+	status   *matrix.Matrix
+}
+
+// process is a running external plugin
+type process struct {
+	cmd        *osexec.Cmd
+	stdin      io.WriteCloser
+	stdout     *bufio.Reader
+	logger     *slog.Logger
+	stderrDone chan struct{}
+}
+
+func New(p *plugin.AbstractPlugin) *Exec {
+	return &Exec{AbstractPlugin: p}
+}
+
+func (e *Exec) Init(remote conf.Remote) error {
+	if err := e.AbstractPlugin.Init(remote); err != nil {
+		return err
+	}
+
+	if e.command = e.Params.GetChildContentS("command"); e.command == "" {
+		return errs.New(errs.ErrMissingParam, "command")
+	}
+	// commands with a relative path are relative to the Harvest home, others are looked up in PATH
+	if strings.ContainsRune(e.command, filepath.Separator) {
+		e.command = conf.Path(e.command)
+	}
+	if args := e.Params.GetChildS("args"); args != nil {
+		e.args = args.GetAllChildContentS()
+	}
+	if env := e.Params.GetChildS("env"); env != nil {
+		for _, v := range env.GetChildren() {
+			e.env = append(e.env, v.GetNameS()+"="+v.GetContentS())
+		}
+	}
+	e.name = cmp.Or(e.Params.GetChildContentS("name"), filepath.Base(e.command))
+
+	e.timeout = defaultTimeout
+	if timeout := e.Params.GetChildContentS("timeout"); timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			return errs.New(errs.ErrInvalidParam, fmt.Sprintf("timeout %s: %v", timeout, err))
+		}
+		e.timeout = d
+	}
+
+	e.status = matrix.New(e.Parent+".Exec", "metadata_plugin", "metadata_plugin")
+	if _, err := e.status.NewMetricInt64("time"); err != nil {
+		return err
+	}
+	for _, name := range []string{"restarts", "failures"} {
+		if _, err := e.status.NewMetricUint64(name); err != nil {
+			return err
+		}
+	}
+	instance, err := e.status.NewInstance(e.name)
+	if err != nil {
+		return err
+	}
+	instance.SetLabel("plugin", e.name)
+	instance.SetLabel("object", e.Object)
+	e.status.SetExportOptions(matrix.DefaultExportOptions())
+
+	return nil
+}
+
+func (e *Exec) Run(dataMap map[string]*matrix.Matrix) ([]*matrix.Matrix, *util.Metadata, error) {
+	start := time.Now()
+	results, err := e.run(dataMap)
+	if err != nil {
+		e.failures++
+		e.stop()
+		e.SLogger.Error("external plugin failed, it will be restarted", slogx.Err(err), slog.String("command", e.command))
+	}
+
+	if data := dataMap[e.Object]; data != nil {
+		e.status.SetGlobalLabels(data.GetGlobalLabels())
+	}
+	instance := e.status.GetInstance(e.name)
+	_ = e.status.GetMetric("time").SetValueInt64(instance, time.Since(start).Microseconds())
+	_ = e.status.GetMetric("restarts").SetValueUint64(instance, e.restarts)
+	_ = e.status.GetMetric("failures").SetValueUint64(instance, e.failures)
+
+	return append(results, e.status), nil, nil
+}
+
+func (e *Exec) run(dataMap map[string]*matrix.Matrix) ([]*matrix.Matrix, error) {
+	request := Request{
+		Version:   ProtocolVersion,
+		Collector: e.Parent,
+		Object:    e.Object,
+		Matrices:  make([]Matrix, 0, len(dataMap)),
+	}
+	if e.Options != nil {
+		request.Poller = e.Options.Poller
+	}
+	for _, m := range dataMap {
+		request.Matrices = append(request.Matrices, encode(m))
+	}
+	line, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.proc == nil {
+		if e.proc, err = e.start(); err != nil {
+			return nil, err
+		}
+	}
+
+	out, err := e.proc.call(append(line, '\n'), e.timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var response Response
+	if err := json.Unmarshal(out, &response); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	if response.Version != ProtocolVersion {
+		return nil, fmt.Errorf("response version %d is not supported, want %d", response.Version, ProtocolVersion)
+	}
+	if response.Error != "" {
+		// the process reported an error but is still healthy
+		e.SLogger.Warn("external plugin returned an error", slog.String("err", response.Error))
+		return nil, nil
+	}
+
+	var results []*matrix.Matrix
+	for _, em := range response.Matrices {
+		if data, ok := dataMap[em.Object]; ok {
+			if err := apply(em, data); err != nil {
+				return nil, fmt.Errorf("invalid matrix %s: %w", em.Object, err)
+			}
+			continue
+		}
+		m, err := decode(em)
+		if err != nil {
+			return nil, fmt.Errorf("invalid matrix %s: %w", em.Object, err)
+		}
+		results = append(results, m)
+	}
+	return results, nil
+}
+
+func (e *Exec) start() (*process, error) {
+	cmd := osexec.Command(e.command, e.args...) // #nosec
+	cmd.Env = append(os.Environ(), e.env...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", e.command, err)
+	}
+	if e.started {
+		e.restarts++
+	}
+	e.started = true
+	logger := e.SLogger.With(slog.String("command", e.name), slog.Int("pid", cmd.Process.Pid))
+
+	p := &process{cmd: cmd, stdin: stdin, stdout: bufio.NewReader(stdout), logger: logger, stderrDone: make(chan struct{})}
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			logger.Warn(scanner.Text())
+		}
+		close(p.stderrDone)
+	}()
+	logger.Info("started external plugin")
+	return p, nil
+}
+
+// stop kills the process, it is started again by the next run
+func (e *Exec) stop() {
+	if e.proc == nil {
+		return
+	}
+	e.proc.kill()
+	e.proc = nil
+}
+
+type reply struct {
+	line []byte
+	err  error
+}
+
+// call writes a request and waits up to timeout for the response
+func (p *process) call(request []byte, timeout time.Duration) ([]byte, error) {
+	done := make(chan reply, 1)
+	go func() {
+		if _, err := p.stdin.Write(request); err != nil {
+			done <- reply{err: fmt.Errorf("failed to write request: %w", err)}
+			return
+		}
+		line, err := p.stdout.ReadBytes('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = errors.New("process exited")
+			}
+			done <- reply{err: fmt.Errorf("failed to read response: %w", err)}
+			return
+		}
+		done <- reply{line: line}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.line, r.err
+	case <-timer.C:
+		return nil, fmt.Errorf("no response within %s", timeout)
+	}
+}
+
+// kill stops the process and releases its resources. Wait is only called here, since it closes stdout,
+// and a response may still be read from stdout after the process exited.
+func (p *process) kill() {
+	_ = p.stdin.Close()
+	_ = p.cmd.Process.Kill()
+	<-p.stderrDone
+	err := p.cmd.Wait()
+	p.logger.Debug("external plugin exited", slogx.Err(err))
+}
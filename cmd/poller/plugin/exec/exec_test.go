@@ -0,0 +1,246 @@
+package exec
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const helperEnv = "HARVEST_EXEC_PLUGIN_HELPER"
+
+// TestHelperProcess is not a real test. It is the external plugin that the other tests start.
+func TestHelperProcess(_ *testing.T) {
+	mode := os.Getenv(helperEnv)
+	if mode == "" {
+		return
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var request Request
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		switch {
+		case strings.HasPrefix(mode, "crash:"):
+			// crash the first time, the marker file remembers it across restarts
+			marker := strings.TrimPrefix(mode, "crash:")
+			if _, err := os.Stat(marker); err != nil {
+				_ = os.WriteFile(marker, nil, 0o600)
+				fmt.Fprintln(os.Stderr, "crashing")
+				os.Exit(2)
+			}
+		case mode == "hang":
+			time.Sleep(time.Minute)
+		}
+
+		var response Response
+		response.Version = ProtocolVersion
+		for _, m := range request.Matrices {
+			if m.Object != request.Object {
+				continue
+			}
+			// add a tier label and a doubled size metric, and hide tmp volumes
+			doubled := Metric{Key: "size_doubled", Exportable: true, Values: make([]*float64, len(m.Instances))}
+			for i := range m.Instances {
+				m.Instances[i].Labels["tier"] = "gold"
+				if m.Instances[i].Labels["volume"] == "tmp" {
+					m.Instances[i].Exportable = false
+				}
+				for _, metric := range m.Metrics {
+					if metric.Key == "size" && metric.Values[i] != nil {
+						v := *metric.Values[i] * 2
+						doubled.Values[i] = &v
+					}
+				}
+			}
+			m.Metrics = append(m.Metrics, doubled)
+			response.Matrices = append(response.Matrices, m)
+		}
+		one := 1.0
+		response.Matrices = append(response.Matrices, Matrix{
+			UUID:      "Exec",
+			Object:    "site",
+			Instances: []Instance{{Key: "site1", Labels: map[string]string{"site": "site1"}, Exportable: true}},
+			Metrics:   []Metric{{Key: "runs", Exportable: true, Values: []*float64{&one}}},
+		})
+		out, _ := json.Marshal(response)
+		fmt.Println(string(out))
+	}
+	os.Exit(0)
+}
+
+func newExec(t *testing.T, mode string, timeout string) *Exec {
+	t.Helper()
+	params := node.NewS("Exec")
+	params.NewChildS("command", os.Args[0])
+	params.NewChildS("args", "").NewChildS("", "-test.run=^TestHelperProcess$")
+	params.NewChildS("env", "").NewChildS(helperEnv, mode)
+	params.NewChildS("name", "helper")
+	params.NewChildS("timeout", timeout)
+
+	e := New(plugin.New("Rest", nil, params, nil, "volume", nil))
+	if err := e.Init(conf.Remote{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.stop)
+	return e
+}
+
+func newVolumes(t *testing.T) *matrix.Matrix {
+	t.Helper()
+	m := matrix.New("Rest", "volume", "volume")
+	size, _ := m.NewMetricFloat64("size")
+	for i, name := range []string{"vol1", "tmp"} {
+		instance, err := m.NewInstance(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		instance.SetLabel("volume", name)
+		_ = size.SetValueFloat64(instance, float64(100*(i+1)))
+	}
+	return m
+}
+
+func status(t *testing.T, results []*matrix.Matrix, metric string) float64 {
+	t.Helper()
+	for _, m := range results {
+		if m.Object != "metadata_plugin" {
+			continue
+		}
+		v, ok := m.GetMetric(metric).GetValueFloat64(m.GetInstance("helper"))
+		if !ok {
+			t.Fatalf("metric %s is not recorded", metric)
+		}
+		return v
+	}
+	t.Fatal("metadata_plugin matrix is missing")
+	return 0
+}
+
+func TestExec(t *testing.T) {
+	e := newExec(t, "echo", "30s")
+	data := newVolumes(t)
+
+	for range 2 {
+		results, _, err := e.Run(map[string]*matrix.Matrix{"volume": data})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		vol1 := data.GetInstance("vol1")
+		if got := vol1.GetLabel("tier"); got != "gold" {
+			t.Errorf("tier got=%s, want=gold", got)
+		}
+		if data.GetInstance("tmp").IsExportable() {
+			t.Error("tmp volume is exportable")
+		}
+		doubled := data.GetMetric("size_doubled")
+		if doubled == nil {
+			t.Fatal("metric size_doubled is missing")
+		}
+		if got, _ := doubled.GetValueFloat64(vol1); got != 200 {
+			t.Errorf("size_doubled got=%f, want=200", got)
+		}
+
+		if len(results) != 2 || results[0].Object != "site" {
+			t.Fatalf("got %d results, want the site matrix and metadata", len(results))
+		}
+		if got, _ := results[0].GetMetric("runs").GetValueFloat64(results[0].GetInstance("site1")); got != 1 {
+			t.Errorf("site runs got=%f, want=1", got)
+		}
+		if got := status(t, results, "failures"); got != 0 {
+			t.Errorf("failures got=%f, want=0", got)
+		}
+	}
+}
+
+func TestExecRestartsAfterCrash(t *testing.T) {
+	e := newExec(t, "crash:"+filepath.Join(t.TempDir(), "crashed"), "30s")
+	data := newVolumes(t)
+
+	results, _, err := e.Run(map[string]*matrix.Matrix{"volume": data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := status(t, results, "failures"); got != 1 {
+		t.Errorf("failures got=%f, want=1", got)
+	}
+
+	// the crashed process is started again, and this time it answers
+	results, _, err = e.Run(map[string]*matrix.Matrix{"volume": data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := status(t, results, "restarts"); got != 1 {
+		t.Errorf("restarts got=%f, want=1", got)
+	}
+	if got := data.GetInstance("vol1").GetLabel("tier"); got != "gold" {
+		t.Errorf("tier got=%s, want=gold after the restart", got)
+	}
+}
+
+func TestExecTimeout(t *testing.T) {
+	e := newExec(t, "hang", "200ms")
+	data := newVolumes(t)
+
+	start := time.Now()
+	results, _, err := e.Run(map[string]*matrix.Matrix{"volume": data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Run took %s, want it to stop waiting after the timeout", elapsed)
+	}
+	if got := status(t, results, "failures"); got != 1 {
+		t.Errorf("failures got=%f, want=1", got)
+	}
+	if e.proc != nil {
+		t.Error("process that timed out was not stopped")
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	m := newVolumes(t)
+	m.SetGlobalLabel("cluster", "c1")
+	m.GetMetric("size").SetValueNAN(m.GetInstance("tmp"))
+
+	e := encode(m)
+	data, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Matrix
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	got, err := decode(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.GetGlobalLabels()["cluster"] != "c1" {
+		t.Error("global labels were not decoded")
+	}
+	if v, ok := got.GetMetric("size").GetValueFloat64(got.GetInstance("vol1")); !ok || v != 100 {
+		t.Errorf("vol1 size got=%f,%t want=100,true", v, ok)
+	}
+	if _, ok := got.GetMetric("size").GetValueFloat64(got.GetInstance("tmp")); ok {
+		t.Error("tmp size is recorded, want no value")
+	}
+
+	decoded.Metrics[0].Values = decoded.Metrics[0].Values[:1]
+	if _, err := decode(decoded); err == nil {
+		t.Error("expected an error for a metric with fewer values than instances")
+	}
+}
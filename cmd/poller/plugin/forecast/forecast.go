@@ -0,0 +1,378 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+// Package forecast forecasts when volumes and aggregates run out of space.
+// It keeps a downsampled history of the used capacity of each instance, persisted across restarts,
+// and fits a linear growth model to it.
+package forecast
+
+import (
+	"fmt"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/slogx"
+	"github.com/netapp/harvest/v2/pkg/state"
+	"github.com/netapp/harvest/v2/pkg/util"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	day               = 24 * time.Hour
+	defaultHistory    = 30 * day
+	defaultInterval   = 6 * time.Hour
+	defaultMinSamples = 4
+	stateVersion      = 1
+)
+
+var defaultHorizons = []string{"7d", "30d", "90d"}
+
+// default used and total metrics of the objects the plugin is meant for
+var defaultMetrics = map[string][2]string{
+	"volume": {"size_used", "size_total"},
+	"aggr":   {"space_used", "space_total"},
+}
+
+type horizon struct {
+	name     string
+	duration time.Duration
+}
+
+// history is the downsampled used capacity of an instance. Times are unix seconds.
+type history struct {
+	Times []int64   `json:"t"`
+	Used  []float64 `json:"u"`
+}
+
+type persisted struct {
+	Version   int                 `json:"version"`
+	Instances map[string]*history `json:"instances"`
+}
+
+type Forecast struct {
+	*plugin.AbstractPlugin
+	used       string
+	total      string
+	history    time.Duration
+	interval   time.Duration
+	minSamples int
+	horizons   []horizon
+	path       string
+
+	histories map[string]*history
+	loaded    bool
+	now       func() time.Time
+}
+
+func New(p *plugin.AbstractPlugin) *Forecast {
+	return &Forecast{AbstractPlugin: p, now: time.Now}
+}
+
+func (f *Forecast) Init(remote conf.Remote) error {
+	if err := f.AbstractPlugin.Init(remote); err != nil {
+		return err
+	}
+
+	defaults := defaultMetrics[f.Object]
+	if f.used = f.Params.GetChildContentS("used"); f.used == "" {
+		f.used = defaults[0]
+	}
+	if f.total = f.Params.GetChildContentS("total"); f.total == "" {
+		f.total = defaults[1]
+	}
+	if f.used == "" || f.total == "" {
+		return errs.New(errs.ErrMissingParam, "used and total metrics")
+	}
+
+	var err error
+	if f.history, err = f.duration("history", defaultHistory); err != nil {
+		return err
+	}
+	if f.interval, err = f.duration("interval", defaultInterval); err != nil {
+		return err
+	}
+	f.minSamples = defaultMinSamples
+	if s := f.Params.GetChildContentS("min_samples"); s != "" {
+		if f.minSamples, err = strconv.Atoi(s); err != nil || f.minSamples < 2 {
+			return errs.New(errs.ErrInvalidParam, "min_samples "+s+", use a number of at least 2")
+		}
+	}
+
+	names := defaultHorizons
+	if h := f.Params.GetChildS("horizons"); h != nil {
+		names = h.GetAllChildContentS()
+	}
+	for _, name := range names {
+		d, err := parseDuration(name)
+		if err != nil || d <= 0 {
+			return errs.New(errs.ErrInvalidParam, "horizon "+name)
+		}
+		f.horizons = append(f.horizons, horizon{name: name, duration: d})
+	}
+
+	poller := ""
+	if f.Options != nil {
+		poller = f.Options.Poller
+	}
+	f.path = state.Path(state.Dir(f.ParentParams), poller, strings.ToLower(f.Parent+"_"+f.Object+"_forecast"))
+	if f.path == "" {
+		f.SLogger.Info("History is not persisted, set counter_state dir to keep it across restarts")
+	}
+	f.histories = make(map[string]*history)
+
+	return nil
+}
+
+func (f *Forecast) duration(name string, defaultValue time.Duration) (time.Duration, error) {
+	value := f.Params.GetChildContentS(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	d, err := parseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, errs.New(errs.ErrInvalidParam, name+" "+value)
+	}
+	return d, nil
+}
+
+// parseDuration parses Go durations and durations in days or weeks, e.g., 30d or 2w
+func parseDuration(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": day, "w": 7 * day} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			v, err := strconv.ParseFloat(n, 64)
+			if err != nil {
+				return 0, err
+			}
+			return time.Duration(v * float64(unit)), nil
+		}
+	}
+	return time.ParseDuration(s)
+}
+
+func (f *Forecast) Run(dataMap map[string]*matrix.Matrix) ([]*matrix.Matrix, *util.Metadata, error) {
+	data := dataMap[f.Object]
+
+	// the history is loaded on the first run, so that it is not read when the template is only validated
+	if !f.loaded {
+		f.load()
+		f.loaded = true
+	}
+
+	used := getMetric(data, f.used)
+	total := getMetric(data, f.total)
+	if used == nil || total == nil {
+		f.SLogger.Debug("skip forecast, used or total metric not found", slog.String("used", f.used), slog.String("total", f.total))
+		return nil, nil, nil
+	}
+
+	metrics, err := f.createMetrics(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := f.now()
+	changed := false
+	for key, instance := range data.GetInstances() {
+		for _, m := range metrics.all() {
+			m.SetValueNAN(instance)
+		}
+		usedValue, ok1 := used.GetValueFloat64(instance)
+		totalValue, ok2 := total.GetValueFloat64(instance)
+		if !ok1 || !ok2 || !instance.IsExportable() {
+			continue
+		}
+
+		h := f.histories[key]
+		if h == nil {
+			h = &history{}
+			f.histories[key] = h
+		}
+		if h.add(now, usedValue, f.interval, f.history) {
+			changed = true
+		}
+		f.forecast(instance, h, usedValue, totalValue, metrics)
+	}
+
+	// forget instances that have not been seen for the whole history
+	for key, h := range f.histories {
+		if len(h.Times) == 0 || now.Sub(time.Unix(h.Times[len(h.Times)-1], 0)) > f.history {
+			delete(f.histories, key)
+			changed = true
+		}
+	}
+
+	if changed {
+		if err := state.Save(f.path, persisted{Version: stateVersion, Instances: f.histories}); err != nil {
+			f.SLogger.Warn("failed to save forecast history", slogx.Err(err), slog.String("path", f.path))
+		}
+	}
+
+	return nil, nil, nil
+}
+
+func (f *Forecast) load() {
+	p := persisted{Instances: make(map[string]*history)}
+	if err := state.Load(f.path, &p); err != nil {
+		f.SLogger.Warn("failed to load forecast history, starting over", slogx.Err(err), slog.String("path", f.path))
+		return
+	}
+	if p.Version != stateVersion && p.Version != 0 {
+		f.SLogger.Warn("unsupported forecast history version, starting over", slog.Int("version", p.Version))
+		return
+	}
+	for key, h := range p.Instances {
+		if h != nil && len(h.Times) == len(h.Used) {
+			f.histories[key] = h
+		}
+	}
+	f.SLogger.Debug("loaded forecast history", slog.String("path", f.path), slog.Int("instances", len(f.histories)))
+}
+
+// add appends a sample when the last sample is at least interval old and drops samples older than keep.
+// It returns true when the history changed.
+func (h *history) add(now time.Time, used float64, interval time.Duration, keep time.Duration) bool {
+	t := now.Unix()
+	if n := len(h.Times); n > 0 && t-h.Times[n-1] < int64(interval.Seconds()) {
+		return false
+	}
+	h.Times = append(h.Times, t)
+	h.Used = append(h.Used, used)
+
+	oldest := t - int64(keep.Seconds())
+	drop := 0
+	for drop < len(h.Times) && h.Times[drop] < oldest {
+		drop++
+	}
+	if drop > 0 {
+		h.Times = append(h.Times[:0], h.Times[drop:]...)
+		h.Used = append(h.Used[:0], h.Used[drop:]...)
+	}
+	return true
+}
+
+// fit returns the growth rate per day of a least squares line through the history,
+// and the coefficient of determination of the line, 0 for no fit and 1 for a perfect fit
+func (h *history) fit() (float64, float64) {
+	n := float64(len(h.Times))
+	t0 := h.Times[0]
+	var sx, sy, sxx, sxy, syy float64
+	for i, t := range h.Times {
+		x := float64(t-t0) / day.Seconds()
+		y := h.Used[i]
+		sx += x
+		sy += y
+		sxx += x * x
+		sxy += x * y
+		syy += y * y
+	}
+	varX := n*sxx - sx*sx
+	if varX == 0 {
+		return math.NaN(), 0
+	}
+	slope := (n*sxy - sx*sy) / varX
+	varY := n*syy - sy*sy
+	if varY <= 0 {
+		// the used capacity did not change, which a flat line fits perfectly
+		return slope, 1
+	}
+	cov := n*sxy - sx*sy
+	return slope, (cov * cov) / (varX * varY)
+}
+
+type forecastMetrics struct {
+	daysUntilFull *matrix.Metric
+	growthRate    *matrix.Metric
+	confidence    *matrix.Metric
+	projected     []*matrix.Metric
+}
+
+func (m forecastMetrics) all() []*matrix.Metric {
+	return append([]*matrix.Metric{m.daysUntilFull, m.growthRate, m.confidence}, m.projected...)
+}
+
+func (f *Forecast) createMetrics(data *matrix.Matrix) (forecastMetrics, error) {
+	var (
+		m   forecastMetrics
+		err error
+	)
+	if m.daysUntilFull, err = newMetric(data, "days_until_full", "days_until_full"); err != nil {
+		return m, err
+	}
+	if m.growthRate, err = newMetric(data, "growth_rate", "growth_rate"); err != nil {
+		return m, err
+	}
+	if m.confidence, err = newMetric(data, "growth_confidence", "growth_confidence"); err != nil {
+		return m, err
+	}
+	for _, h := range f.horizons {
+		p, err := newMetric(data, "projected_used_percent_"+h.name, "projected_used_percent")
+		if err != nil {
+			return m, err
+		}
+		p.SetLabel("horizon", h.name)
+		m.projected = append(m.projected, p)
+	}
+	return m, nil
+}
+
+func newMetric(data *matrix.Matrix, key string, display string) (*matrix.Metric, error) {
+	if m := data.GetMetric(key); m != nil {
+		return m, nil
+	}
+	m, err := data.NewMetricFloat64(key, display)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create metric %s: %w", key, err)
+	}
+	m.SetProperty("forecast")
+	return m, nil
+}
+
+func (f *Forecast) forecast(instance *matrix.Instance, h *history, used float64, total float64, m forecastMetrics) {
+	if len(h.Times) < f.minSamples {
+		return
+	}
+	rate, confidence := h.fit()
+	if math.IsNaN(rate) {
+		return
+	}
+	_ = m.growthRate.SetValueFloat64(instance, rate)
+	_ = m.confidence.SetValueFloat64(instance, confidence)
+
+	if total <= 0 {
+		return
+	}
+	if rate > 0 {
+		_ = m.daysUntilFull.SetValueFloat64(instance, math.Max(0, (total-used)/rate))
+	}
+	for i, hz := range f.horizons {
+		projected := used + rate*hz.duration.Hours()/24
+		_ = m.projected[i].SetValueFloat64(instance, math.Max(0, projected/total*100))
+	}
+}
+
+func getMetric(m *matrix.Matrix, name string) *matrix.Metric {
+	if metric := m.DisplayMetric(name); metric != nil {
+		return metric
+	}
+	return m.GetMetric(name)
+}
+
+// NewMetrics returns the new metrics the receiver creates
+func (f *Forecast) NewMetrics() []plugin.DerivedMetric {
+	source := f.used + ", " + f.total
+	derivedMetrics := []plugin.DerivedMetric{
+		{Name: "days_until_full", Source: source},
+		{Name: "growth_rate", Source: f.used},
+		{Name: "growth_confidence", Source: f.used},
+	}
+	if len(f.horizons) > 0 {
+		derivedMetrics = append(derivedMetrics, plugin.DerivedMetric{Name: "projected_used_percent", Source: source})
+	}
+	return derivedMetrics
+}
@@ -0,0 +1,156 @@
+package forecast
+
+import (
+	"github.com/netapp/harvest/v2/cmd/poller/options"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"math"
+	"testing"
+	"time"
+)
+
+const gib = 1024 * 1024 * 1024
+
+func newForecast(t *testing.T, dir string, now *time.Time) *Forecast {
+	t.Helper()
+	parentParams := node.NewS("Rest")
+	parentParams.NewChildS("counter_state", "").NewChildS("dir", dir)
+	params := node.NewS("Forecast")
+	params.NewChildS("interval", "1d")
+	params.NewChildS("min_samples", "3")
+	horizons := params.NewChildS("horizons", "")
+	horizons.NewChildS("", "10d")
+
+	f := New(plugin.New("Rest", &options.Options{Poller: "test"}, params, parentParams, "volume", nil))
+	if err := f.Init(conf.Remote{}); err != nil {
+		t.Fatal(err)
+	}
+	f.now = func() time.Time { return *now }
+	return f
+}
+
+func newVolumes(t *testing.T, used float64) *matrix.Matrix {
+	t.Helper()
+	m := matrix.New("Rest", "volume", "volume")
+	usedMetric, _ := m.NewMetricFloat64("size_used")
+	totalMetric, _ := m.NewMetricFloat64("size_total")
+	instance, err := m.NewInstance("uuid1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance.SetLabel("volume", "vol1")
+	_ = usedMetric.SetValueFloat64(instance, used)
+	_ = totalMetric.SetValueFloat64(instance, 100*gib)
+	return m
+}
+
+func value(t *testing.T, m *matrix.Matrix, key string) (float64, bool) {
+	t.Helper()
+	metric := m.GetMetric(key)
+	if metric == nil {
+		t.Fatalf("metric %s is missing", key)
+	}
+	return metric.GetValueFloat64(m.GetInstance("uuid1"))
+}
+
+func TestForecast(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := newForecast(t, dir, &now)
+
+	// the volume grows 2 GiB per day
+	var data *matrix.Matrix
+	for i := range 3 {
+		data = newVolumes(t, float64(40+2*i)*gib)
+		if _, _, err := f.Run(map[string]*matrix.Matrix{"volume": data}); err != nil {
+			t.Fatal(err)
+		}
+		if i < 2 {
+			if _, ok := value(t, data, "days_until_full"); ok {
+				t.Errorf("days_until_full is recorded after %d samples, want it after 3", i+1)
+			}
+		}
+		now = now.Add(24 * time.Hour)
+	}
+
+	if got, _ := value(t, data, "growth_rate"); math.Abs(got-2*gib) > 1 {
+		t.Errorf("growth_rate got=%f, want=%d", got, 2*gib)
+	}
+	if got, _ := value(t, data, "growth_confidence"); math.Abs(got-1) > 1e-9 {
+		t.Errorf("growth_confidence got=%f, want=1", got)
+	}
+	if got, _ := value(t, data, "days_until_full"); math.Abs(got-28) > 1e-9 {
+		t.Errorf("days_until_full got=%f, want=28", got)
+	}
+	if got, _ := value(t, data, "projected_used_percent_10d"); math.Abs(got-64) > 1e-9 {
+		t.Errorf("projected_used_percent_10d got=%f, want=64", got)
+	}
+	projected := data.GetMetric("projected_used_percent_10d")
+	if projected.GetName() != "projected_used_percent" || projected.GetLabel("horizon") != "10d" {
+		t.Errorf("projected metric got name=%s horizon=%s", projected.GetName(), projected.GetLabel("horizon"))
+	}
+
+	// a restarted poller continues with the persisted history
+	restarted := newForecast(t, dir, &now)
+	data = newVolumes(t, 46*gib)
+	if _, _, err := restarted.Run(map[string]*matrix.Matrix{"volume": data}); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(restarted.histories["uuid1"].Times); got != 4 {
+		t.Errorf("history has %d samples after the restart, want=4", got)
+	}
+	if got, _ := value(t, data, "days_until_full"); math.Abs(got-27) > 1e-9 {
+		t.Errorf("days_until_full got=%f, want=27", got)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var h history
+
+	if !h.add(start, 1, time.Hour, 3*time.Hour) {
+		t.Error("first sample was not added")
+	}
+	if h.add(start.Add(30*time.Minute), 2, time.Hour, 3*time.Hour) {
+		t.Error("sample within the interval was added")
+	}
+	for i := 1; i <= 4; i++ {
+		h.add(start.Add(time.Duration(i)*time.Hour), float64(i), time.Hour, 3*time.Hour)
+	}
+	if len(h.Times) != 4 || h.Used[0] != 1 {
+		t.Errorf("got %d samples starting with %f, want 4 samples starting with 1", len(h.Times), h.Used[0])
+	}
+
+	// a flat history is a perfect fit with no growth
+	flat := history{Times: []int64{0, 86400, 172800}, Used: []float64{5, 5, 5}}
+	if rate, confidence := flat.fit(); rate != 0 || confidence != 1 {
+		t.Errorf("flat fit got rate=%f confidence=%f, want 0 and 1", rate, confidence)
+	}
+	noisy := history{Times: []int64{0, 86400, 172800, 259200}, Used: []float64{10, 30, 10, 30}}
+	if _, confidence := noisy.fit(); confidence > 0.5 {
+		t.Errorf("noisy fit got confidence=%f, want a low confidence", confidence)
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{in: "7d", want: 7 * 24 * time.Hour},
+		{in: "2w", want: 14 * 24 * time.Hour},
+		{in: "6h", want: 6 * time.Hour},
+		{in: "1.5d", want: 36 * time.Hour},
+	}
+	for _, tt := range tests {
+		got, err := parseDuration(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("parseDuration(%s) got=%s,%v want=%s", tt.in, got, err, tt.want)
+		}
+	}
+	if _, err := parseDuration("xd"); err == nil {
+		t.Error("expected an error for xd")
+	}
+}
@@ -0,0 +1,300 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+// Package inventory adds business labels, like owner or cost center, from an external inventory to the instances
+// of an object. The inventory is a csv, json, or yaml file, or an HTTP endpoint that returns json.
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/requests"
+	"github.com/netapp/harvest/v2/pkg/slogx"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"github.com/netapp/harvest/v2/pkg/util"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRefresh = 10 * time.Minute
+	defaultTimeout = 30 * time.Second
+)
+
+type Inventory struct {
+	*plugin.AbstractPlugin
+	source  string
+	format  string
+	isHTTP  bool
+	refresh time.Duration
+	match   []plugin.Mapping
+	labels  []plugin.Mapping // empty means all columns that are not matched on
+	client  *http.Client
+
+	table       *table
+	lastAttempt time.Time
+	modified    time.Time // when the source was last changed, the file's modification time or the response's Last-Modified
+	loadErr     error
+	applied     map[string]bool // labels set on instances, cleared when an instance no longer matches
+	status      *matrix.Matrix
+	now         func() time.Time
+}
+
+func New(p *plugin.AbstractPlugin) *Inventory {
+	return &Inventory{AbstractPlugin: p, now: time.Now}
+}
+
+func (i *Inventory) Init(remote conf.Remote) error {
+	if err := i.AbstractPlugin.Init(remote); err != nil {
+		return err
+	}
+
+	if i.source = i.Params.GetChildContentS("source"); i.source == "" {
+		return errs.New(errs.ErrMissingParam, "source")
+	}
+	i.isHTTP = strings.HasPrefix(i.source, "http://") || strings.HasPrefix(i.source, "https://")
+
+	i.format = strings.ToLower(i.Params.GetChildContentS("format"))
+	if i.format == "" {
+		i.format = formatOf(i.source, i.isHTTP)
+	}
+	if !slices.Contains([]string{"csv", "json", "yaml"}, i.format) {
+		return errs.New(errs.ErrInvalidParam, "format "+i.format+", use csv, json, or yaml")
+	}
+
+	var err error
+	if i.refresh, err = duration(i.Params, "refresh", defaultRefresh); err != nil {
+		return err
+	}
+	timeout, err := duration(i.Params, "timeout", defaultTimeout)
+	if err != nil {
+		return err
+	}
+	i.client = &http.Client{Timeout: timeout}
+
+	if i.match = plugin.ParseMappings(i.Params.GetChildS("match")); len(i.match) == 0 {
+		return errs.New(errs.ErrMissingParam, "match")
+	}
+	i.labels = plugin.ParseMappings(i.Params.GetChildS("labels"))
+	i.applied = make(map[string]bool)
+
+	i.status = matrix.New(i.Parent+".Inventory", "inventory", "inventory")
+	for _, name := range []string{"rows", "matched", "unmatched", "source_age", "load_error"} {
+		if _, err := i.status.NewMetricFloat64(name); err != nil {
+			return err
+		}
+	}
+	instance, err := i.status.NewInstance(i.source)
+	if err != nil {
+		return err
+	}
+	instance.SetLabel("source", i.source)
+	instance.SetLabel("object", i.Object)
+
+	return nil
+}
+
+func formatOf(source string, isHTTP bool) string {
+	if isHTTP {
+		return "json"
+	}
+	switch ext := strings.ToLower(filepath.Ext(source)); ext {
+	case ".yml":
+		return "yaml"
+	default:
+		return strings.TrimPrefix(ext, ".")
+	}
+}
+
+func duration(params *node.Node, name string, defaultValue time.Duration) (time.Duration, error) {
+	value := params.GetChildContentS(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, errs.New(errs.ErrInvalidParam, fmt.Sprintf("%s %s: %v", name, value, err))
+	}
+	return d, nil
+}
+
+func (i *Inventory) Run(dataMap map[string]*matrix.Matrix) ([]*matrix.Matrix, *util.Metadata, error) {
+	data := dataMap[i.Object]
+	now := i.now()
+
+	if i.isDue(now) {
+		i.load(now)
+	}
+
+	var matched, unmatched int
+	values := make([]string, len(i.match))
+	for _, instance := range data.GetInstances() {
+		var r *row
+		if i.table != nil {
+			for j, m := range i.match {
+				values[j] = instance.GetLabel(m.To)
+			}
+			r = i.table.lookup(values)
+		}
+		if r == nil {
+			unmatched++
+			for label := range i.applied {
+				instance.SetLabel(label, "")
+			}
+			continue
+		}
+		matched++
+		i.apply(instance, r)
+	}
+
+	i.status.SetGlobalLabels(data.GetGlobalLabels())
+	instance := i.status.GetInstance(i.source)
+	rows := 0
+	if i.table != nil {
+		rows = i.table.rows
+	}
+	_ = i.status.GetMetric("rows").SetValueFloat64(instance, float64(rows))
+	_ = i.status.GetMetric("matched").SetValueFloat64(instance, float64(matched))
+	_ = i.status.GetMetric("unmatched").SetValueFloat64(instance, float64(unmatched))
+	if i.modified.IsZero() {
+		i.status.GetMetric("source_age").SetValueNAN(instance)
+	} else {
+		_ = i.status.GetMetric("source_age").SetValueFloat64(instance, now.Sub(i.modified).Seconds())
+	}
+	loadError := 0.0
+	if i.loadErr != nil {
+		loadError = 1
+	}
+	_ = i.status.GetMetric("load_error").SetValueFloat64(instance, loadError)
+
+	return []*matrix.Matrix{i.status}, nil, nil
+}
+
+// apply copies the columns of a row to the labels of an instance
+func (i *Inventory) apply(instance *matrix.Instance, r *row) {
+	set := make(map[string]bool, len(r.values))
+	if len(i.labels) > 0 {
+		for _, l := range i.labels {
+			instance.SetLabel(l.To, r.values[l.From])
+			set[l.To] = true
+		}
+	} else {
+		for column, value := range r.values {
+			if slices.ContainsFunc(i.match, func(m plugin.Mapping) bool { return m.From == column }) {
+				continue
+			}
+			instance.SetLabel(column, value)
+			set[column] = true
+		}
+	}
+	for label := range i.applied {
+		if !set[label] {
+			instance.SetLabel(label, "")
+		}
+	}
+	for label := range set {
+		i.applied[label] = true
+	}
+}
+
+// isDue reports whether the inventory should be loaded. Files are loaded when they change,
+// and both files and HTTP sources are loaded every refresh interval.
+func (i *Inventory) isDue(now time.Time) bool {
+	if i.table == nil || now.Sub(i.lastAttempt) >= i.refresh {
+		return true
+	}
+	if i.isHTTP {
+		return false
+	}
+	info, err := os.Stat(i.source)
+	if err != nil {
+		// load reports the error
+		return true
+	}
+	return !info.ModTime().Equal(i.modified)
+}
+
+// load replaces the inventory. When loading fails, the previous inventory is kept.
+func (i *Inventory) load(now time.Time) {
+	i.lastAttempt = now
+	data, modified, err := i.read()
+	if err == nil {
+		var records []map[string]string
+		if records, err = parseRecords(data, i.format); err == nil {
+			var t *table
+			if t, err = newTable(records, i.match); err == nil {
+				i.table = t
+				i.modified = modified
+				i.SLogger.Debug("loaded inventory", slog.String("source", i.source), slog.Int("rows", t.rows))
+			}
+		}
+	}
+	if err != nil && (i.loadErr == nil || i.loadErr.Error() != err.Error()) {
+		i.SLogger.Error("failed to load inventory", slogx.Err(err), slog.String("source", i.source))
+	}
+	i.loadErr = err
+}
+
+func (i *Inventory) read() ([]byte, time.Time, error) {
+	if !i.isHTTP {
+		info, err := os.Stat(i.source)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		data, err := os.ReadFile(i.source)
+		return data, info.ModTime(), err
+	}
+
+	request, err := requests.New(http.MethodGet, i.source, nil)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	request.Header.Set("Accept", "application/json")
+	response, err := i.client.Do(request)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, time.Time{}, errors.New("unexpected status " + response.Status)
+	}
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	modified := i.now()
+	if lm, err := http.ParseTime(response.Header.Get("Last-Modified")); err == nil {
+		modified = lm
+	}
+	return data, modified, nil
+}
+
+// NewLabels returns the labels the receiver adds to instances. When all columns are copied, they are not known
+// until the inventory is loaded.
+func (i *Inventory) NewLabels() []string {
+	labels := make([]string, 0, len(i.labels))
+	for _, l := range i.labels {
+		labels = append(labels, l.To)
+	}
+	return labels
+}
+
+// SourceLabels returns the labels the receiver matches instances on
+func (i *Inventory) SourceLabels() []string {
+	labels := make([]string, 0, len(i.match))
+	for _, m := range i.match {
+		labels = append(labels, m.To)
+	}
+	return labels
+}
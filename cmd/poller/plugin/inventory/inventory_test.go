@@ -0,0 +1,320 @@
+package inventory
+
+import (
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/tree"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newInventory(t *testing.T, template string) *Inventory {
+	t.Helper()
+	root, err := tree.LoadYaml([]byte(template))
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := New(plugin.New("Rest", nil, root.GetChildS("Inventory"), nil, "volume", nil))
+	if err := i.Init(conf.Remote{}); err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
+
+func newVolumes(t *testing.T, volumes ...[2]string) *matrix.Matrix {
+	t.Helper()
+	m := matrix.New("volume", "volume", "volume")
+	m.SetGlobalLabel("cluster", "cluster1")
+	for _, v := range volumes {
+		instance, err := m.NewInstance(v[0] + "/" + v[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		instance.SetLabel("svm", v[0])
+		instance.SetLabel("volume", v[1])
+	}
+	return m
+}
+
+func status(t *testing.T, m *matrix.Matrix, metric string) float64 {
+	t.Helper()
+	v, ok := m.GetMetric(metric).GetValueFloat64(m.GetInstances()[m.GetInstanceKeys()[0]])
+	if !ok {
+		t.Fatalf("metric %s is not recorded", metric)
+	}
+	return v
+}
+
+func TestInventory(t *testing.T) {
+	for _, file := range []string{"volumes.csv", "volumes.json", "volumes.yaml"} {
+		t.Run(file, func(t *testing.T) {
+			i := newInventory(t, `
+Inventory:
+  source: testdata/`+file+`
+  match:
+    - svm
+    - volume
+  labels:
+    - owner
+    - cost_center => cost
+`)
+			data := newVolumes(t,
+				[2]string{"svm1", "vol1"},
+				[2]string{"svm1", "vol_a"},
+				[2]string{"svm1", "vol_exact"},
+				[2]string{"svm2", "tmp42"},
+				[2]string{"svm2", "vol1"},
+			)
+			out, _, err := i.Run(map[string]*matrix.Matrix{"volume": data})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tests := []struct {
+				instance string
+				owner    string
+				cost     string
+			}{
+				{instance: "svm1/vol1", owner: "alice", cost: "100"},
+				{instance: "svm1/vol_a", owner: "bob", cost: "200"},
+				{instance: "svm1/vol_exact", owner: "carol", cost: "400"},
+				{instance: "svm2/tmp42", owner: "ops", cost: "300"},
+				{instance: "svm2/vol1", owner: "", cost: ""},
+			}
+			for _, tt := range tests {
+				instance := data.GetInstance(tt.instance)
+				if got := instance.GetLabel("owner"); got != tt.owner {
+					t.Errorf("%s owner got=%s, want=%s", tt.instance, got, tt.owner)
+				}
+				if got := instance.GetLabel("cost"); got != tt.cost {
+					t.Errorf("%s cost got=%s, want=%s", tt.instance, got, tt.cost)
+				}
+				if got := instance.GetLabel("tier"); got != "" {
+					t.Errorf("%s tier got=%s, want it not to be copied", tt.instance, got)
+				}
+			}
+
+			if len(out) != 1 {
+				t.Fatalf("got %d matrices, want the inventory status", len(out))
+			}
+			if got := status(t, out[0], "rows"); got != 4 {
+				t.Errorf("rows got=%f, want=4", got)
+			}
+			if got := status(t, out[0], "matched"); got != 4 {
+				t.Errorf("matched got=%f, want=4", got)
+			}
+			if got := status(t, out[0], "unmatched"); got != 1 {
+				t.Errorf("unmatched got=%f, want=1", got)
+			}
+			if got := status(t, out[0], "load_error"); got != 0 {
+				t.Errorf("load_error got=%f, want=0", got)
+			}
+			if out[0].GetGlobalLabels()["cluster"] != "cluster1" {
+				t.Errorf("status does not have the cluster label")
+			}
+		})
+	}
+}
+
+func TestInventoryReload(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "svms.csv")
+	write := func(content string, modTime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(source, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(source, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	write("svm,owner,tier\nsvm1,alice,gold\n", modTime)
+
+	i := newInventory(t, `
+Inventory:
+  source: `+source+`
+  refresh: 24h
+  match:
+    - svm
+`)
+	data := newVolumes(t, [2]string{"svm1", "vol1"})
+	run := func() *matrix.Matrix {
+		t.Helper()
+		out, _, err := i.Run(map[string]*matrix.Matrix{"volume": data})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out[0]
+	}
+
+	out := run()
+	instance := data.GetInstance("svm1/vol1")
+	if instance.GetLabel("owner") != "alice" || instance.GetLabel("tier") != "gold" {
+		t.Fatalf("labels got=%v, want owner=alice tier=gold", instance.GetLabels())
+	}
+	if age := status(t, out, "source_age"); age < 3600 {
+		t.Errorf("source_age got=%f, want >= 3600", age)
+	}
+
+	// a changed file is loaded again, and labels that are no longer in the inventory are removed
+	write("svm,owner\nsvm1,bob\n", modTime.Add(time.Minute))
+	run()
+	if instance.GetLabel("owner") != "bob" || instance.GetLabel("tier") != "" {
+		t.Errorf("labels got=%v, want owner=bob and no tier", instance.GetLabels())
+	}
+
+	// an invalid file is reported and the previous inventory is kept
+	write("svm,owner\nsvm1,bob,extra\n", modTime.Add(2*time.Minute))
+	out = run()
+	if got := status(t, out, "load_error"); got != 1 {
+		t.Errorf("load_error got=%f, want=1", got)
+	}
+	if instance.GetLabel("owner") != "bob" {
+		t.Errorf("owner got=%s, want=bob", instance.GetLabel("owner"))
+	}
+}
+
+func TestInventoryHTTP(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls++
+		w.Header().Set("Last-Modified", time.Now().Add(-2*time.Hour).UTC().Format(http.TimeFormat))
+		_, _ = w.Write([]byte(`[{"svm": "svm*", "application": "erp"}]`))
+	}))
+	defer server.Close()
+
+	i := newInventory(t, `
+Inventory:
+  source: `+server.URL+`
+  refresh: 1h
+  match:
+    - svm
+  labels:
+    - application => app
+`)
+	data := newVolumes(t, [2]string{"svm1", "vol1"})
+	for range 2 {
+		out, _, err := i.Run(map[string]*matrix.Matrix{"volume": data})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if age := status(t, out[0], "source_age"); age < 7200 {
+			t.Errorf("source_age got=%f, want >= 7200", age)
+		}
+	}
+	if got := data.GetInstance("svm1/vol1").GetLabel("app"); got != "erp" {
+		t.Errorf("app got=%s, want=erp", got)
+	}
+	if calls != 1 {
+		t.Errorf("calls got=%d, want=1 within the refresh interval", calls)
+	}
+}
+
+func TestInvalidParams(t *testing.T) {
+	templates := []string{
+		"Inventory:\n  match:\n    - svm\n",
+		"Inventory:\n  source: inventory.txt\n  match:\n    - svm\n",
+		"Inventory:\n  source: inventory.csv\n",
+		"Inventory:\n  source: inventory.csv\n  refresh: often\n  match:\n    - svm\n",
+	}
+	for _, tmpl := range templates {
+		root, err := tree.LoadYaml([]byte(tmpl))
+		if err != nil {
+			t.Fatal(err)
+		}
+		i := New(plugin.New("Rest", nil, root.GetChildS("Inventory"), nil, "volume", nil))
+		if err := i.Init(conf.Remote{}); err == nil {
+			t.Errorf("expected an error for template\n%s", tmpl)
+		}
+	}
+}
+
+func TestInventoryPaths(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "qtrees.csv")
+	csv := "volume, junction_path, owner\n" +
+		"vol1, /vol/data/home/*, home\n" +
+		"vol1, /vol/data/*, data\n" +
+		"vol?, *, other\n"
+	if err := os.WriteFile(source, []byte(csv), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	i := newInventory(t, `
+Inventory:
+  source: `+source+`
+  match:
+    - volume
+    - junction_path
+  labels:
+    - owner
+`)
+	data := matrix.New("volume", "volume", "volume")
+	for _, v := range [][2]string{
+		{"vol1", "/vol/data/home/user1"},
+		{"vol1", "/vol/data/projects/a/b"},
+		{"vol2", "/vol/scratch/tmp"},
+		{"vol10", "/vol/scratch/tmp"},
+	} {
+		instance, err := data.NewInstance(v[0] + v[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		instance.SetLabel("volume", v[0])
+		instance.SetLabel("junction_path", v[1])
+	}
+	if _, _, err := i.Run(map[string]*matrix.Matrix{"volume": data}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"vol1/vol/data/home/user1":   "home",
+		"vol1/vol/data/projects/a/b": "data",
+		"vol2/vol/scratch/tmp":       "other",
+		"vol10/vol/scratch/tmp":      "",
+	}
+	for key, owner := range want {
+		if got := data.GetInstance(key).GetLabel("owner"); got != owner {
+			t.Errorf("%s owner got=%s, want=%s", key, got, owner)
+		}
+	}
+}
+
+func TestPattern(t *testing.T) {
+	tests := []struct {
+		cell    string
+		value   string
+		want    bool
+		wantErr bool
+	}{
+		{cell: "vol_*", value: "vol_a", want: true},
+		{cell: "vol_*", value: "avol_a"},
+		{cell: "vol1/q*", value: "vol1/qtree1", want: true},
+		{cell: "*/qtree1", value: "vol1/qtree1", want: true},
+		{cell: "/vol/*/home", value: "/vol/data/projects/home", want: true},
+		{cell: "/vol/?", value: "/vol/a", want: true},
+		{cell: "vol[0-9]", value: "vol7", want: true},
+		{cell: "vol[!0-9]", value: "vol7"},
+		{cell: "vol.*", value: "vol1"},
+		{cell: "vol.*", value: "vol.backup", want: true},
+		{cell: "vol[", wantErr: true},
+		{cell: "/[/", wantErr: true},
+	}
+	for _, tt := range tests {
+		p, err := parsePattern(tt.cell)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s err got=%v, wantErr=%v", tt.cell, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got := p.match(tt.value); got != tt.want {
+			t.Errorf("%s match %s got=%v, want=%v", tt.cell, tt.value, got, tt.want)
+		}
+	}
+}
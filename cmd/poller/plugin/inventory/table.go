@@ -0,0 +1,237 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package inventory
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"gopkg.in/yaml.v3"
+	"regexp"
+	"strings"
+)
+
+// pattern matches the value of an instance label. Inventory cells are exact values, wildcards like vol_*,
+// or regular expressions between slashes like /^vol\d+$/. An empty cell or * matches any value.
+// Wildcards are not file paths, * and ? match / too, so /vol/data/* matches the junction path /vol/data/home/user1.
+type pattern struct {
+	exact string
+	re    *regexp.Regexp
+	any   bool
+}
+
+func parsePattern(cell string) (pattern, error) {
+	cell = strings.TrimSpace(cell)
+	switch {
+	case cell == "" || cell == "*":
+		return pattern{any: true}, nil
+	case len(cell) > 1 && strings.HasPrefix(cell, "/") && strings.HasSuffix(cell, "/"):
+		re, err := regexp.Compile("^(?:" + cell[1:len(cell)-1] + ")$")
+		if err != nil {
+			return pattern{}, fmt.Errorf("invalid regex %s: %w", cell, err)
+		}
+		return pattern{re: re}, nil
+	case strings.ContainsAny(cell, "*?["):
+		re, err := globToRegexp(cell)
+		if err != nil {
+			return pattern{}, fmt.Errorf("invalid wildcard %s: %w", cell, err)
+		}
+		return pattern{re: re}, nil
+	default:
+		return pattern{exact: cell}, nil
+	}
+}
+
+func (p pattern) isExact() bool {
+	return p.re == nil && !p.any
+}
+
+func (p pattern) match(value string) bool {
+	switch {
+	case p.any:
+		return true
+	case p.re != nil:
+		return p.re.MatchString(value)
+	default:
+		return p.exact == value
+	}
+}
+
+// globToRegexp converts a wildcard to an anchored regular expression. * matches any run of characters,
+// ? matches one character, and [...] matches a character class, negated with [!...] or [^...].
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for glob != "" {
+		switch glob[0] {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '[':
+			class, rest, found := strings.Cut(glob[1:], "]")
+			if !found {
+				return nil, errors.New("unterminated character class")
+			}
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			if class == "" || class == "^" {
+				return nil, errors.New("empty character class")
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			glob = rest
+			continue
+		default:
+			end := strings.IndexAny(glob, "*?[")
+			if end < 0 {
+				end = len(glob)
+			}
+			b.WriteString(regexp.QuoteMeta(glob[:end]))
+			glob = glob[end:]
+			continue
+		}
+		glob = glob[1:]
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+type row struct {
+	patterns []pattern // one per match column
+	values   map[string]string
+}
+
+// table is a loaded inventory. Rows whose match columns are all exact values are indexed,
+// the others are tried in the order of the source.
+type table struct {
+	rows    int
+	exact   map[string]*row
+	ordered []*row
+}
+
+func newTable(records []map[string]string, match []plugin.Mapping) (*table, error) {
+	t := &table{exact: make(map[string]*row)}
+	for i, record := range records {
+		r := &row{values: record, patterns: make([]pattern, 0, len(match))}
+		exact := true
+		for _, m := range match {
+			p, err := parsePattern(record[m.From])
+			if err != nil {
+				return nil, fmt.Errorf("row %d column %s: %w", i+1, m.From, err)
+			}
+			exact = exact && p.isExact()
+			r.patterns = append(r.patterns, p)
+		}
+		t.rows++
+		if !exact {
+			t.ordered = append(t.ordered, r)
+			continue
+		}
+		k := exactKey(r.patterns)
+		// the first row wins, like it does for patterns
+		if _, ok := t.exact[k]; !ok {
+			t.exact[k] = r
+		}
+	}
+	return t, nil
+}
+
+func exactKey(patterns []pattern) string {
+	values := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		values = append(values, p.exact)
+	}
+	return strings.Join(values, "\x00")
+}
+
+// lookup returns the row of an instance whose match labels have values. Exact rows are preferred over patterns.
+func (t *table) lookup(values []string) *row {
+	if r, ok := t.exact[strings.Join(values, "\x00")]; ok {
+		return r
+	}
+	for _, r := range t.ordered {
+		matched := true
+		for i, p := range r.patterns {
+			if !p.match(values[i]) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return r
+		}
+	}
+	return nil
+}
+
+// parseRecords parses an inventory in csv, json, or yaml format.
+// csv files have a header row. json and yaml files are a list of objects with scalar values.
+func parseRecords(data []byte, format string) ([]map[string]string, error) {
+	switch format {
+	case "csv":
+		return parseCSV(data)
+	case "json":
+		var records []map[string]any
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.UseNumber()
+		if err := d.Decode(&records); err != nil {
+			return nil, fmt.Errorf("failed to parse json: %w", err)
+		}
+		return stringify(records), nil
+	case "yaml":
+		var records []map[string]any
+		if err := yaml.Unmarshal(data, &records); err != nil {
+			return nil, fmt.Errorf("failed to parse yaml: %w", err)
+		}
+		return stringify(records), nil
+	default:
+		return nil, fmt.Errorf("unsupported format %s", format)
+	}
+}
+
+func parseCSV(data []byte) ([]map[string]string, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.TrimLeadingSpace = true
+	r.Comment = '#'
+	lines, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse csv: %w", err)
+	}
+	if len(lines) == 0 {
+		return nil, errors.New("csv has no header")
+	}
+	header := lines[0]
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+	records := make([]map[string]string, 0, len(lines)-1)
+	for _, line := range lines[1:] {
+		record := make(map[string]string, len(header))
+		for i, column := range header {
+			record[column] = strings.TrimSpace(line[i])
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func stringify(records []map[string]any) []map[string]string {
+	result := make([]map[string]string, 0, len(records))
+	for _, record := range records {
+		r := make(map[string]string, len(record))
+		for k, v := range record {
+			if v == nil {
+				continue
+			}
+			r[k] = fmt.Sprint(v)
+		}
+		result = append(result, r)
+	}
+	return result
+}
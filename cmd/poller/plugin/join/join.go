@@ -0,0 +1,252 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+// Package join enriches the instances of an object with the labels and metrics of another object
+// that a collector of the same poller collects. The other object is read from the poller's registry,
+// so joining does not make any API calls.
+package join
+
+import (
+	"errors"
+	"fmt"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/cmd/poller/registry"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"github.com/netapp/harvest/v2/pkg/util"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const defaultMaxAge = 10 * time.Minute
+
+type rule struct {
+	collector string
+	object    string
+	keys      []plugin.Mapping // labels of the other object => labels of this object
+	labels    []plugin.Mapping
+	metrics   []plugin.Mapping
+	maxAge    time.Duration
+
+	// index of the other object's instances by key, rebuilt when the other object is published again
+	published time.Time
+	index     map[string]*matrix.Instance
+}
+
+type Join struct {
+	*plugin.AbstractPlugin
+	registry *registry.Registry
+	rules    []*rule
+}
+
+func New(p *plugin.AbstractPlugin) *Join {
+	return &Join{AbstractPlugin: p, registry: registry.Default}
+}
+
+func (j *Join) Init(remote conf.Remote) error {
+	if err := j.AbstractPlugin.Init(remote); err != nil {
+		return err
+	}
+
+	for _, c := range j.Params.GetChildren() {
+		r, err := j.parseRule(c)
+		if err != nil {
+			return errs.New(errs.ErrInvalidParam, err.Error())
+		}
+		j.rules = append(j.rules, r)
+		j.registry.Want(r.collector, r.object)
+		j.SLogger.Debug(
+			"parsed rule",
+			slog.String("collector", r.collector),
+			slog.String("object", r.object),
+			slog.Int("labels", len(r.labels)),
+			slog.Int("metrics", len(r.metrics)),
+		)
+	}
+
+	if len(j.rules) == 0 {
+		return errs.New(errs.ErrMissingParam, "valid rules")
+	}
+	return nil
+}
+
+func (j *Join) parseRule(n *node.Node) (*rule, error) {
+	r := &rule{
+		collector: n.GetChildContentS("collector"),
+		object:    n.GetNameS(),
+		maxAge:    defaultMaxAge,
+	}
+	if r.object == "" {
+		return nil, errors.New("rule has no object")
+	}
+	if r.collector == "" {
+		r.collector = j.Parent
+	}
+	if maxAge := n.GetChildContentS("max_age"); maxAge != "" {
+		d, err := time.ParseDuration(maxAge)
+		if err != nil {
+			return nil, fmt.Errorf("object %s has invalid max_age %s: %w", r.object, maxAge, err)
+		}
+		r.maxAge = d
+	}
+	r.keys = plugin.ParseMappings(n.GetChildS("keys"))
+	r.labels = plugin.ParseMappings(n.GetChildS("labels"))
+	r.metrics = plugin.ParseMappings(n.GetChildS("metrics"))
+	if len(r.keys) == 0 {
+		return nil, fmt.Errorf("object %s has no keys", r.object)
+	}
+	if len(r.labels) == 0 && len(r.metrics) == 0 {
+		return nil, fmt.Errorf("object %s has no labels or metrics to join", r.object)
+	}
+	return r, nil
+}
+
+func (j *Join) Run(dataMap map[string]*matrix.Matrix) ([]*matrix.Matrix, *util.Metadata, error) {
+	data := dataMap[j.Object]
+	metadata := &util.Metadata{}
+
+	for _, r := range j.rules {
+		if err := j.join(data, r, metadata); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return nil, metadata, nil
+}
+
+func (j *Join) join(data *matrix.Matrix, r *rule, metadata *util.Metadata) error {
+	metrics := make([]*matrix.Metric, len(r.metrics))
+	for i, m := range r.metrics {
+		metric := data.GetMetric(m.To)
+		if metric == nil {
+			var err error
+			if metric, err = data.NewMetricFloat64(m.To); err != nil {
+				return fmt.Errorf("failed to create metric %s: %w", m.To, err)
+			}
+			metric.SetProperty("join")
+		}
+		metrics[i] = metric
+	}
+
+	entry, ok := j.registry.Get(r.collector, r.object)
+	if !ok || entry.Age() > r.maxAge {
+		// Do not export joined values that may be outdated
+		for _, instance := range data.GetInstances() {
+			clearJoined(instance, r, metrics)
+		}
+		metadata.JoinStale += uint64(len(data.GetInstances()))
+		j.SLogger.Debug(
+			"skip stale join",
+			slog.String("collector", r.collector),
+			slog.String("object", r.object),
+			slog.Bool("published", ok),
+			slog.Duration("maxAge", r.maxAge),
+		)
+		return nil
+	}
+
+	if r.index == nil || !entry.Published.Equal(r.published) {
+		r.index = make(map[string]*matrix.Instance, len(entry.Matrix.GetInstances()))
+		for _, instance := range entry.Matrix.GetInstances() {
+			if k, ok := joinKey(instance, r.keys, func(m plugin.Mapping) string { return m.From }); ok {
+				r.index[k] = instance
+			}
+		}
+		r.published = entry.Published
+	}
+
+	for _, instance := range data.GetInstances() {
+		k, ok := joinKey(instance, r.keys, func(m plugin.Mapping) string { return m.To })
+		other, found := r.index[k]
+		if !ok || !found {
+			clearJoined(instance, r, metrics)
+			metadata.JoinMisses++
+			continue
+		}
+		metadata.JoinHits++
+		for _, l := range r.labels {
+			instance.SetLabel(l.To, other.GetLabel(l.From))
+		}
+		for i, m := range r.metrics {
+			var (
+				value float64
+				ok    bool
+			)
+			if otherMetric := entry.Matrix.GetMetric(m.From); otherMetric != nil {
+				value, ok = otherMetric.GetValueFloat64(other)
+			}
+			if ok {
+				_ = metrics[i].SetValueFloat64(instance, value)
+			} else {
+				metrics[i].SetValueNAN(instance)
+			}
+		}
+	}
+	return nil
+}
+
+// clearJoined removes the joined labels and metrics of an instance that can not be joined
+func clearJoined(instance *matrix.Instance, r *rule, metrics []*matrix.Metric) {
+	for _, l := range r.labels {
+		instance.SetLabel(l.To, "")
+	}
+	for _, metric := range metrics {
+		metric.SetValueNAN(instance)
+	}
+}
+
+// joinKey returns the values of the key labels of an instance and false when one of them is empty
+func joinKey(instance *matrix.Instance, keys []plugin.Mapping, label func(plugin.Mapping) string) (string, bool) {
+	var b strings.Builder
+	for i, k := range keys {
+		value := instance.GetLabel(label(k))
+		if value == "" {
+			return "", false
+		}
+		if i > 0 {
+			b.WriteByte(0)
+		}
+		b.WriteString(value)
+	}
+	return b.String(), true
+}
+
+// NewLabels returns the labels the receiver adds to instances
+func (j *Join) NewLabels() []string {
+	var labels []string
+	for _, r := range j.rules {
+		for _, l := range r.labels {
+			labels = append(labels, l.To)
+		}
+	}
+	return labels
+}
+
+// SourceLabels returns the labels of this object the receiver joins on
+func (j *Join) SourceLabels() []string {
+	var labels []string
+	for _, r := range j.rules {
+		for _, k := range r.keys {
+			labels = append(labels, k.To)
+		}
+	}
+	return labels
+}
+
+// NewMetrics returns the new metrics the receiver creates
+func (j *Join) NewMetrics() []plugin.DerivedMetric {
+	var derivedMetrics []plugin.DerivedMetric
+	for _, r := range j.rules {
+		for _, m := range r.metrics {
+			derivedMetrics = append(derivedMetrics, plugin.DerivedMetric{
+				Name:   m.To,
+				Source: r.object + " " + m.From,
+			})
+		}
+	}
+	return derivedMetrics
+}
@@ -0,0 +1,192 @@
+package join
+
+import (
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/cmd/poller/registry"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/tree"
+	"testing"
+	"time"
+)
+
+const template = `
+plugins:
+  - Join:
+      aggr:
+        keys:
+          - aggr
+          - node => node
+        labels:
+          - type => aggr_type
+        metrics:
+          - space_total => aggr_space_total
+        max_age: 5m
+`
+
+func newJoin(t *testing.T, r *registry.Registry) *Join {
+	t.Helper()
+	root, err := tree.LoadYaml([]byte(template))
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := root.GetChildS("plugins").GetChildS("Join")
+	j := New(plugin.New("Rest", nil, params, nil, "volume", nil))
+	j.registry = r
+	if err := j.Init(conf.Remote{}); err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+func newAggr(t *testing.T) *matrix.Matrix {
+	t.Helper()
+	m := matrix.New("aggr", "aggr", "aggr")
+	spaceTotal, _ := m.NewMetricFloat64("space_total")
+	for _, a := range []struct {
+		aggr, node, kind string
+		total            float64
+	}{
+		{aggr: "aggr1", node: "n1", kind: "ssd", total: 100},
+		{aggr: "aggr2", node: "n2", kind: "hdd", total: 200},
+	} {
+		instance, err := m.NewInstance(a.aggr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		instance.SetLabel("aggr", a.aggr)
+		instance.SetLabel("node", a.node)
+		instance.SetLabel("type", a.kind)
+		_ = spaceTotal.SetValueFloat64(instance, a.total)
+	}
+	return m
+}
+
+func newVolume(t *testing.T) *matrix.Matrix {
+	t.Helper()
+	m := matrix.New("volume", "volume", "volume")
+	for _, v := range []struct{ volume, aggr, node string }{
+		{volume: "vol1", aggr: "aggr1", node: "n1"},
+		{volume: "vol2", aggr: "aggr2", node: "n2"},
+		{volume: "vol3", aggr: "aggr2", node: "n1"},
+	} {
+		instance, err := m.NewInstance(v.volume)
+		if err != nil {
+			t.Fatal(err)
+		}
+		instance.SetLabel("volume", v.volume)
+		instance.SetLabel("aggr", v.aggr)
+		instance.SetLabel("node", v.node)
+	}
+	return m
+}
+
+func TestJoin(t *testing.T) {
+	r := registry.New()
+	j := newJoin(t, r)
+
+	if !r.Wanted("Rest", "aggr") {
+		t.Fatal("Join did not ask for the aggr matrix")
+	}
+
+	aggr := newAggr(t)
+	r.Publish("Rest", "aggr", aggr)
+	// later changes of the source matrix are not visible to readers
+	aggr.GetInstance("aggr1").SetLabel("type", "changed")
+
+	volume := newVolume(t)
+	_, metadata, err := j.Run(map[string]*matrix.Matrix{"volume": volume})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if metadata.JoinHits != 2 || metadata.JoinMisses != 1 || metadata.JoinStale != 0 {
+		t.Errorf("metadata got hits=%d misses=%d stale=%d, want hits=2 misses=1 stale=0",
+			metadata.JoinHits, metadata.JoinMisses, metadata.JoinStale)
+	}
+
+	tests := []struct {
+		volume string
+		kind   string
+		total  float64
+		record bool
+	}{
+		{volume: "vol1", kind: "ssd", total: 100, record: true},
+		{volume: "vol2", kind: "hdd", total: 200, record: true},
+		{volume: "vol3", kind: "", record: false},
+	}
+	total := volume.GetMetric("aggr_space_total")
+	if total == nil {
+		t.Fatal("metric aggr_space_total missing")
+	}
+	for _, tt := range tests {
+		instance := volume.GetInstance(tt.volume)
+		if got := instance.GetLabel("aggr_type"); got != tt.kind {
+			t.Errorf("%s aggr_type got=%s, want=%s", tt.volume, got, tt.kind)
+		}
+		got, ok := total.GetValueFloat64(instance)
+		if ok != tt.record || got != tt.total {
+			t.Errorf("%s aggr_space_total got=%f,%t want=%f,%t", tt.volume, got, ok, tt.total, tt.record)
+		}
+	}
+}
+
+func TestJoinStale(t *testing.T) {
+	r := registry.New()
+	j := newJoin(t, r)
+	volume := newVolume(t)
+
+	// nothing published yet
+	_, metadata, err := j.Run(map[string]*matrix.Matrix{"volume": volume})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.JoinStale != 3 || metadata.JoinHits != 0 {
+		t.Errorf("metadata got hits=%d stale=%d, want hits=0 stale=3", metadata.JoinHits, metadata.JoinStale)
+	}
+
+	r.Publish("Rest", "aggr", newAggr(t))
+	if _, _, err := j.Run(map[string]*matrix.Matrix{"volume": volume}); err != nil {
+		t.Fatal(err)
+	}
+	if got := volume.GetInstance("vol1").GetLabel("aggr_type"); got != "ssd" {
+		t.Fatalf("aggr_type got=%s, want=ssd", got)
+	}
+
+	// joined values are removed once the source is older than max_age
+	j.rules[0].maxAge = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	_, metadata, err = j.Run(map[string]*matrix.Matrix{"volume": volume})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.JoinStale != 3 {
+		t.Errorf("stale got=%d, want=3", metadata.JoinStale)
+	}
+	if got := volume.GetInstance("vol1").GetLabel("aggr_type"); got != "" {
+		t.Errorf("aggr_type got=%s, want empty", got)
+	}
+	if _, ok := volume.GetMetric("aggr_space_total").GetValueFloat64(volume.GetInstance("vol1")); ok {
+		t.Error("aggr_space_total is recorded for a stale join")
+	}
+}
+
+func TestInvalidRules(t *testing.T) {
+	templates := []string{
+		"Join:\n  aggr:\n    labels:\n      - type\n",
+		"Join:\n  aggr:\n    keys:\n      - aggr\n",
+		"Join:\n  aggr:\n    keys:\n      - aggr\n    labels:\n      - type\n    max_age: soon\n",
+		"Join:\n  - aggr\n",
+	}
+	for _, tmpl := range templates {
+		root, err := tree.LoadYaml([]byte(tmpl))
+		if err != nil {
+			t.Fatal(err)
+		}
+		j := New(plugin.New("Rest", nil, root.GetChildS("Join"), nil, "volume", nil))
+		j.registry = registry.New()
+		if err := j.Init(conf.Remote{}); err == nil {
+			t.Errorf("expected an error for template\n%s", tmpl)
+		}
+	}
+}
@@ -330,3 +330,51 @@ func (a *LabelAgent) mapValueToNumRegex(m *matrix.Matrix) error {
 func (a *LabelAgent) NewLabels() []string {
 	return a.newLabelNames
 }
+
+// SourceLabels returns the labels the receiver's rules read from
+func (a *LabelAgent) SourceLabels() []string {
+	var labels []string
+	for _, r := range a.splitSimpleRules {
+		labels = append(labels, r.source)
+	}
+	for _, r := range a.splitRegexRules {
+		labels = append(labels, r.source)
+	}
+	for _, r := range a.splitPairsRules {
+		labels = append(labels, r.source)
+	}
+	for _, r := range a.joinSimpleRules {
+		labels = append(labels, r.sources...)
+	}
+	for _, r := range a.replaceSimpleRules {
+		labels = append(labels, r.source)
+	}
+	for _, r := range a.replaceRegexRules {
+		labels = append(labels, r.source)
+	}
+	for _, r := range a.excludeEqualsRules {
+		labels = append(labels, r.label)
+	}
+	for _, r := range a.excludeContainsRules {
+		labels = append(labels, r.label)
+	}
+	for _, r := range a.excludeRegexRules {
+		labels = append(labels, r.label)
+	}
+	for _, r := range a.includeEqualsRules {
+		labels = append(labels, r.label)
+	}
+	for _, r := range a.includeContainsRules {
+		labels = append(labels, r.label)
+	}
+	for _, r := range a.includeRegexRules {
+		labels = append(labels, r.label)
+	}
+	for _, r := range a.valueToNumRules {
+		labels = append(labels, r.label)
+	}
+	for _, r := range a.valueToNumRegexRules {
+		labels = append(labels, r.label)
+	}
+	return labels
+}
@@ -0,0 +1,560 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package metricagent
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// An expression rule creates a metric from an arithmetic expression over metrics, optionally guarded by a predicate:
+//
+//	free_after_reserve = (size_used - snapshot_reserve_used) / max(size_total, 1)
+//	dp_size = size if svm_subtype == "dp" and size > 0
+//
+// Expressions are compiled once when the plugin is initialized. Evaluation is NaN-aware: a missing metric value,
+// a division by zero, or a function outside its domain makes the result invalid, and invalid results are not recorded
+
+// valuer resolves the metric values and labels of the instance an expression is evaluated for
+type valuer interface {
+	// value returns the metric's value or NaN when the instance has no value
+	value(name string) float64
+	label(name string) string
+}
+
+type expr interface {
+	eval(v valuer) float64
+}
+
+type predicate interface {
+	test(v valuer) bool
+}
+
+type exprRule struct {
+	metric string
+	source string // the expression as written, without the target metric
+	expr   expr
+	cond   predicate // nil when the rule has no if
+	refs   []string  // metrics the expression reads from
+	labels []string  // labels the predicate reads from
+}
+
+var exprRuleRe = regexp.MustCompile(`^([A-Za-z_][\w.]*)\s*=([^=~].*)$`)
+
+// isExprRule reports whether a compute_metric rule uses the expression syntax instead of METRIC OPERATION METRIC1...
+func isExprRule(rule string) bool {
+	return exprRuleRe.MatchString(rule)
+}
+
+func compileExprRule(rule string) (*exprRule, error) {
+	match := exprRuleRe.FindStringSubmatch(rule)
+	if match == nil {
+		return nil, errors.New("expected METRIC = EXPRESSION")
+	}
+	tokens, err := tokenize(match[2])
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, rule: &exprRule{metric: match[1], source: strings.TrimSpace(match[2])}}
+	if p.rule.expr, err = p.parseExpr(); err != nil {
+		return nil, err
+	}
+	if p.peek().isWord("if") {
+		p.next()
+		if p.rule.cond, err = p.parseOr(); err != nil {
+			return nil, err
+		}
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", t.text, t.pos)
+	}
+	return p.rule, nil
+}
+
+// eval returns the value of the rule and false when the value should not be recorded
+func (r *exprRule) eval(v valuer) (float64, bool) {
+	if r.cond != nil && !r.cond.test(v) {
+		return 0, false
+	}
+	result := r.expr.eval(v)
+	if math.IsNaN(result) || math.IsInf(result, 0) {
+		return 0, false
+	}
+	return result, true
+}
+
+// tokens
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokNumber
+	tokIdent
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind tokKind
+	text string
+	num  float64
+	pos  int
+}
+
+func (t token) isOp(op string) bool {
+	return t.kind == tokOp && t.text == op
+}
+
+func (t token) isWord(w string) bool {
+	return t.kind == tokIdent && t.text == w
+}
+
+var twoCharOps = []string{"==", "!=", "<=", ">=", "=~", "!~"}
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(s) {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c) || c == '.' && i+1 < len(s) && unicode.IsDigit(rune(s[i+1])):
+			j := i
+			for j < len(s) && (unicode.IsDigit(rune(s[j])) || s[j] == '.') {
+				j++
+			}
+			// exponent, e.g. 1e9 or 2.5E-3
+			if j < len(s) && (s[j] == 'e' || s[j] == 'E') {
+				k := j + 1
+				if k < len(s) && (s[k] == '+' || s[k] == '-') {
+					k++
+				}
+				if k < len(s) && unicode.IsDigit(rune(s[k])) {
+					for k < len(s) && unicode.IsDigit(rune(s[k])) {
+						k++
+					}
+					j = k
+				}
+			}
+			n, err := strconv.ParseFloat(s[i:j], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at position %d", s[i:j], i)
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: s[i:j], num: n, pos: i})
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || s[j] == '_' || s[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: s[i:j], pos: i})
+			i = j
+		case c == '"' || c == '\'':
+			j := strings.IndexByte(s[i+1:], s[i])
+			if j < 0 {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			tokens = append(tokens, token{kind: tokString, text: s[i+1 : i+1+j], pos: i})
+			i += j + 2
+		default:
+			op := ""
+			for _, two := range twoCharOps {
+				if strings.HasPrefix(s[i:], two) {
+					op = two
+					break
+				}
+			}
+			if op == "" && strings.ContainsRune("+-*/(),<>", c) {
+				op = string(c)
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at position %d", c, i)
+			}
+			tokens = append(tokens, token{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{kind: tokEOF, text: "end of expression", pos: len(s)}), nil
+}
+
+// parser
+//
+//	or         = and { "or" and }
+//	and        = not { "and" not }
+//	not        = "not" not | comparison
+//	comparison = IDENT ( "==" | "!=" | "=~" | "!~" ) STRING | expr ( "==" | "!=" | "<" | "<=" | ">" | ">=" ) expr
+//	expr       = term { ( "+" | "-" ) term }
+//	term       = unary { ( "*" | "/" ) unary }
+//	unary      = "-" unary | primary
+//	primary    = NUMBER | IDENT | IDENT "(" expr { "," expr } ")" | "(" expr ")"
+
+type parser struct {
+	tokens []token
+	pos    int
+	rule   *exprRule
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) expectOp(op string) error {
+	if t := p.next(); !t.isOp(op) {
+		return fmt.Errorf("expected %q but got %q at position %d", op, t.text, t.pos)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (predicate, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().isWord("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orPred{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (predicate, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().isWord("and") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andPred{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (predicate, error) {
+	if p.peek().isWord("not") {
+		p.next()
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notPred{x}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (predicate, error) {
+	// label comparison, e.g. svm_subtype == "dp"
+	if p.peek().kind == tokIdent && p.tokens[p.pos+1].kind == tokOp && p.pos+2 < len(p.tokens) && p.tokens[p.pos+2].kind == tokString {
+		label, op, value := p.next(), p.next(), p.next()
+		lc := labelCmp{label: label.text, op: op.text, value: value.text}
+		switch op.text {
+		case "==", "!=":
+		case "=~", "!~":
+			re, err := regexp.Compile(value.text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regex %q at position %d err: %w", value.text, value.pos, err)
+			}
+			lc.re = re
+		default:
+			return nil, fmt.Errorf("labels can not be compared with %q at position %d", op.text, op.pos)
+		}
+		p.rule.labels = append(p.rule.labels, label.text)
+		return lc, nil
+	}
+
+	left, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	op := p.next()
+	switch op.text {
+	case "==", "!=", "<", "<=", ">", ">=":
+	default:
+		return nil, fmt.Errorf("expected a comparison but got %q at position %d", op.text, op.pos)
+	}
+	right, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	return numCmp{op: op.text, left: left, right: right}, nil
+}
+
+func (p *parser) parseExpr() (expr, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().isOp("+") || p.peek().isOp("-") {
+		op := p.next().text
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseTerm() (expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().isOp("*") || p.peek().isOp("/") {
+		op := p.next().text
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (expr, error) {
+	if p.peek().isOp("-") {
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return negate{x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (expr, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokNumber:
+		return number(t.num), nil
+	case t.isOp("("):
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+		return x, nil
+	case t.kind == tokIdent && p.peek().isOp("("):
+		return p.parseCall(t)
+	case t.kind == tokIdent && !slices.Contains(keywords, t.text):
+		p.rule.refs = append(p.rule.refs, t.text)
+		return metricRef(t.text), nil
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", t.text, t.pos)
+}
+
+func (p *parser) parseCall(name token) (expr, error) {
+	f, ok := functions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at position %d", name.text, name.pos)
+	}
+	p.next() // (
+	var args []expr
+	for {
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if !p.peek().isOp(",") {
+			break
+		}
+		p.next()
+	}
+	if err := p.expectOp(")"); err != nil {
+		return nil, err
+	}
+	if len(args) < f.minArgs || f.maxArgs > 0 && len(args) > f.maxArgs {
+		return nil, fmt.Errorf("function %s called with %d arguments at position %d", name.text, len(args), name.pos)
+	}
+	return call{fn: f, args: args}, nil
+}
+
+var keywords = []string{"if", "and", "or", "not"}
+
+// expressions
+
+type number float64
+
+func (n number) eval(valuer) float64 {
+	return float64(n)
+}
+
+type metricRef string
+
+func (m metricRef) eval(v valuer) float64 {
+	return v.value(string(m))
+}
+
+type negate struct {
+	x expr
+}
+
+func (n negate) eval(v valuer) float64 {
+	return -n.x.eval(v)
+}
+
+type binary struct {
+	op          string
+	left, right expr
+}
+
+func (b binary) eval(v valuer) float64 {
+	l, r := b.left.eval(v), b.right.eval(v)
+	switch b.op {
+	case "+":
+		return l + r
+	case "-":
+		return l - r
+	case "*":
+		return l * r
+	case "/":
+		if r == 0 {
+			return math.NaN()
+		}
+		return l / r
+	}
+	return math.NaN()
+}
+
+type function struct {
+	minArgs, maxArgs int // maxArgs is 0 for variadic functions
+	apply            func(args []float64) float64
+}
+
+var functions = map[string]function{
+	"abs":   {1, 1, func(a []float64) float64 { return math.Abs(a[0]) }},
+	"clamp": {3, 3, func(a []float64) float64 { return math.Min(math.Max(a[0], a[1]), a[2]) }},
+	"log":   {1, 1, func(a []float64) float64 { return domainLog(math.Log, a[0]) }},
+	"log2":  {1, 1, func(a []float64) float64 { return domainLog(math.Log2, a[0]) }},
+	"log10": {1, 1, func(a []float64) float64 { return domainLog(math.Log10, a[0]) }},
+	"max":   {1, 0, func(a []float64) float64 { return fold(math.Max, a) }},
+	"min":   {1, 0, func(a []float64) float64 { return fold(math.Min, a) }},
+}
+
+func domainLog(log func(float64) float64, x float64) float64 {
+	if x <= 0 {
+		return math.NaN()
+	}
+	return log(x)
+}
+
+func fold(f func(float64, float64) float64, a []float64) float64 {
+	result := a[0]
+	for _, x := range a[1:] {
+		result = f(result, x)
+	}
+	return result
+}
+
+type call struct {
+	fn   function
+	args []expr
+}
+
+func (c call) eval(v valuer) float64 {
+	values := make([]float64, len(c.args))
+	for i, arg := range c.args {
+		values[i] = arg.eval(v)
+	}
+	return c.fn.apply(values)
+}
+
+// predicates
+
+type labelCmp struct {
+	label, op, value string
+	re               *regexp.Regexp
+}
+
+func (l labelCmp) test(v valuer) bool {
+	value := v.label(l.label)
+	switch l.op {
+	case "==":
+		return value == l.value
+	case "!=":
+		return value != l.value
+	case "=~":
+		return l.re.MatchString(value)
+	case "!~":
+		return !l.re.MatchString(value)
+	}
+	return false
+}
+
+// numCmp compares two expressions. Comparisons with NaN are false
+type numCmp struct {
+	op          string
+	left, right expr
+}
+
+func (n numCmp) test(v valuer) bool {
+	l, r := n.left.eval(v), n.right.eval(v)
+	switch n.op {
+	case "==":
+		return l == r
+	case "!=":
+		return l != r && !math.IsNaN(l) && !math.IsNaN(r)
+	case "<":
+		return l < r
+	case "<=":
+		return l <= r
+	case ">":
+		return l > r
+	case ">=":
+		return l >= r
+	}
+	return false
+}
+
+type andPred struct {
+	left, right predicate
+}
+
+func (a andPred) test(v valuer) bool {
+	return a.left.test(v) && a.right.test(v)
+}
+
+type orPred struct {
+	left, right predicate
+}
+
+func (o orPred) test(v valuer) bool {
+	return o.left.test(v) || o.right.test(v)
+}
+
+type notPred struct {
+	x predicate
+}
+
+func (n notPred) test(v valuer) bool {
+	return !n.x.test(v)
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright NetApp Inc, 2021 All rights reserved
+ */
+
+package metricagent
+
+import (
+	"math"
+	"testing"
+)
+
+type testValuer struct {
+	values map[string]float64
+	labels map[string]string
+}
+
+func (v testValuer) value(name string) float64 {
+	if val, ok := v.values[name]; ok {
+		return val
+	}
+	return math.NaN()
+}
+
+func (v testValuer) label(name string) string {
+	return v.labels[name]
+}
+
+func TestExprRule(t *testing.T) {
+	v := testValuer{
+		values: map[string]float64{
+			"size":                  100,
+			"size_used":             60,
+			"snapshot.reserve_used": 10,
+			"zero":                  0,
+		},
+		labels: map[string]string{
+			"svm_subtype": "dp",
+			"volume":      "vol_root",
+		},
+	}
+
+	tests := []struct {
+		rule   string
+		want   float64
+		record bool
+	}{
+		{rule: "a = 1 + 2 * 3", want: 7, record: true},
+		{rule: "a = (1 + 2) * 3", want: 9, record: true},
+		{rule: "a = -size + 0.5", want: -99.5, record: true},
+		{rule: "a = 1.5e2 / 3", want: 50, record: true},
+		{rule: "a = (size_used - snapshot.reserve_used) * 100 / size", want: 50, record: true},
+		{rule: "a = max(size, size_used, 200)", want: 200, record: true},
+		{rule: "a = min(size, size_used)", want: 60, record: true},
+		{rule: "a = abs(size_used - size)", want: 40, record: true},
+		{rule: "a = clamp(size_used, 0, 50)", want: 50, record: true},
+		{rule: "a = log10(size)", want: 2, record: true},
+		{rule: "a = log2(zero)", record: false},
+		{rule: "a = size / zero", record: false},
+		{rule: "a = size + missing", record: false},
+		{rule: `a = size if svm_subtype == "dp"`, want: 100, record: true},
+		{rule: `a = size if svm_subtype != "dp"`, record: false},
+		{rule: `a = size if volume =~ "^vol_" and size > 50`, want: 100, record: true},
+		{rule: `a = size if volume !~ "root" or not size_used < 50`, want: 100, record: true},
+		{rule: `a = size if size_used >= size`, record: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			r, err := compileExprRule(tt.rule)
+			if err != nil {
+				t.Fatalf("failed to compile err: %v", err)
+			}
+			got, record := r.eval(v)
+			if record != tt.record {
+				t.Errorf("record got=%t, want=%t", record, tt.record)
+			}
+			if record && math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("value got=%f, want=%f", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExprRuleInvalid(t *testing.T) {
+	rules := []string{
+		"a = ",
+		"a = (size + 1",
+		"a = size +",
+		"a = size 1",
+		"a = unknown(size)",
+		"a = clamp(size, 1)",
+		"a = max()",
+		`a = "dp"`,
+		"a = size if",
+		`a = size if volume =~ "("`,
+		"a = size & 1",
+	}
+	for _, rule := range rules {
+		if _, err := compileExprRule(rule); err == nil {
+			t.Errorf("rule %q compiled, want error", rule)
+		}
+	}
+}
+
+func TestIsExprRule(t *testing.T) {
+	tests := []struct {
+		rule string
+		want bool
+	}{
+		{rule: "space_total ADD space_available space_used", want: false},
+		{rule: "space_total = space_available + space_used", want: true},
+		{rule: "space.total=space_available", want: true},
+		{rule: "a == b", want: false},
+	}
+	for _, tt := range tests {
+		if got := isExprRule(tt.rule); got != tt.want {
+			t.Errorf("isExprRule(%q) got=%t, want=%t", tt.rule, got, tt.want)
+		}
+	}
+}
@@ -5,6 +5,7 @@
 package metricagent
 
 import (
+	"errors"
 	"github.com/netapp/harvest/v2/cmd/poller/plugin"
 	"github.com/netapp/harvest/v2/pkg/conf"
 	"github.com/netapp/harvest/v2/pkg/errs"
@@ -12,6 +13,8 @@ import (
 	"github.com/netapp/harvest/v2/pkg/slogx"
 	"github.com/netapp/harvest/v2/pkg/util"
 	"log/slog"
+	"math"
+	"slices"
 	"strconv"
 	"strings"
 )
@@ -20,6 +23,8 @@ type MetricAgent struct {
 	*plugin.AbstractPlugin
 	actions            []func(*matrix.Matrix) error
 	computeMetricRules []computeMetricRule
+	exprRules          []*exprRule
+	ruleErrs           []error
 }
 
 func New(p *plugin.AbstractPlugin) *MetricAgent {
@@ -37,7 +42,11 @@ func (a *MetricAgent) Init(remote conf.Remote) error {
 		return err
 	}
 
-	if count = a.parseRules(); count == 0 {
+	count = a.parseRules()
+	if len(a.ruleErrs) > 0 {
+		return errs.New(errs.ErrInvalidParam, errors.Join(a.ruleErrs...).Error())
+	}
+	if count == 0 {
 		err = errs.New(errs.ErrMissingParam, "valid rules")
 	} else {
 		a.SLogger.Debug("parsed rules", slog.Int("count", count), slog.Int("actions", len(a.actions)))
@@ -141,6 +150,60 @@ func (a *MetricAgent) computeMetrics(m *matrix.Matrix) error {
 	return nil
 }
 
+// instanceValuer resolves the operands of an expression rule for one instance
+type instanceValuer struct {
+	metrics  map[string]*matrix.Metric
+	instance *matrix.Instance
+}
+
+func (v *instanceValuer) value(name string) float64 {
+	metric := v.metrics[name]
+	if metric == nil {
+		return math.NaN()
+	}
+	if val, ok := metric.GetValueFloat64(v.instance); ok {
+		return val
+	}
+	return math.NaN()
+}
+
+func (v *instanceValuer) label(name string) string {
+	return v.instance.GetLabel(name)
+}
+
+// computeExpressions evaluates the compute_metric expression rules. Instances whose result is invalid,
+// or that do not match the rule's predicate, are not recorded
+func (a *MetricAgent) computeExpressions(m *matrix.Matrix) error {
+	for _, r := range a.exprRules {
+		metric := a.getMetric(m, r.metric)
+		if metric == nil {
+			var err error
+			if metric, err = m.NewMetricFloat64(r.metric); err != nil {
+				a.SLogger.Error("Failed to create metric", slogx.Err(err), slog.String("metric", r.metric))
+				return err
+			}
+			metric.SetProperty("compute_metric mapping")
+		}
+
+		v := &instanceValuer{metrics: make(map[string]*matrix.Metric, len(r.refs))}
+		for _, ref := range r.refs {
+			if operand := a.getMetric(m, ref); operand != nil {
+				v.metrics[ref] = operand
+			}
+		}
+
+		for _, instance := range m.GetInstances() {
+			v.instance = instance
+			if result, ok := r.eval(v); ok {
+				_ = metric.SetValueFloat64(instance, result)
+			} else {
+				metric.SetValueNAN(instance)
+			}
+		}
+	}
+	return nil
+}
+
 func (a *MetricAgent) getMetric(m *matrix.Matrix, name string) *matrix.Metric {
 	metric := m.DisplayMetric(name)
 	if metric != nil {
@@ -151,12 +214,31 @@ func (a *MetricAgent) getMetric(m *matrix.Matrix, name string) *matrix.Metric {
 
 // NewMetrics returns the new metrics the receiver creates
 func (a *MetricAgent) NewMetrics() []plugin.DerivedMetric {
-	derivedMetrics := make([]plugin.DerivedMetric, 0, len(a.computeMetricRules))
+	derivedMetrics := make([]plugin.DerivedMetric, 0, len(a.computeMetricRules)+len(a.exprRules))
 	for _, rule := range a.computeMetricRules {
 		derivedMetrics = append(derivedMetrics, plugin.DerivedMetric{
 			Name:   rule.metric,
 			Source: strings.Join(rule.metricNames, ", "),
 		})
 	}
+	for _, rule := range a.exprRules {
+		derivedMetrics = append(derivedMetrics, plugin.DerivedMetric{
+			Name:   rule.metric,
+			Source: rule.source,
+		})
+	}
 	return derivedMetrics
 }
+
+// SourceLabels returns the labels the receiver's expression rules read from
+func (a *MetricAgent) SourceLabels() []string {
+	var labels []string
+	for _, rule := range a.exprRules {
+		for _, label := range rule.labels {
+			if !slices.Contains(labels, label) {
+				labels = append(labels, label)
+			}
+		}
+	}
+	return labels
+}
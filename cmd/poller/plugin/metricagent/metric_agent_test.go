@@ -201,3 +201,79 @@ func TestComputeMetricsRule(t *testing.T) {
 	}
 
 }
+
+func TestComputeExpressions(t *testing.T) {
+	params := node.NewS("MetricAgent")
+	rules := params.NewChildS("compute_metric", "")
+	rules.NewChildS("", "used_percent = (size_used - snapshot_used) * 100 / size")
+	rules.NewChildS("", "used_max = max(size_used, snapshot_used, 1.5)")
+	rules.NewChildS("", `dp_size = size if svm_subtype == "dp"`)
+
+	abc := plugin.New("Test", nil, params, nil, "", nil)
+	p := New(abc)
+	if err := p.Init(conf.Remote{}); err != nil {
+		t.Fatal(err)
+	}
+
+	m := matrix.New("TestMetricAgent", "test", "test")
+	instanceA, _ := m.NewInstance("A")
+	instanceA.SetLabel("svm_subtype", "dp")
+	instanceB, _ := m.NewInstance("B")
+	instanceB.SetLabel("svm_subtype", "default")
+
+	size, _ := m.NewMetricFloat64("size")
+	sizeUsed, _ := m.NewMetricFloat64("size_used")
+	snapshotUsed, _ := m.NewMetricFloat64("snapshot_used")
+	_ = size.SetValueFloat64(instanceA, 200)
+	_ = sizeUsed.SetValueFloat64(instanceA, 120)
+	_ = snapshotUsed.SetValueFloat64(instanceA, 20)
+	// instanceB has a zero size and no snapshot_used value
+	_ = size.SetValueFloat64(instanceB, 0)
+	_ = sizeUsed.SetValueFloat64(instanceB, 1)
+
+	if err := p.computeExpressions(m); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		metric   string
+		instance *matrix.Instance
+		want     float64
+		record   bool
+	}{
+		{metric: "used_percent", instance: instanceA, want: 50, record: true},
+		{metric: "used_percent", instance: instanceB, record: false},
+		{metric: "used_max", instance: instanceA, want: 120, record: true},
+		{metric: "used_max", instance: instanceB, record: false},
+		{metric: "dp_size", instance: instanceA, want: 200, record: true},
+		{metric: "dp_size", instance: instanceB, record: false},
+	}
+	for _, tt := range tests {
+		metric := m.GetMetric(tt.metric)
+		if metric == nil {
+			t.Fatalf("metric [%s] missing", tt.metric)
+		}
+		got, ok := metric.GetValueFloat64(tt.instance)
+		if ok != tt.record {
+			t.Errorf("metric [%s] instance [%s]: record got=%t, want=%t", tt.metric, tt.instance.GetLabel("svm_subtype"), ok, tt.record)
+			continue
+		}
+		if ok && got != tt.want {
+			t.Errorf("metric [%s] instance [%s]: got=%f, want=%f", tt.metric, tt.instance.GetLabel("svm_subtype"), got, tt.want)
+		}
+	}
+
+	if labels := p.SourceLabels(); len(labels) != 1 || labels[0] != "svm_subtype" {
+		t.Errorf("SourceLabels got=%v, want=[svm_subtype]", labels)
+	}
+}
+
+func TestInvalidExpression(t *testing.T) {
+	params := node.NewS("MetricAgent")
+	params.NewChildS("compute_metric", "").NewChildS("", "used_percent = size_used * 100 / (size")
+
+	p := New(plugin.New("Test", nil, params, nil, "", nil))
+	if err := p.Init(conf.Remote{}); err == nil {
+		t.Error("expected Init to fail for an invalid expression")
+	}
+}
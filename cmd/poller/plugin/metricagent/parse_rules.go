@@ -5,6 +5,7 @@
 package metricagent
 
 import (
+	"fmt"
 	"github.com/netapp/harvest/v2/pkg/matrix"
 	"log/slog"
 	"strings"
@@ -14,6 +15,8 @@ import (
 func (a *MetricAgent) parseRules() int {
 
 	a.computeMetricRules = make([]computeMetricRule, 0)
+	a.exprRules = make([]*exprRule, 0)
+	a.ruleErrs = nil
 
 	for _, c := range a.Params.GetChildren() {
 		name := c.GetNameS()
@@ -25,7 +28,11 @@ func (a *MetricAgent) parseRules() int {
 
 			switch name {
 			case "compute_metric":
-				a.parseComputeMetricRule(rule)
+				if isExprRule(rule) {
+					a.parseExprRule(rule)
+				} else {
+					a.parseComputeMetricRule(rule)
+				}
 			default:
 				a.SLogger.Warn(
 					"Unknown rule name",
@@ -47,6 +54,10 @@ func (a *MetricAgent) parseRules() int {
 				a.actions = append(a.actions, a.computeMetrics)
 				count += len(a.computeMetricRules)
 			}
+			if len(a.exprRules) != 0 {
+				a.actions = append(a.actions, a.computeExpressions)
+				count += len(a.exprRules)
+			}
 		default:
 			a.SLogger.Warn(
 				"Unknown rule name",
@@ -83,3 +94,18 @@ func (a *MetricAgent) parseComputeMetricRule(rule string) {
 	}
 	a.SLogger.Warn("(compute_metric) rule has invalid format", slog.String("rule", rule))
 }
+
+func (a *MetricAgent) parseExprRule(rule string) {
+	r, err := compileExprRule(rule)
+	if err != nil {
+		a.SLogger.Warn("(compute_metric) invalid expression", slog.String("rule", rule), slog.String("err", err.Error()))
+		a.ruleErrs = append(a.ruleErrs, fmt.Errorf("compute_metric %q: %w", rule, err))
+		return
+	}
+	a.exprRules = append(a.exprRules, r)
+	a.SLogger.Debug(
+		"(compute_metric) parsed expression",
+		slog.String("metric", r.metric),
+		slog.String("expression", r.source),
+	)
+}
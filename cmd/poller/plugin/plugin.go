@@ -10,18 +10,19 @@
 // **built-in**
 // 	Statically compiled, generic plugins. "Generic" means
 // 	the plugin is collector-agnostic. These plugins are
-// 	provided in this package.
-//
-// **generic**
-// 	These are generic plugins as well, but they are compiled
-// 	as shared objects and dynamically loaded. These plugins are
-// 	living in the directory src/plugins.
+// 	provided in the sub-packages of this package.
 //
 // **custom**
 // 	These plugins are collector-specific. Their source code should
-// 	reside inside the plugins/ subdirectory of the collector package.
+// 	reside inside the plugins/ subdirectory of the collector package,
+// 	and they are created by the collector's LoadPlugin.
 // 	Custom plugins have access to all the parameters of their parent
 // 	collector and should be therefore treated with great care.
+//
+// **external**
+// 	Plugins that run as a long-lived process outside Harvest and
+// 	can be written in any language. They are configured with the
+// 	built-in Exec plugin, see package exec for the protocol.
 
 package plugin
 
@@ -35,6 +36,7 @@ import (
 	"github.com/netapp/harvest/v2/pkg/tree/node"
 	"github.com/netapp/harvest/v2/pkg/util"
 	"log/slog"
+	"strings"
 	"sync"
 	"time"
 )
@@ -187,4 +189,33 @@ type DerivedMetric struct {
 	Name   string
 	Source string
 	IsMax  bool
+	// Object is the object of the metric when it is not the object of the template, e.g., for aggregations
+	Object string
+}
+
+// Mapping relates a label, metric, or column From one side to a label or metric To the other
+type Mapping struct {
+	From string
+	To   string
+}
+
+// ParseMappings parses a list of "FROM => TO" or "NAME" entries. A NAME maps to itself.
+func ParseMappings(n *node.Node) []Mapping {
+	if n == nil {
+		return nil
+	}
+	var mappings []Mapping
+	for _, entry := range n.GetAllChildContentS() {
+		from, to, found := strings.Cut(entry, "=>")
+		from = strings.TrimSpace(from)
+		to = strings.TrimSpace(to)
+		if !found || to == "" {
+			to = from
+		}
+		if from == "" {
+			continue
+		}
+		mappings = append(mappings, Mapping{From: from, To: to})
+	}
+	return mappings
 }
@@ -0,0 +1,83 @@
+// Copyright NetApp Inc, 2021 All rights reserved
+
+// Package registry shares the latest instance matrix of each collector with the
+// other collectors of the same poller.
+//
+// Collectors publish the matrices of their data task after their plugins ran.
+// Only matrices that a consumer asked for with Want are published, since
+// publishing clones the matrix. Published matrices are snapshots that are shared
+// by all readers and must be treated as read-only.
+package registry
+
+import (
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"sync"
+	"time"
+)
+
+// Default is the registry of the poller
+var Default = New()
+
+// Entry is a published matrix and when it was published
+type Entry struct {
+	Matrix    *matrix.Matrix
+	Published time.Time
+}
+
+// Age returns how long ago the entry was published
+func (e Entry) Age() time.Duration {
+	return time.Since(e.Published)
+}
+
+type key struct {
+	collector string
+	object    string
+}
+
+type Registry struct {
+	mu      sync.RWMutex
+	wanted  map[key]bool
+	entries map[key]Entry
+}
+
+func New() *Registry {
+	return &Registry{
+		wanted:  make(map[key]bool),
+		entries: make(map[key]Entry),
+	}
+}
+
+// Want asks collector to publish the matrix of object
+func (r *Registry) Want(collector, object string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.wanted[key{collector: collector, object: object}] = true
+}
+
+// Wanted reports whether a consumer asked for the matrix of object
+func (r *Registry) Wanted(collector, object string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.wanted[key{collector: collector, object: object}]
+}
+
+// Publish stores a snapshot of m as the latest matrix of object. Matrices nobody wants are ignored.
+func (r *Registry) Publish(collector, object string, m *matrix.Matrix) {
+	k := key{collector: collector, object: object}
+	if !r.Wanted(collector, object) {
+		return
+	}
+	snapshot := m.Clone(matrix.With{Data: true, Metrics: true, Instances: true, ExportInstances: true})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[k] = Entry{Matrix: snapshot, Published: time.Now()}
+}
+
+// Get returns the latest matrix collector published for object
+func (r *Registry) Get(collector, object string) (Entry, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	e, ok := r.entries[key{collector: collector, object: object}]
+	return e, ok
+}
@@ -0,0 +1,33 @@
+package registry
+
+import (
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"testing"
+)
+
+func TestPublish(t *testing.T) {
+	r := New()
+	m := matrix.New("aggr", "aggr", "aggr")
+	instance, _ := m.NewInstance("aggr1")
+	instance.SetLabel("type", "ssd")
+
+	r.Publish("Rest", "aggr", m)
+	if _, ok := r.Get("Rest", "aggr"); ok {
+		t.Fatal("published a matrix nobody wants")
+	}
+
+	r.Want("Rest", "aggr")
+	r.Publish("Rest", "aggr", m)
+	e, ok := r.Get("Rest", "aggr")
+	if !ok {
+		t.Fatal("wanted matrix was not published")
+	}
+	if _, ok := r.Get("ZapiPerf", "aggr"); ok {
+		t.Error("matrix published by Rest is returned for ZapiPerf")
+	}
+
+	instance.SetLabel("type", "hdd")
+	if got := e.Matrix.GetInstance("aggr1").GetLabel("type"); got != "ssd" {
+		t.Errorf("snapshot changed with its source, type got=%s, want=ssd", got)
+	}
+}
@@ -0,0 +1,240 @@
+package doctor
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/util"
+	tw "github.com/netapp/harvest/v2/third_party/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var cardinalityCmd = &cobra.Command{
+	Use:   "cardinality",
+	Short: "Report the metrics and objects that contribute the most series per poller",
+	Long: `Scrape the Prometheus endpoint of each running poller and report the metrics with the most series,
+and the objects with the most instances, including instances dropped by cardinality budgets.`,
+	Run: doCardinalityCmd,
+}
+
+type objectCardinality struct {
+	collector         string
+	object            string
+	instances         int
+	overflowInstances int
+	overflowSeries    int
+}
+
+type cardinalityReport struct {
+	name    string
+	series  int
+	metrics map[string]int
+	objects map[string]*objectCardinality
+}
+
+var labelRe = regexp.MustCompile(`(\w+)="((?:[^"\\]|\\.)*)"`)
+
+func doCardinalityCmd(cmd *cobra.Command, _ []string) {
+	var config = cmd.Root().PersistentFlags().Lookup("config")
+
+	targets := cardinalityTargets(conf.ConfigPath(config.Value.String()))
+	if len(targets) == 0 {
+		fmt.Println("No running pollers with a Prometheus port found. Use --url to scrape an endpoint directly.")
+		os.Exit(1)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	failed := false
+	for _, target := range targets {
+		report, err := scrapeCardinality(client, target[0], target[1])
+		if err != nil {
+			fmt.Printf("poller=%s url=%s err=%v\n\n", target[0], target[1], err)
+			failed = true
+			continue
+		}
+		printCardinality(os.Stdout, report, opts.top)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// cardinalityTargets returns the name and metrics URL of each target, either from --url or the running pollers
+func cardinalityTargets(configPath string) [][2]string {
+	var targets [][2]string
+	for _, u := range opts.cardinalityURLs {
+		targets = append(targets, [2]string{u, u})
+	}
+	if len(targets) > 0 {
+		return targets
+	}
+
+	_, _ = conf.LoadHarvestConfig(configPath)
+	statuses, err := util.GetPollerStatuses()
+	if err != nil {
+		fmt.Printf("Unable to read poller statuses err=%v\n", err)
+		return nil
+	}
+	slices.SortFunc(statuses, func(a, b util.PollerStatus) int { return cmp.Compare(a.Name, b.Name) })
+
+	for _, status := range statuses {
+		if len(opts.cardinalityPollers) > 0 && !slices.Contains(opts.cardinalityPollers, status.Name) {
+			continue
+		}
+		port := status.PromPort
+		if port == "" {
+			if p, err := conf.GetLastPromPort(status.Name, false); err == nil && p != 0 {
+				port = strconv.Itoa(p)
+			}
+		}
+		if port == "" {
+			fmt.Printf("poller=%s skipped, Prometheus port is unknown\n", status.Name)
+			continue
+		}
+		//goland:noinspection HttpUrlsUsage
+		targets = append(targets, [2]string{status.Name, "http://localhost:" + port + "/metrics"})
+	}
+	return targets
+}
+
+func scrapeCardinality(client *http.Client, name string, url string) (*cardinalityReport, error) {
+	response, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status=%s", response.Status)
+	}
+	return parseCardinality(name, response.Body)
+}
+
+// parseCardinality counts the series of each metric in the Prometheus exposition format.
+// Instances and overflow per object are read from the collector metadata metrics.
+func parseCardinality(name string, r io.Reader) (*cardinalityReport, error) {
+	report := &cardinalityReport{
+		name:    name,
+		metrics: make(map[string]int),
+		objects: make(map[string]*objectCardinality),
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		end := strings.IndexAny(line, "{ ")
+		if end < 0 {
+			continue
+		}
+		metric := line[:end]
+		report.series++
+		report.metrics[metric]++
+
+		var field *int
+		switch metric {
+		case "metadata_collector_instances", "metadata_collector_overflow_instances", "metadata_collector_overflow_series":
+		default:
+			continue
+		}
+
+		labels := make(map[string]string)
+		for _, m := range labelRe.FindAllStringSubmatch(line, -1) {
+			labels[m[1]] = m[2]
+		}
+		if labels["task"] != "data" {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(line[strings.LastIndexByte(line, ' ')+1:]), 64)
+		if err != nil {
+			continue
+		}
+		key := labels["collector"] + ":" + labels["object"]
+		o, ok := report.objects[key]
+		if !ok {
+			o = &objectCardinality{collector: labels["collector"], object: labels["object"]}
+			report.objects[key] = o
+		}
+		switch metric {
+		case "metadata_collector_instances":
+			field = &o.instances
+		case "metadata_collector_overflow_instances":
+			field = &o.overflowInstances
+		default:
+			field = &o.overflowSeries
+		}
+		*field = int(value)
+	}
+	return report, scanner.Err()
+}
+
+func printCardinality(w io.Writer, report *cardinalityReport, top int) {
+	_, _ = fmt.Fprintf(w, "Poller: %s\nSeries: %d\n\n", report.name, report.series)
+
+	type count struct {
+		name  string
+		count int
+	}
+	metrics := make([]count, 0, len(report.metrics))
+	for name, n := range report.metrics {
+		metrics = append(metrics, count{name: name, count: n})
+	}
+	slices.SortFunc(metrics, func(a, b count) int {
+		return cmp.Or(cmp.Compare(b.count, a.count), cmp.Compare(a.name, b.name))
+	})
+
+	table := tw.NewWriter(w)
+	table.SetBorder(false)
+	table.SetAutoFormatHeaders(false)
+	table.SetHeader([]string{"Metric", "Series"})
+	table.SetColumnAlignment([]int{tw.ALIGN_LEFT, tw.ALIGN_RIGHT})
+	for _, m := range metrics[:min(top, len(metrics))] {
+		table.Append([]string{m.name, strconv.Itoa(m.count)})
+	}
+	table.Render()
+	_, _ = fmt.Fprintln(w)
+
+	objects := make([]*objectCardinality, 0, len(report.objects))
+	for _, o := range report.objects {
+		objects = append(objects, o)
+	}
+	if len(objects) == 0 {
+		return
+	}
+	slices.SortFunc(objects, func(a, b *objectCardinality) int {
+		return cmp.Or(
+			cmp.Compare(b.instances+b.overflowInstances, a.instances+a.overflowInstances),
+			cmp.Compare(a.collector, b.collector),
+			cmp.Compare(a.object, b.object),
+		)
+	})
+
+	table = tw.NewWriter(w)
+	table.SetBorder(false)
+	table.SetAutoFormatHeaders(false)
+	table.SetHeader([]string{"Collector", "Object", "Instances", "Overflow Instances", "Overflow Series"})
+	table.SetColumnAlignment([]int{tw.ALIGN_LEFT, tw.ALIGN_LEFT, tw.ALIGN_RIGHT, tw.ALIGN_RIGHT, tw.ALIGN_RIGHT})
+	for _, o := range objects[:min(top, len(objects))] {
+		table.Append([]string{
+			o.collector,
+			o.object,
+			strconv.Itoa(o.instances),
+			strconv.Itoa(o.overflowInstances),
+			strconv.Itoa(o.overflowSeries),
+		})
+	}
+	table.Render()
+	_, _ = fmt.Fprintln(w)
+}
@@ -0,0 +1,42 @@
+package doctor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCardinality(t *testing.T) {
+	exposition := `# HELP volume_size size
+# TYPE volume_size gauge
+volume_size{datacenter="dc1",cluster="c1",volume="vol1",svm="svm1"} 100
+volume_size{datacenter="dc1",cluster="c1",volume="vol2",svm="svm1"} 200
+volume_size{datacenter="dc1",cluster="c1",volume="other",svm="other"} 300
+volume_labels{datacenter="dc1",cluster="c1",volume="vol1",svm="svm1",style="flexvol"} 1.0
+metadata_collector_instances{poller="p1",collector="Rest",object="Volume",task="data"} 3
+metadata_collector_instances{poller="p1",collector="Rest",object="Volume",task="counter"} 0
+metadata_collector_overflow_instances{poller="p1",collector="Rest",object="Volume",task="data"} 12
+metadata_collector_overflow_series{poller="p1",collector="Rest",object="Volume",task="data"} 24
+metadata_collector_instances{poller="p1",collector="Rest",object="Qtree",task="data"} 7
+`
+	report, err := parseCardinality("p1", strings.NewReader(exposition))
+	if err != nil {
+		t.Fatalf("expected no error got %+v", err)
+	}
+	if report.series != 9 {
+		t.Errorf("got series=%d, want 9", report.series)
+	}
+	if report.metrics["volume_size"] != 3 || report.metrics["metadata_collector_instances"] != 3 {
+		t.Errorf("got metrics=%v", report.metrics)
+	}
+
+	volume := report.objects["Rest:Volume"]
+	if volume == nil {
+		t.Fatalf("expected the Rest:Volume object")
+	}
+	if volume.instances != 3 || volume.overflowInstances != 12 || volume.overflowSeries != 24 {
+		t.Errorf("got volume=%+v", *volume)
+	}
+	if qtree := report.objects["Rest:Qtree"]; qtree == nil || qtree.instances != 7 {
+		t.Errorf("got qtree=%+v", qtree)
+	}
+}
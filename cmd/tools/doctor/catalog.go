@@ -0,0 +1,324 @@
+package doctor
+
+import (
+	"errors"
+	"fmt"
+	"github.com/netapp/harvest/v2/cmd/tools/rest"
+	"github.com/netapp/harvest/v2/pkg/auth"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/third_party/go-version"
+	"gopkg.in/yaml.v3"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	swaggerFile  = "swagger.yaml"
+	countersFile = "counters.yaml"
+)
+
+// catalog is an offline copy of one ONTAP version's REST swagger and performance counter tables.
+// Catalogs are stored as <catalog dir>/<ontap version>/swagger.yaml and counters.yaml,
+// where counters.yaml maps each counter table to the names of its counters.
+type catalog struct {
+	version  *version.Version
+	paths    map[string]map[string]any // swagger path without the /api prefix -> path item
+	defs     map[string]any            // swagger definitions or components.schemas
+	counters map[string]map[string]bool
+}
+
+func defaultCatalogDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "harvest", "catalog")
+}
+
+// loadCatalogs loads every versioned catalog in dir. A missing dir is not an error.
+func loadCatalogs(dir string) ([]*catalog, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var catalogs []*catalog
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		v, err := version.NewVersion(entry.Name())
+		if err != nil {
+			continue
+		}
+		c, err := loadCatalog(filepath.Join(dir, entry.Name()), v)
+		if err != nil {
+			return nil, err
+		}
+		if c.paths == nil && c.counters == nil {
+			continue
+		}
+		catalogs = append(catalogs, c)
+	}
+	sort.Slice(catalogs, func(i, j int) bool { return catalogs[i].version.LessThan(catalogs[j].version) })
+	return catalogs, nil
+}
+
+func loadCatalog(dir string, v *version.Version) (*catalog, error) {
+	c := &catalog{version: v}
+
+	data, err := os.ReadFile(filepath.Join(dir, swaggerFile))
+	if err == nil {
+		var swagger struct {
+			Paths       map[string]map[string]any `yaml:"paths"`
+			Definitions map[string]any            `yaml:"definitions"`
+			Components  struct {
+				Schemas map[string]any `yaml:"schemas"`
+			} `yaml:"components"`
+		}
+		if err := yaml.Unmarshal(data, &swagger); err != nil {
+			return nil, fmt.Errorf("failed to parse %s err=%w", filepath.Join(dir, swaggerFile), err)
+		}
+		c.paths = make(map[string]map[string]any, len(swagger.Paths))
+		for path, item := range swagger.Paths {
+			c.paths[strings.Trim(strings.TrimPrefix(path, "/api"), "/")] = item
+		}
+		c.defs = swagger.Definitions
+		if c.defs == nil {
+			c.defs = swagger.Components.Schemas
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	data, err = os.ReadFile(filepath.Join(dir, countersFile))
+	if err == nil {
+		var tables map[string][]string
+		if err := yaml.Unmarshal(data, &tables); err != nil {
+			return nil, fmt.Errorf("failed to parse %s err=%w", filepath.Join(dir, countersFile), err)
+		}
+		c.counters = make(map[string]map[string]bool, len(tables))
+		for table, names := range tables {
+			c.counters[table] = make(map[string]bool, len(names))
+			for _, name := range names {
+				c.counters[table][name] = true
+			}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// pathItem returns the swagger path matching query. Swagger path parameters, e.g. {uuid}, match any segment.
+func (c *catalog) pathItem(query string) (map[string]any, bool) {
+	if c.paths == nil {
+		return nil, false
+	}
+	query = strings.Trim(strings.TrimPrefix(query, "api/"), "/")
+	if item, ok := c.paths[query]; ok {
+		return item, true
+	}
+	want := strings.Split(query, "/")
+	for path, item := range c.paths {
+		got := strings.Split(path, "/")
+		if len(got) != len(want) {
+			continue
+		}
+		matched := true
+		for i, segment := range got {
+			if strings.HasPrefix(segment, "{") || strings.HasPrefix(want[i], "{") {
+				continue
+			}
+			if segment != want[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return item, true
+		}
+	}
+	return nil, false
+}
+
+// hasPath returns true when the catalog has no swagger, since nothing can be checked, or when query exists
+func (c *catalog) hasPath(query string) bool {
+	if c.paths == nil {
+		return true
+	}
+	_, ok := c.pathItem(query)
+	return ok
+}
+
+// hasField returns true if the dotted field is a property of the records returned by GET query.
+// Fields of free-form objects, or of queries whose schema can not be resolved, are assumed to exist.
+func (c *catalog) hasField(query string, field string) bool {
+	item, ok := c.pathItem(query)
+	if !ok {
+		return true
+	}
+	schema := c.responseSchema(item)
+	if schema == nil {
+		return true
+	}
+	if records := c.property(schema, "records"); records != nil {
+		schema = records
+	}
+	for _, segment := range strings.Split(field, ".") {
+		// array indexes, e.g. ha.partners.0.name, since resolve already followed the array's items
+		if _, err := strconv.Atoi(segment); err == nil {
+			continue
+		}
+		if !c.hasProperties(schema) {
+			return true
+		}
+		schema = c.property(schema, segment)
+		if schema == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *catalog) responseSchema(item map[string]any) map[string]any {
+	get, _ := item["get"].(map[string]any)
+	responses, _ := get["responses"].(map[string]any)
+	ok, _ := responses["200"].(map[string]any)
+	if ok == nil {
+		return nil
+	}
+	// Swagger 2.0
+	if schema, isMap := ok["schema"].(map[string]any); isMap {
+		return c.resolve(schema)
+	}
+	// OpenAPI 3
+	content, _ := ok["content"].(map[string]any)
+	for _, media := range content {
+		if m, isMap := media.(map[string]any); isMap {
+			if schema, isMap := m["schema"].(map[string]any); isMap {
+				return c.resolve(schema)
+			}
+		}
+	}
+	return nil
+}
+
+// resolve follows $ref and array items until it reaches an object schema
+func (c *catalog) resolve(schema map[string]any) map[string]any {
+	for range 32 {
+		if schema == nil {
+			return nil
+		}
+		if ref, ok := schema["$ref"].(string); ok {
+			name := ref[strings.LastIndex(ref, "/")+1:]
+			schema, _ = c.defs[name].(map[string]any)
+			continue
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			schema = items
+			continue
+		}
+		return schema
+	}
+	return nil
+}
+
+func (c *catalog) hasProperties(schema map[string]any) bool {
+	if _, ok := schema["properties"].(map[string]any); ok {
+		return true
+	}
+	allOf, _ := schema["allOf"].([]any)
+	for _, part := range allOf {
+		if m, ok := part.(map[string]any); ok && c.hasProperties(c.resolve(m)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *catalog) property(schema map[string]any, name string) map[string]any {
+	if properties, ok := schema["properties"].(map[string]any); ok {
+		if p, ok := properties[name].(map[string]any); ok {
+			return c.resolve(p)
+		}
+	}
+	allOf, _ := schema["allOf"].([]any)
+	for _, part := range allOf {
+		if m, ok := part.(map[string]any); ok {
+			if p := c.property(c.resolve(m), name); p != nil {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+// downloadCatalog saves the swagger and counter tables of the poller's cluster in <dir>/<cluster version>
+func downloadCatalog(pollerName string, dir string) (string, error) {
+	poller, _, err := rest.GetPollerAndAddr(pollerName)
+	if err != nil {
+		return "", err
+	}
+
+	timeout, _ := time.ParseDuration(rest.DefaultTimeout)
+	client, err := rest.New(poller, timeout, auth.NewCredentials(poller, slog.Default()))
+	if err != nil {
+		return "", err
+	}
+	if err := client.Init(2, conf.Remote{}); err != nil {
+		return "", err
+	}
+
+	v := client.Remote().Version
+	versionDir := filepath.Join(dir, v)
+	if err := os.MkdirAll(versionDir, 0750); err != nil {
+		return "", err
+	}
+
+	swagger, err := client.GetPlainRest("docs/api/swagger.yaml", false, map[string]string{"Accept": "*/*"})
+	if err != nil {
+		return "", fmt.Errorf("failed to download swagger err=%w", err)
+	}
+	if err := os.WriteFile(filepath.Join(versionDir, swaggerFile), swagger, 0600); err != nil {
+		return "", err
+	}
+
+	href := rest.NewHrefBuilder().
+		APIPath("api/cluster/counter/tables").
+		Fields([]string{"name", "counter_schemas"}).
+		Build()
+	records, err := rest.FetchAll(client, href)
+	if err != nil {
+		return "", fmt.Errorf("failed to download counter tables err=%w", err)
+	}
+
+	tables := make(map[string][]string, len(records))
+	for _, record := range records {
+		var names []string
+		for _, schema := range record.Get("counter_schemas").Array() {
+			names = append(names, schema.Get("name").String())
+		}
+		slices.Sort(names)
+		tables[record.Get("name").String()] = names
+	}
+	data, err := yaml.Marshal(tables)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(versionDir, countersFile), data, 0600); err != nil {
+		return "", err
+	}
+
+	return v, nil
+}
@@ -27,6 +27,13 @@ type options struct {
 	restDataCenterName string
 	prometheusURL      string
 	expandVar          bool
+	catalogDir         string
+	catalogPoller      string
+	cardinalityPollers []string
+	cardinalityURLs    []string
+	top                int
+	role               string
+	restRole           string
 }
 
 var opts = &options{
@@ -108,24 +115,32 @@ func doDoctorCmd(cmd *cobra.Command, _ []string) {
 }
 
 func doDoctor(aPath string) string {
-	contents, err := os.ReadFile(aPath)
+	out, err := RedactedConfig(aPath, opts.expandVar)
 	if err != nil {
-		fmt.Printf("error reading config file. err=%+v\n", err)
+		fmt.Println(err)
 		return ""
 	}
+	return out
+}
+
+// RedactedConfig returns the Harvest config at aPath, merged with its Poller_files, with credentials, hosts, and
+// comments removed so the config can be shared
+func RedactedConfig(aPath string, expandVars bool) (string, error) {
+	contents, err := os.ReadFile(aPath)
+	if err != nil {
+		return "", fmt.Errorf("error reading config file: %w", err)
+	}
 
-	if opts.expandVar {
+	if expandVars {
 		contents, err = conf.ExpandVars(contents)
 		if err != nil {
-			fmt.Printf("error reading config file. err=%+v\n", err)
-			return ""
+			return "", fmt.Errorf("error reading config file: %w", err)
 		}
 	}
 
 	parentRoot, err := printRedactedConfig(aPath, contents)
 	if err != nil {
-		fmt.Printf("error processing parent config file=[%s] %+v\n", aPath, err)
-		return ""
+		return "", fmt.Errorf("error processing parent config file=[%s]: %w", aPath, err)
 	}
 
 	// Extract Poller_files field from parentRoot
@@ -164,11 +179,9 @@ func doDoctor(aPath string) string {
 
 	marshaled, err := yaml.Marshal(parentRoot)
 	if err != nil {
-		fmt.Printf("error marshalling yaml sanitized from config file=[%s] %+v\n", aPath, err)
-		return ""
+		return "", fmt.Errorf("error marshalling yaml sanitized from config file=[%s]: %w", aPath, err)
 	}
-	out := string(marshaled)
-	return out
+	return string(marshaled), nil
 }
 
 func mergeYamlNodes(parent, child *yaml.Node) {
@@ -670,7 +683,7 @@ func checkPollerPromPorts(config conf.HarvestConfig) validation {
 func sanitize(nodes []*yaml.Node) {
 	// Update this list when there are additional tokens to sanitize
 	sanitizeWords := []string{"username", "password", "grafana_api_token", "token",
-		"host", "addr"}
+		"host", "addr", "role_id", "secret_id"}
 	for i, node := range nodes {
 		if node == nil {
 			continue
@@ -707,6 +720,9 @@ func collectNodes(root *yaml.Node, nodes *[]*yaml.Node) {
 func init() {
 	Cmd.AddCommand(mergeCmd)
 	Cmd.AddCommand(compareZapiRestMetricsCmd)
+	Cmd.AddCommand(templatesCmd)
+	Cmd.AddCommand(cardinalityCmd)
+	Cmd.AddCommand(permissionsCmd)
 	dFlags := compareZapiRestMetricsCmd.PersistentFlags()
 	mFlags := mergeCmd.PersistentFlags()
 
@@ -721,6 +737,21 @@ func init() {
 
 	_ = mergeCmd.MarkPersistentFlagRequired("template")
 	_ = mergeCmd.MarkPersistentFlagRequired("with")
+
+	tFlags := templatesCmd.Flags()
+	tFlags.StringVar(&opts.catalogDir, "catalog", defaultCatalogDir(), "Directory of ONTAP swagger and counter catalogs, one sub-directory per ONTAP version")
+	tFlags.StringVar(&opts.catalogPoller, "poller", "", "Download the swagger and counter catalog from this poller's cluster before validating")
+	tFlags.StringVar(&opts.Color, "color", "auto", "When to use colors. One of: auto | always | never. Auto will guess based on tty.")
+
+	cFlags := cardinalityCmd.Flags()
+	cFlags.StringSliceVar(&opts.cardinalityPollers, "poller", nil, "Only report these pollers (default all running pollers)")
+	cFlags.StringSliceVar(&opts.cardinalityURLs, "url", nil, "Scrape these Prometheus endpoints instead of the running pollers, e.g., http://host:12990/metrics")
+	cFlags.IntVar(&opts.top, "top", 10, "Number of metrics and objects to report per poller")
+
+	pFlags := permissionsCmd.Flags()
+	pFlags.StringVar(&opts.role, "role", "harvest2-role", "Name of the ZAPI role used in the suggested commands")
+	pFlags.StringVar(&opts.restRole, "rest-role", "harvest2-rest-role", "Name of the REST role used in the suggested commands")
+
 	Cmd.Flags().BoolVarP(
 		&opts.ShouldPrintConfig,
 		"print",
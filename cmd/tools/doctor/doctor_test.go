@@ -1,9 +1,12 @@
 package doctor
 
 import (
+	"errors"
 	"github.com/netapp/harvest/v2/pkg/conf"
 	"gopkg.in/yaml.v3"
+	"io/fs"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -14,12 +17,20 @@ func TestRedaction(t *testing.T) {
 	assertRedacted(t, `password: f`, `password: -REDACTED-`)
 	assertRedacted(t, `grafana_api_token: secret`, `grafana_api_token: -REDACTED-`)
 	assertRedacted(t, `token: secret`, `token: -REDACTED-`)
+	assertRedacted(t, `secret_id: secret`, `secret_id: -REDACTED-`)
 	assertRedacted(t, "# foo\nusername: pass\n#foot", `username: -REDACTED-`)
 	assertRedacted(t, `host: 1.2.3.4`, `host: -REDACTED-`)
 	assertRedacted(t, `addr: 1.2.3.4`, `addr: -REDACTED-`)
 	assertRedacted(t, "auth_style: password\nusername: cat", "auth_style: password\nusername: -REDACTED-")
 }
 
+func TestRedactedConfigMissingFile(t *testing.T) {
+	_, err := RedactedConfig(filepath.Join(t.TempDir(), "harvest.yml"), false)
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("RedactedConfig() got err=%v, want fs.ErrNotExist", err)
+	}
+}
+
 func assertRedacted(t *testing.T, input, redacted string) {
 	t.Helper()
 	redacted = strings.TrimSpace(redacted)
@@ -0,0 +1,312 @@
+package doctor
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"github.com/netapp/harvest/v2/cmd/collectors"
+	"github.com/netapp/harvest/v2/cmd/poller/collector"
+	"github.com/netapp/harvest/v2/cmd/tools/rest"
+	"github.com/netapp/harvest/v2/pkg/api/ontapi/zapi"
+	"github.com/netapp/harvest/v2/pkg/auth"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"github.com/netapp/harvest/v2/third_party/go-version"
+	tw "github.com/netapp/harvest/v2/third_party/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	permissionAllowed = "allowed"
+	permissionDenied  = "denied"
+	permissionMissing = "missing API"
+	permissionError   = "error"
+	// zapiNotFound is the errno of a ZAPI the cluster does not implement
+	zapiNotFound = "13005"
+	// usedByLimit is the number of objects and plugins listed per endpoint
+	usedByLimit = 3
+)
+
+var permissionsCmd = &cobra.Command{
+	Use:   "permissions POLLER",
+	Short: "Check that the poller's user can read every endpoint its collectors and plugins call",
+	Long: `Enumerate the REST endpoints and ZAPIs of the poller's templates and plugins, probe each one
+with a minimal request, and report which are allowed, denied, or missing on the cluster.
+The ONTAP commands that grant the denied endpoints to the poller's role are printed after the table.`,
+	Args: cobra.ExactArgs(1),
+	Run:  doPermissionsCmd,
+}
+
+// permission is the result of probing one endpoint
+type permission struct {
+	endpoint collectors.Endpoint
+	result   string
+	err      error
+}
+
+// permissionProber probes endpoints with the poller's credentials. A nil client skips its protocol
+type permissionProber struct {
+	rest    *rest.Client
+	zapi    *zapi.Client
+	remote  conf.Remote
+	version *version.Version
+}
+
+func doPermissionsCmd(cmd *cobra.Command, args []string) {
+	var config = cmd.Root().PersistentFlags().Lookup("config")
+	var confPath = cmd.Root().PersistentFlags().Lookup("confpath")
+
+	if _, err := conf.LoadHarvestConfig(conf.ConfigPath(config.Value.String())); err != nil {
+		fmt.Printf("failed to load config err=%v\n", err)
+		os.Exit(1)
+	}
+	poller, _, err := rest.GetPollerAndAddr(args[0])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	p, err := newPermissionProber(poller)
+	if err != nil {
+		fmt.Printf("poller=%s failed to connect err=%v\n", poller.Name, err)
+		if errors.Is(err, errs.ErrPermissionDenied) {
+			fmt.Println("The user can not read the cluster's version. Grant the role access to /api/cluster or system-get-version.")
+		}
+		os.Exit(1)
+	}
+
+	endpoints, err := pollerEndpoints(poller, confPath.Value.String(), p.remote.Version)
+	if err != nil {
+		fmt.Printf("poller=%s err=%v\n", poller.Name, err)
+		os.Exit(1)
+	}
+
+	permissions, err := p.probe(endpoints)
+	if err != nil {
+		fmt.Printf("poller=%s err=%v\n", poller.Name, err)
+		os.Exit(1)
+	}
+	if printPermissions(os.Stdout, permissions, p.fixCommands(permissions)) {
+		os.Exit(1)
+	}
+}
+
+func newPermissionProber(poller *conf.Poller) (*permissionProber, error) {
+	var hasRest, hasZapi bool
+	for _, c := range poller.Collectors {
+		switch {
+		case collectors.IsRestCollector(c.Name):
+			hasRest = true
+		case c.Name == "Zapi" || c.Name == "ZapiPerf":
+			hasZapi = true
+		}
+	}
+
+	p := &permissionProber{}
+	credentials := auth.NewCredentials(poller, slog.Default())
+	if hasRest {
+		timeout, _ := time.ParseDuration(rest.DefaultTimeout)
+		client, err := rest.New(poller, timeout, credentials)
+		if err != nil {
+			return nil, err
+		}
+		if err := client.Init(2, conf.Remote{}); err != nil {
+			return nil, err
+		}
+		p.rest = client
+		p.remote = client.Remote()
+	}
+	if hasZapi {
+		client, err := zapi.New(poller, credentials)
+		if err != nil {
+			return nil, err
+		}
+		if err := client.Init(2, p.remote); err != nil {
+			return nil, err
+		}
+		p.zapi = client
+		p.remote = client.Remote()
+	}
+	if p.rest == nil && p.zapi == nil {
+		return nil, fmt.Errorf("poller=%s has no ONTAP collectors", poller.Name)
+	}
+	p.version, _ = version.NewVersion(p.remote.Version)
+	return p, nil
+}
+
+// pollerEndpoints returns the endpoints of the poller's ONTAP collectors, as the poller would load them
+func pollerEndpoints(poller *conf.Poller, confPath string, ontapVersion string) ([]collectors.Endpoint, error) {
+	var objects []collector.ObjectTemplate
+	confPaths := filepath.SplitList(cmp.Or(poller.ConfPath, confPath))
+	for _, c := range poller.Collectors {
+		if !collectors.IsRestCollector(c.Name) && c.Name != "Zapi" && c.Name != "ZapiPerf" {
+			continue
+		}
+		all, err := collector.ObjectTemplates(c, confPaths, confPath, ontapVersion)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %s templates err=%w", c.Name, err)
+		}
+		objects = append(objects, all...)
+	}
+	return collectors.Endpoints(objects), nil
+}
+
+// probe sends a minimal request to each endpoint. Probing stops when the credentials are rejected
+func (p *permissionProber) probe(endpoints []collectors.Endpoint) ([]permission, error) {
+	permissions := make([]permission, 0, len(endpoints))
+	for _, e := range endpoints {
+		var err error
+		if collectors.IsRestCollector(e.Collector) {
+			if p.rest == nil {
+				continue
+			}
+			_, err = p.rest.GetRest(restProbe(e.Query))
+		} else {
+			if p.zapi == nil {
+				continue
+			}
+			_, err = p.zapi.InvokeRequest(zapiProbe(e))
+		}
+		if errors.Is(err, errs.ErrAuthFailed) {
+			return nil, err
+		}
+		permissions = append(permissions, permission{endpoint: e, result: classifyPermission(err), err: err})
+	}
+	return permissions, nil
+}
+
+// restProbe returns a request for a single record of query. Path parameters are dropped since a role grants a path
+// and everything below it. Counter tables are requested by name, so tables the cluster does not have are reported
+func restProbe(query string) string {
+	apiPath := strings.TrimPrefix(collectors.RolePath(query), "/")
+	if strings.HasPrefix(query, counterTables) {
+		apiPath = query
+	}
+	return rest.NewHrefBuilder().
+		APIPath(apiPath).
+		MaxRecords("1").
+		Build()
+}
+
+// zapiProbe returns a request for a single record of the endpoint. ZapiPerf queries are perf objects
+func zapiProbe(e collectors.Endpoint) *node.Node {
+	if e.IsPerfObject() {
+		request := node.NewXMLS("perf-object-instance-list-info-iter")
+		request.NewChildS("objectname", e.Query)
+		request.NewChildS("max-records", "1")
+		return request
+	}
+	request := node.NewXMLS(e.Query)
+	if strings.HasSuffix(e.Query, "-iter") {
+		request.NewChildS("max-records", "1")
+	}
+	return request
+}
+
+func classifyPermission(err error) string {
+	var restErr *errs.RestError
+	var harvestErr errs.HarvestError
+	switch {
+	case err == nil:
+		return permissionAllowed
+	case errors.Is(err, errs.ErrPermissionDenied):
+		return permissionDenied
+	case errs.IsRestErr(err, errs.APINotFound), errs.IsRestErr(err, errs.TableNotFound):
+		return permissionMissing
+	case errors.As(err, &restErr) && restErr.StatusCode == http.StatusNotFound:
+		return permissionMissing
+	case errors.As(err, &harvestErr) && harvestErr.ErrNum == zapiNotFound:
+		return permissionMissing
+	}
+	return permissionError
+}
+
+// fixCommands returns the ONTAP commands that grant the denied endpoints to the roles of docs/prepare-cdot-clusters.md
+func (p *permissionProber) fixCommands(permissions []permission) []string {
+	var commands []string
+	add := func(command string) {
+		if !slices.Contains(commands, command) {
+			commands = append(commands, command)
+		}
+	}
+	vserver := cmp.Or(p.remote.Name, "$ADMIN_VSERVER")
+	// least-privilege REST roles need ONTAP 9.14 or later
+	leastPrivilege := p.version == nil || !p.version.LessThan(version.Must(version.NewVersion("9.14.0")))
+
+	for _, perm := range permissions {
+		if perm.result != permissionDenied {
+			continue
+		}
+		e := perm.endpoint
+		if collectors.IsRestCollector(e.Collector) {
+			api := "/api"
+			if leastPrivilege {
+				api = collectors.RolePath(e.Query)
+			}
+			add(fmt.Sprintf("security login rest-role create -role %s -access readonly -api %s -vserver %s",
+				opts.restRole, api, vserver))
+			continue
+		}
+		dir := collectors.ZapiDirectory(e)
+		if dir == "" {
+			add(fmt.Sprintf("# no command directory is known for %s, see docs/prepare-cdot-clusters.md", e.Query))
+			continue
+		}
+		add(fmt.Sprintf(`security login role create -role %s -access readonly -cmddirname "%s" -vserver %s`,
+			opts.role, dir, vserver))
+	}
+	return commands
+}
+
+// printPermissions prints a table of the probed endpoints and the commands that fix the denied ones.
+// Returns true when an endpoint was denied
+func printPermissions(w io.Writer, permissions []permission, commands []string) bool {
+	counts := make(map[string]int)
+	table := tw.NewWriter(w)
+	table.SetBorder(false)
+	table.SetAutoFormatHeaders(false)
+	table.SetHeader([]string{"Collector", "Endpoint", "Result", "Used By"})
+	table.SetColumnAlignment([]int{tw.ALIGN_LEFT, tw.ALIGN_LEFT, tw.ALIGN_LEFT, tw.ALIGN_LEFT})
+	for _, perm := range permissions {
+		counts[perm.result]++
+		result := perm.result
+		if perm.result == permissionError {
+			result += ": " + perm.err.Error()
+		}
+		table.Append([]string{perm.endpoint.Collector, perm.endpoint.Query, result, usedBy(perm.endpoint.UsedBy)})
+	}
+	table.Render()
+
+	_, _ = fmt.Fprintf(w, "\n%d endpoints: %d allowed, %d denied, %d missing, %d errors\n", len(permissions),
+		counts[permissionAllowed], counts[permissionDenied], counts[permissionMissing], counts[permissionError])
+	if counts[permissionMissing] > 0 {
+		_, _ = fmt.Fprintln(w, "Missing endpoints are not implemented by this ONTAP version and are skipped by the poller.")
+	}
+	if len(commands) > 0 {
+		_, _ = fmt.Fprintln(w, "\nRun these commands on the cluster to grant the denied endpoints:")
+		for _, c := range commands {
+			_, _ = fmt.Fprintln(w, "  "+c)
+		}
+	}
+	return counts[permissionDenied] > 0
+}
+
+func usedBy(names []string) string {
+	if len(names) == 0 {
+		return "collector"
+	}
+	if len(names) <= usedByLimit {
+		return strings.Join(names, ", ")
+	}
+	return strings.Join(names[:usedByLimit], ", ") + " +" + strconv.Itoa(len(names)-usedByLimit) + " more"
+}
@@ -0,0 +1,92 @@
+package doctor
+
+import (
+	"bytes"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakePermissions is an ONTAP REST API that denies api/storage/disks and does not implement api/storage/shelves
+// or the volume:node counter table
+func fakePermissions(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/api/cluster":
+		_, _ = w.Write([]byte(`{"name": "cluster1", "version": {"generation": 9, "major": 15, "minor": 1}}`))
+	case "/api/storage/disks":
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"error": {"message": "not authorized for that command", "code": "6"}}`))
+	case "/api/storage/shelves":
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error": {"message": "API not found", "code": "3"}}`))
+	case "/api/cluster/counter/tables/volume:node":
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error": {"message": "Table is not found", "code": "8585320"}}`))
+	default:
+		_, _ = w.Write([]byte(`{"records": [], "num_records": 0}`))
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(fakePermissions))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	insecure := true
+	poller := &conf.Poller{
+		Name:           "sar",
+		Addr:           u.Host,
+		Username:       "harvest",
+		Password:       "password",
+		UseInsecureTLS: &insecure,
+		Collectors:     []conf.Collector{conf.NewCollector("Rest"), conf.NewCollector("RestPerf")},
+	}
+	p, err := newPermissionProber(poller)
+	if err != nil {
+		t.Fatalf("failed to connect err=%v", err)
+	}
+	endpoints, err := pollerEndpoints(poller, "../../../conf", p.remote.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	permissions, err := p.probe(endpoints)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]string)
+	for _, perm := range permissions {
+		got[perm.endpoint.Collector+" "+perm.endpoint.Query] = perm.result
+	}
+	want := map[string]string{
+		"Rest api/storage/volumes":                        permissionAllowed,
+		"Rest api/storage/disks":                          permissionDenied,
+		"RestPerf api/storage/shelves":                    permissionMissing,
+		"RestPerf api/cluster/counter/tables/volume:node": permissionMissing,
+		"RestPerf api/cluster/counter/tables/lif":         permissionAllowed,
+	}
+	for endpoint, result := range want {
+		if got[endpoint] != result {
+			t.Errorf("%s got=%q want=%q", endpoint, got[endpoint], result)
+		}
+	}
+
+	opts.role = "harvest2-role"
+	opts.restRole = "harvest2-rest-role"
+	commands := p.fixCommands(permissions)
+	wantCommands := []string{"security login rest-role create -role harvest2-rest-role -access readonly -api /api/storage/disks -vserver cluster1"}
+	if !slices.Equal(commands, wantCommands) {
+		t.Errorf("commands got=%v want=%v", commands, wantCommands)
+	}
+
+	var out bytes.Buffer
+	if !printPermissions(&out, permissions, commands) {
+		t.Errorf("printPermissions should report the denied endpoint")
+	}
+	if !strings.Contains(out.String(), "2 denied") {
+		t.Errorf("missing summary\n%s", out.String())
+	}
+}
@@ -0,0 +1,596 @@
+package doctor
+
+import (
+	"cmp"
+	"fmt"
+	"github.com/netapp/harvest/v2/cmd/poller/collector"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin/aggregator"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin/inventory"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin/join"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin/labelagent"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin/metricagent"
+	"github.com/netapp/harvest/v2/pkg/color"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/tree"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"github.com/netapp/harvest/v2/pkg/util"
+	"github.com/netapp/harvest/v2/third_party/go-version"
+	"github.com/spf13/cobra"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+	privateCLI      = "api/private/cli"
+	counterTables   = "api/cluster/counter/tables/"
+)
+
+var templatesCmd = &cobra.Command{
+	Use:   "templates",
+	Short: "Validate templates offline against the ONTAP REST schema",
+	Long: `Validate every template in the conf path offline.
+Templates are checked for bad key markers, missing instance keys, duplicate display names,
+and export options or plugin rules that reference labels the template does not define.
+When a catalog of ONTAP swagger and counter schemas is available, queries and counters are also checked against it.`,
+	Run: doTemplatesCmd,
+}
+
+// lintedCollectors are the collectors whose templates share the counters/endpoints/export_options shape
+var lintedCollectors = map[string]bool{
+	"rest":     true,
+	"restperf": true,
+	"keyperf":  true,
+	"zapi":     true,
+	"zapiperf": true,
+}
+
+var versionDirRe = regexp.MustCompile(`^\d+\.\d+\.\d+$`)
+
+type templateIssue struct {
+	path     string
+	severity string
+	message  string
+}
+
+type templateFile struct {
+	path      string
+	collector string
+	family    string // path between the collector and version directory, e.g., cdot for Zapi
+	version   string
+	name      string // path relative to the version directory
+}
+
+type counterDef struct {
+	raw      string
+	name     string
+	display  string
+	kind     string
+	endpoint string // empty for the template's top-level counters
+}
+
+type templateLinter struct {
+	issues []templateIssue
+}
+
+func doTemplatesCmd(cmd *cobra.Command, _ []string) {
+	color.DetectConsole(opts.Color)
+	var config = cmd.Root().PersistentFlags().Lookup("config")
+	var confPaths = cmd.Root().PersistentFlags().Lookup("confpath")
+
+	dirs := templateDirs(conf.ConfigPath(config.Value.String()), confPaths.Value.String())
+
+	if opts.catalogPoller != "" {
+		v, err := downloadCatalog(opts.catalogPoller, opts.catalogDir)
+		if err != nil {
+			fmt.Printf("%s: failed to download catalog from poller=%s err=%v\n",
+				color.Colorize("Error", color.Red), opts.catalogPoller, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Cached ONTAP %s catalog in %s\n", v, filepath.Join(opts.catalogDir, v))
+	}
+
+	catalogs, err := loadCatalogs(opts.catalogDir)
+	if err != nil {
+		fmt.Printf("%s: failed to load catalog from %s err=%v\n", color.Colorize("Error", color.Red), opts.catalogDir, err)
+		os.Exit(1)
+	}
+
+	issues := lintTemplates(dirs, catalogs)
+	if printTemplateIssues(issues, len(catalogs), opts.catalogDir) {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// templateDirs returns the conf path from the command line followed by every poller's conf_path
+func templateDirs(configPath string, confPath string) []string {
+	dirs := filepath.SplitList(confPath)
+	if _, err := conf.LoadHarvestConfig(configPath); err == nil {
+		for _, name := range conf.Config.PollersOrdered {
+			poller := conf.Config.Pollers[name]
+			if poller == nil || poller.ConfPath == "" {
+				continue
+			}
+			dirs = append(dirs, filepath.SplitList(poller.ConfPath)...)
+		}
+	}
+	slices.Sort(dirs)
+	return slices.Compact(dirs)
+}
+
+func lintTemplates(dirs []string, catalogs []*catalog) []templateIssue {
+	l := &templateLinter{}
+	files := findTemplates(dirs)
+
+	for _, f := range files {
+		l.lintTemplate(f, nil)
+	}
+
+	for _, c := range catalogs {
+		for _, f := range bestFitTemplates(files, c.version) {
+			l.lintTemplate(f, c)
+		}
+	}
+
+	return l.issues
+}
+
+func (l *templateLinter) add(path string, severity string, format string, a ...any) {
+	issue := templateIssue{path: path, severity: severity, message: fmt.Sprintf(format, a...)}
+	if slices.Contains(l.issues, issue) {
+		return
+	}
+	l.issues = append(l.issues, issue)
+}
+
+// findTemplates walks each conf directory and returns the versioned templates of the linted collectors
+func findTemplates(dirs []string) []templateFile {
+	var files []templateFile
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() || !lintedCollectors[entry.Name()] {
+				continue
+			}
+			collectorDir := filepath.Join(dir, entry.Name())
+			_ = filepath.WalkDir(collectorDir, func(path string, d fs.DirEntry, err error) error {
+				if err != nil || d.IsDir() || filepath.Ext(path) != ".yaml" {
+					return nil //nolint:nilerr
+				}
+				rel, _ := filepath.Rel(collectorDir, path)
+				parts := strings.Split(filepath.ToSlash(rel), "/")
+				for i, part := range parts[:len(parts)-1] {
+					if versionDirRe.MatchString(part) {
+						files = append(files, templateFile{
+							path:      path,
+							collector: entry.Name(),
+							family:    strings.Join(parts[:i], "/"),
+							version:   part,
+							name:      strings.Join(parts[i+1:], "/"),
+						})
+						break
+					}
+				}
+				return nil
+			})
+		}
+	}
+	return files
+}
+
+// bestFitTemplates returns the templates a poller would select for the given ONTAP version
+func bestFitTemplates(files []templateFile, ontapVersion *version.Version) []templateFile {
+	type group struct {
+		versions []*version.Version
+		files    map[string]templateFile
+	}
+	groups := make(map[string]*group)
+	for _, f := range files {
+		key := f.path[:len(f.path)-len(filepath.Join(f.family, f.version, f.name))] + f.collector + "/" + f.family + "/" + f.name
+		v, err := version.NewVersion(f.version)
+		if err != nil {
+			continue
+		}
+		g, ok := groups[key]
+		if !ok {
+			g = &group{files: make(map[string]templateFile)}
+			groups[key] = g
+		}
+		g.versions = append(g.versions, v)
+		g.files[v.String()] = f
+	}
+
+	var selected []templateFile
+	for _, g := range groups {
+		sort.Sort(version.Collection(g.versions))
+		idx := collector.GetClosestIndex(g.versions, ontapVersion)
+		if idx < 0 || g.versions[idx].GreaterThan(ontapVersion) {
+			continue
+		}
+		selected = append(selected, g.files[g.versions[idx].String()])
+	}
+	sort.Slice(selected, func(i, j int) bool { return selected[i].path < selected[j].path })
+	return selected
+}
+
+func (l *templateLinter) lintTemplate(f templateFile, c *catalog) {
+	template, err := tree.ImportYaml(f.path)
+	if err != nil || template == nil {
+		if c == nil {
+			l.add(f.path, severityError, "template is empty or invalid err=%v", err)
+		}
+		return
+	}
+
+	// The default.yaml and custom.yaml of a collector are not object templates
+	if template.GetChildS("objects") != nil {
+		return
+	}
+
+	query := template.GetChildContentS("query")
+	counters := collectCounters(template)
+
+	if c != nil {
+		l.lintCatalog(f, c, query, template, counters)
+		return
+	}
+
+	if template.GetChildContentS("name") == "" {
+		l.add(f.path, severityError, "template has no name")
+	}
+	if query == "" {
+		l.add(f.path, severityError, "template has no query")
+	}
+	if template.GetChildContentS("object") == "" {
+		l.add(f.path, severityError, "template has no object")
+	}
+	if len(counters) == 0 {
+		l.add(f.path, severityError, "template has no counters")
+		return
+	}
+
+	l.lintMarkers(f, counters)
+	l.lintInstanceKeys(f, template, counters)
+	l.lintDisplayNames(f, counters)
+	l.lintLabels(f, template, counters)
+}
+
+// collectCounters flattens the template's counters and the counters of each of its endpoints
+func collectCounters(template *node.Node) []counterDef {
+	var counters []counterDef
+	if n := template.GetChildS("counters"); n != nil {
+		flattenTemplateCounters(n, "", &counters)
+	}
+	if endpoints := template.GetChildS("endpoints"); endpoints != nil {
+		for _, endpoint := range endpoints.GetChildren() {
+			query := endpoint.GetChildContentS("query")
+			if n := endpoint.GetChildS("counters"); n != nil {
+				flattenTemplateCounters(n, query, &counters)
+			}
+		}
+	}
+	return counters
+}
+
+func flattenTemplateCounters(n *node.Node, endpoint string, counters *[]counterDef) {
+	for _, child := range n.GetChildren() {
+		name := child.GetNameS()
+		if name == "filter" || name == "hidden_fields" || name == "refine" {
+			continue
+		}
+		if len(child.GetChildren()) > 0 {
+			flattenTemplateCounters(child, endpoint, counters)
+			continue
+		}
+		raw := strings.Join(strings.Fields(child.GetContentS()), " ")
+		if raw == "" {
+			continue
+		}
+		counterName, display, kind, _ := util.ParseMetric(raw)
+		*counters = append(*counters, counterDef{
+			raw:      raw,
+			name:     counterName,
+			display:  display,
+			kind:     kind,
+			endpoint: endpoint,
+		})
+	}
+}
+
+func (l *templateLinter) lintMarkers(f templateFile, counters []counterDef) {
+	for _, c := range counters {
+		left, right, hasDisplay := strings.Cut(c.raw, "=>")
+		left = strings.TrimSpace(left)
+		if strings.HasPrefix(left, "^^^") {
+			l.add(f.path, severityError, "counter %q has an invalid key marker, use ^^ for keys and ^ for labels", c.raw)
+			continue
+		}
+		if strings.Contains(strings.TrimLeft(left, "^"), "^") {
+			l.add(f.path, severityError, "counter %q has a misplaced ^ marker, markers must prefix the counter name", c.raw)
+			continue
+		}
+		if hasDisplay && strings.Contains(right, "^") {
+			l.add(f.path, severityError, "counter %q has a ^ marker in its display name", c.raw)
+			continue
+		}
+		if hasDisplay && strings.TrimSpace(right) == "" {
+			l.add(f.path, severityError, "counter %q has an empty display name", c.raw)
+		}
+	}
+}
+
+func (l *templateLinter) lintInstanceKeys(f templateFile, template *node.Node, counters []counterDef) {
+	// ZapiPerf and templates that name an instance_key select their keys at runtime
+	if f.collector == "zapiperf" || template.GetChildContentS("instance_key") != "" {
+		return
+	}
+
+	keys := make(map[string][]string)
+	for _, c := range counters {
+		if _, ok := keys[c.endpoint]; !ok {
+			keys[c.endpoint] = nil
+		}
+		if c.kind == "key" {
+			keys[c.endpoint] = append(keys[c.endpoint], c.display)
+		}
+	}
+
+	if len(keys[""]) == 0 {
+		l.add(f.path, severityWarning, "template has no instance keys (^^), every record will update the same instance")
+		return
+	}
+
+	want := slices.Sorted(slices.Values(keys[""]))
+	for endpoint, endpointKeys := range keys {
+		if endpoint == "" {
+			continue
+		}
+		got := slices.Sorted(slices.Values(endpointKeys))
+		if !slices.Equal(got, want) {
+			l.add(f.path, severityError, "endpoint %s has instance keys [%s] that do not match the template's instance keys [%s]",
+				endpoint, strings.Join(got, ", "), strings.Join(want, ", "))
+		}
+	}
+}
+
+func (l *templateLinter) lintDisplayNames(f templateFile, counters []counterDef) {
+	displays := make(map[string]string)
+	seen := make(map[string]bool)
+	for _, c := range counters {
+		// endpoints repeat the template's keys to join their records with the template's instances
+		if c.endpoint != "" && c.kind == "key" {
+			continue
+		}
+		id := c.endpoint + " " + c.raw
+		if seen[id] {
+			l.add(f.path, severityWarning, "counter %q is listed more than once", c.raw)
+			continue
+		}
+		seen[id] = true
+		if other, ok := displays[c.display]; ok && other != c.name {
+			l.add(f.path, severityError, "display name %q is used by counters %q and %q", c.display, other, c.name)
+			continue
+		}
+		displays[c.display] = c.name
+	}
+}
+
+var builtInPlugins = map[string]bool{
+	"Aggregator":  true,
+	"Anomaly":     true,
+	"ChangeLog":   true,
+	"Forecast":    true,
+	"Inventory":   true,
+	"Join":        true,
+	"LabelAgent":  true,
+	"Max":         true,
+	"MetricAgent": true,
+}
+
+func (l *templateLinter) lintLabels(f templateFile, template *node.Node, counters []counterDef) {
+	labels := make(map[string]bool)
+	for _, c := range counters {
+		if c.kind == "key" || c.kind == "label" {
+			labels[c.display] = true
+		}
+	}
+
+	var customPlugins []string
+	type reference struct {
+		source string
+		label  string
+	}
+	var references []reference
+
+	if plugins := template.GetChildS("plugins"); plugins != nil {
+		template.PreprocessTemplate()
+		for _, p := range plugins.GetChildren() {
+			name := cmp.Or(p.GetNameS(), p.GetContentS())
+			switch name {
+			case "LabelAgent":
+				la := labelagent.New(&plugin.AbstractPlugin{Params: p})
+				if err := la.Init(conf.Remote{}); err != nil {
+					l.add(f.path, severityError, "LabelAgent has no valid rules err=%v", err)
+					continue
+				}
+				for _, label := range la.NewLabels() {
+					labels[label] = true
+				}
+				for _, label := range la.SourceLabels() {
+					references = append(references, reference{source: "LabelAgent rule", label: label})
+				}
+			case "Aggregator":
+				agg := aggregator.New(&plugin.AbstractPlugin{Params: p})
+				if err := agg.Init(conf.Remote{}); err != nil {
+					l.add(f.path, severityError, "Aggregator has an invalid rule err=%v", err)
+					continue
+				}
+				for _, label := range agg.SourceLabels() {
+					references = append(references, reference{source: "Aggregator rule", label: label})
+				}
+			case "Inventory":
+				inv := inventory.New(&plugin.AbstractPlugin{Params: p})
+				if err := inv.Init(conf.Remote{}); err != nil {
+					l.add(f.path, severityError, "Inventory has an invalid parameter err=%v", err)
+					continue
+				}
+				newLabels := inv.NewLabels()
+				if len(newLabels) == 0 {
+					// all columns of the inventory are copied, so its labels are not known offline
+					customPlugins = append(customPlugins, name)
+				}
+				for _, label := range newLabels {
+					labels[label] = true
+				}
+				for _, label := range inv.SourceLabels() {
+					references = append(references, reference{source: "Inventory match", label: label})
+				}
+			case "Join":
+				jn := join.New(&plugin.AbstractPlugin{Params: p})
+				if err := jn.Init(conf.Remote{}); err != nil {
+					l.add(f.path, severityError, "Join has an invalid rule err=%v", err)
+					continue
+				}
+				for _, label := range jn.NewLabels() {
+					labels[label] = true
+				}
+				for _, label := range jn.SourceLabels() {
+					references = append(references, reference{source: "Join key", label: label})
+				}
+			case "MetricAgent":
+				ma := metricagent.New(&plugin.AbstractPlugin{Params: p})
+				if err := ma.Init(conf.Remote{}); err != nil {
+					l.add(f.path, severityError, "MetricAgent has an invalid rule err=%v", err)
+					continue
+				}
+				for _, label := range ma.SourceLabels() {
+					references = append(references, reference{source: "MetricAgent rule", label: label})
+				}
+			default:
+				if !builtInPlugins[name] {
+					customPlugins = append(customPlugins, name)
+				}
+			}
+		}
+	}
+
+	if exportOptions := template.GetChildS("export_options"); exportOptions != nil {
+		if keys := exportOptions.GetChildS("instance_keys"); keys != nil {
+			for _, label := range keys.GetAllChildContentS() {
+				references = append(references, reference{source: "export_options instance_keys", label: label})
+			}
+		}
+		if instanceLabels := exportOptions.GetChildS("instance_labels"); instanceLabels != nil {
+			for _, label := range instanceLabels.GetAllChildContentS() {
+				references = append(references, reference{source: "export_options instance_labels", label: label})
+			}
+		}
+	}
+
+	for _, r := range references {
+		if labels[r.label] {
+			continue
+		}
+		// Perf collectors add instance labels at runtime, e.g., from workload or instance names
+		if f.collector == "restperf" || f.collector == "zapiperf" {
+			l.add(f.path, severityWarning, "%s references label %q which is not defined by the template, unless it is created by the %s collector",
+				r.source, r.label, f.collector)
+			continue
+		}
+		// Collector-specific plugins may create labels that can not be known offline
+		if len(customPlugins) > 0 {
+			l.add(f.path, severityWarning, "%s references label %q which is not defined by the template, unless it is created by plugin %s",
+				r.source, r.label, strings.Join(customPlugins, ", "))
+			continue
+		}
+		l.add(f.path, severityError, "%s references label %q which is not defined by the template", r.source, r.label)
+	}
+}
+
+func (l *templateLinter) lintCatalog(f templateFile, c *catalog, query string, template *node.Node, counters []counterDef) {
+	switch f.collector {
+	case "rest", "keyperf":
+		queries := map[string]bool{query: true}
+		if endpoints := template.GetChildS("endpoints"); endpoints != nil {
+			for _, endpoint := range endpoints.GetChildren() {
+				queries[endpoint.GetChildContentS("query")] = true
+			}
+		}
+		for q := range queries {
+			if q == "" || strings.HasPrefix(q, privateCLI) {
+				continue
+			}
+			if !c.hasPath(q) {
+				l.add(f.path, severityError, "query %s does not exist in the ONTAP %s REST API", q, c.version)
+			}
+		}
+		for _, counter := range counters {
+			q := cmp.Or(counter.endpoint, query)
+			if strings.HasPrefix(q, privateCLI) || !c.hasPath(q) {
+				continue
+			}
+			if !c.hasField(q, counter.name) {
+				l.add(f.path, severityError, "counter %q is not a field of %s in ONTAP %s", counter.name, q, c.version)
+			}
+		}
+	case "restperf":
+		if !strings.HasPrefix(query, counterTables) || c.counters == nil {
+			return
+		}
+		table := strings.TrimPrefix(query, counterTables)
+		tableCounters, ok := c.counters[table]
+		if !ok {
+			l.add(f.path, severityError, "counter table %s does not exist in ONTAP %s", table, c.version)
+			return
+		}
+		for _, counter := range counters {
+			if counter.kind != "float" || counter.endpoint != "" {
+				continue
+			}
+			if !tableCounters[counter.name] {
+				l.add(f.path, severityError, "counter %q does not exist in counter table %s in ONTAP %s", counter.name, table, c.version)
+			}
+		}
+	}
+}
+
+// printTemplateIssues prints the issues grouped by template and returns true if any are errors
+func printTemplateIssues(issues []templateIssue, numCatalogs int, catalogDir string) bool {
+	sort.SliceStable(issues, func(i, j int) bool { return issues[i].path < issues[j].path })
+
+	numErrors := 0
+	numWarnings := 0
+	prevPath := ""
+	for _, issue := range issues {
+		if issue.path != prevPath {
+			fmt.Println(issue.path)
+			prevPath = issue.path
+		}
+		severity := color.Colorize("Warning", color.Yellow)
+		if issue.severity == severityError {
+			severity = color.Colorize("Error", color.Red)
+			numErrors++
+		} else {
+			numWarnings++
+		}
+		fmt.Printf("  %s: %s\n", severity, issue.message)
+	}
+
+	if numCatalogs == 0 {
+		fmt.Printf("%s: no ONTAP catalog found in %s, queries and counters were not checked against the REST schema. "+
+			"Use --poller to download one, or --catalog to use a shared catalog.\n", color.Colorize("Warning", color.Yellow), catalogDir)
+	}
+	fmt.Printf("Found %d errors and %d warnings\n", numErrors, numWarnings)
+	return numErrors > 0
+}
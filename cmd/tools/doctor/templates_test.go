@@ -0,0 +1,114 @@
+package doctor
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLintTemplates(t *testing.T) {
+	catalogs, err := loadCatalogs("testdata/templates/catalog")
+	if err != nil {
+		t.Fatalf("expected no error got %+v", err)
+	}
+	if len(catalogs) != 1 || catalogs[0].version.String() != "9.14.1" {
+		t.Fatalf("got %d catalogs, want the 9.14.1 catalog", len(catalogs))
+	}
+
+	issues := lintTemplates([]string{"testdata/templates/conf"}, catalogs)
+
+	type want struct {
+		template string
+		message  string
+	}
+	wants := []want{
+		{template: "rest/9.12.0/broken.yaml", message: `counter "^^^uuid => uuid" has an invalid key marker`},
+		{template: "rest/9.12.0/broken.yaml", message: `counter "^svm^name => svm" has a misplaced ^ marker`},
+		{template: "rest/9.12.0/broken.yaml", message: `counter "^state => ^state" has a ^ marker in its display name`},
+		{template: "rest/9.12.0/broken.yaml", message: `counter "^type =>" has an empty display name`},
+		{template: "rest/9.12.0/broken.yaml", message: `endpoint api/private/cli/broken has instance keys [svm]`},
+		{template: "rest/9.12.0/broken.yaml", message: `display name "name" is used by counters "name" and "size"`},
+		{template: "rest/9.12.0/broken.yaml", message: `Aggregator rule references label "node"`},
+		{template: "rest/9.12.0/broken.yaml", message: `MetricAgent has an invalid rule`},
+		{template: "rest/9.12.0/broken.yaml", message: `instance_keys references label "missing"`},
+		{template: "rest/9.12.0/broken.yaml", message: `query api/storage/broken does not exist in the ONTAP 9.14.1 REST API`},
+		{template: "restperf/9.12.0/missing.yaml", message: `counter table missing does not exist in ONTAP 9.14.1`},
+		{template: "restperf/9.12.0/volume.yaml", message: `counter "write_ops" does not exist in counter table volume`},
+	}
+
+	for _, w := range wants {
+		found := slices.ContainsFunc(issues, func(issue templateIssue) bool {
+			return filepath.ToSlash(issue.path) == "testdata/templates/conf/"+w.template &&
+				issue.severity == severityError && strings.Contains(issue.message, w.message)
+		})
+		if !found {
+			t.Errorf("missing error template=%s message=%s", w.template, w.message)
+		}
+	}
+
+	// The 9.16.0 volume template is not the best fit for ONTAP 9.14.1, so its unknown field is not reported
+	for _, issue := range issues {
+		if strings.HasSuffix(filepath.ToSlash(issue.path), "rest/9.12.0/volume.yaml") ||
+			strings.HasSuffix(filepath.ToSlash(issue.path), "rest/9.16.0/volume.yaml") {
+			t.Errorf("got unexpected issue template=%s message=%s", issue.path, issue.message)
+		}
+	}
+
+	if len(issues) != len(wants) {
+		for _, issue := range issues {
+			t.Logf("%s %s %s", issue.path, issue.severity, issue.message)
+		}
+		t.Errorf("got %d issues, want %d", len(issues), len(wants))
+	}
+}
+
+func TestCatalogHasField(t *testing.T) {
+	catalogs, err := loadCatalogs("testdata/templates/catalog")
+	if err != nil {
+		t.Fatalf("expected no error got %+v", err)
+	}
+	c := catalogs[0]
+
+	tests := []struct {
+		query string
+		field string
+		want  bool
+	}{
+		{query: "api/storage/volumes", field: "name", want: true},
+		{query: "api/storage/volumes", field: "space.size", want: true},
+		{query: "api/storage/volumes", field: "svm.name", want: true},
+		{query: "api/storage/volumes", field: "space.missing", want: false},
+		{query: "api/storage/volumes", field: "uuid", want: false},
+		{query: "api/storage/volumes/1234", field: "svm.name", want: true},
+		{query: "api/storage/volumes", field: "aggregates.0.name", want: true},
+		{query: "api/storage/volumes", field: "aggregates.0.missing", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query+" "+tt.field, func(t *testing.T) {
+			if got := c.hasField(tt.query, tt.field); got != tt.want {
+				t.Errorf("hasField() got=%t, want=%t", got, tt.want)
+			}
+		})
+	}
+
+	if c.hasPath("api/storage/luns") {
+		t.Errorf("expected api/storage/luns to be missing from the catalog")
+	}
+
+	// Without a cached or downloaded catalog there is nothing to check against
+	catalogs, err = loadCatalogs(filepath.Join(t.TempDir(), "missing"))
+	if err != nil || len(catalogs) != 0 {
+		t.Errorf("got %d catalogs err=%v, want none", len(catalogs), err)
+	}
+}
+
+func TestShippedTemplates(t *testing.T) {
+	issues := lintTemplates([]string{"../../../conf"}, nil)
+	for _, issue := range issues {
+		if issue.severity == severityError {
+			t.Errorf("template=%s %s", issue.path, issue.message)
+		}
+	}
+}
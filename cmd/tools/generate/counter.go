@@ -1,6 +1,7 @@
 package generate
 
 import (
+	"cmp"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -532,9 +533,10 @@ func processRestConfigCounters(path string, api string) map[string]Counter {
 
 	// If the template has any PluginMetrics, add them
 	for _, metric := range model.PluginMetrics {
+		object := cmp.Or(metric.Object, model.Object)
 		co := Counter{
-			Object: model.Object,
-			Name:   model.Object + "_" + metric.Name,
+			Object: object,
+			Name:   object + "_" + metric.Name,
 			APIs: []MetricDef{
 				{
 					API:          api,
@@ -810,9 +812,10 @@ func processZAPIPerfCounters(path string, client *zapi.Client) map[string]Counte
 
 	// If the template has any PluginMetrics, add them
 	for _, metric := range model.PluginMetrics {
+		object := cmp.Or(metric.Object, model.Object)
 		co := Counter{
-			Object: model.Object,
-			Name:   model.Object + "_" + metric.Name,
+			Object: object,
+			Name:   object + "_" + metric.Name,
 			APIs: []MetricDef{
 				{
 					API:          "ZAPI",
@@ -896,9 +899,10 @@ func processZapiConfigCounters(path string) map[string]Counter {
 
 	// If the template has any PluginMetrics, add them
 	for _, metric := range model.PluginMetrics {
+		object := cmp.Or(metric.Object, model.Object)
 		co := Counter{
-			Object: model.Object,
-			Name:   model.Object + "_" + metric.Name,
+			Object: object,
+			Name:   object + "_" + metric.Name,
 			APIs: []MetricDef{
 				{
 					API:          "ZAPI",
@@ -1074,6 +1078,57 @@ func appendRow(table *tw.Table, missing string, counter Counter, def MetricDef)
 	table.Append([]string{missing, counter.Name, def.API, def.Endpoint, def.ONTAPCounter, def.Template})
 }
 
+// generateZapiRestMapping writes the ZAPI to REST mapping used by harvest template convert.
+// Metrics that are collected by both APIs relate a ZAPI counter to a REST counter
+func generateZapiRestMapping(counters map[string]Counter) {
+	var mapping template2.Mapping
+
+	for _, counter := range counters {
+		var zapiDefs, restDefs []MetricDef
+		for _, def := range counter.APIs {
+			if def.Endpoint == "NA" || def.ONTAPCounter == "" || def.ONTAPCounter == "Harvest generated" {
+				continue
+			}
+			switch def.API {
+			case "ZAPI":
+				zapiDefs = append(zapiDefs, def)
+			case "REST":
+				restDefs = append(restDefs, def)
+			}
+		}
+		if len(restDefs) == 0 {
+			continue
+		}
+		for _, zapiDef := range zapiDefs {
+			// prefer the REST template with the same file name, e.g. zapiperf volume.yaml and restperf volume.yaml
+			restDef := restDefs[0]
+			for _, def := range restDefs {
+				if filepath.Base(def.Template) == filepath.Base(zapiDef.Template) {
+					restDef = def
+					break
+				}
+			}
+			mapping.Counters = append(mapping.Counters, template2.CounterMapping{
+				Metric:       counter.Name,
+				ZapiEndpoint: strings.TrimPrefix(zapiDef.Endpoint, "perf-object-get-instances "),
+				ZapiCounter:  zapiDef.ONTAPCounter,
+				RestEndpoint: restDef.Endpoint,
+				RestCounter:  restDef.ONTAPCounter,
+			})
+		}
+	}
+
+	out, err := os.Create(template2.MappingPath)
+	if err != nil {
+		panic(err)
+	}
+	defer out.Close()
+	if err := template2.WriteMapping(out, mapping); err != nil {
+		panic(err)
+	}
+	fmt.Printf("ZAPI to REST mapping generated at %s \n", template2.MappingPath)
+}
+
 // Regex to match NFS version and operation
 var reRemove = regexp.MustCompile(`NFSv\d+\.\d+`)
 
@@ -1245,9 +1300,10 @@ func processRestPerfCounters(path string, client *rest.Client) map[string]Counte
 
 	// If the template has any PluginMetrics, add them
 	for _, metric := range model.PluginMetrics {
+		object := cmp.Or(metric.Object, model.Object)
 		co := Counter{
-			Object: model.Object,
-			Name:   model.Object + "_" + metric.Name,
+			Object: object,
+			Name:   object + "_" + metric.Name,
 			APIs: []MetricDef{
 				{
 					API:          "REST",
@@ -73,6 +73,11 @@ type options struct {
 	configPath  string
 	confPath    string
 	promURL     string
+	rulesURL    string
+	window      string
+	namespace   string
+	statefulSet bool
+	monitor     string
 }
 
 var metricRe = regexp.MustCompile(`(\w+)\{`)
@@ -138,6 +143,7 @@ func doDockerCompose(cmd *cobra.Command, _ []string) {
 func doGenerateMetrics(cmd *cobra.Command, _ []string) {
 	addRootOptions(cmd)
 	counters, cluster := BuildMetrics("", "", opts.Poller)
+	generateZapiRestMapping(counters)
 	generateCounterTemplate(counters, cluster.Version)
 }
 
@@ -691,6 +697,8 @@ func init() {
 	Cmd.AddCommand(metricCmd)
 	Cmd.AddCommand(descCmd)
 	Cmd.AddCommand(dockerCmd)
+	Cmd.AddCommand(rulesCmd)
+	Cmd.AddCommand(kubernetesCmd)
 	dockerCmd.AddCommand(fullCmd)
 
 	dFlags := dockerCmd.PersistentFlags()
@@ -720,4 +728,21 @@ func init() {
 	fFlags.IntVar(&opts.grafanaPort, "grafanaPort", 3000, "Grafana Port")
 
 	metricCmd.PersistentFlags().StringVar(&opts.promURL, "prom-url", "", "Prometheus URL for CI validation")
+
+	rFlags := rulesCmd.PersistentFlags()
+	rFlags.StringVarP(&opts.Poller, "poller", "p", "", "name of a running poller to read raw counters from")
+	rFlags.StringVar(&opts.rulesURL, "url", "", "Prometheus endpoint to read raw counters from, e.g. http://localhost:13000/metrics. Overrides --poller")
+	rFlags.StringVar(&opts.window, "window", "5m", "range used by rate and increase, at least twice the data poll interval")
+	rFlags.StringVarP(&opts.outputPath, "output", "o", "", "Output file path. Rules are printed to stdout when empty")
+	rulesCmd.MarkFlagsOneRequired("poller", "url")
+
+	kFlags := kubernetesCmd.PersistentFlags()
+	kFlags.IntVarP(&opts.loglevel, "loglevel", "l", 2,
+		"logging level (0=trace, 1=debug, 2=info, 3=warning, 4=error, 5=critical)",
+	)
+	kFlags.StringVar(&opts.image, "image", "ghcr.io/netapp/harvest:latest", "Harvest image")
+	kFlags.StringVarP(&opts.outputPath, "output", "o", "", "Output file path. Manifests are printed to stdout when empty")
+	kFlags.StringVarP(&opts.namespace, "namespace", "n", "harvest", "Namespace of the Harvest objects")
+	kFlags.BoolVar(&opts.statefulSet, "statefulset", false, "Run all pollers in a single StatefulSet, one poller per pod, instead of a Deployment per poller")
+	kFlags.StringVar(&opts.monitor, "monitor", "servicemonitor", "Prometheus Operator object used to scrape the pollers: servicemonitor, podmonitor, or none")
 }
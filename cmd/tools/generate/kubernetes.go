@@ -0,0 +1,559 @@
+package generate
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"github.com/netapp/harvest/v2/cmd/poller/collector"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/tree/yamlnode"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+//go:embed kubernetes.tmpl
+var kubernetesTmpl string
+
+const (
+	kubernetesPromPort   = 12990
+	kubernetesConfigDir  = "/opt/harvest-config"
+	kubernetesSecretsDir = "/opt/harvest/secrets"
+	kubernetesHome       = "/opt/harvest"
+	// kubernetesCredentials is the Secret of the credentials harvest.yml reads from environment variables
+	kubernetesCredentials = "harvest-credentials"
+	// configMapMaxBytes is the maximum size of a ConfigMap
+	configMapMaxBytes = 1024 * 1024
+	mebibyte          = 1024 * 1024
+)
+
+var kubernetesCmd = &cobra.Command{
+	Use:   "kubernetes",
+	Short: "generate Kubernetes manifests for all pollers defined in config",
+	Long: `Generate Kubernetes manifests for all pollers defined in config.
+Each poller runs in its own Deployment, or, with --statefulset, in a pod of a single StatefulSet.
+harvest.yml and the templates of custom conf_path directories are stored in ConfigMaps.
+Pollers that authenticate with a password in harvest.yml read it from a Secret instead.
+The other credentials of harvest.yml, e.g., Vault tokens and exporter tokens, are moved to the harvest-credentials
+Secret and read from environment variables.
+Resource requests are sized from the instance counts in the last AutoSupport payload of each poller.`,
+	Run: doKubernetes,
+}
+
+type KubernetesTemplate struct {
+	Namespace    string
+	Image        string
+	LogLevel     int
+	Port         int
+	ConfigDir    string
+	HarvestYml   string
+	Templates    []KubernetesFile
+	TemplateDirs []KubernetesTemplateDir
+	Pollers      []KubernetesPoller
+	Secrets      []*KubernetesSecret
+	Credentials  []KubernetesCredential
+	StatefulSet  bool
+	Shard        KubernetesPoller // sizing of the StatefulSet pods, the largest of the pollers
+	Monitor      string
+}
+
+type KubernetesPoller struct {
+	PollerName string
+	Name       string // name of the poller's Kubernetes objects
+	HasPort    bool   // true when the poller exports to Prometheus
+	Resources  KubernetesResources
+	SizedFrom  string
+	Secret     *KubernetesSecret
+}
+
+type KubernetesResources struct {
+	CPU         string
+	Memory      string
+	MemoryLimit string
+	cpuMillis   int64
+	memoryBytes int64
+}
+
+type KubernetesSecret struct {
+	Name string
+	Path string // where the Secret is mounted, the path of the poller's kubernetes credentials_store
+}
+
+// KubernetesCredential is a credential of harvest.yml that is read from the harvest-credentials Secret
+type KubernetesCredential struct {
+	Field string // dotted path of the field in harvest.yml
+	Key   string // key of the Secret and name of the environment variable that harvest.yml references
+}
+
+// KubernetesFile is a template stored in the harvest-templates ConfigMap
+type KubernetesFile struct {
+	Key     string
+	Path    string // path relative to the template directory
+	Content string
+}
+
+// KubernetesTemplateDir is a conf_path directory mounted from the harvest-templates ConfigMap
+type KubernetesTemplateDir struct {
+	Volume    string
+	MountPath string
+	Files     []KubernetesFile
+}
+
+var kubernetesNameRe = regexp.MustCompile(`[^a-z0-9-]+`)
+
+func doKubernetes(cmd *cobra.Command, _ []string) {
+	addRootOptions(cmd)
+	if opts.monitor != "servicemonitor" && opts.monitor != "podmonitor" && opts.monitor != "none" {
+		logErrAndExit(fmt.Errorf("invalid monitor=%s, use servicemonitor, podmonitor, or none", opts.monitor))
+	}
+	if _, err := conf.LoadHarvestConfig(opts.configPath); err != nil {
+		logErrAndExit(err)
+	}
+
+	out := os.Stdout
+	if opts.outputPath != "" {
+		f, err := os.Create(opts.outputPath)
+		if err != nil {
+			logErrAndExit(err)
+		}
+		defer silentClose(f)
+		out = f
+	}
+	if err := generateKubernetes(out); err != nil {
+		logErrAndExit(err)
+	}
+	if opts.outputPath != "" {
+		_, _ = fmt.Fprintf(os.Stderr, "Wrote Kubernetes manifests to %s\nApply them with:\nkubectl apply -f %s\n",
+			opts.outputPath, opts.outputPath)
+	}
+}
+
+// generateKubernetes writes the Kubernetes manifests of the enabled pollers of conf.Config to w
+func generateKubernetes(w io.Writer) error {
+	k := KubernetesTemplate{
+		Namespace:   opts.namespace,
+		Image:       opts.image,
+		LogLevel:    opts.loglevel,
+		Port:        kubernetesPromPort,
+		ConfigDir:   kubernetesConfigDir,
+		StatefulSet: opts.statefulSet,
+		Monitor:     opts.monitor,
+	}
+
+	names := make(map[string]bool)
+	confPaths := make([]string, 0)
+	for _, pollerName := range conf.Config.PollersOrdered {
+		poller, ok := conf.Config.Pollers[pollerName]
+		if !ok || poller == nil || poller.IsDisabled {
+			continue
+		}
+		kp := KubernetesPoller{
+			PollerName: pollerName,
+			Name:       kubernetesName("harvest-"+pollerName, names),
+			HasPort:    hasPrometheusExporter(poller),
+		}
+		kp.Resources, kp.SizedFrom = kubernetesResources(pollerName)
+		kp.Secret = kubernetesSecret(poller, kp.Name)
+		if kp.Secret != nil {
+			k.Secrets = append(k.Secrets, kp.Secret)
+		}
+		warnKubernetes(pollerName, poller)
+		k.Pollers = append(k.Pollers, kp)
+
+		confPath := opts.confPath
+		if confPath == "conf" {
+			confPath = poller.ConfPath
+		}
+		for _, p := range filepath.SplitList(confPath) {
+			if p != "" && p != "conf" && !slices.Contains(confPaths, p) {
+				confPaths = append(confPaths, p)
+			}
+		}
+	}
+	if len(k.Pollers) == 0 {
+		return errors.New("no enabled pollers found")
+	}
+
+	if k.StatefulSet {
+		k.Shard = k.Pollers[0]
+		for _, p := range k.Pollers[1:] {
+			if p.Resources.memoryBytes > k.Shard.Resources.memoryBytes {
+				k.Shard = p
+			}
+		}
+		k.Shard.SizedFrom = "the largest poller " + k.Shard.PollerName + ": " + k.Shard.SizedFrom
+	}
+	if !slices.ContainsFunc(k.Pollers, func(p KubernetesPoller) bool { return p.HasPort }) {
+		k.Monitor = "none"
+	}
+
+	secretPaths := make(map[string]string, len(k.Secrets))
+	for _, p := range k.Pollers {
+		if p.Secret != nil {
+			secretPaths[p.PollerName] = p.Secret.Path
+		}
+	}
+	harvestYml, credentials, err := kubernetesConfig(opts.configPath, secretPaths)
+	if err != nil {
+		return err
+	}
+	k.HarvestYml = harvestYml
+	k.Credentials = credentials
+	for _, c := range credentials {
+		_, _ = fmt.Fprintf(os.Stderr, "%s is read from the %s key of Secret %s\n", c.Field, c.Key, kubernetesCredentials)
+	}
+
+	if err := k.addTemplates(confPaths); err != nil {
+		return err
+	}
+
+	t, err := template.New("kubernetes.tmpl").Funcs(template.FuncMap{
+		"indent": indent,
+		"quote":  strconv.Quote,
+	}).Parse(kubernetesTmpl)
+	if err != nil {
+		return err
+	}
+	return t.Execute(w, k)
+}
+
+// kubernetesName returns a unique DNS-1123 name of at most 63 characters
+func kubernetesName(name string, seen map[string]bool) string {
+	base := strings.Trim(kubernetesNameRe.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	base = strings.TrimRight(base[:min(len(base), 58)], "-")
+	unique := base
+	for i := 2; seen[unique]; i++ {
+		unique = base + "-" + strconv.Itoa(i)
+	}
+	seen[unique] = true
+	return unique
+}
+
+func hasPrometheusExporter(poller *conf.Poller) bool {
+	for _, e := range poller.Exporters {
+		if conf.Config.Exporters[e].Type == "Prometheus" {
+			return true
+		}
+	}
+	return false
+}
+
+// kubernetesSecret returns the Secret a poller reads its credentials from, or nil when the poller does not
+// authenticate with a password or gets it from elsewhere, e.g., Vault.
+// Each poller gets its own Secret, mounted where its rewritten kubernetes credentials_store reads it
+func kubernetesSecret(poller *conf.Poller, name string) *KubernetesSecret {
+	auth := authPoller(poller)
+	isPassword := auth.Password != "" && auth.CredentialsStore.Type == "" && auth.CredentialsFile == "" &&
+		auth.CredentialsScript.Path == ""
+	if !isPassword && auth.CredentialsStore.Type != conf.KubernetesStore {
+		return nil
+	}
+	return &KubernetesSecret{Name: name, Path: path.Join(kubernetesSecretsDir, name)}
+}
+
+// authPoller returns the poller whose auth fields a poller uses. Like auth.Credentials, pollers without
+// their own password or credentials provider fall back to the Defaults
+func authPoller(poller *conf.Poller) *conf.Poller {
+	hasAuth := poller.Password != "" || poller.CredentialsFile != "" || poller.CredentialsScript.Path != "" ||
+		poller.CredentialsStore.Type != "" || poller.AuthStyle != ""
+	if hasAuth || conf.Config.Defaults == nil {
+		return poller
+	}
+	return conf.Config.Defaults
+}
+
+// warnKubernetes warns about the files a poller needs that are not part of the manifests
+func warnKubernetes(pollerName string, poller *conf.Poller) {
+	auth := authPoller(poller)
+	files := map[string]string{
+		"credentials_file":   auth.CredentialsFile,
+		"credentials_script": auth.CredentialsScript.Path,
+		"certificate_script": poller.CertificateScript.Path,
+		"ca_cert":            poller.CaCertPath,
+		"ssl_cert":           poller.SslCert,
+		"ssl_key":            poller.SslKey,
+	}
+	for _, param := range slices.Sorted(maps.Keys(files)) {
+		if files[param] != "" {
+			_, _ = fmt.Fprintf(os.Stderr, "poller %s uses %s %s, mount it into the poller's pod\n",
+				pollerName, param, files[param])
+		}
+	}
+	for _, e := range poller.Exporters {
+		exporter := conf.Config.Exporters[e]
+		if exporter.Type == "Prometheus" && (exporter.LocalHTTPAddr == "localhost" || exporter.LocalHTTPAddr == "127.0.0.1") {
+			_, _ = fmt.Fprintf(os.Stderr, "poller %s exports to %s, which listens on %s, Prometheus cannot scrape it\n",
+				pollerName, e, exporter.LocalHTTPAddr)
+		}
+	}
+}
+
+// kubernetesResources sizes a poller's pod from the instance counts, and the max RSS, of its last AutoSupport payload
+func kubernetesResources(pollerName string) (KubernetesResources, string) {
+	const (
+		baseMemory       = 64 * mebibyte
+		memoryPerInst    = 16 * 1024
+		defaultMemory    = 128 * mebibyte
+		baseCPU          = 100
+		cpuPer10kInst    = 100
+		maxCPU           = 2000
+		rssHeadroomRatio = 1.25
+	)
+	var (
+		instances int64
+		maxRss    uint64
+	)
+	payload, err := collector.ReadPayload(pollerName)
+	if err == nil {
+		if payload.Collectors != nil {
+			for _, c := range *payload.Collectors {
+				if c.InstanceInfo != nil {
+					instances += c.InstanceInfo.Count
+				}
+			}
+		}
+		if payload.Harvest != nil {
+			maxRss = payload.Harvest.MaxRssBytes
+		}
+	}
+
+	r := KubernetesResources{memoryBytes: defaultMemory, cpuMillis: baseCPU}
+	sizedFrom := "default sizing, the poller has no AutoSupport payload"
+	if instances > 0 || maxRss > 0 {
+		r.memoryBytes = baseMemory + instances*memoryPerInst
+		r.cpuMillis = min(baseCPU+instances/10_000*cpuPer10kInst, maxCPU)
+		sizedFrom = fmt.Sprintf("sized from %d instances in the last AutoSupport payload", instances)
+		if rss := int64(float64(maxRss) * rssHeadroomRatio); rss > r.memoryBytes {
+			r.memoryBytes = rss
+			sizedFrom += fmt.Sprintf(" and its max RSS of %dMi", maxRss/mebibyte)
+		}
+	}
+	// round up to whole mebibytes
+	r.memoryBytes = (r.memoryBytes + mebibyte - 1) / mebibyte * mebibyte
+	r.CPU = strconv.FormatInt(r.cpuMillis, 10) + "m"
+	r.Memory = strconv.FormatInt(r.memoryBytes/mebibyte, 10) + "Mi"
+	r.MemoryLimit = strconv.FormatInt(2*r.memoryBytes/mebibyte, 10) + "Mi"
+	return r, sizedFrom
+}
+
+// kubernetesConfig returns the harvest.yml of the harvest-config ConfigMap. The pollers of Poller_files are
+// inlined, pollers with a Secret read their credentials from it instead of harvest.yml, and the other credentials
+// are replaced by references to environment variables of the harvest-credentials Secret
+func kubernetesConfig(configPath string, secretPaths map[string]string) (string, []KubernetesCredential, error) {
+	contents, err := os.ReadFile(configPath)
+	if err != nil {
+		return "", nil, err
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(contents, &root); err != nil {
+		return "", nil, fmt.Errorf("error unmarshalling config file=[%s] %w", configPath, err)
+	}
+	if len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return "", nil, fmt.Errorf("config file=[%s] is not a yaml map", configPath)
+	}
+	doc := root.Content[0]
+
+	pollers := yamlnode.Value(doc, "Pollers")
+	if pollers == nil {
+		pollers = &yaml.Node{Kind: yaml.MappingNode}
+		doc.Content = append(doc.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "Pollers"}, pollers)
+	}
+	if pollerFiles := yamlnode.Value(doc, "Poller_files"); pollerFiles != nil {
+		for _, pattern := range pollerFiles.Content {
+			matches, err := filepath.Glob(pattern.Value)
+			if err != nil {
+				return "", nil, fmt.Errorf("error retrieving poller_files path=%s err=%w", pattern.Value, err)
+			}
+			slices.Sort(matches)
+			for _, fp := range matches {
+				if err := inlinePollerFile(pollers, fp); err != nil {
+					return "", nil, err
+				}
+			}
+		}
+		yamlnode.Delete(doc, "Poller_files")
+	}
+
+	if len(secretPaths) > 0 {
+		if defaults := yamlnode.Value(doc, "Defaults"); defaults != nil {
+			yamlnode.Delete(defaults, "password")
+		}
+	}
+	for i := 0; i+1 < len(pollers.Content); i += 2 {
+		secretPath, ok := secretPaths[pollers.Content[i].Value]
+		poller := pollers.Content[i+1]
+		if !ok || poller.Kind != yaml.MappingNode {
+			continue
+		}
+		yamlnode.Delete(poller, "password")
+		yamlnode.Delete(poller, "credentials_store")
+		store := &yaml.Node{Kind: yaml.MappingNode}
+		store.Content = append(store.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: "type"}, &yaml.Node{Kind: yaml.ScalarNode, Value: conf.KubernetesStore},
+			&yaml.Node{Kind: yaml.ScalarNode, Value: "path"}, &yaml.Node{Kind: yaml.ScalarNode, Value: secretPath},
+		)
+		poller.Content = append(poller.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "credentials_store"}, store)
+	}
+
+	credentials := moveCredentials(doc)
+
+	var b strings.Builder
+	encoder := yaml.NewEncoder(&b)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&root); err != nil {
+		return "", nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return "", nil, err
+	}
+	return b.String(), credentials, nil
+}
+
+// moveCredentials replaces the credentials of harvest.yml with ${KEY} references to environment variables,
+// so they are stored in a Secret instead of the ConfigMap. Values that already reference a variable are kept
+func moveCredentials(doc *yaml.Node) []KubernetesCredential {
+	var credentials []KubernetesCredential
+	keys := make(map[string]bool)
+
+	move := func(n *yaml.Node, fields []string, names ...string) {
+		for _, name := range names {
+			v := yamlnode.Value(n, name)
+			if v == nil || v.Kind != yaml.ScalarNode || v.Value == "" || strings.Contains(v.Value, "${") {
+				continue
+			}
+			field := append(slices.Clone(fields), name)
+			key := credentialKey(field, keys)
+			// quoted, so that a value with yaml syntax, e.g., # or :, is still a string once expanded
+			*v = yaml.Node{Kind: yaml.ScalarNode, Style: yaml.DoubleQuotedStyle, Value: "${" + key + "}"}
+			credentials = append(credentials, KubernetesCredential{Field: strings.Join(field, "."), Key: key})
+		}
+	}
+	movePoller := func(n *yaml.Node, fields ...string) {
+		move(n, fields, "password")
+		move(yamlnode.Value(n, "credentials_store"), append(fields, "credentials_store"), "token", "secret_id")
+	}
+	each := func(section string, f func(name string, n *yaml.Node)) {
+		n := yamlnode.Value(doc, section)
+		if n == nil || n.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i+1].Kind == yaml.MappingNode {
+				f(n.Content[i].Value, n.Content[i+1])
+			}
+		}
+	}
+
+	movePoller(yamlnode.Value(doc, "Defaults"), "Defaults")
+	each("Pollers", func(name string, n *yaml.Node) { movePoller(n, "Pollers", name) })
+	each("Exporters", func(name string, n *yaml.Node) { move(n, []string{"Exporters", name}, "token", "password") })
+	move(yamlnode.Value(doc, "Tools"), []string{"Tools"}, "grafana_api_token")
+	move(yamlnode.Value(yamlnode.Value(yamlnode.Value(doc, "Admin"), "httpsd"), "auth_basic"),
+		[]string{"Admin", "httpsd", "auth_basic"}, "password")
+	return credentials
+}
+
+var credentialKeyRe = regexp.MustCompile(`[^A-Z0-9_]+`)
+
+// credentialKey returns a unique environment variable name for the field, e.g., HARVEST_EXPORTERS_INFLUX_TOKEN
+func credentialKey(field []string, seen map[string]bool) string {
+	base := "HARVEST_" + credentialKeyRe.ReplaceAllString(strings.ToUpper(strings.Join(field, "_")), "_")
+	key := base
+	for i := 2; seen[key]; i++ {
+		key = base + "_" + strconv.Itoa(i)
+	}
+	seen[key] = true
+	return key
+}
+
+func inlinePollerFile(pollers *yaml.Node, fp string) error {
+	contents, err := os.ReadFile(fp)
+	if err != nil {
+		return fmt.Errorf("error reading poller_file=%s err=%w", fp, err)
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(contents, &root); err != nil {
+		return fmt.Errorf("error unmarshalling poller_file=%s err=%w", fp, err)
+	}
+	if len(root.Content) == 0 {
+		return nil
+	}
+	if child := yamlnode.Value(root.Content[0], "Pollers"); child != nil {
+		pollers.Content = append(pollers.Content, child.Content...)
+	}
+	return nil
+}
+
+// addTemplates adds the yaml files of the custom conf_path directories to the harvest-templates ConfigMap.
+// The directories are mounted where the pollers look for them, relative paths are relative to the image's HARVEST_CONF
+func (k *KubernetesTemplate) addTemplates(confPaths []string) error {
+	size := len(k.HarvestYml)
+	for i, dir := range confPaths {
+		td := KubernetesTemplateDir{
+			Volume:    "templates-" + strconv.Itoa(i),
+			MountPath: dir,
+		}
+		if !filepath.IsAbs(dir) {
+			td.MountPath = path.Join(kubernetesHome, filepath.ToSlash(dir))
+		}
+		err := filepath.WalkDir(conf.Path(dir), func(fp string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || (!strings.HasSuffix(d.Name(), ".yaml") && !strings.HasSuffix(d.Name(), ".yml")) {
+				return nil
+			}
+			rel, err := filepath.Rel(conf.Path(dir), fp)
+			if err != nil {
+				return err
+			}
+			content, err := os.ReadFile(fp)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			f := KubernetesFile{
+				Key:     strconv.Itoa(i) + "_" + strings.ReplaceAll(rel, "/", "_"),
+				Path:    rel,
+				Content: string(content),
+			}
+			size += len(f.Content)
+			td.Files = append(td.Files, f)
+			k.Templates = append(k.Templates, f)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("error reading templates of conf_path=%s err=%w", dir, err)
+		}
+		if len(td.Files) > 0 {
+			k.TemplateDirs = append(k.TemplateDirs, td)
+		}
+	}
+	if size > configMapMaxBytes {
+		_, _ = fmt.Fprintf(os.Stderr, "harvest.yml and the custom templates are %d bytes, larger than the %d bytes of a ConfigMap\n",
+			size, configMapMaxBytes)
+	}
+	return nil
+}
+
+// indent indents each non-empty line of s with n spaces
+func indent(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = pad + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
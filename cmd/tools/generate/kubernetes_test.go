@@ -0,0 +1,311 @@
+package generate
+
+import (
+	"bytes"
+	"errors"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const kubernetesConfigYml = `
+Exporters:
+  prom:
+    exporter: Prometheus
+    port_range: 13000-13100
+Defaults:
+  collectors:
+    - Rest
+  exporters:
+    - prom
+  username: admin
+  password: secret
+Pollers:
+  sar:
+    addr: 10.0.0.1
+  Cluster_02.example:
+    addr: 10.0.0.2
+    conf_path: custom:conf
+  vault:
+    addr: 10.0.0.3
+    credentials_script:
+      path: /opt/get.sh
+  disabled:
+    addr: 10.0.0.4
+    disabled: true
+`
+
+func TestKubernetes(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Setenv(conf.HomeEnvVar, dir)
+	writeFile(t, "harvest.yml", kubernetesConfigYml)
+	writeFile(t, "custom/rest/volume.yaml", "name: Volume\n")
+	// 100k instances and a max RSS of 1GiB
+	writeFile(t, "asup/payload/sar_payload.json",
+		`{"Harvest": {"MaxRssBytes": 1073741824}, "Collectors": [{"Name": "Rest", "InstanceInfo": {"Count": 100000}}]}`)
+
+	conf.TestLoadHarvestConfig("harvest.yml")
+	opts.configPath = "harvest.yml"
+	opts.confPath = "conf"
+	opts.namespace = "harvest"
+	opts.monitor = "podmonitor"
+
+	tests := []struct {
+		name        string
+		statefulSet bool
+		wantKinds   []string
+	}{
+		{
+			name:      "deployments",
+			wantKinds: []string{"Namespace", "ConfigMap", "ConfigMap", "Service", "Deployment", "Service", "Deployment", "Service", "Deployment", "PodMonitor"},
+		},
+		{
+			name:        "statefulset",
+			statefulSet: true,
+			wantKinds:   []string{"Namespace", "ConfigMap", "ConfigMap", "Service", "StatefulSet", "PodMonitor"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts.statefulSet = tt.statefulSet
+			var out bytes.Buffer
+			if err := generateKubernetes(&out); err != nil {
+				t.Fatalf("generateKubernetes err=%v", err)
+			}
+			docs := decodeDocs(t, out.Bytes())
+
+			var kinds []string
+			for _, doc := range docs {
+				kinds = append(kinds, doc["kind"].(string))
+			}
+			if !slices.Equal(kinds, tt.wantKinds) {
+				t.Errorf("kinds got=%v want=%v", kinds, tt.wantKinds)
+			}
+
+			harvestYml := docs[1]["data"].(map[string]any)["harvest.yml"].(string)
+			if strings.Contains(harvestYml, "password") {
+				t.Errorf("harvest.yml contains the password\n%s", harvestYml)
+			}
+			var config conf.HarvestConfig
+			if err := yaml.Unmarshal([]byte(harvestYml), &config); err != nil {
+				t.Fatalf("failed to unmarshal harvest.yml err=%v", err)
+			}
+			store := config.Pollers["sar"].CredentialsStore
+			if store.Type != conf.KubernetesStore || store.Path != "/opt/harvest/secrets/harvest-sar" {
+				t.Errorf("sar credentials_store got=%+v", store)
+			}
+			if config.Pollers["vault"].CredentialsStore.Type != "" {
+				t.Errorf("vault should keep its credentials_script")
+			}
+
+			templates := docs[2]["data"].(map[string]any)
+			if templates["0_rest_volume.yaml"] != "name: Volume\n" {
+				t.Errorf("templates got=%v", templates)
+			}
+			if !strings.Contains(out.String(), "create secret generic harvest-sar ") {
+				t.Errorf("missing secret instructions for sar")
+			}
+			// sized from sar's payload, 64Mi + 100k * 16KiB
+			if !strings.Contains(out.String(), "memory: 1627Mi") {
+				t.Errorf("missing resources sized from sar's payload")
+			}
+			if strings.Contains(out.String(), "harvest-disabled") {
+				t.Errorf("disabled poller should not be generated")
+			}
+		})
+	}
+}
+
+func TestKubernetesCredentials(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Setenv(conf.HomeEnvVar, dir)
+	writeFile(t, "harvest.yml", `
+Tools:
+  grafana_api_token: grafana-secret
+Admin:
+  httpsd:
+    listen: :8887
+    auth_basic:
+      username: admin
+      password: "httpsd #secret"
+Exporters:
+  influx:
+    exporter: InfluxDB
+    url: http://influx:8086
+    token: influx-secret
+Defaults:
+  collectors:
+    - Rest
+  exporters:
+    - influx
+  credentials_store:
+    type: vault
+    addr: https://vault:8200
+    path: harvest/defaults
+    token: defaults-vault-secret
+Pollers:
+  approle:
+    addr: 10.0.0.1
+    credentials_store:
+      type: vault
+      addr: https://vault:8200
+      path: harvest/approle
+      role_id: role
+      secret_id: approle-secret
+  env:
+    addr: 10.0.0.2
+    credentials_store:
+      type: vault
+      addr: https://vault:8200
+      token: ${VAULT_TOKEN}
+  own:
+    addr: 10.0.0.3
+    username: admin
+    password: own-secret
+`)
+
+	conf.TestLoadHarvestConfig("harvest.yml")
+	opts.configPath = "harvest.yml"
+	opts.confPath = "conf"
+	opts.namespace = "harvest"
+	opts.monitor = "none"
+	opts.statefulSet = false
+
+	var out bytes.Buffer
+	if err := generateKubernetes(&out); err != nil {
+		t.Fatalf("generateKubernetes err=%v", err)
+	}
+	docs := decodeDocs(t, out.Bytes())
+	harvestYml := docs[1]["data"].(map[string]any)["harvest.yml"].(string)
+
+	secrets := map[string]string{
+		"HARVEST_TOOLS_GRAFANA_API_TOKEN":                     "grafana-secret",
+		"HARVEST_ADMIN_HTTPSD_AUTH_BASIC_PASSWORD":            "httpsd #secret",
+		"HARVEST_EXPORTERS_INFLUX_TOKEN":                      "influx-secret",
+		"HARVEST_DEFAULTS_CREDENTIALS_STORE_TOKEN":            "defaults-vault-secret",
+		"HARVEST_POLLERS_APPROLE_CREDENTIALS_STORE_SECRET_ID": "approle-secret",
+	}
+	for key, secret := range secrets {
+		if strings.Contains(out.String(), secret) {
+			t.Errorf("manifests contain the secret of %s", key)
+		}
+		if !strings.Contains(out.String(), "--from-literal="+key+"=VALUE") {
+			t.Errorf("missing secret instructions for %s", key)
+		}
+	}
+	if strings.Contains(out.String(), "own-secret") {
+		t.Errorf("manifests contain the password of poller own")
+	}
+	if !strings.Contains(harvestYml, "${VAULT_TOKEN}") {
+		t.Errorf("harvest.yml should keep the reference to VAULT_TOKEN\n%s", harvestYml)
+	}
+	if strings.Count(out.String(), "name: harvest-credentials") != 3 {
+		t.Errorf("every poller should read the harvest-credentials Secret\n%s", out.String())
+	}
+
+	// Once the poller expands the environment variables of the Secret, harvest.yml has the original credentials
+	for key, secret := range secrets {
+		t.Setenv(key, secret)
+	}
+	expanded, err := conf.ExpandVars([]byte(harvestYml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config conf.HarvestConfig
+	if err := yaml.Unmarshal(expanded, &config); err != nil {
+		t.Fatalf("failed to unmarshal harvest.yml err=%v", err)
+	}
+	if got := *config.Exporters["influx"].Token; got != "influx-secret" {
+		t.Errorf("influx token got=%s", got)
+	}
+	if got := config.Admin.Httpsd.AuthBasic.Password; got != "httpsd #secret" {
+		t.Errorf("httpsd password got=%s", got)
+	}
+	if got := config.Pollers["approle"].CredentialsStore.SecretID; got != "approle-secret" {
+		t.Errorf("approle secret_id got=%s", got)
+	}
+	if got := config.Defaults.CredentialsStore.Token; got != "defaults-vault-secret" {
+		t.Errorf("defaults token got=%s", got)
+	}
+}
+
+func TestKubernetesResources(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeFile(t, "asup/payload/big_payload.json",
+		`{"Harvest": {"MaxRssBytes": 2147483648}, "Collectors": [{"InstanceInfo": {"Count": 60000}}, {"InstanceInfo": {"Count": 40000}}]}`)
+	writeFile(t, "asup/payload/small_payload.json",
+		`{"Harvest": {"MaxRssBytes": 1048576}, "Collectors": [{"InstanceInfo": {"Count": 1000}}, {"Name": "no instances"}]}`)
+
+	tests := []struct {
+		poller string
+		want   KubernetesResources
+	}{
+		// max RSS * 1.25 is larger than 64Mi + 100k * 16KiB
+		{poller: "big", want: KubernetesResources{CPU: "1100m", Memory: "2560Mi", MemoryLimit: "5120Mi"}},
+		// 64Mi + 1000 * 16KiB, rounded up
+		{poller: "small", want: KubernetesResources{CPU: "100m", Memory: "80Mi", MemoryLimit: "160Mi"}},
+		{poller: "missing", want: KubernetesResources{CPU: "100m", Memory: "128Mi", MemoryLimit: "256Mi"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.poller, func(t *testing.T) {
+			got, _ := kubernetesResources(tt.poller)
+			if got.CPU != tt.want.CPU || got.Memory != tt.want.Memory || got.MemoryLimit != tt.want.MemoryLimit {
+				t.Errorf("got=%+v want=%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKubernetesName(t *testing.T) {
+	seen := make(map[string]bool)
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "harvest-Cluster_02.example", want: "harvest-cluster-02-example"},
+		{name: "harvest-cluster-02-example", want: "harvest-cluster-02-example-2"},
+		{name: "harvest-" + strings.Repeat("a", 70), want: "harvest-" + strings.Repeat("a", 50)},
+	}
+	for _, tt := range tests {
+		if got := kubernetesName(tt.name, seen); got != tt.want {
+			t.Errorf("kubernetesName(%s) got=%s want=%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func writeFile(t *testing.T, name string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func decodeDocs(t *testing.T, data []byte) []map[string]any {
+	t.Helper()
+	var docs []map[string]any
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc map[string]any
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to decode manifests err=%v", err)
+		}
+		if doc != nil {
+			docs = append(docs, doc)
+		}
+	}
+	return docs
+}
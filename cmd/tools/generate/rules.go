@@ -0,0 +1,184 @@
+package generate
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var rulesCmd = &cobra.Command{
+	Use:   "recording-rules",
+	Short: "generate Prometheus recording rules that cook the raw counters exported by perf collectors",
+	Long: `Generate Prometheus recording rules that cook the raw counters exported by RestPerf, ZapiPerf, and KeyPerf
+collectors when export_raw is enabled. The rules reproduce the rates, averages, and percents Harvest calculates.
+The raw counters are read from the Prometheus endpoint of a running poller.`,
+	Run: doRecordingRules,
+}
+
+// rawCounterHelpRe matches the HELP metadata of raw counters, e.g.
+// # HELP volume_read_latency_total Raw counter for volume. property=average base=volume_read_ops_total
+var rawCounterHelpRe = regexp.MustCompile(`^# HELP (\S+) Raw counter for \S+\. property=(\w+)(?: base=(\S+))?`)
+
+type rawCounterDef struct {
+	name     string
+	property string
+	base     string
+}
+
+type recordingRule struct {
+	Record string `yaml:"record"`
+	Expr   string `yaml:"expr"`
+}
+
+type ruleGroup struct {
+	Name  string          `yaml:"name"`
+	Rules []recordingRule `yaml:"rules"`
+}
+
+type ruleFile struct {
+	Groups []ruleGroup `yaml:"groups"`
+}
+
+func doRecordingRules(cmd *cobra.Command, _ []string) {
+	addRootOptions(cmd)
+
+	window, err := time.ParseDuration(opts.window)
+	if err != nil || window <= 0 {
+		logErrAndExit(fmt.Errorf("invalid window=%s", opts.window))
+	}
+
+	url := opts.rulesURL
+	if url == "" {
+		if _, err := conf.LoadHarvestConfig(opts.configPath); err != nil {
+			logErrAndExit(err)
+		}
+		port, err := conf.GetLastPromPort(opts.Poller, false)
+		if err != nil {
+			logErrAndExit(err)
+		}
+		//goland:noinspection HttpUrlsUsage
+		url = "http://localhost:" + strconv.Itoa(port) + "/metrics"
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	response, err := client.Get(url)
+	if err != nil {
+		logErrAndExit(err)
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		logErrAndExit(fmt.Errorf("unable to scrape %s status=%s", url, response.Status))
+	}
+
+	counters, err := parseRawCounters(response.Body)
+	if err != nil {
+		logErrAndExit(err)
+	}
+	if len(counters) == 0 {
+		logErrAndExit(fmt.Errorf("no raw counters found at %s, is export_raw enabled for the poller's perf collectors?", url))
+	}
+
+	out := os.Stdout
+	if opts.outputPath != "" {
+		out, err = os.Create(opts.outputPath)
+		if err != nil {
+			logErrAndExit(err)
+		}
+		//goland:noinspection GoUnhandledErrorResult
+		defer out.Close()
+	}
+	if err := writeRecordingRules(out, counters, window); err != nil {
+		logErrAndExit(err)
+	}
+}
+
+// parseRawCounters reads the raw counter definitions from the HELP metadata of a Prometheus exposition
+func parseRawCounters(r io.Reader) ([]rawCounterDef, error) {
+	var counters []rawCounterDef
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		m := rawCounterHelpRe.FindStringSubmatch(scanner.Text())
+		if m == nil || seen[m[1]] {
+			continue
+		}
+		seen[m[1]] = true
+		counters = append(counters, rawCounterDef{name: m[1], property: m[2], base: m[3]})
+	}
+	slices.SortFunc(counters, func(a, b rawCounterDef) int { return strings.Compare(a.name, b.name) })
+	return counters, scanner.Err()
+}
+
+// recordingRules returns a rule for each raw counter that reproduces the metric Harvest cooks from it.
+// Averages and percents only keep series whose base counter increased, like Harvest skips them otherwise.
+// Rules are named level:metric:operation, e.g. harvest:volume_read_ops:rate5m, so they do not collide with the
+// cooked metrics Harvest exports
+func recordingRules(counters []rawCounterDef, window time.Duration) []recordingRule {
+	win := formatWindow(window)
+	w := "[" + win + "]"
+	rules := make([]recordingRule, 0, len(counters))
+	for _, c := range counters {
+		var rule recordingRule
+		var operation string
+		switch c.property {
+		case "rate":
+			operation = "rate"
+			rule.Expr = "rate(" + c.name + w + ")"
+		case "delta":
+			operation = "increase"
+			rule.Expr = "increase(" + c.name + w + ")"
+		case "average", "percent":
+			if c.base == "" {
+				continue
+			}
+			operation = "avg"
+			rule.Expr = "rate(" + c.name + w + ") / (rate(" + c.base + w + ") > 0)"
+			if c.property == "percent" {
+				operation = "percent"
+				rule.Expr = "100 * " + rule.Expr
+			}
+		default:
+			continue
+		}
+		rule.Record = "harvest:" + strings.TrimSuffix(c.name, "_total") + ":" + operation + win
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func writeRecordingRules(w io.Writer, counters []rawCounterDef, window time.Duration) error {
+	file := ruleFile{Groups: []ruleGroup{{
+		Name:  "harvest_raw_counters",
+		Rules: recordingRules(counters, window),
+	}}}
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(file); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// formatWindow formats a duration the way PromQL expects, e.g. 5m instead of 5m0s
+func formatWindow(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return strconv.FormatInt(int64(d/time.Hour), 10) + "h"
+	case d%time.Minute == 0:
+		return strconv.FormatInt(int64(d/time.Minute), 10) + "m"
+	default:
+		return strconv.FormatInt(int64(d/time.Second), 10) + "s"
+	}
+}
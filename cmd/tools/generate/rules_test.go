@@ -0,0 +1,51 @@
+package generate
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordingRules(t *testing.T) {
+	exposition := `# HELP volume_read_latency_total Raw counter for volume. property=average base=volume_read_ops_total
+# TYPE volume_read_latency_total counter
+volume_read_latency_total{volume="vol1"} 5000
+# HELP volume_read_ops_total Raw counter for volume. property=rate
+# TYPE volume_read_ops_total counter
+volume_read_ops_total{volume="vol1"} 100
+# HELP node_cpu_busy_total Raw counter for node. property=percent base=node_cpu_elapsed_time_total
+# HELP node_cpu_elapsed_time_total Raw counter for node. property=delta
+# HELP volume_size Metric for volume
+# TYPE volume_size gauge
+volume_size{volume="vol1"} 10
+`
+	counters, err := parseRawCounters(strings.NewReader(exposition))
+	if err != nil {
+		t.Fatalf("expected no error got %+v", err)
+	}
+	if len(counters) != 4 {
+		t.Fatalf("got %d counters, want 4", len(counters))
+	}
+
+	var out bytes.Buffer
+	if err := writeRecordingRules(&out, counters, 5*time.Minute); err != nil {
+		t.Fatalf("expected no error got %+v", err)
+	}
+
+	want := `groups:
+  - name: harvest_raw_counters
+    rules:
+      - record: harvest:node_cpu_busy:percent5m
+        expr: 100 * rate(node_cpu_busy_total[5m]) / (rate(node_cpu_elapsed_time_total[5m]) > 0)
+      - record: harvest:node_cpu_elapsed_time:increase5m
+        expr: increase(node_cpu_elapsed_time_total[5m])
+      - record: harvest:volume_read_latency:avg5m
+        expr: rate(volume_read_latency_total[5m]) / (rate(volume_read_ops_total[5m]) > 0)
+      - record: harvest:volume_read_ops:rate5m
+        expr: rate(volume_read_ops_total[5m])
+`
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
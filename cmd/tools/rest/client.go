@@ -127,7 +127,7 @@ func (c *Client) GetPlainRest(request string, encodeURL bool, headers ...map[str
 	}
 	if pollerAuth.AuthToken != "" {
 		c.request.Header.Set("Authorization", "Bearer "+pollerAuth.AuthToken)
-		c.Logger.Debug("Using authToken from credential script")
+		c.Logger.Debug("Using authToken")
 	} else if pollerAuth.Username != "" {
 		c.request.SetBasicAuth(pollerAuth.Username, pollerAuth.Password)
 	}
@@ -237,34 +237,29 @@ func (c *Client) invokeWithAuthRetry() ([]byte, error) {
 
 	body, err = doInvoke()
 
-	if err != nil {
-		var he errs.HarvestError
-		if errors.As(err, &he) {
-			// If this is an auth failure and the client is using a credential script,
-			// expire the current credentials, call the script again, update the client's password,
-			// and try again
-			if errors.Is(he, errs.ErrAuthFailed) {
-				pollerAuth, err2 := c.auth.GetPollerAuth()
-				if err2 != nil {
-					return nil, err2
-				}
-				if pollerAuth.HasCredentialScript {
-					c.auth.Expire()
-					pollerAuth2, err2 := c.auth.GetPollerAuth()
-					if err2 != nil {
-						return nil, err2
-					}
-					// If the credential script returns an authToken, use it without re-fetching
-					if pollerAuth.AuthToken != "" {
-						c.token = pollerAuth.AuthToken
-						c.request.Header.Set("Authorization", "Bearer "+c.token)
-						c.Logger.Debug("Using authToken from credential script")
-						return doInvoke()
-					}
-					c.request.SetBasicAuth(pollerAuth2.Username, pollerAuth2.Password)
-					return doInvoke()
-				}
+	// If this is an auth failure and the client is using refreshable credentials,
+	// expire the current credentials, call the script again, update the client's password,
+	// and try again
+	if err != nil && errors.Is(err, errs.ErrAuthFailed) {
+		pollerAuth, err2 := c.auth.GetPollerAuth()
+		if err2 != nil {
+			return nil, err2
+		}
+		if pollerAuth.IsRefreshable() {
+			c.auth.Expire()
+			pollerAuth2, err2 := c.auth.GetPollerAuth()
+			if err2 != nil {
+				return nil, err2
+			}
+			// If the credentials include an authToken, use it without re-fetching
+			if pollerAuth2.AuthToken != "" {
+				c.token = pollerAuth2.AuthToken
+				c.request.Header.Set("Authorization", "Bearer "+c.token)
+				c.Logger.Debug("Using authToken")
+				return doInvoke()
 			}
+			c.request.SetBasicAuth(pollerAuth2.Username, pollerAuth2.Password)
+			return doInvoke()
 		}
 	}
 	return body, err
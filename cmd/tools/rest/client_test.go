@@ -0,0 +1,84 @@
+package rest
+
+import (
+	"github.com/netapp/harvest/v2/pkg/auth"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_OAuth2(t *testing.T) {
+	var issued atomic.Int32
+	var accepted atomic.Int32
+	var revoked atomic.Int32
+
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "harvest" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		n := issued.Add(1)
+		_, _ = w.Write([]byte(`{"access_token":"token-` + strconv.Itoa(int(n)) + `","token_type":"Bearer","expires_in":3600}`))
+	}))
+	defer tokenServer.Close()
+
+	// The fake cluster rejects tokens that have been revoked
+	cluster := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := strconv.Atoi(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer token-"))
+		if err != nil || n <= int(revoked.Load()) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		accepted.Add(1)
+		_, _ = w.Write([]byte(`{"name":"cluster1","uuid":"1","version":{"generation":9,"major":16,"minor":1,"full":"9.16.1"}}`))
+	}))
+	defer cluster.Close()
+
+	conf.Config.Defaults = nil
+	err := conf.DecodeConfig([]byte(`
+Pollers:
+  test:
+    addr: ` + strings.TrimPrefix(cluster.URL, "https://") + `
+    use_insecure_tls: true
+    auth_style: oauth2
+    username: harvest
+    password: s3cret
+    oauth2:
+      token_url: ` + tokenServer.URL + `
+`))
+	if err != nil {
+		t.Fatalf("expected no error got %+v", err)
+	}
+	poller, err := conf.PollerNamed("test")
+	if err != nil {
+		t.Fatalf("expected no error got %+v", err)
+	}
+
+	credentials := auth.NewCredentials(poller, slog.Default())
+	client, err := New(poller, 5*time.Second, credentials)
+	if err != nil {
+		t.Fatalf("expected no error got %+v", err)
+	}
+	if err := client.Init(1, conf.Remote{}); err != nil {
+		t.Fatalf("expected no error got %+v", err)
+	}
+	if client.Remote().Version != "9.16.1" {
+		t.Errorf("got version=[%s], want version=[9.16.1]", client.Remote().Version)
+	}
+
+	// Simulate the authorization server revoking the cached token. The client should retry once with a new token.
+	revoked.Store(issued.Load())
+	if _, err := client.GetRest("api/cluster"); err != nil {
+		t.Fatalf("expected no error got %+v", err)
+	}
+	if issued.Load() != 2 || accepted.Load() != 2 {
+		t.Errorf("got issued=%d accepted=%d, want issued=2 accepted=2", issued.Load(), accepted.Load())
+	}
+}
@@ -315,7 +315,11 @@ func FetchForCli(client *Client, href string, records *[]any, downloadAll bool,
 		if err != nil {
 			return err
 		}
-		*curls = append(*curls, fmt.Sprintf("curl --user %s --insecure '%s%s'", pollerAuth.Username, client.baseURL, nextLink))
+		if pollerAuth.AuthToken != "" {
+			*curls = append(*curls, fmt.Sprintf("curl --header 'Authorization: Bearer $TOKEN' --insecure '%s%s'", client.baseURL, nextLink))
+		} else {
+			*curls = append(*curls, fmt.Sprintf("curl --user %s --insecure '%s%s'", pollerAuth.Username, client.baseURL, nextLink))
+		}
 
 		isNonIterRestCall := false
 		value := gjson.GetBytes(getRest, "records")
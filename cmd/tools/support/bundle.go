@@ -0,0 +1,133 @@
+package support
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+const manifestName = "manifest.json"
+
+// Entry is a file of the bundle, or a file that was left out of the bundle
+type Entry struct {
+	Name    string `json:"name"`
+	Size    int64  `json:"size"`
+	Source  string `json:"source,omitempty"`
+	Skipped string `json:"skipped,omitempty"` // why the file was left out
+}
+
+// Manifest describes the contents of a bundle. It is the last file of the bundle and does not count toward its size cap
+type Manifest struct {
+	Version  string    `json:"version"`
+	Created  time.Time `json:"created"`
+	Hostname string    `json:"hostname"`
+	Pollers  []string  `json:"pollers"`
+	MaxBytes int64     `json:"max_bytes"`
+	Bytes    int64     `json:"bytes"`
+	Entries  []Entry   `json:"entries"`
+}
+
+// bundle writes files to a gzipped tarball until the uncompressed size of the files reaches maxBytes.
+// Files that do not fit are listed in the manifest as skipped
+type bundle struct {
+	gz       *gzip.Writer
+	tw       *tar.Writer
+	dir      string // all files of the bundle are in this directory
+	maxBytes int64
+	manifest Manifest
+}
+
+func newBundle(w io.Writer, dir string, maxBytes int64, now time.Time) *bundle {
+	gz := gzip.NewWriter(w)
+	hostname, _ := os.Hostname()
+	return &bundle{
+		gz:       gz,
+		tw:       tar.NewWriter(gz),
+		dir:      dir,
+		maxBytes: maxBytes,
+		manifest: Manifest{
+			Created:  now,
+			Hostname: hostname,
+			MaxBytes: maxBytes,
+			Entries:  []Entry{},
+		},
+	}
+}
+
+// add writes data to the bundle as name, unless the bundle would exceed its size cap
+func (b *bundle) add(name string, data []byte, source string) error {
+	size := int64(len(data))
+	if !b.fits(size) {
+		b.skip(name, source, size, "size cap")
+		return nil
+	}
+	if err := b.write(name, data); err != nil {
+		return err
+	}
+	b.manifest.Bytes += size
+	b.manifest.Entries = append(b.manifest.Entries, Entry{Name: name, Size: size, Source: source})
+	return nil
+}
+
+// addFile writes the file at fp to the bundle as name. Files that cannot be read are skipped
+func (b *bundle) addFile(name string, fp string) error {
+	info, err := os.Stat(fp)
+	if err != nil {
+		b.skip(name, fp, 0, err.Error())
+		return nil
+	}
+	if !b.fits(info.Size()) {
+		b.skip(name, fp, info.Size(), "size cap")
+		return nil
+	}
+	data, err := os.ReadFile(fp)
+	if err != nil {
+		b.skip(name, fp, info.Size(), err.Error())
+		return nil
+	}
+	return b.add(name, data, fp)
+}
+
+func (b *bundle) fits(size int64) bool {
+	return b.maxBytes <= 0 || b.manifest.Bytes+size <= b.maxBytes
+}
+
+func (b *bundle) skip(name string, source string, size int64, reason string) {
+	b.manifest.Entries = append(b.manifest.Entries, Entry{Name: name, Size: size, Source: source, Skipped: reason})
+}
+
+func (b *bundle) write(name string, data []byte) error {
+	header := &tar.Header{
+		Name:    path.Join(b.dir, name),
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: b.manifest.Created,
+	}
+	if err := b.tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if _, err := b.tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// close writes the manifest and flushes the bundle
+func (b *bundle) close() error {
+	data, err := json.MarshalIndent(b.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := b.write(manifestName, data); err != nil {
+		return err
+	}
+	if err := b.tw.Close(); err != nil {
+		return err
+	}
+	return b.gz.Close()
+}
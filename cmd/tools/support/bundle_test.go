@@ -0,0 +1,88 @@
+package support
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func readBundle(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[header.Name] = content
+	}
+	return files
+}
+
+func TestBundleSizeCap(t *testing.T) {
+	var buf bytes.Buffer
+	b := newBundle(&buf, "bundle", 10, time.Now())
+	for _, f := range []struct{ name, content string }{
+		{name: "a.txt", content: "123456"},
+		{name: "b.txt", content: "123456"},
+		{name: "c.txt", content: "1234"},
+	} {
+		if err := b.add(f.name, []byte(f.content), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.addFile("missing.txt", "testdata/missing.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := readBundle(t, buf.Bytes())
+	if _, ok := files["bundle/a.txt"]; !ok {
+		t.Error("a.txt is missing")
+	}
+	if _, ok := files["bundle/b.txt"]; ok {
+		t.Error("b.txt exceeds the size cap")
+	}
+	if _, ok := files["bundle/c.txt"]; !ok {
+		t.Error("c.txt is missing")
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(files["bundle/"+manifestName], &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Bytes != 10 {
+		t.Errorf("bytes got=%d, want=10", manifest.Bytes)
+	}
+	skipped := make(map[string]string)
+	for _, e := range manifest.Entries {
+		if e.Skipped != "" {
+			skipped[e.Name] = e.Skipped
+		}
+	}
+	if skipped["b.txt"] != "size cap" {
+		t.Errorf("b.txt skipped got=%q, want=size cap", skipped["b.txt"])
+	}
+	if _, ok := skipped["missing.txt"]; !ok {
+		t.Error("missing.txt is not listed as skipped")
+	}
+}
@@ -0,0 +1,376 @@
+package support
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"github.com/netapp/harvest/v2/cmd/poller/collector"
+	"github.com/netapp/harvest/v2/cmd/poller/options"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/cmd/tools/doctor"
+	"github.com/netapp/harvest/v2/pkg/auth"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/logging"
+	"github.com/netapp/harvest/v2/pkg/tree"
+	harvestyaml "github.com/netapp/harvest/v2/pkg/tree/yaml"
+	"github.com/netapp/harvest/v2/pkg/util"
+	tw "github.com/netapp/harvest/v2/third_party/olekukonko/tablewriter"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// newestVersion picks the newest templates when the ONTAP version of a poller is unknown
+const newestVersion = "99.99.99"
+
+// collection gathers the diagnostics of pollers into a bundle
+type collection struct {
+	b        *bundle
+	confPath string
+	pollers  []string
+	statuses map[string][]util.PollerStatus
+	client   *http.Client
+	logFiles int
+	profiles bool
+	capture  bool
+}
+
+// collect adds the diagnostics to the bundle, most useful first, so the size cap drops the least useful files
+func (c *collection) collect(configPath string) error {
+	if err := c.config(configPath); err != nil {
+		return err
+	}
+	if err := c.status(); err != nil {
+		return err
+	}
+
+	templates := make(map[string][]collector.ObjectTemplate, len(c.pollers))
+	for _, name := range c.pollers {
+		poller := conf.Config.Pollers[name]
+		if err := c.b.addFile(path.Join("pollers", name, "autosupport.json"), collector.PayloadPath(name)); err != nil {
+			return err
+		}
+		if err := c.metadata(name); err != nil {
+			return err
+		}
+		objects, err := c.templates(name, poller)
+		if err != nil {
+			return err
+		}
+		templates[name] = objects
+	}
+	for _, name := range c.pollers {
+		if err := c.logs(name); err != nil {
+			return err
+		}
+	}
+	if c.profiles {
+		for _, name := range c.pollers {
+			if err := c.pprof(name); err != nil {
+				return err
+			}
+		}
+	}
+	if c.capture {
+		for _, name := range c.pollers {
+			if err := c.captures(name, conf.Config.Pollers[name], templates[name]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *collection) config(configPath string) error {
+	out, err := doctor.RedactedConfig(configPath, false)
+	if err != nil {
+		c.b.skip("harvest.yml", configPath, 0, err.Error())
+		return nil
+	}
+	return c.b.add("harvest.yml", []byte(out), configPath)
+}
+
+// status writes the same table as harvest status --long
+func (c *collection) status() error {
+	var buf bytes.Buffer
+	table := tw.NewWriter(&buf)
+	table.SetBorder(false)
+	table.SetAutoFormatHeaders(false)
+	table.SetHeader([]string{"Datacenter", "Poller", "PID", "PromPort", "Profiling", "Status"})
+	table.SetColumnAlignment([]int{tw.ALIGN_LEFT, tw.ALIGN_LEFT, tw.ALIGN_RIGHT, tw.ALIGN_RIGHT, tw.ALIGN_RIGHT})
+	for _, name := range c.pollers {
+		poller := conf.Config.Pollers[name]
+		statuses := c.statuses[name]
+		if len(statuses) == 0 {
+			status := util.StatusNotRunning
+			if poller.IsDisabled {
+				status = util.StatusDisabled
+			}
+			statuses = []util.PollerStatus{{Status: status}}
+		}
+		for i, s := range statuses {
+			pn := name
+			if i > 0 {
+				pn = "+" + name
+			}
+			pid := ""
+			if s.Pid != 0 {
+				pid = strconv.Itoa(int(s.Pid))
+			}
+			table.Append([]string{poller.Datacenter, pn, pid, s.PromPort, s.ProfilingPort, string(s.Status)})
+		}
+	}
+	table.Render()
+	return c.b.add("status.txt", buf.Bytes(), "harvest status --long")
+}
+
+// payloadVersion returns the ONTAP version from the last AutoSupport payload of a poller
+func payloadVersion(pollerName string) string {
+	payload, err := collector.ReadPayload(pollerName)
+	if err != nil || payload.Target == nil {
+		return ""
+	}
+	return payload.Target.Version
+}
+
+// running returns the status of the running poller, if any
+func (c *collection) running(name string) (util.PollerStatus, bool) {
+	for _, s := range c.statuses[name] {
+		if s.Status == util.StatusRunning {
+			return s, true
+		}
+	}
+	return util.PollerStatus{}, false
+}
+
+// metadata writes the metadata_ metrics that a running poller exports to Prometheus
+func (c *collection) metadata(name string) error {
+	fileName := path.Join("pollers", name, "metadata.prom")
+	status, ok := c.running(name)
+	if !ok || status.PromPort == "" {
+		c.b.skip(fileName, "", 0, "poller is not running or does not export to Prometheus")
+		return nil
+	}
+	url := "http://localhost:" + status.PromPort + "/metrics"
+	data, err := c.get(url)
+	if err != nil {
+		c.b.skip(fileName, url, 0, err.Error())
+		return nil
+	}
+	var buf bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		metric := strings.TrimPrefix(strings.TrimPrefix(line, "# HELP "), "# TYPE ")
+		if strings.HasPrefix(metric, "metadata_") {
+			buf.WriteString(line)
+			buf.WriteByte('\n')
+		}
+	}
+	return c.b.add(fileName, buf.Bytes(), url)
+}
+
+// pprof writes the heap and goroutine profiles of a running poller started with --profiling
+func (c *collection) pprof(name string) error {
+	status, ok := c.running(name)
+	if !ok || status.ProfilingPort == "" {
+		c.b.skip(path.Join("pollers", name, "heap.pprof"), "", 0, "poller is not running with profiling enabled")
+		return nil
+	}
+	profiles := []struct{ fileName, query string }{
+		{fileName: "heap.pprof", query: "heap"},
+		{fileName: "goroutine.txt", query: "goroutine?debug=2"},
+	}
+	for _, p := range profiles {
+		fileName := path.Join("pollers", name, p.fileName)
+		url := "http://localhost:" + status.ProfilingPort + "/debug/pprof/" + p.query
+		data, err := c.get(url)
+		if err != nil {
+			c.b.skip(fileName, url, 0, err.Error())
+			continue
+		}
+		if err := c.b.add(fileName, data, url); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *collection) get(url string) ([]byte, error) {
+	resp, err := c.client.Get(url) //nolint:noctx
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// logs writes the newest log files of a poller, including rotated ones
+func (c *collection) logs(name string) error {
+	logDir := logging.GetLogPath()
+	current := filepath.Join(logDir, "poller_"+name+".log")
+	rotated, _ := filepath.Glob(filepath.Join(logDir, "poller_"+name+"-*.log*"))
+	files := append([]string{current}, rotated...)
+
+	type logFile struct {
+		path    string
+		modTime time.Time
+	}
+	found := make([]logFile, 0, len(files))
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+		found = append(found, logFile{path: f, modTime: info.ModTime()})
+	}
+	if len(found) == 0 {
+		c.b.skip(path.Join("logs", filepath.Base(current)), current, 0, "no log files")
+		return nil
+	}
+	slices.SortFunc(found, func(a, b logFile) int {
+		return b.modTime.Compare(a.modTime)
+	})
+	for i, f := range found {
+		fileName := path.Join("logs", filepath.Base(f.path))
+		if i >= c.logFiles {
+			c.b.skip(fileName, f.path, 0, "older than the "+strconv.Itoa(c.logFiles)+" newest log files")
+			continue
+		}
+		if err := c.b.addFile(fileName, f.path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// templates writes the effective template of each object of a poller, and returns them
+func (c *collection) templates(name string, poller *conf.Poller) ([]collector.ObjectTemplate, error) {
+	ontapVersion := cmp.Or(payloadVersion(name), newestVersion)
+	bestFit := "# best-fit for ONTAP " + ontapVersion
+	if ontapVersion == newestVersion {
+		bestFit = "# best-fit for the newest ONTAP version, the ONTAP version of the poller is unknown"
+	}
+	confPaths := filepath.SplitList(c.confPath)
+
+	var all []collector.ObjectTemplate
+	for _, col := range poller.Collectors {
+		dir := path.Join("templates", name, strings.ToLower(col.Name))
+		objects, err := collector.ObjectTemplates(col, confPaths, c.confPath, ontapVersion)
+		if err != nil {
+			c.b.skip(dir, "", 0, err.Error())
+			continue
+		}
+		for _, o := range objects {
+			fileName := path.Join(dir, o.Object+".yaml")
+			if o.Template == nil {
+				c.b.skip(fileName, o.Path, 0, "no best-fit template")
+				continue
+			}
+			data, err := harvestyaml.Dump(o.Template)
+			if err != nil {
+				c.b.skip(fileName, o.Path, 0, err.Error())
+				continue
+			}
+			header := bestFit + "\n# " + o.Path + "\n"
+			if err := c.b.add(fileName, append([]byte(header), data...), o.Path); err != nil {
+				return nil, err
+			}
+			all = append(all, o)
+		}
+	}
+	return all, nil
+}
+
+// captures polls each object of a poller once in record mode, and writes the recorded requests and responses.
+// The recorder does not record the Authorization header
+func (c *collection) captures(name string, poller *conf.Poller, objects []collector.ObjectTemplate) error {
+	var logs bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	for _, o := range objects {
+		dir := path.Join("captures", name, strings.ToLower(o.Class), o.Object)
+		tmp, err := os.MkdirTemp("", "harvest-capture-")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("capturing %s %s %s\n", name, o.Class, o.Object)
+		if err := capture(name, poller, o, c.confPath, tmp); err != nil {
+			c.b.skip(dir, "", 0, err.Error())
+		}
+		err = filepath.WalkDir(tmp, func(fp string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			return c.b.addFile(path.Join(dir, d.Name()), fp)
+		})
+		_ = os.RemoveAll(tmp)
+		if err != nil {
+			return err
+		}
+	}
+	return c.b.add(path.Join("captures", name, "capture.log"), logs.Bytes(), "")
+}
+
+// capture runs each task of an object's collector once, with the poller's recorder writing to dir
+func capture(pollerName string, poller *conf.Poller, o collector.ObjectTemplate, confPath string, dir string) error {
+	p := *poller
+	p.Recorder = conf.Recorder{Path: dir, Mode: "record"}
+
+	params := o.Params.Copy()
+	if o.FileName != "" {
+		params.PopChildS("objects")
+		params.NewChildS("objects", "").NewChildS(o.Object, o.FileName)
+	}
+	out, err := yaml.Marshal(&p)
+	if err != nil {
+		return err
+	}
+	pollerParams, err := tree.LoadYaml(out)
+	if err != nil {
+		return err
+	}
+	params.Union(pollerParams)
+	params.NewChildS("poller_name", pollerName)
+
+	moduleName := "harvest.collector." + strings.ToLower(o.Class)
+	mod, err := plugin.GetModule(moduleName)
+	if err != nil {
+		return fmt.Errorf("collector %s does not support captures: %w", o.Class, err)
+	}
+	col, ok := mod.New().(collector.Collector)
+	if !ok {
+		return errs.New(errs.ErrNoCollector, o.Class)
+	}
+	opts := options.New(options.WithConfPath(confPath))
+	opts.Poller = pollerName
+	ac := collector.New(o.Class, o.Object, opts, params, auth.NewCredentials(&p, slog.Default()), conf.Remote{})
+	if err := col.Init(ac); err != nil {
+		return err
+	}
+	var pollErrs []error
+	for _, task := range ac.Schedule.GetTasks() {
+		if _, err := task.Run(); err != nil {
+			pollErrs = append(pollErrs, fmt.Errorf("poll %s err: %w", task.Name, err))
+		}
+	}
+	return errors.Join(pollErrs...)
+}
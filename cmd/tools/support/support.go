@@ -0,0 +1,134 @@
+// Package support builds diagnostic bundles to attach to support cases
+package support
+
+import (
+	"errors"
+	"fmt"
+	_ "github.com/netapp/harvest/v2/cmd/collectors/keyperf"
+	_ "github.com/netapp/harvest/v2/cmd/collectors/rest"
+	_ "github.com/netapp/harvest/v2/cmd/collectors/restperf"
+	_ "github.com/netapp/harvest/v2/cmd/collectors/zapi/collector"
+	_ "github.com/netapp/harvest/v2/cmd/collectors/zapiperf"
+	"github.com/netapp/harvest/v2/cmd/harvest/version"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/util"
+	"github.com/spf13/cobra"
+	"net/http"
+	"os"
+	"time"
+)
+
+type bundleOptions struct {
+	output   string
+	maxMB    int
+	logFiles int
+	profiles bool
+	capture  bool
+}
+
+var opts = &bundleOptions{}
+
+var Cmd = &cobra.Command{
+	Use:   "support",
+	Short: "Collect diagnostics for support cases",
+}
+
+var bundleCmd = &cobra.Command{
+	Use:   "bundle [POLLER...]",
+	Short: "Create a tarball of diagnostics for all or individual pollers",
+	Long: `Create a tarball of diagnostics for all or individual pollers.
+The bundle contains the redacted Harvest config, the status of the pollers, the effective template of each object,
+the newest log files, the last AutoSupport payload, and the metadata metrics of running pollers.
+Optionally, it contains pprof profiles of pollers started with --profiling and a capture of one poll of each object.
+manifest.json lists the files of the bundle and the files that were left out.`,
+	Args: cobra.ArbitraryArgs,
+	Run:  doBundle,
+}
+
+func doBundle(cmd *cobra.Command, args []string) {
+	configPath := conf.ConfigPath(cmd.Root().PersistentFlags().Lookup("config").Value.String())
+	confPath := cmd.Root().PersistentFlags().Lookup("confpath").Value.String()
+
+	if _, err := conf.LoadHarvestConfig(configPath); err != nil {
+		fmt.Printf("error reading config %s err=%+v\n", configPath, err)
+		os.Exit(1)
+	}
+	pollers := conf.Config.PollersOrdered
+	if len(args) > 0 {
+		for _, name := range args {
+			if _, ok := conf.Config.Pollers[name]; !ok {
+				fmt.Printf("poller [%s] not defined\n", name)
+				os.Exit(1)
+			}
+		}
+		pollers = args
+	}
+
+	now := time.Now()
+	name := "harvest_support_" + now.Format("20060102_150405")
+	output := opts.output
+	if output == "" {
+		output = name + ".tar.gz"
+	}
+	f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		fmt.Printf("error creating bundle %s err=%+v\n", output, err)
+		os.Exit(1)
+	}
+
+	b := newBundle(f, name, int64(opts.maxMB)*1024*1024, now)
+	b.manifest.Version = version.String()
+	b.manifest.Pollers = pollers
+
+	c := &collection{
+		b:        b,
+		confPath: confPath,
+		pollers:  pollers,
+		statuses: pollerStatuses(),
+		client:   &http.Client{Timeout: 30 * time.Second},
+		logFiles: opts.logFiles,
+		profiles: opts.profiles,
+		capture:  opts.capture,
+	}
+	if err := c.collect(configPath); err != nil {
+		_ = f.Close()
+		fmt.Printf("error creating bundle %s err=%+v\n", output, err)
+		os.Exit(1)
+	}
+	if err := errors.Join(b.close(), f.Close()); err != nil {
+		fmt.Printf("error creating bundle %s err=%+v\n", output, err)
+		os.Exit(1)
+	}
+
+	skipped := 0
+	for _, e := range b.manifest.Entries {
+		if e.Skipped != "" {
+			skipped++
+		}
+	}
+	fmt.Printf("Wrote %s: %d files, %d bytes uncompressed, %d files left out, see %s\n",
+		output, len(b.manifest.Entries)-skipped, b.manifest.Bytes, skipped, manifestName)
+}
+
+func pollerStatuses() map[string][]util.PollerStatus {
+	statusesByName := make(map[string][]util.PollerStatus)
+	statuses, err := util.GetPollerStatuses()
+	if err != nil {
+		fmt.Printf("Unable to GetPollerStatuses err: %+v\n", err)
+		return statusesByName
+	}
+	for _, status := range statuses {
+		statusesByName[status.Name] = append(statusesByName[status.Name], status)
+	}
+	return statusesByName
+}
+
+func init() {
+	Cmd.AddCommand(bundleCmd)
+	flags := bundleCmd.Flags()
+	flags.StringVarP(&opts.output, "output", "o", "", "Path of the bundle, defaults to harvest_support_<time>.tar.gz")
+	flags.IntVar(&opts.maxMB, "max-size", 100, "Maximum uncompressed size of the bundle in MB, 0 for no limit")
+	flags.IntVar(&opts.logFiles, "logs", 3, "Number of newest log files to include per poller")
+	flags.BoolVar(&opts.profiles, "profiles", false, "Include heap and goroutine profiles of pollers started with --profiling")
+	flags.BoolVar(&opts.capture, "capture", false, "Poll each object once in record mode and include the recorded requests and responses")
+}
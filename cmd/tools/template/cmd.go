@@ -0,0 +1,111 @@
+package template
+
+import (
+	"fmt"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+)
+
+type convertOptions struct {
+	output     string
+	verify     bool
+	zapiPoller string
+	restPoller string
+}
+
+var opts = &convertOptions{}
+
+var Cmd = &cobra.Command{
+	Use:   "template",
+	Short: "Work with templates",
+}
+
+var convertCmd = &cobra.Command{
+	Use:   "convert <template>",
+	Short: "Convert a Zapi or ZapiPerf template to a Rest or RestPerf template",
+	Long: `Convert a Zapi or ZapiPerf template to a Rest or RestPerf template.
+Counters are converted with the ZAPI to REST mapping that Harvest maintains and with the stock template of the same object.
+Counters that cannot be converted are reported and left as comments in the converted template.`,
+	Args: cobra.ExactArgs(1),
+	Run:  doConvert,
+}
+
+func doConvert(cmd *cobra.Command, args []string) {
+	confPath := cmd.Root().PersistentFlags().Lookup("confpath").Value.String()
+	path := args[0]
+	fileName := filepath.Base(path)
+
+	source, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Printf("error reading template %s err=%+v\n", path, err)
+		os.Exit(1)
+	}
+	mapping, err := ReadMapping()
+	if err != nil {
+		fmt.Printf("error reading mapping err=%+v\n", err)
+		os.Exit(1)
+	}
+	c, err := Convert(source, fileName, confPath, mapping)
+	if err != nil {
+		fmt.Printf("error converting template %s err=%+v\n", path, err)
+		os.Exit(1)
+	}
+
+	if opts.output == "" {
+		fmt.Print(string(c.Template))
+	} else if err := os.WriteFile(opts.output, c.Template, 0600); err != nil {
+		fmt.Printf("error writing template %s err=%+v\n", opts.output, err)
+		os.Exit(1)
+	}
+
+	// Print the report to stderr, so stdout can be redirected to a file
+	report := os.Stderr
+	_, _ = fmt.Fprintf(report, "Converted %s template %s to %s query %s: %d counters converted, %d not converted\n",
+		c.From, path, c.To, c.Query, c.Mapped, len(c.Unmapped))
+	for _, u := range c.Unmapped {
+		_, _ = fmt.Fprintf(report, "  not converted: %s => %s: %s\n", u.Counter, u.Display, u.Reason)
+	}
+	for _, note := range c.Notes {
+		_, _ = fmt.Fprintf(report, "  note: %s\n", note)
+	}
+
+	if !opts.verify {
+		return
+	}
+	config := cmd.Root().PersistentFlags().Lookup("config").Value.String()
+	if _, err := conf.LoadHarvestConfig(config); err != nil {
+		fmt.Printf("error reading config %s err=%+v\n", config, err)
+		os.Exit(1)
+	}
+	v, err := Verify(c, source, fileName, confPath, opts.zapiPoller, opts.restPoller)
+	if err != nil {
+		fmt.Printf("error verifying template err=%+v\n", err)
+		os.Exit(1)
+	}
+	_, _ = fmt.Fprintf(report, "Verified with recorder captures: %d instances matched, %d only in %s, %d only in %s, %d differences\n",
+		v.Instances, len(v.OnlyZapi), c.From, len(v.OnlyRest), c.To, len(v.Diffs))
+	for _, id := range v.OnlyZapi {
+		_, _ = fmt.Fprintf(report, "  only in %s: %s\n", c.From, id)
+	}
+	for _, id := range v.OnlyRest {
+		_, _ = fmt.Fprintf(report, "  only in %s: %s\n", c.To, id)
+	}
+	for _, diff := range v.Diffs {
+		_, _ = fmt.Fprintf(report, "  diff: %s\n", diff)
+	}
+	if len(v.OnlyZapi)+len(v.OnlyRest)+len(v.Diffs) > 0 {
+		os.Exit(1)
+	}
+}
+
+func init() {
+	Cmd.AddCommand(convertCmd)
+	flags := convertCmd.Flags()
+	flags.StringVarP(&opts.output, "output", "o", "", "Write the converted template to this file instead of stdout")
+	flags.BoolVar(&opts.verify, "verify", false, "Verify the converted template by replaying recorder captures of both APIs")
+	flags.StringVar(&opts.zapiPoller, "zapi-poller", "", "Poller that replays ZAPI recorder captures, used with --verify")
+	flags.StringVar(&opts.restPoller, "rest-poller", "", "Poller that replays REST recorder captures, used with --verify")
+	convertCmd.MarkFlagsRequiredTogether("verify", "zapi-poller", "rest-poller")
+}
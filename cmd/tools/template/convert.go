@@ -0,0 +1,368 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"github.com/netapp/harvest/v2/pkg/tree"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"github.com/netapp/harvest/v2/pkg/util"
+	"github.com/netapp/harvest/v2/third_party/go-version"
+	y3 "gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// restPerfTablePrefix is the RestPerf endpoint of a ZapiPerf object without a mapping
+const restPerfTablePrefix = "api/cluster/counter/tables/"
+
+// carriedKeys are the template parameters that mean the same to the Zapi and Rest collectors
+var carriedKeys = []string{"client_timeout", "export_data", "export_options", "jitter", "override", "plugins", "schedule"}
+
+var builtInPlugins = []string{"Aggregator", "ChangeLog", "LabelAgent", "Max", "MetricAgent"}
+
+// Conversion is a Rest or RestPerf template converted from a Zapi or ZapiPerf template
+type Conversion struct {
+	From     string // Zapi or ZapiPerf
+	To       string // Rest or RestPerf
+	Name     string
+	Query    string
+	Object   string
+	Keys     []string // display names of the instance keys
+	Mapped   int
+	Unmapped []Unmapped
+	Notes    []string
+	Template []byte
+}
+
+// Unmapped is a ZAPI counter that was not converted
+type Unmapped struct {
+	Counter string
+	Display string
+	Reason  string
+}
+
+type zapiCounter struct {
+	key     string // dotted path of a ZAPI attribute or the name of a ZapiPerf counter
+	display string
+	sigil   string
+}
+
+type restCounter struct {
+	field   string
+	display string
+	sigil   string
+}
+
+// Convert converts a Zapi or ZapiPerf template into a Rest or RestPerf template.
+// Counters are converted with the ZAPI to REST mapping and, failing that, by matching display names with the
+// stock template of the same object found on confPath. fileName is used to prefer the stock template with the same name
+func Convert(data []byte, fileName string, confPath string, mapping Mapping) (Conversion, error) {
+	var c Conversion
+
+	t, err := tree.LoadYaml(data)
+	if err != nil {
+		return c, fmt.Errorf("failed to load template err: %w", err)
+	}
+	root := &y3.Node{}
+	if err := y3.Unmarshal(data, root); err != nil {
+		return c, fmt.Errorf("failed to unmarshal template err: %w", err)
+	}
+	if len(root.Content) == 0 || root.Content[0].Kind != y3.MappingNode {
+		return c, errors.New("template is empty")
+	}
+
+	c.Name = t.GetChildContentS("name")
+	query := t.GetChildContentS("query")
+	c.Object = t.GetChildContentS("object")
+	counters := t.GetChildS("counters")
+	switch {
+	case c.Name == "":
+		return c, errors.New("template has no name")
+	case query == "":
+		return c, errors.New("template has no query")
+	case c.Object == "":
+		return c, errors.New("template has no object")
+	case counters == nil:
+		return c, errors.New("template has no counters")
+	}
+
+	// ZAPI names are hyphenated, e.g. volume-get-iter, while perf objects are not, e.g. volume or disk:constituent
+	var zapiCounters []zapiCounter
+	if strings.Contains(query, "-") {
+		c.From, c.To = "Zapi", "Rest"
+		for _, child := range counters.GetChildren() {
+			zapiCounters = parseZapiCounters(child, nil, c.Object, zapiCounters)
+		}
+	} else {
+		c.From, c.To = "ZapiPerf", "RestPerf"
+		zapiCounters = parseZapiPerfCounters(counters)
+	}
+
+	stockQuery, stock := stockTemplate(confPath, c.To, c.Object, fileName)
+	switch {
+	case stockQuery != "":
+		c.Query = stockQuery
+	case len(mapping.restEndpoints(query)) > 0:
+		c.Query = mapping.restEndpoints(query)[0]
+	case c.From == "ZapiPerf":
+		c.Query = restPerfTablePrefix + strings.ReplaceAll(query, ":", "_")
+		c.Notes = append(c.Notes, "no mapping for perf object "+query+", check that the counter table "+c.Query+" exists")
+	default:
+		return c, fmt.Errorf("no REST endpoint is known for %s", query)
+	}
+
+	restCounters := c.convertCounters(zapiCounters, query, stock, mapping)
+	if len(c.Keys) == 0 {
+		c.Notes = append(c.Notes, "none of the instance keys were converted, the "+c.To+" collector needs at least one")
+	}
+
+	c.Template, err = c.render(root.Content[0], restCounters)
+	if err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+func (c *Conversion) convertCounters(zapiCounters []zapiCounter, query string, stock map[string]restCounter, mapping Mapping) []restCounter {
+	var restCounters []restCounter
+	seen := make(map[string]string)
+
+	for _, zc := range zapiCounters {
+		var field, reason string
+		if m, ok := mapping.lookup(query, zc.key, c.Query); ok {
+			switch {
+			case strings.Contains(m.RestCounter, ","):
+				reason = "REST derives it from " + m.RestCounter
+			case m.RestEndpoint != c.Query:
+				reason = "REST collects it as " + m.RestCounter + " from " + m.RestEndpoint
+			default:
+				field = m.RestCounter
+			}
+		}
+		if field == "" {
+			if s, ok := stock[zc.display]; ok {
+				field, reason = s.field, ""
+			}
+		}
+		if field == "" && reason == "" {
+			reason = "no REST field is known for this counter"
+		}
+		if other, ok := seen[field]; ok && field != "" {
+			reason = "maps to " + field + " like " + other
+			field = ""
+		}
+		if field == "" {
+			c.Unmapped = append(c.Unmapped, Unmapped{Counter: zc.sigil + zc.key, Display: zc.display, Reason: reason})
+			continue
+		}
+		seen[field] = zc.key
+		restCounters = append(restCounters, restCounter{field: field, display: zc.display, sigil: zc.sigil})
+		if zc.sigil == "^^" {
+			c.Keys = append(c.Keys, zc.display)
+		}
+		c.Mapped++
+	}
+	return restCounters
+}
+
+// render writes the converted template. Template parameters that both collectors understand are copied as is,
+// comments included, the others are dropped with a note
+func (c *Conversion) render(source *y3.Node, counters []restCounter) ([]byte, error) {
+	var b strings.Builder
+
+	_, _ = fmt.Fprintf(&b, "%-26s%s\n", "name:", c.Name)
+	_, _ = fmt.Fprintf(&b, "%-26s%s\n", "query:", c.Query)
+	_, _ = fmt.Fprintf(&b, "%-26s%s\n", "object:", c.Object)
+
+	// keys first, then labels, then metrics, like the stock templates
+	slices.SortStableFunc(counters, func(a, b restCounter) int {
+		if len(a.sigil) != len(b.sigil) {
+			return len(b.sigil) - len(a.sigil)
+		}
+		return strings.Compare(a.field, b.field)
+	})
+	width := 0
+	for _, rc := range counters {
+		width = max(width, len(rc.sigil+rc.field))
+	}
+	b.WriteString("\ncounters:\n")
+	for _, rc := range counters {
+		if rc.display == strings.ReplaceAll(rc.field, ".", "_") {
+			_, _ = fmt.Fprintf(&b, "  - %s\n", rc.sigil+rc.field)
+			continue
+		}
+		_, _ = fmt.Fprintf(&b, "  - %-*s => %s\n", width, rc.sigil+rc.field, rc.display)
+	}
+	if len(c.Unmapped) > 0 {
+		b.WriteString("# The following " + c.From + " counters could not be converted\n")
+		for _, u := range c.Unmapped {
+			_, _ = fmt.Fprintf(&b, "#  - %s => %s  # %s\n", u.Counter, u.Display, u.Reason)
+		}
+	}
+
+	for i := 0; i+1 < len(source.Content); i += 2 {
+		key, value := source.Content[i], source.Content[i+1]
+		switch {
+		case slices.Contains([]string{"name", "query", "object", "counters"}, key.Value):
+			continue
+		case !slices.Contains(carriedKeys, key.Value):
+			c.Notes = append(c.Notes, "dropped "+key.Value+", the "+c.To+" collector does not support it")
+			continue
+		case key.Value == "plugins":
+			c.notePlugins(value)
+		}
+		out, err := marshalKey(key, value)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString("\n")
+		b.Write(out)
+	}
+
+	// make sure the result is valid yaml
+	if err := y3.Unmarshal([]byte(b.String()), &y3.Node{}); err != nil {
+		return nil, fmt.Errorf("converted template is invalid err: %w", err)
+	}
+	return []byte(b.String()), nil
+}
+
+func (c *Conversion) notePlugins(plugins *y3.Node) {
+	for _, p := range plugins.Content {
+		name := p.Value
+		if p.Kind == y3.MappingNode && len(p.Content) > 0 {
+			name = p.Content[0].Value
+		}
+		if !slices.Contains(builtInPlugins, name) {
+			c.Notes = append(c.Notes, "plugin "+name+" is not built-in, check that the "+c.To+" collector implements it")
+		}
+	}
+}
+
+func marshalKey(key *y3.Node, value *y3.Node) ([]byte, error) {
+	var b strings.Builder
+	enc := y3.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(&y3.Node{Kind: y3.MappingNode, Content: []*y3.Node{key, value}}); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return []byte(b.String()), nil
+}
+
+// parseZapiCounters walks the attribute tree of a Zapi template the same way the Zapi collector does
+func parseZapiCounters(elem *node.Node, path []string, object string, counters []zapiCounter) []zapiCounter {
+	newPath := path
+	if name := elem.GetNameS(); name != "" {
+		newPath = append(slices.Clip(path), name)
+	}
+	if content := elem.GetContentS(); content != "" {
+		name, display, sigil := splitCounter(content)
+		fullPath := append(slices.Clip(newPath), name)
+		if display == "" {
+			display = util.ParseZAPIDisplay(object, fullPath)
+		}
+		counters = append(counters, zapiCounter{key: strings.Join(fullPath, "."), display: display, sigil: sigil})
+	}
+	for _, child := range elem.GetChildren() {
+		counters = parseZapiCounters(child, newPath, object, counters)
+	}
+	return counters
+}
+
+func parseZapiPerfCounters(counters *node.Node) []zapiCounter {
+	var zapiCounters []zapiCounter
+	for _, content := range counters.GetAllChildContentS() {
+		if content == "" {
+			continue
+		}
+		name, display, sigil := splitCounter(content)
+		if display == "" {
+			display = name
+		}
+		zapiCounters = append(zapiCounters, zapiCounter{key: name, display: display, sigil: sigil})
+	}
+	return zapiCounters
+}
+
+// splitCounter splits a template counter, e.g. ^^instance_name => volume, into its name, display name, and sigil
+func splitCounter(content string) (string, string, string) {
+	name, display, _ := strings.Cut(content, "=>")
+	name = strings.TrimSpace(name)
+	trimmed := strings.TrimLeft(name, "^")
+	sigil := name[:min(len(name)-len(trimmed), 2)]
+	return strings.TrimSpace(trimmed), strings.TrimSpace(display), sigil
+}
+
+// stockTemplate returns the query and counters, by display name, of the newest stock template of object.
+// A template named fileName is preferred over other templates of the same object
+func stockTemplate(confPath string, collectorName string, object string, fileName string) (string, map[string]restCounter) {
+	for _, cp := range filepath.SplitList(confPath) {
+		dir := filepath.Join(cp, strings.ToLower(collectorName))
+		for _, versionDir := range versionDirs(dir) {
+			files, err := os.ReadDir(versionDir)
+			if err != nil {
+				continue
+			}
+			names := make([]string, 0, len(files))
+			for _, f := range files {
+				if !f.IsDir() && strings.HasSuffix(f.Name(), ".yaml") {
+					names = append(names, f.Name())
+				}
+			}
+			if i := slices.Index(names, fileName); i > 0 {
+				names[0], names[i] = names[i], names[0]
+			}
+			for _, name := range names {
+				t, err := tree.ImportYaml(filepath.Join(versionDir, name))
+				if err != nil || t == nil || t.GetChildContentS("object") != object {
+					continue
+				}
+				counters := make(map[string]restCounter)
+				if cs := t.GetChildS("counters"); cs != nil {
+					for _, content := range cs.GetAllChildContentS() {
+						field, display, kind, _ := util.ParseMetric(content)
+						rc := restCounter{field: field, display: display}
+						switch kind {
+						case "key":
+							rc.sigil = "^^"
+						case "label":
+							rc.sigil = "^"
+						}
+						counters[display] = rc
+					}
+				}
+				return t.GetChildContentS("query"), counters
+			}
+		}
+	}
+	return "", nil
+}
+
+// versionDirs returns the version subdirectories of dir, newest first
+func versionDirs(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var versions []*version.Version
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if v, err := version.NewVersion(e.Name()); err == nil {
+			versions = append(versions, v)
+		}
+	}
+	slices.SortFunc(versions, func(a, b *version.Version) int {
+		return b.Compare(a)
+	})
+	dirs := make([]string, 0, len(versions))
+	for _, v := range versions {
+		dirs = append(dirs, filepath.Join(dir, v.Original()))
+	}
+	return dirs
+}
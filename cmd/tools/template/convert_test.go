@@ -0,0 +1,150 @@
+package template
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestConvert(t *testing.T) {
+	mapping, err := ReadMapping()
+	if err != nil {
+		t.Fatalf("failed to read mapping err: %v", err)
+	}
+
+	tests := []struct {
+		file      string
+		from      string
+		query     string
+		keys      []string
+		counters  []string
+		unmapped  []string
+		notes     []string
+		carried   []string
+		unchanged []string
+	}{
+		{
+			file:  "volume.yaml",
+			from:  "Zapi",
+			query: "api/private/cli/volume",
+			keys:  []string{"volume", "svm"},
+			counters: []string{
+				"^^volume",
+				"^^vserver => svm",
+				"^aggr_list => aggr",
+				"^volume_style_extended => style",
+				"max_autosize => autosize_maximum_size",
+				"expected_available => space_expected_available",
+				"size",
+			},
+			unmapped: []string{"^volume-attributes.volume-performance-attributes.is-atime-update-enabled"},
+			notes: []string{
+				"dropped batch_size, the Rest collector does not support it",
+				"plugin Volume is not built-in, check that the Rest collector implements it",
+			},
+			carried: []string{"client_timeout: 2m", "# exclude root volumes", "instance_labels:"},
+		},
+		{
+			file:  "volume_perf.yaml",
+			from:  "ZapiPerf",
+			query: "api/cluster/counter/tables/volume",
+			keys:  []string{"uuid"},
+			counters: []string{
+				"^^uuid",
+				"^name => volume",
+				"^svm.name => svm",
+				"average_latency => avg_latency",
+				"nfs.access_latency",
+				"total_read_ops => read_ops",
+			},
+			unmapped: []string{"wvblk_past_eof"},
+			notes:    []string{"dropped instance_key, the RestPerf collector does not support it"},
+			carried:  []string{"instance_keys:"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", "convert", tt.file))
+			if err != nil {
+				t.Fatalf("failed to read template err: %v", err)
+			}
+			c, err := Convert(data, tt.file, "../../../conf", mapping)
+			if err != nil {
+				t.Fatalf("failed to convert template err: %v", err)
+			}
+
+			if c.From != tt.from {
+				t.Errorf("From got=%s, want=%s", c.From, tt.from)
+			}
+			if c.Query != tt.query {
+				t.Errorf("Query got=%s, want=%s", c.Query, tt.query)
+			}
+			if !slices.Equal(c.Keys, tt.keys) {
+				t.Errorf("Keys got=%v, want=%v", c.Keys, tt.keys)
+			}
+
+			model, err := unmarshalModel(c.Template)
+			if err != nil {
+				t.Fatalf("converted template is invalid err: %v\n%s", err, c.Template)
+			}
+			if model.Query != tt.query {
+				t.Errorf("template query got=%s, want=%s", model.Query, tt.query)
+			}
+			var lines []string
+			for _, m := range model.metrics {
+				line := m.line
+				if m.right != "" {
+					line = strings.TrimSpace(strings.Split(m.line, "=>")[0]) + " => " + m.right
+				}
+				lines = append(lines, line)
+			}
+			for _, want := range tt.counters {
+				if !slices.Contains(lines, want) {
+					t.Errorf("counter %q is missing, got=%v", want, lines)
+				}
+			}
+
+			var unmapped []string
+			for _, u := range c.Unmapped {
+				unmapped = append(unmapped, u.Counter)
+				if !strings.Contains(string(c.Template), "#  - "+u.Counter+" => ") {
+					t.Errorf("unmapped counter %s is not flagged in the template", u.Counter)
+				}
+			}
+			if !slices.Equal(unmapped, tt.unmapped) {
+				t.Errorf("Unmapped got=%v, want=%v", unmapped, tt.unmapped)
+			}
+			if !slices.Equal(c.Notes, tt.notes) {
+				t.Errorf("Notes got=%v, want=%v", c.Notes, tt.notes)
+			}
+			for _, want := range tt.carried {
+				if !strings.Contains(string(c.Template), want) {
+					t.Errorf("%q was not carried over\n%s", want, c.Template)
+				}
+			}
+		})
+	}
+}
+
+func TestReadMapping(t *testing.T) {
+	mapping, err := ReadMapping()
+	if err != nil {
+		t.Fatalf("failed to read mapping err: %v", err)
+	}
+	m, ok := mapping.lookup("volume-get-iter", "volume-attributes.volume-space-attributes.size", "api/private/cli/volume")
+	if !ok || m.RestCounter != "size" {
+		t.Errorf("lookup volume size got=%+v, want=size", m)
+	}
+
+	// writing the mapping again does not change it
+	var b strings.Builder
+	if err := WriteMapping(&b, mapping); err != nil {
+		t.Fatalf("failed to write mapping err: %v", err)
+	}
+	if b.String() != string(mappingYaml) {
+		t.Error("WriteMapping output differs from zapi_rest.yaml")
+	}
+}
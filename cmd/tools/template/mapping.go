@@ -0,0 +1,112 @@
+package template
+
+import (
+	_ "embed"
+	"fmt"
+	y3 "gopkg.in/yaml.v3"
+	"io"
+	"slices"
+	"strings"
+)
+
+// MappingPath is where harvest generate metrics writes the ZAPI to REST mapping
+const MappingPath = "cmd/tools/template/zapi_rest.yaml"
+
+const mappingHeader = `# Code generated by harvest generate metrics. DO NOT EDIT.
+# Each entry relates a ZAPI attribute or ZapiPerf counter to the REST field or RestPerf counter
+# that Harvest exports as the same metric. harvest template convert uses it to convert templates.
+`
+
+//go:embed zapi_rest.yaml
+var mappingYaml []byte
+
+// Mapping relates ZAPI attributes to REST fields
+type Mapping struct {
+	Counters []CounterMapping `yaml:"counters"`
+}
+
+// CounterMapping relates a counter of a ZAPI endpoint to a counter of a REST endpoint.
+// ZAPI counters are the dotted path of the attribute, e.g. volume-attributes.volume-space-attributes.size,
+// ZapiPerf endpoints are the perf object, e.g. volume
+type CounterMapping struct {
+	Metric       string `yaml:"metric"`
+	ZapiEndpoint string `yaml:"zapi_endpoint"`
+	ZapiCounter  string `yaml:"zapi_counter"`
+	RestEndpoint string `yaml:"rest_endpoint"`
+	RestCounter  string `yaml:"rest_counter"`
+}
+
+// ReadMapping returns the ZAPI to REST mapping that is embedded in Harvest
+func ReadMapping() (Mapping, error) {
+	var m Mapping
+	if err := y3.Unmarshal(mappingYaml, &m); err != nil {
+		return Mapping{}, fmt.Errorf("failed to unmarshal mapping err: %w", err)
+	}
+	return m, nil
+}
+
+// WriteMapping sorts the mapping and writes it to w in the format of zapi_rest.yaml
+func WriteMapping(w io.Writer, m Mapping) error {
+	counters := slices.Clone(m.Counters)
+	slices.SortFunc(counters, func(a, b CounterMapping) int {
+		if c := strings.Compare(a.ZapiEndpoint, b.ZapiEndpoint); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.ZapiCounter, b.ZapiCounter); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Metric, b.Metric)
+	})
+	counters = slices.Compact(counters)
+
+	if _, err := io.WriteString(w, mappingHeader); err != nil {
+		return err
+	}
+	enc := y3.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(Mapping{Counters: counters}); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// lookup returns the mapping of a ZAPI counter, preferring the one whose REST endpoint is query
+func (m Mapping) lookup(zapiEndpoint string, zapiCounter string, query string) (CounterMapping, bool) {
+	var (
+		found CounterMapping
+		ok    bool
+	)
+	for _, c := range m.Counters {
+		if c.ZapiEndpoint != zapiEndpoint || c.ZapiCounter != zapiCounter {
+			continue
+		}
+		if c.RestEndpoint == query {
+			return c, true
+		}
+		if !ok {
+			found, ok = c, true
+		}
+	}
+	return found, ok
+}
+
+// restEndpoints returns the REST endpoints of a ZAPI endpoint, most mapped counters first
+func (m Mapping) restEndpoints(zapiEndpoint string) []string {
+	counts := make(map[string]int)
+	for _, c := range m.Counters {
+		if c.ZapiEndpoint == zapiEndpoint {
+			counts[c.RestEndpoint]++
+		}
+	}
+	endpoints := make([]string, 0, len(counts))
+	for e := range counts {
+		endpoints = append(endpoints, e)
+	}
+	slices.SortFunc(endpoints, func(a, b string) int {
+		if counts[a] != counts[b] {
+			return counts[b] - counts[a]
+		}
+		return strings.Compare(a, b)
+	})
+	return endpoints
+}
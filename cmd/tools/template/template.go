@@ -327,7 +327,14 @@ func readAggregator(template *node.Node, model *Model) error {
 			return err
 		}
 		model.pluginLabels = append(model.pluginLabels, agg.NewLabels()...)
-		model.MultiplierMetrics = append(model.MultiplierMetrics, agg.NewMetrics()...)
+		// rules without functions aggregate every metric, rules with functions create metrics of their own object
+		for _, metric := range agg.NewMetrics() {
+			if metric.Object != "" {
+				model.PluginMetrics = append(model.PluginMetrics, metric)
+			} else {
+				model.MultiplierMetrics = append(model.MultiplierMetrics, metric)
+			}
+		}
 	}
 
 	return nil
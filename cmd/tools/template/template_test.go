@@ -3,6 +3,7 @@ package template
 import (
 	"bytes"
 	"fmt"
+	"github.com/netapp/harvest/v2/pkg/tree"
 	"github.com/netapp/harvest/v2/pkg/util"
 	y3 "gopkg.in/yaml.v3"
 	"io/fs"
@@ -584,3 +585,34 @@ func shortPath(path string) string {
 	}
 	return path
 }
+
+func TestReadAggregatorFunctions(t *testing.T) {
+	template, err := tree.LoadYaml([]byte(`
+name: Volume
+query: api/storage/volumes
+object: volume
+plugins:
+  - Aggregator:
+      - node
+      - svm,node<>svm_node count p90(read_latency) online=count_if(state=online)
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var model Model
+	if err := readAggregator(template, &model); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(model.MultiplierMetrics) != 1 || model.MultiplierMetrics[0].Name != "node" {
+		t.Errorf("got multiplier metrics %+v, want node", model.MultiplierMetrics)
+	}
+	var got []string
+	for _, m := range model.PluginMetrics {
+		got = append(got, m.Object+"_"+m.Name)
+	}
+	want := []string{"svm_node_count", "svm_node_read_latency_p90", "svm_node_online"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got plugin metrics %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,242 @@
+package template
+
+import (
+	"fmt"
+	_ "github.com/netapp/harvest/v2/cmd/collectors/rest"
+	_ "github.com/netapp/harvest/v2/cmd/collectors/restperf"
+	_ "github.com/netapp/harvest/v2/cmd/collectors/zapi/collector"
+	_ "github.com/netapp/harvest/v2/cmd/collectors/zapiperf"
+	"github.com/netapp/harvest/v2/cmd/poller/collector"
+	"github.com/netapp/harvest/v2/cmd/poller/options"
+	"github.com/netapp/harvest/v2/cmd/poller/plugin"
+	"github.com/netapp/harvest/v2/pkg/auth"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/tree"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"gopkg.in/yaml.v3"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// verifyVersion is the version directory the templates are written to. It is the only version available,
+// so the collectors pick it regardless of the cluster's version
+const verifyVersion = "0.0.0"
+
+// Verification compares what the Zapi and Rest collectors collect with the source and converted template
+type Verification struct {
+	Instances int      // instances collected by both
+	OnlyZapi  []string // instances only collected by the Zapi collector
+	OnlyRest  []string // instances only collected by the Rest collector
+	Diffs     []string
+}
+
+// Verify polls the source template with zapiPoller and the converted template with restPoller and compares the results.
+// Both pollers must replay recorder captures, so the comparison is offline and repeatable. RestPerf and ZapiPerf
+// compute rates from consecutive polls, which are identical when replayed, so only the presence of perf
+// metrics is compared
+func Verify(c Conversion, source []byte, fileName string, confPath string, zapiPoller string, restPoller string) (Verification, error) {
+	var v Verification
+
+	// The collectors look for templates relative to HARVEST_CONF
+	homePath := conf.Path("")
+	tmp, err := os.MkdirTemp(homePath, "harvest-convert-")
+	if err != nil {
+		return v, err
+	}
+	defer os.RemoveAll(tmp)
+	tmpConf, err := filepath.Rel(homePath, tmp)
+	if homePath == "" || err != nil {
+		tmpConf = tmp
+	}
+
+	zapiMat, err := pollReplay(zapiPoller, c.From, c.Name, fileName, source, tmp, tmpConf, confPath)
+	if err != nil {
+		return v, fmt.Errorf("%s poller %s err: %w", c.From, zapiPoller, err)
+	}
+	restMat, err := pollReplay(restPoller, c.To, c.Name, fileName, c.Template, tmp, tmpConf, confPath)
+	if err != nil {
+		return v, fmt.Errorf("%s poller %s err: %w", c.To, restPoller, err)
+	}
+
+	v.compare(zapiMat, restMat, c.Keys, c.From == "ZapiPerf")
+	return v, nil
+}
+
+// pollReplay runs the collector of class with the template until it has polled data, and returns the data matrix
+func pollReplay(pollerName, class, object, fileName string, template []byte, tmp, tmpConf, confPath string) (*matrix.Matrix, error) {
+	poller, err := conf.PollerNamed(pollerName)
+	if err != nil {
+		return nil, err
+	}
+	if poller.Recorder.Mode != "replay" {
+		return nil, fmt.Errorf("poller does not replay recorder captures, set its recorder mode to replay")
+	}
+
+	// Zapi templates are split by cluster model, only cDOT is supported
+	dir := filepath.Join(tmp, strings.ToLower(class))
+	if strings.HasPrefix(class, "Zapi") {
+		dir = filepath.Join(dir, "cdot")
+	}
+	dir = filepath.Join(dir, verifyVersion)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, fileName), template, 0600); err != nil {
+		return nil, err
+	}
+
+	params, err := verifyParams(pollerName, poller, class, object, fileName, confPath)
+	if err != nil {
+		return nil, err
+	}
+
+	name := "harvest.collector." + strings.ToLower(class)
+	mod, err := plugin.GetModule(name)
+	if err != nil {
+		return nil, fmt.Errorf("error getting module %s err: %w", name, err)
+	}
+	col, ok := mod.New().(collector.Collector)
+	if !ok {
+		return nil, errs.New(errs.ErrNoCollector, class)
+	}
+	opts := options.New(options.WithConfPath(tmpConf))
+	opts.Poller = pollerName
+	ac := collector.New(class, object, opts, params, auth.NewCredentials(poller, slog.Default()), conf.Remote{})
+	if err := col.Init(ac); err != nil {
+		return nil, err
+	}
+
+	// perf collectors need two data polls before they export anything
+	rounds := 1
+	if strings.HasSuffix(class, "Perf") {
+		rounds = 2
+	}
+	var data map[string]*matrix.Matrix
+	for range rounds {
+		for _, task := range ac.Schedule.GetTasks() {
+			result, err := task.Run()
+			if err != nil {
+				return nil, fmt.Errorf("poll %s err: %w", task.Name, err)
+			}
+			if task.Name == "data" {
+				data = result
+			}
+		}
+	}
+	mat, ok := data[object]
+	if !ok {
+		return nil, errs.New(errs.ErrNoInstance, "no data for "+object)
+	}
+	return mat, nil
+}
+
+// verifyParams returns the parameters of a collector with a single object. The schedule comes from the collector's
+// default.yaml on confPath, the connection details from the poller
+func verifyParams(pollerName string, poller *conf.Poller, class, object, fileName, confPath string) (*node.Node, error) {
+	params, err := collector.ImportTemplate(filepath.SplitList(confPath), "default.yaml", class)
+	if err != nil {
+		params = node.NewS("")
+		schedule := params.NewChildS("schedule", "")
+		if strings.HasSuffix(class, "Perf") {
+			schedule.NewChildS("counter", "24h")
+			schedule.NewChildS("instance", "10m")
+		}
+		schedule.NewChildS("data", "1m")
+	}
+	params.PopChildS("objects")
+	params.NewChildS("objects", "").NewChildS(object, fileName)
+
+	out, err := yaml.Marshal(poller)
+	if err != nil {
+		return nil, err
+	}
+	pollerParams, err := tree.LoadYaml(out)
+	if err != nil {
+		return nil, err
+	}
+	params.Union(pollerParams)
+	params.NewChildS("poller_name", pollerName)
+	return params, nil
+}
+
+// compare matches instances by the values of their instance keys and compares their labels and metrics
+func (v *Verification) compare(zapiMat, restMat *matrix.Matrix, keys []string, perf bool) {
+	zapiInstances := instancesByKeys(zapiMat, keys)
+	restInstances := instancesByKeys(restMat, keys)
+	zapiMetrics := metricsByName(zapiMat)
+	restMetrics := metricsByName(restMat)
+
+	for name := range zapiMetrics {
+		if _, ok := restMetrics[name]; !ok {
+			v.Diffs = append(v.Diffs, "metric "+name+" is not collected with the converted template")
+		}
+	}
+
+	for id, zi := range zapiInstances {
+		ri, ok := restInstances[id]
+		if !ok {
+			v.OnlyZapi = append(v.OnlyZapi, id)
+			continue
+		}
+		v.Instances++
+		restLabels := ri.GetLabels()
+		for label, zValue := range zi.Labels() {
+			if rValue, ok := restLabels[label]; ok && rValue != zValue {
+				v.Diffs = append(v.Diffs, fmt.Sprintf("%s label %s: %q != %q", id, label, zValue, rValue))
+			}
+		}
+		for name, zm := range zapiMetrics {
+			rm, ok := restMetrics[name]
+			if !ok {
+				continue
+			}
+			zValue, zok := zm.GetValueFloat64(zi)
+			rValue, rok := rm.GetValueFloat64(ri)
+			switch {
+			case zok && !rok:
+				v.Diffs = append(v.Diffs, fmt.Sprintf("%s metric %s is missing", id, name))
+			case zok && !perf && zValue != rValue:
+				v.Diffs = append(v.Diffs, fmt.Sprintf("%s metric %s: %g != %g", id, name, zValue, rValue))
+			}
+		}
+	}
+	for id := range restInstances {
+		if _, ok := zapiInstances[id]; !ok {
+			v.OnlyRest = append(v.OnlyRest, id)
+		}
+	}
+	slices.Sort(v.OnlyZapi)
+	slices.Sort(v.OnlyRest)
+	slices.Sort(v.Diffs)
+}
+
+func instancesByKeys(mat *matrix.Matrix, keys []string) map[string]*matrix.Instance {
+	instances := make(map[string]*matrix.Instance)
+	for key, instance := range mat.GetInstances() {
+		id := key
+		if len(keys) > 0 {
+			values := make([]string, 0, len(keys))
+			for _, k := range keys {
+				values = append(values, k+"="+instance.GetLabel(k))
+			}
+			id = strings.Join(values, ",")
+		}
+		instances[id] = instance
+	}
+	return instances
+}
+
+func metricsByName(mat *matrix.Matrix) map[string]*matrix.Metric {
+	metrics := make(map[string]*matrix.Metric)
+	for _, m := range mat.GetMetrics() {
+		if m.IsExportable() {
+			metrics[m.GetName()] = m
+		}
+	}
+	return metrics
+}
@@ -33,10 +33,12 @@ label: [string]: string
 	add_meta_tags?: bool
 	addr?:          string // deprecated
 	allow_addrs_regex?: [...string]
+	cardinality?:     #Cardinality
 	exporter:         "Prometheus"
 	local_http_addr?: "0.0.0.0" | "localhost" | "127.0.0.1"
 	port?:            int
 	port_range?:      string
+	relabel_configs?: [...#Relabel]
 	sort_labels?:     bool
 	tls?:             #TLS
 }
@@ -44,11 +46,40 @@ label: [string]: string
 #Influx: {
 	addr?: string // one of addr|url
 	allow_addrs_regex: [...string]
-	bucket?:  string
-	exporter: "InfluxDB"
+	bucket?:      string
+	cardinality?: #Cardinality
+	exporter:     "InfluxDB"
 	org?:     string
-	token?:   string
-	url?:     string
+	relabel_configs?: [...#Relabel]
+	token?: string
+	url?:   string
+}
+
+#Relabel: {
+	action?:        "replace" | "keep" | "drop" | "hashmod" | "labeldrop" | "labelkeep"
+	source_labels?: [...string]
+	separator?:     string
+	regex?:         string
+	target_label?:  string
+	replacement?:   string
+	modulus?:       int
+}
+
+#CardinalityBudget: {
+	max_instances?: int
+	max_series?:    int
+	sort_by?:       string
+	other?:         bool
+}
+
+#Cardinality: {
+	#CardinalityBudget
+	objects?: [Name=_]: #CardinalityBudget
+}
+
+#CounterState: {
+	dir:      string
+	max_age?: string
 }
 
 #CertificateScript: {
@@ -62,6 +93,37 @@ label: [string]: string
 	timeout?:  string
 }
 
+#CredentialsStore: {
+	type:            "vault" | "kubernetes" | "env"
+	schedule?:       string
+	timeout?:        string
+	username_key?:   string
+	password_key?:   string
+	path?:           string
+	addr?:           string
+	mount?:          string
+	namespace?:      string
+	ca_cert?:        string
+	token?:          string
+	token_file?:     string
+	approle_mount?:  string
+	role_id?:        string
+	secret_id?:      string
+	secret_id_file?: string
+	username_env?:   string
+	password_env?:   string
+}
+
+#OAuth2: {
+	token_url:  string
+	client_id?: string
+	scopes?: [...string]
+	audience?: string
+	ca_cert?:  string
+	mtls?:     bool
+	timeout?:  string
+}
+
 #Recorder: {
 	path: string
 	mode: "record" | "replay"
@@ -76,14 +138,16 @@ Pollers: [Name=_]: #Poller
 
 #Poller: {
 	addr?:               string
-	auth_style?:         "basic_auth" | "certificate_auth"
+	auth_style?:         "basic_auth" | "certificate_auth" | "oauth2"
 	ca_cert?:            string
 	certificate_script?: #CertificateScript
 	client_timeout?:     string
 	collectors?:         [...#CollectorDef] | [...string]
 	conf_path?:          string
+	counter_state?:      #CounterState
 	credentials_file?:   string
 	credentials_script?: #CredentialsScript
+	credentials_store?:  #CredentialsStore
 	datacenter?:         string
 	disabled?:           bool
 	exporters:           [...#ExporterDefs]
@@ -92,6 +156,7 @@ Pollers: [Name=_]: #Poller
 	log:                 [...string]
 	log_max_bytes?:      int
 	log_max_files?:      int
+	oauth2?:             #OAuth2
 	password?:           string
 	poller_log_schedule?: string
 	prefer_zapi?:        bool
@@ -416,58 +416,83 @@ func (c *Client) InvokeWithTimers(testFilePath string, headers ...map[string]str
 }
 
 func (c *Client) invokeWithAuthRetry(withTimers bool, headers ...map[string]string) (*node.Node, time.Duration, time.Duration, error) {
+	var (
+		root, result *node.Node
+		start        time.Time
+		parseT       time.Duration
+	)
+
+	body, responseT, err := c.sendWithAuthRetry(withTimers, headers...)
+	if err != nil {
+		return nil, responseT, parseT, err
+	}
+
+	// parse xml
+	if withTimers {
+		start = time.Now()
+	}
+	if root, err = tree.LoadXML(body); err != nil {
+		return nil, responseT, parseT, err
+	}
+	if withTimers {
+		parseT = time.Since(start)
+	}
+
+	// check if the request was successful
+	if result = root.GetChildS("results"); result == nil {
+		return nil, responseT, parseT, errs.New(errs.ErrAPIResponse, "missing \"results\"")
+	}
+	return result, responseT, parseT, statusError(result.GetAttrValueS)
+}
+
+// sendWithAuthRetry sends the request and returns the response body. When the credentials are refreshable and
+// rejected, they are refreshed and the request is sent again
+func (c *Client) sendWithAuthRetry(withTimers bool, headers ...map[string]string) ([]byte, time.Duration, error) {
 	var buffer bytes.Buffer
 	pollerAuth, err := c.auth.GetPollerAuth()
 	if err != nil {
-		return nil, 0, 0, err
+		return nil, 0, err
 	}
-	if pollerAuth.HasCredentialScript {
+	if pollerAuth.IsRefreshable() {
 		// Save the buffer in case it needs to be replayed after an auth failure
 		// This is required because Go clears the buffer when making a POST request
 		buffer = *c.buffer
 	}
 
-	resp, t1, t2, err := c.invoke(withTimers, headers...)
+	body, t1, err := c.send(withTimers, headers...)
 
 	if err != nil {
 		var he errs.HarvestError
 		if errors.As(err, &he) {
-			// If this is an auth failure and the client is using a credential script,
+			// If this is an auth failure and the client is using refreshable credentials,
 			// expire the current credentials, call the script again, update the client's password,
 			// and try again
-			if errors.Is(he, errs.ErrAuthFailed) && pollerAuth.HasCredentialScript {
+			if errors.Is(he, errs.ErrAuthFailed) && pollerAuth.IsRefreshable() {
 				c.auth.Expire()
 				pollerAuth2, err2 := c.auth.GetPollerAuth()
 				if err2 != nil {
-					return nil, 0, 0, err2
+					return nil, 0, err2
 				}
 				c.request.SetBasicAuth(pollerAuth2.Username, pollerAuth2.Password)
 				c.request.Body = io.NopCloser(&buffer)
 				c.request.ContentLength = int64(buffer.Len())
-				result2, s1, s2, err3 := c.invoke(withTimers)
-				u1 := t1.Nanoseconds() + s1.Nanoseconds()
-				u2 := t2.Nanoseconds() + s2.Nanoseconds()
-				return result2, time.Duration(u1) * time.Nanosecond, time.Duration(u2) * time.Nanosecond, err3
+				body2, t2, err3 := c.send(withTimers)
+				return body2, t1 + t2, err3
 			}
 		}
 	}
-	return resp, t1, t2, err
+	return body, t1, err
 }
 
-// invokes the request that has been built with one of the BuildRequest* methods
-func (c *Client) invoke(withTimers bool, headers ...map[string]string) (*node.Node, time.Duration, time.Duration, error) {
+// send sends the request that has been built with one of the BuildRequest* methods and returns the response body
+func (c *Client) send(withTimers bool, headers ...map[string]string) ([]byte, time.Duration, error) {
 
 	var (
-		root, result      *node.Node
-		response          *http.Response
-		start             time.Time
-		responseT, parseT time.Duration
-		body              []byte
-		status            string
-		reason            string
-		errNum            string
-		found             bool
-		err               error
+		response  *http.Response
+		start     time.Time
+		responseT time.Duration
+		body      []byte
+		err       error
 	)
 
 	//goland:noinspection GoUnhandledErrorResult
@@ -492,7 +517,7 @@ func (c *Client) invoke(withTimers bool, headers ...map[string]string) (*node.No
 	}
 
 	if response, err = c.client.Do(c.request); err != nil {
-		return result, responseT, parseT, errs.New(errs.ErrConnection, err.Error())
+		return nil, responseT, errs.New(errs.ErrConnection, err.Error())
 	}
 	//goland:noinspection GoUnhandledErrorResult
 	defer response.Body.Close()
@@ -502,58 +527,45 @@ func (c *Client) invoke(withTimers bool, headers ...map[string]string) (*node.No
 
 	if response.StatusCode != http.StatusOK {
 		if response.StatusCode == http.StatusUnauthorized {
-			return result, responseT, parseT, errs.New(errs.ErrAuthFailed, response.Status, errs.WithStatus(response.StatusCode))
+			return nil, responseT, errs.New(errs.ErrAuthFailed, response.Status, errs.WithStatus(response.StatusCode))
 		}
-		return result, responseT, parseT, errs.New(errs.ErrAPIResponse, response.Status, errs.WithStatus(response.StatusCode))
+		return nil, responseT, errs.New(errs.ErrAPIResponse, response.Status, errs.WithStatus(response.StatusCode))
 	}
 
 	// read response body
 	if body, err = io.ReadAll(response.Body); err != nil {
-		return result, responseT, parseT, err
+		return nil, responseT, err
 	}
-	defer c.printRequestAndResponse(zapiReq, body)
+	c.printRequestAndResponse(zapiReq, body)
 	if withTimers {
 		responseT = time.Since(start)
 	}
 
-	// parse xml
-	if withTimers {
-		start = time.Now()
-	}
-	if root, err = tree.LoadXML(body); err != nil {
-		return result, responseT, parseT, err
-	}
-	if withTimers {
-		parseT = time.Since(start)
-	}
-
 	c.Metadata.BytesRx += uint64(len(body))
 	c.Metadata.NumCalls++
 
-	// check if the request was successful
-	if result = root.GetChildS("results"); result == nil {
-		return nil, responseT, parseT, errs.New(errs.ErrAPIResponse, "missing \"results\"")
-	}
+	return body, responseT, nil
+}
 
-	if status, found = result.GetAttrValueS("status"); !found {
-		return result, responseT, parseT, errs.New(errs.ErrAPIResponse, "missing status attribute")
+// statusError returns the error of a results element whose status is not passed. attr returns the value of
+// the element's attributes
+func statusError(attr func(string) (string, bool)) error {
+	status, found := attr("status")
+	if !found {
+		return errs.New(errs.ErrAPIResponse, "missing status attribute")
 	}
-
-	if status != "passed" {
-		reason, _ = result.GetAttrValueS("reason")
-		if reason == "" {
-			reason = "no reason"
-		}
-		errNum, _ = result.GetAttrValueS("errno")
-		if errNum == errs.ZAPIPermissionDenied {
-			err = errs.New(errs.ErrPermissionDenied, reason, errs.WithErrorNum(errNum))
-		} else {
-			err = errs.New(errs.ErrAPIRequestRejected, reason, errs.WithErrorNum(errNum))
-		}
-		return result, responseT, parseT, err
+	if status == "passed" {
+		return nil
 	}
-
-	return result, responseT, parseT, nil
+	reason, _ := attr("reason")
+	if reason == "" {
+		reason = "no reason"
+	}
+	errNum, _ := attr("errno")
+	if errNum == errs.ZAPIPermissionDenied {
+		return errs.New(errs.ErrPermissionDenied, reason, errs.WithErrorNum(errNum))
+	}
+	return errs.New(errs.ErrAPIRequestRejected, reason, errs.WithErrorNum(errNum))
 }
 
 func (c *Client) TraceLogSet(collectorName string, config *node.Node) {
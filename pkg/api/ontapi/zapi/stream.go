@@ -0,0 +1,394 @@
+package zapi
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"io"
+	"os"
+	"time"
+)
+
+// PerfInstance is an instance-data element of a perf-object-get-instances response.
+// Its strings are substrings of the response. Clone the ones kept after the handler returns,
+// otherwise the whole response stays in memory
+type PerfInstance struct {
+	Counters    []PerfCounter
+	HasCounters bool   // false when the instance has no counters element
+	Aggregation string // result of the instance's aggregation-data, e.g., partial_aggregation
+	fields      []PerfCounter
+}
+
+// PerfCounter is a counter-data element of a PerfInstance
+type PerfCounter struct {
+	Name  string
+	Value string
+}
+
+// Field returns the content of the instance's first child element with name, e.g., name or uuid
+func (p *PerfInstance) Field(name string) string {
+	for _, f := range p.fields {
+		if f.Name == name {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+func (p *PerfInstance) reset() {
+	p.Counters = p.Counters[:0]
+	p.fields = p.fields[:0]
+	p.HasCounters = false
+	p.Aggregation = ""
+}
+
+// StreamResponse is the result of a streamed batch request
+type StreamResponse struct {
+	Tag     string // next tag, empty after the last batch
+	Records int    // number of elements passed to the handler
+	Rd      time.Duration
+	Pd      time.Duration
+}
+
+// InvokePerfWithTimers invokes the perf-object-get-instances request built with BuildRequest and calls handle with
+// each instance-data as it is decoded, instead of building the tree of the response. The instance passed to handle is
+// reused for the next one. Returns the number of instances, the API time, and the parse time, which includes the
+// time spent in handle. If testFilePath is non-empty, the response is read from it
+func (c *Client) InvokePerfWithTimers(testFilePath string, handle func(*PerfInstance) error, headers ...map[string]string) (int, time.Duration, time.Duration, error) {
+	data, rd, err := c.responseBody(testFilePath, headers...)
+	if err != nil {
+		return 0, rd, 0, err
+	}
+
+	start := time.Now()
+	r := newResponseDecoder(data)
+	r.text = string(data)
+	if err := r.results(); err != nil {
+		return 0, rd, time.Since(start), err
+	}
+	var instance PerfInstance
+	_, count, err := r.records([]string{"instances", "*"}, func(xml.StartElement) error {
+		instance.reset()
+		if err := r.perfInstance(&instance); err != nil {
+			return err
+		}
+		return handle(&instance)
+	})
+	return count, rd, time.Since(start), err
+}
+
+// InvokeBatchStream is like InvokeBatchRequest, but instead of returning the tree of the response, it calls handle
+// with each element of the results that matches path, as node.SearchChildren does. A path element of "*" matches
+// any element. Only the matching elements are built as trees.
+// The parse time includes the time spent in handle. If testFilePath is non-empty, the response is read from it
+func (c *Client) InvokeBatchStream(request *node.Node, tag string, testFilePath string, path []string, handle func(*node.Node) error, headers ...map[string]string) (StreamResponse, error) {
+	var response StreamResponse
+
+	if tag == "" {
+		return response, nil
+	}
+	if testFilePath == "" {
+		if tag != "initial" {
+			request.SetChildContentS("tag", tag)
+		}
+		if err := c.BuildRequest(request); err != nil {
+			return response, err
+		}
+	}
+
+	data, rd, err := c.responseBody(testFilePath, headers...)
+	response.Rd = rd
+	if err != nil {
+		return response, err
+	}
+
+	start := time.Now()
+	r := newResponseDecoder(data)
+	if err := r.results(); err != nil {
+		response.Pd = time.Since(start)
+		return response, err
+	}
+	nextTag, count, err := r.records(path, func(start xml.StartElement) error {
+		n, err := r.node(start)
+		if err != nil {
+			return err
+		}
+		return handle(n)
+	})
+	response.Pd = time.Since(start)
+	response.Records = count
+
+	// avoid ZAPI bug, see InvokeBatchWithTimers
+	if nextTag != tag && testFilePath == "" {
+		response.Tag = nextTag
+	}
+	return response, err
+}
+
+// responseBody sends the built request, or reads testFilePath when it is non-empty
+func (c *Client) responseBody(testFilePath string, headers ...map[string]string) ([]byte, time.Duration, error) {
+	if testFilePath != "" {
+		data, err := os.ReadFile(testFilePath)
+		return data, 0, err
+	}
+	return c.sendWithAuthRetry(true, headers...)
+}
+
+// responseDecoder decodes a ZAPI response token by token, without building its tree.
+// The content of an element is sliced from the response, like the innerxml of xml.Load,
+// so values are identical to the ones of the tree
+type responseDecoder struct {
+	data []byte
+	text string // optional copy of data as a string, when set, content returns substrings of it
+	dec  *xml.Decoder
+	off  int64 // offset of the last token
+}
+
+func newResponseDecoder(data []byte) *responseDecoder {
+	return &responseDecoder{data: data, dec: xml.NewDecoder(bytes.NewReader(data))}
+}
+
+func (r *responseDecoder) token() (xml.Token, error) {
+	r.off = r.dec.InputOffset()
+	return r.dec.RawToken()
+}
+
+// next returns the next child element of the current element. It returns false at the end of the current element
+func (r *responseDecoder) next() (xml.StartElement, bool, error) {
+	for {
+		t, err := r.token()
+		if err != nil {
+			return xml.StartElement{}, false, err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			return t, true, nil
+		case xml.EndElement:
+			return xml.StartElement{}, false, nil
+		}
+	}
+}
+
+// content consumes the current element and returns its inner XML
+func (r *responseDecoder) content() (string, error) {
+	begin := r.dec.InputOffset()
+	for depth := 0; ; {
+		t, err := r.token()
+		if err != nil {
+			return "", err
+		}
+		switch t.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			if depth == 0 {
+				if r.text != "" {
+					return r.text[begin:r.off], nil
+				}
+				return string(r.data[begin:r.off]), nil
+			}
+			depth--
+		}
+	}
+}
+
+// skip consumes the current element
+func (r *responseDecoder) skip() error {
+	_, err := r.content()
+	return err
+}
+
+// node consumes the current element and returns it as a tree
+func (r *responseDecoder) node(start xml.StartElement) (*node.Node, error) {
+	n := node.NewXMLS(start.Name.Local)
+	for _, attr := range start.Attr {
+		n.AddAttr(attr)
+	}
+	begin := r.dec.InputOffset()
+	for {
+		t, err := r.token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			child, err := r.node(t)
+			if err != nil {
+				return nil, err
+			}
+			n.AddChild(child)
+		case xml.EndElement:
+			n.Content = r.data[begin:r.off]
+			return n, nil
+		}
+	}
+}
+
+// results advances to the results element, the root of test files or a child of netapp, and returns an error when
+// the request was not successful
+func (r *responseDecoder) results() error {
+	for {
+		t, err := r.token()
+		if errors.Is(err, io.EOF) {
+			return errs.New(errs.ErrAPIResponse, "missing \"results\"")
+		}
+		if err != nil {
+			return err
+		}
+		if start, ok := t.(xml.StartElement); ok && start.Name.Local == "results" {
+			return statusError(func(name string) (string, bool) {
+				for _, attr := range start.Attr {
+					if attr.Name.Local == name {
+						return attr.Value, true
+					}
+				}
+				return "", false
+			})
+		}
+	}
+}
+
+// records calls handle with each element of the results that matches path, in document order.
+// handle must consume the element. Returns the next-tag of the results and the number of matching elements
+func (r *responseDecoder) records(path []string, handle func(xml.StartElement) error) (string, int, error) {
+	var (
+		nextTag string
+		count   int
+		walk    func(start xml.StartElement, matched int) error
+	)
+
+	matches := func(i int, name string) bool {
+		return path[i] == "*" || path[i] == name
+	}
+
+	// matched is the number of path elements matched by the ancestors of start
+	walk = func(start xml.StartElement, matched int) error {
+		if matched > 0 || matches(0, start.Name.Local) {
+			if !matches(matched, start.Name.Local) {
+				return r.skip()
+			}
+			matched++
+		}
+		if matched == len(path) {
+			count++
+			return handle(start)
+		}
+		for {
+			child, ok, err := r.next()
+			if err != nil || !ok {
+				return err
+			}
+			if err := walk(child, matched); err != nil {
+				return err
+			}
+		}
+	}
+
+	for {
+		child, ok, err := r.next()
+		if err != nil || !ok {
+			return nextTag, count, err
+		}
+		if child.Name.Local == "next-tag" {
+			if nextTag, err = r.content(); err != nil {
+				return nextTag, count, err
+			}
+			continue
+		}
+		if err := walk(child, 0); err != nil {
+			return nextTag, count, err
+		}
+	}
+}
+
+// perfInstance consumes an instance-data element
+func (r *responseDecoder) perfInstance(p *PerfInstance) error {
+	for {
+		child, ok, err := r.next()
+		if err != nil || !ok {
+			return err
+		}
+		switch child.Name.Local {
+		case "counters":
+			p.HasCounters = true
+			if err := r.perfCounters(p); err != nil {
+				return err
+			}
+		case "aggregation":
+			if err := r.perfAggregation(p); err != nil {
+				return err
+			}
+		default:
+			value, err := r.content()
+			if err != nil {
+				return err
+			}
+			p.fields = append(p.fields, PerfCounter{Name: child.Name.Local, Value: value})
+		}
+	}
+}
+
+// perfCounters consumes a counters element
+func (r *responseDecoder) perfCounters(p *PerfInstance) error {
+	for {
+		_, ok, err := r.next()
+		if err != nil || !ok {
+			return err
+		}
+		var counter PerfCounter
+		for {
+			child, ok, err := r.next()
+			if err != nil {
+				return err
+			}
+			if !ok {
+				break
+			}
+			value, err := r.content()
+			if err != nil {
+				return err
+			}
+			switch child.Name.Local {
+			case "name":
+				counter.Name = value
+			case "value":
+				counter.Value = value
+			}
+		}
+		p.Counters = append(p.Counters, counter)
+	}
+}
+
+// perfAggregation consumes an aggregation element and keeps the result of its aggregation-data
+func (r *responseDecoder) perfAggregation(p *PerfInstance) error {
+	for {
+		child, ok, err := r.next()
+		if err != nil || !ok {
+			return err
+		}
+		if child.Name.Local != "aggregation-data" || p.Aggregation != "" {
+			if err := r.skip(); err != nil {
+				return err
+			}
+			continue
+		}
+		for {
+			data, ok, err := r.next()
+			if err != nil {
+				return err
+			}
+			if !ok {
+				break
+			}
+			value, err := r.content()
+			if err != nil {
+				return err
+			}
+			if data.Name.Local == "result" && p.Aggregation == "" {
+				p.Aggregation = value
+			}
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package zapi
+
+import (
+	"encoding/xml"
+	"errors"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"github.com/netapp/harvest/v2/pkg/tree"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+const perfTestdata = "../../../../cmd/collectors/zapiperf/testdata/"
+
+func loadResults(t testing.TB, path string) *node.Node {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := tree.LoadXML(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestInvokePerfWithTimers(t *testing.T) {
+	files, _ := filepath.Glob(perfTestdata + "*/pollData*.xml")
+	more, _ := filepath.Glob(perfTestdata + "pollData*.xml")
+	files = append(files, more...)
+	if len(files) == 0 {
+		t.Fatal("no test files")
+	}
+
+	c := NewTestClient()
+	for _, file := range files {
+		t.Run(file, func(t *testing.T) {
+			want := loadResults(t, file).GetChildS("instances").GetChildren()
+
+			var got []*node.Node
+			count, _, _, err := c.InvokePerfWithTimers(file, func(i *PerfInstance) error {
+				n := want[len(got)]
+				got = append(got, n)
+				for _, name := range []string{"name", "uuid", "instance-name"} {
+					if i.Field(name) != n.GetChildContentS(name) {
+						t.Errorf("%s got=%q want=%q", name, i.Field(name), n.GetChildContentS(name))
+					}
+				}
+				counters := n.GetChildS("counters")
+				if i.HasCounters != (counters != nil) {
+					t.Fatalf("HasCounters got=%v", i.HasCounters)
+				}
+				var wantCounters []PerfCounter
+				for _, cnt := range counters.GetChildren() {
+					wantCounters = append(wantCounters, PerfCounter{Name: cnt.GetChildContentS("name"), Value: cnt.GetChildContentS("value")})
+				}
+				if !slices.Equal(i.Counters, wantCounters) {
+					t.Errorf("counters got=%v want=%v", i.Counters, wantCounters)
+				}
+				var aggregation string
+				if a := n.GetChildS("aggregation"); a != nil {
+					if d := a.GetChildS("aggregation-data"); d != nil {
+						aggregation = d.GetChildContentS("result")
+					}
+				}
+				if i.Aggregation != aggregation {
+					t.Errorf("aggregation got=%q want=%q", i.Aggregation, aggregation)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != len(want) {
+				t.Errorf("count got=%d want=%d", count, len(want))
+			}
+		})
+	}
+}
+
+func TestInvokeBatchStream(t *testing.T) {
+	files, _ := filepath.Glob(perfTestdata + "*/pollInstance*.xml")
+	more, _ := filepath.Glob(perfTestdata + "pollInstance*.xml")
+	files = append(files, more...)
+	if len(files) == 0 {
+		t.Fatal("no test files")
+	}
+
+	c := NewTestClient()
+	for _, file := range files {
+		t.Run(file, func(t *testing.T) {
+			path := []string{"attributes-list", "*"}
+			root := loadResults(t, file)
+			want := root.GetChildS("attributes-list").GetChildren()
+
+			var got []*node.Node
+			response, err := c.InvokeBatchStream(nil, "initial", file, path, func(n *node.Node) error {
+				got = append(got, n)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.Records != len(want) || len(got) != len(want) {
+				t.Fatalf("records got=%d want=%d", response.Records, len(want))
+			}
+			if response.Tag != "" {
+				t.Errorf("tag got=%q want empty in test mode", response.Tag)
+			}
+			for i, n := range got {
+				gotXML, _ := tree.DumpXML(n)
+				wantXML, _ := tree.DumpXML(want[i])
+				if string(gotXML) != string(wantXML) {
+					t.Errorf("got=%s\nwant=%s", gotXML, wantXML)
+				}
+				if n.GetContentS() != want[i].GetContentS() {
+					t.Errorf("content got=%q want=%q", n.GetContentS(), want[i].GetContentS())
+				}
+			}
+		})
+	}
+}
+
+func TestResponseDecoder(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		path    []string
+		key     []string // path of the value collected from each record
+		want    []string
+		nextTag string
+		wantErr error
+	}{
+		{
+			name:    "next-tag",
+			data:    `<netapp version="1.21"><results status="passed"><attributes-list><volume-attributes><volume-id-attributes><name>vol1</name></volume-id-attributes></volume-attributes><volume-attributes><volume-id-attributes><name>vol2</name></volume-id-attributes></volume-attributes></attributes-list><next-tag>&lt;volume-get-iter-key-td&gt;</next-tag><num-records>2</num-records></results></netapp>`,
+			path:    []string{"attributes-list", "volume-attributes"},
+			key:     []string{"volume-id-attributes", "name"},
+			want:    []string{"vol1", "vol2"},
+			nextTag: "&lt;volume-get-iter-key-td&gt;",
+		},
+		{
+			name: "search nested",
+			data: `<results status="passed"><attributes><system-info><system-name>node1</system-name></system-info></attributes></results>`,
+			path: []string{"system-info"},
+			key:  []string{"system-name"},
+			want: []string{"node1"},
+		},
+		{
+			name: "mismatch",
+			data: `<results status="passed"><attributes-list><aggr-attributes><aggregate-name>aggr1</aggregate-name></aggr-attributes></attributes-list></results>`,
+			path: []string{"attributes-list", "volume-attributes"},
+		},
+		{
+			name:    "permission denied",
+			data:    `<netapp><results status="failed" errno="13003" reason="Insufficient privileges"></results></netapp>`,
+			wantErr: errs.ErrPermissionDenied,
+		},
+		{
+			name:    "rejected",
+			data:    `<netapp><results status="failed" errno="13005" reason="Unable to find API"></results></netapp>`,
+			wantErr: errs.ErrAPIRequestRejected,
+		},
+		{
+			name:    "missing results",
+			data:    `<netapp></netapp>`,
+			wantErr: errs.ErrAPIResponse,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newResponseDecoder([]byte(tt.data))
+			err := r.results()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("results got=%v want=%v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			var got []string
+			nextTag, count, err := r.records(tt.path, func(start xml.StartElement) error {
+				n, err := r.node(start)
+				if err != nil {
+					return err
+				}
+				for _, name := range tt.key[:len(tt.key)-1] {
+					n = n.GetChildS(name)
+				}
+				got = append(got, n.GetChildContentS(tt.key[len(tt.key)-1]))
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != len(tt.want) || !slices.Equal(got, tt.want) {
+				t.Errorf("records got=%v want=%v", got, tt.want)
+			}
+			if nextTag != tt.nextTag {
+				t.Errorf("next-tag got=%q want=%q", nextTag, tt.nextTag)
+			}
+		})
+	}
+}
+
+// go test -run=^$ -bench=. -benchmem ./pkg/api/ontapi/zapi/
+var benchPollData = perfTestdata + "partialAggregation/pollData1.xml"
+
+func BenchmarkPollData_Tree(b *testing.B) {
+	b.ReportAllocs()
+	for range b.N {
+		// read the file in each iteration, like InvokePerfWithTimers does
+		data, err := os.ReadFile(benchPollData)
+		if err != nil {
+			b.Fatal(err)
+		}
+		root, err := tree.LoadXML(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, i := range root.GetChildS("instances").GetChildren() {
+			_ = i.GetChildContentS("uuid")
+			for _, cnt := range i.GetChildS("counters").GetChildren() {
+				_ = cnt.GetChildContentS("name")
+				_ = cnt.GetChildContentS("value")
+			}
+		}
+	}
+}
+
+func BenchmarkPollData_Stream(b *testing.B) {
+	c := NewTestClient()
+	b.ReportAllocs()
+	for range b.N {
+		_, _, _, err := c.InvokePerfWithTimers(benchPollData, func(i *PerfInstance) error {
+			_ = i.Field("uuid")
+			for _, cnt := range i.Counters {
+				_ = cnt.Name
+				_ = cnt.Value
+			}
+			return nil
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
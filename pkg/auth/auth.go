@@ -49,22 +49,27 @@ type Credentials struct {
 	logger         *slog.Logger
 	authMu         *sync.Mutex
 	cachedResponse ScriptResponse
+	storeModTime   time.Time
+	cachedToken    string
+	tokenRefresh   time.Time
 }
 
-// Expire will reset the credential schedule if the receiver has a CredentialsScript
-// Otherwise it will do nothing.
+// Expire will reset the credential schedule if the receiver's credentials are refreshable.
+// See PollerAuth.IsRefreshable. Otherwise, it will do nothing.
 // Resetting the schedule will cause the next call to Password to fetch the credentials
+// and the next call to GetPollerAuth to request a new OAuth2 access token
 func (c *Credentials) Expire() {
 	auth, err := c.GetPollerAuth()
 	if err != nil {
 		return
 	}
-	if !auth.HasCredentialScript {
+	if !auth.IsRefreshable() {
 		return
 	}
 	c.authMu.Lock()
 	defer c.authMu.Unlock()
 	c.nextUpdate = time.Time{}
+	c.tokenRefresh = time.Time{}
 }
 
 func (c *Credentials) certs(poller *conf.Poller) (string, error) {
@@ -95,7 +100,7 @@ func (c *Credentials) password(poller *conf.Poller) (ScriptResponse, error) {
 		}
 		// Cache the new response and update the next update time.
 		c.cachedResponse = response
-		c.setNextUpdate()
+		c.setNextUpdate(c.poller.CredentialsScript.Schedule)
 	}
 	return c.cachedResponse, nil
 }
@@ -215,8 +220,7 @@ func (c *Credentials) execScript(cmdPath string, kind string, timeout string, e
 	return response, nil
 }
 
-func (c *Credentials) setNextUpdate() {
-	schedule := c.poller.CredentialsScript.Schedule
+func (c *Credentials) setNextUpdate(schedule string) {
 	if schedule == "" {
 		schedule = defaultSchedule
 	}
@@ -243,6 +247,9 @@ type PollerAuth struct {
 	IsCert               bool
 	HasCredentialScript  bool
 	HasCertificateScript bool
+	HasCredentialStore   bool
+	IsOAuth2             bool
+	IsOAuth2MTLS         bool
 	Schedule             string
 	PemCert              []byte
 	PemKey               []byte
@@ -252,6 +259,12 @@ type PollerAuth struct {
 	insecureTLS          bool
 }
 
+// IsRefreshable returns true when the credentials are fetched at runtime and may change after Expire is called.
+// Clients use this to decide if an authentication failure is worth retrying.
+func (a PollerAuth) IsRefreshable() bool {
+	return a.HasCredentialScript || a.HasCredentialStore || a.IsOAuth2
+}
+
 func (a PollerAuth) Certificate() (tls.Certificate, error) {
 	if a.HasCertificateScript {
 		return tls.X509KeyPair(a.PemCert, a.PemKey)
@@ -300,7 +313,7 @@ func (c *Credentials) GetPollerAuth() (PollerAuth, error) {
 	if err != nil {
 		return PollerAuth{}, err
 	}
-	if auth.IsCert {
+	if auth.IsCert || auth.IsOAuth2 {
 		return auth, nil
 	}
 	if auth.Username != "" && auth.Password != "" {
@@ -334,6 +347,9 @@ func getPollerAuth(c *Credentials, poller *conf.Poller) (PollerAuth, error) {
 	if poller.AuthStyle == conf.CertificateAuth {
 		return handCertificateAuth(c, poller, insecureTLS)
 	}
+	if poller.AuthStyle == conf.OAuth2Auth {
+		return handleOAuth2(c, poller, insecureTLS)
+	}
 	if poller.Password != "" {
 		return PollerAuth{
 			Username:    poller.Username,
@@ -357,6 +373,21 @@ func getPollerAuth(c *Credentials, poller *conf.Poller) (PollerAuth, error) {
 			CaCertPath:          poller.CaCertPath,
 		}, nil
 	}
+	if poller.CredentialsStore.Type != "" {
+		response, err := c.secret(poller)
+		if err != nil {
+			return PollerAuth{}, err
+		}
+		return PollerAuth{
+			Username:           response.Username,
+			Password:           response.Data,
+			AuthToken:          response.AuthToken,
+			HasCredentialStore: true,
+			Schedule:           poller.CredentialsStore.Schedule,
+			insecureTLS:        insecureTLS,
+			CaCertPath:         poller.CaCertPath,
+		}, nil
+	}
 	if poller.CredentialsFile != "" {
 		err := conf.ReadCredentialFile(poller.CredentialsFile, poller)
 		if err != nil {
@@ -467,7 +498,7 @@ func (c *Credentials) Transport(request *http.Request, poller *conf.Poller) (htt
 			},
 		}
 	} else {
-		if !pollerAuth.HasCredentialScript {
+		if !pollerAuth.IsRefreshable() {
 			if pollerAuth.Username == "" {
 				return nil, errs.New(errs.ErrMissingParam, "username")
 			} else if pollerAuth.Password == "" {
@@ -475,7 +506,7 @@ func (c *Credentials) Transport(request *http.Request, poller *conf.Poller) (htt
 			}
 		}
 
-		if request != nil {
+		if request != nil && !pollerAuth.IsOAuth2 {
 			request.SetBasicAuth(pollerAuth.Username, pollerAuth.Password)
 		}
 
@@ -486,6 +517,15 @@ func (c *Credentials) Transport(request *http.Request, poller *conf.Poller) (htt
 				InsecureSkipVerify: pollerAuth.insecureTLS, //nolint:gosec
 			},
 		}
+
+		// Certificate-bound access tokens are only accepted over a connection that presents the same certificate
+		if pollerAuth.IsOAuth2MTLS {
+			cert, err = pollerAuth.Certificate()
+			if err != nil {
+				return nil, err
+			}
+			transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+		}
 	}
 
 	transport.DialContext = (&net.Dialer{Timeout: DefaultDialerTimeout}).DialContext
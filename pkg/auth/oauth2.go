@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultTokenLifetime is used when the authorization server does not include expires_in in its response
+	defaultTokenLifetime = time.Hour
+	// Tokens are refreshed once this fraction of their lifetime has elapsed, so they never lapse mid-poll
+	tokenRefreshRatio = 0.8
+)
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// handleOAuth2 returns a PollerAuth with an access token obtained via the OAuth 2.0 client credentials grant.
+// The client secret is resolved the same way a basic auth password is.
+// When mtls is enabled, the poller's client certificate is presented to both the authorization server and ONTAP,
+// so certificate-bound tokens (RFC 8705) are accepted.
+func handleOAuth2(c *Credentials, poller *conf.Poller, insecureTLS bool) (PollerAuth, error) {
+	if poller.OAuth2.TokenURL == "" {
+		return PollerAuth{}, errs.New(errs.ErrMissingParam, "oauth2 token_url")
+	}
+
+	secret, err := oauth2ClientSecret(c, poller)
+	if err != nil {
+		return PollerAuth{}, err
+	}
+
+	clientID := cmp.Or(poller.OAuth2.ClientID, secret.Username)
+	if clientID == "" {
+		return PollerAuth{}, errs.New(errs.ErrMissingParam, "oauth2 client_id")
+	}
+
+	auth := PollerAuth{
+		Username:            clientID,
+		IsOAuth2:            true,
+		HasCredentialScript: secret.HasCredentialScript,
+		HasCredentialStore:  secret.HasCredentialStore,
+		insecureTLS:         insecureTLS,
+		CaCertPath:          poller.CaCertPath,
+	}
+
+	if poller.OAuth2.MTLS {
+		certAuth, err := handCertificateAuth(c, poller, insecureTLS)
+		if err != nil {
+			return PollerAuth{}, err
+		}
+		auth.IsOAuth2MTLS = true
+		auth.HasCertificateScript = certAuth.HasCertificateScript
+		auth.PemCert = certAuth.PemCert
+		auth.PemKey = certAuth.PemKey
+		auth.CertPath = certAuth.CertPath
+		auth.KeyPath = certAuth.KeyPath
+	} else if secret.Password == "" {
+		return PollerAuth{}, errs.New(errs.ErrMissingParam, "oauth2 client secret")
+	}
+
+	auth.AuthToken, err = c.oauth2Token(poller, clientID, secret.Password, auth)
+	if err != nil {
+		return PollerAuth{}, err
+	}
+	return auth, nil
+}
+
+// oauth2ClientSecret resolves the client id and secret from the poller, or the defaults, using basic auth precedence
+func oauth2ClientSecret(c *Credentials, poller *conf.Poller) (PollerAuth, error) {
+	basic := *poller
+	basic.AuthStyle = conf.BasicAuth
+	secret, err := getPollerAuth(c, &basic)
+	if err != nil {
+		return PollerAuth{}, err
+	}
+	if secret.Password != "" || conf.Config.Defaults == nil {
+		return secret, nil
+	}
+
+	copyDefault := *conf.Config.Defaults
+	copyDefault.Name = poller.Name
+	copyDefault.Addr = poller.Addr
+	copyDefault.AuthStyle = conf.BasicAuth
+	if poller.Username != "" {
+		copyDefault.Username = poller.Username
+	}
+	return getPollerAuth(c, &copyDefault)
+}
+
+// oauth2Token returns the cached access token, or requests a new one when the cached token is missing, expired,
+// or close to expiring.
+func (c *Credentials) oauth2Token(poller *conf.Poller, clientID string, clientSecret string, auth PollerAuth) (string, error) {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+
+	if c.cachedToken != "" && time.Now().Before(c.tokenRefresh) {
+		return c.cachedToken, nil
+	}
+
+	response, err := requestToken(poller.OAuth2, clientID, clientSecret, auth)
+	if err != nil {
+		return "", err
+	}
+
+	lifetime := defaultTokenLifetime
+	if response.ExpiresIn > 0 {
+		lifetime = time.Duration(response.ExpiresIn) * time.Second
+	}
+	c.cachedToken = response.AccessToken
+	c.tokenRefresh = time.Now().Add(time.Duration(float64(lifetime) * tokenRefreshRatio))
+	return c.cachedToken, nil
+}
+
+func requestToken(oauth2 conf.OAuth2, clientID string, clientSecret string, auth PollerAuth) (tokenResponse, error) {
+	timeout, err := time.ParseDuration(cmp.Or(oauth2.Timeout, defaultTimeout))
+	if err != nil {
+		timeout, _ = time.ParseDuration(defaultTimeout)
+	}
+
+	client, err := tokenClient(oauth2, auth, timeout)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if len(oauth2.Scopes) > 0 {
+		form.Set("scope", strings.Join(oauth2.Scopes, " "))
+	}
+	if oauth2.Audience != "" {
+		form.Set("audience", oauth2.Audience)
+	}
+	if clientSecret == "" {
+		// mTLS client authentication identifies the client by its certificate and client_id
+		form.Set("client_id", clientID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, oauth2.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if clientSecret != "" {
+		request.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return tokenResponse{}, fmt.Errorf("token request failed err=%w", err)
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	var token tokenResponse
+	if err := json.Unmarshal(body, &token); err != nil && response.StatusCode == http.StatusOK {
+		return tokenResponse{}, fmt.Errorf("failed to parse token response err=%w", err)
+	}
+	if response.StatusCode != http.StatusOK {
+		return tokenResponse{}, errs.New(errs.ErrAuthFailed,
+			fmt.Sprintf("token request failed status=%s error=%s description=%s",
+				response.Status, token.Error, token.ErrorDescription))
+	}
+	if token.AccessToken == "" {
+		return tokenResponse{}, errs.New(errs.ErrAuthFailed, "token response does not contain an access_token")
+	}
+	if token.TokenType != "" && !strings.EqualFold(token.TokenType, "bearer") {
+		return tokenResponse{}, errs.New(errs.ErrAuthFailed, "unsupported token_type="+token.TokenType)
+	}
+	return token, nil
+}
+
+func tokenClient(oauth2 conf.OAuth2, auth PollerAuth, timeout time.Duration) (*http.Client, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if oauth2.CaCertPath != "" {
+		caCert, err := os.ReadFile(oauth2.CaCertPath)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("failed to append CA certificate caCertPath=%s", oauth2.CaCertPath)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if auth.IsOAuth2MTLS {
+		cert, err := auth.Certificate()
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}, nil
+}
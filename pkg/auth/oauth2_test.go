@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"errors"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeTokenServer struct {
+	*httptest.Server
+	requests  atomic.Int32
+	expiresIn atomic.Int64
+	lastScope atomic.Value
+}
+
+func newFakeTokenServer(t *testing.T) *fakeTokenServer {
+	t.Helper()
+	f := &fakeTokenServer{}
+	f.expiresIn.Store(300)
+	f.lastScope.Store("")
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.FormValue("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"unsupported_grant_type"}`))
+			return
+		}
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "harvest" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid_client","error_description":"bad secret"}`))
+			return
+		}
+		f.lastScope.Store(r.FormValue("scope"))
+		n := f.requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"token-` + strconv.Itoa(int(n)) +
+			`","token_type":"Bearer","expires_in":` + strconv.FormatInt(f.expiresIn.Load(), 10) + `}`))
+	}))
+	return f
+}
+
+func TestOAuth2_ClientCredentials(t *testing.T) {
+	server := newFakeTokenServer(t)
+	defer server.Close()
+
+	c := newTestCredentials(t, `
+Pollers:
+  test:
+    addr: a.b.c
+    auth_style: oauth2
+    username: harvest
+    password: s3cret
+    oauth2:
+      token_url: `+server.URL+`/token
+      scopes:
+        - ontap.read
+        - ontap.metrics
+`)
+	got, err := c.GetPollerAuth()
+	if err != nil {
+		t.Fatalf("expected no error got %+v", err)
+	}
+	if !got.IsOAuth2 || got.AuthToken != "token-1" || got.Username != "harvest" {
+		t.Errorf("got IsOAuth2=[%t] authToken=[%s] username=[%s]", got.IsOAuth2, got.AuthToken, got.Username)
+	}
+	if !got.IsRefreshable() {
+		t.Errorf("expected OAuth2 credentials to be refreshable")
+	}
+	if scope := server.lastScope.Load().(string); scope != "ontap.read ontap.metrics" {
+		t.Errorf("got scope=[%s], want scope=[ontap.read ontap.metrics]", scope)
+	}
+
+	// The token is cached until it nears expiry
+	got, _ = c.GetPollerAuth()
+	if got.AuthToken != "token-1" || server.requests.Load() != 1 {
+		t.Errorf("got authToken=[%s] requests=%d, want cached token", got.AuthToken, server.requests.Load())
+	}
+
+	// Expire forces a new token, e.g., after ONTAP returns a 401
+	c.Expire()
+	got, _ = c.GetPollerAuth()
+	if got.AuthToken != "token-2" {
+		t.Errorf("got authToken=[%s], want authToken=[token-2]", got.AuthToken)
+	}
+}
+
+func TestOAuth2_RefreshBeforeExpiry(t *testing.T) {
+	server := newFakeTokenServer(t)
+	defer server.Close()
+	server.expiresIn.Store(1)
+
+	c := newTestCredentials(t, `
+Pollers:
+  test:
+    addr: a.b.c
+    auth_style: oauth2
+    password: s3cret
+    oauth2:
+      token_url: `+server.URL+`
+      client_id: harvest
+`)
+	got, err := c.GetPollerAuth()
+	if err != nil {
+		t.Fatalf("expected no error got %+v", err)
+	}
+	if got.AuthToken != "token-1" {
+		t.Errorf("got authToken=[%s], want authToken=[token-1]", got.AuthToken)
+	}
+
+	// A one-second token is refreshed after 80% of its lifetime, before it lapses
+	time.Sleep(850 * time.Millisecond)
+	got, _ = c.GetPollerAuth()
+	if got.AuthToken != "token-2" {
+		t.Errorf("got authToken=[%s], want authToken=[token-2]", got.AuthToken)
+	}
+}
+
+func TestOAuth2_ClientSecretFromScript(t *testing.T) {
+	server := newFakeTokenServer(t)
+	defer server.Close()
+
+	c := newTestCredentials(t, `
+Pollers:
+  test:
+    addr: a.b.c
+    auth_style: oauth2
+    credentials_script:
+      path: testdata/get_oauth2_secret
+    oauth2:
+      token_url: `+server.URL+`
+`)
+	got, err := c.GetPollerAuth()
+	if err != nil {
+		t.Fatalf("expected no error got %+v", err)
+	}
+	if got.AuthToken != "token-1" || !got.HasCredentialScript {
+		t.Errorf("got authToken=[%s] HasCredentialScript=[%t]", got.AuthToken, got.HasCredentialScript)
+	}
+}
+
+func TestOAuth2_Errors(t *testing.T) {
+	server := newFakeTokenServer(t)
+	defer server.Close()
+
+	type test struct {
+		name    string
+		yaml    string
+		wantErr error
+	}
+	tests := []test{
+		{
+			name:    "missing token_url",
+			wantErr: errs.ErrMissingParam,
+			yaml: `
+Pollers:
+  test:
+    addr: a.b.c
+    auth_style: oauth2
+    username: harvest
+    password: s3cret
+`,
+		},
+		{
+			name:    "missing secret",
+			wantErr: errs.ErrMissingParam,
+			yaml: `
+Pollers:
+  test:
+    addr: a.b.c
+    auth_style: oauth2
+    username: harvest
+    oauth2:
+      token_url: ` + server.URL + `
+`,
+		},
+		{
+			name:    "wrong secret",
+			wantErr: errs.ErrAuthFailed,
+			yaml: `
+Pollers:
+  test:
+    addr: a.b.c
+    auth_style: oauth2
+    username: harvest
+    password: wrong
+    oauth2:
+      token_url: ` + server.URL + `
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCredentials(t, tt.yaml)
+			_, err := c.GetPollerAuth()
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got err=%v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
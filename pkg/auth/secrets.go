@@ -0,0 +1,357 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/errs"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	defaultUsernameKey  = "username"
+	defaultPasswordKey  = "password"
+	defaultAuthTokenKey = "authToken"
+	defaultVaultMount   = "secret"
+	defaultAppRoleMount = "approle"
+	vaultTokenHeader    = "X-Vault-Token"
+	vaultNSHeader       = "X-Vault-Namespace"
+)
+
+// secretStore is implemented by each built-in credentials provider.
+// fetch returns the current credentials from the provider, the same shape a credentials script returns.
+type secretStore interface {
+	fetch() (ScriptResponse, error)
+}
+
+func newSecretStore(store conf.CredentialsStore, timeout time.Duration) (secretStore, error) {
+	switch store.Type {
+	case conf.VaultStore:
+		return &vaultStore{store: store, timeout: timeout}, nil
+	case conf.KubernetesStore:
+		return &kubernetesStore{store: store}, nil
+	case conf.EnvStore:
+		return &envStore{store: store}, nil
+	default:
+		return nil, errs.New(errs.ErrInvalidParam, "credentials_store type="+store.Type)
+	}
+}
+
+// secret fetches credentials from the poller's credentials_store. Responses are cached until the store's schedule
+// elapses, Expire is called, or, for Kubernetes mounted secrets, one of the mounted files changes.
+func (c *Credentials) secret(poller *conf.Poller) (ScriptResponse, error) {
+	store := poller.CredentialsStore
+
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+
+	var modTime time.Time
+	if store.Type == conf.KubernetesStore {
+		modTime = kubernetesModTime(store)
+	}
+
+	if time.Now().After(c.nextUpdate) || !modTime.Equal(c.storeModTime) {
+		response, err := c.fetchSecret(poller)
+		if err != nil {
+			return ScriptResponse{}, err
+		}
+		c.cachedResponse = response
+		c.storeModTime = modTime
+		c.setNextUpdate(store.Schedule)
+	}
+	return c.cachedResponse, nil
+}
+
+func (c *Credentials) fetchSecret(p *conf.Poller) (ScriptResponse, error) {
+	timeout := p.CredentialsStore.Timeout
+	if timeout == "" {
+		timeout = defaultTimeout
+	}
+	duration, err := time.ParseDuration(timeout)
+	if err != nil {
+		duration, _ = time.ParseDuration(defaultTimeout)
+	}
+
+	store, err := newSecretStore(p.CredentialsStore, duration)
+	if err != nil {
+		return ScriptResponse{}, err
+	}
+	response, err := store.fetch()
+	if err != nil {
+		// Don't log the error, it may contain credentials
+		return ScriptResponse{}, fmt.Errorf("credentials_store fetch failed type=%s err=%w", p.CredentialsStore.Type, err)
+	}
+	// If username is empty, use harvest config poller username
+	if response.Username == "" {
+		response.Username = p.Username
+	}
+	return response, nil
+}
+
+func keyOrDefault(key string, defaultKey string) string {
+	if key == "" {
+		return defaultKey
+	}
+	return key
+}
+
+// envStore reads credentials from environment variables
+type envStore struct {
+	store conf.CredentialsStore
+}
+
+func (e *envStore) fetch() (ScriptResponse, error) {
+	if e.store.PasswordEnv == "" {
+		return ScriptResponse{}, errs.New(errs.ErrMissingParam, "credentials_store password_env")
+	}
+	password, ok := os.LookupEnv(e.store.PasswordEnv)
+	if !ok {
+		return ScriptResponse{}, fmt.Errorf("environment variable %s is not set", e.store.PasswordEnv)
+	}
+	response := ScriptResponse{Data: password}
+	if e.store.UsernameEnv != "" {
+		response.Username = os.Getenv(e.store.UsernameEnv)
+	}
+	return response, nil
+}
+
+// kubernetesStore reads credentials from a directory of files, one file per key,
+// which is how Kubernetes mounts a Secret into a pod.
+type kubernetesStore struct {
+	store conf.CredentialsStore
+}
+
+func (k *kubernetesStore) fetch() (ScriptResponse, error) {
+	if k.store.Path == "" {
+		return ScriptResponse{}, errs.New(errs.ErrMissingParam, "credentials_store path")
+	}
+	password, err := readSecretFile(k.store.Path, keyOrDefault(k.store.PasswordKey, defaultPasswordKey))
+	if err != nil {
+		return ScriptResponse{}, err
+	}
+	response := ScriptResponse{Data: password}
+
+	// username and authToken are optional
+	response.Username, _ = readSecretFile(k.store.Path, keyOrDefault(k.store.UsernameKey, defaultUsernameKey))
+	response.AuthToken, _ = readSecretFile(k.store.Path, defaultAuthTokenKey)
+	return response, nil
+}
+
+func readSecretFile(dir string, name string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// kubernetesModTime returns the most recent modification time of the mounted secret files.
+// Kubernetes updates mounted secrets by atomically swapping a symlink, os.Stat follows the link,
+// so a changed secret results in a changed modification time.
+func kubernetesModTime(store conf.CredentialsStore) time.Time {
+	var latest time.Time
+	keys := []string{
+		keyOrDefault(store.UsernameKey, defaultUsernameKey),
+		keyOrDefault(store.PasswordKey, defaultPasswordKey),
+		defaultAuthTokenKey,
+	}
+	for _, key := range keys {
+		info, err := os.Stat(filepath.Join(store.Path, key))
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// vaultStore reads credentials from a HashiCorp Vault KV version 2 secrets engine.
+// It authenticates with a static token or with AppRole.
+type vaultStore struct {
+	store   conf.CredentialsStore
+	timeout time.Duration
+	client  *http.Client
+}
+
+type vaultLoginResponse struct {
+	Auth struct {
+		ClientToken string `json:"client_token"`
+	} `json:"auth"`
+}
+
+type vaultKVResponse struct {
+	Data struct {
+		Data map[string]any `json:"data"`
+	} `json:"data"`
+}
+
+func (v *vaultStore) fetch() (ScriptResponse, error) {
+	if v.store.Addr == "" {
+		return ScriptResponse{}, errs.New(errs.ErrMissingParam, "credentials_store addr")
+	}
+	if v.store.Path == "" {
+		return ScriptResponse{}, errs.New(errs.ErrMissingParam, "credentials_store path")
+	}
+
+	client, err := v.httpClient()
+	if err != nil {
+		return ScriptResponse{}, err
+	}
+	v.client = client
+
+	token, err := v.token()
+	if err != nil {
+		return ScriptResponse{}, err
+	}
+
+	mount := strings.Trim(keyOrDefault(v.store.Mount, defaultVaultMount), "/")
+	secretPath := strings.Trim(v.store.Path, "/")
+	body, err := v.do(http.MethodGet, "/v1/"+mount+"/data/"+secretPath, token, nil)
+	if err != nil {
+		return ScriptResponse{}, err
+	}
+
+	var kv vaultKVResponse
+	if err := json.Unmarshal(body, &kv); err != nil {
+		return ScriptResponse{}, fmt.Errorf("failed to parse vault response: %w", err)
+	}
+	data := kv.Data.Data
+	response := ScriptResponse{
+		Username:  vaultString(data, keyOrDefault(v.store.UsernameKey, defaultUsernameKey)),
+		Data:      vaultString(data, keyOrDefault(v.store.PasswordKey, defaultPasswordKey)),
+		AuthToken: vaultString(data, defaultAuthTokenKey),
+	}
+	if response.Data == "" && response.AuthToken == "" {
+		return ScriptResponse{}, fmt.Errorf("vault secret path=%s does not contain key=%s",
+			secretPath, keyOrDefault(v.store.PasswordKey, defaultPasswordKey))
+	}
+	return response, nil
+}
+
+func vaultString(data map[string]any, key string) string {
+	value, ok := data[key]
+	if !ok || value == nil {
+		return ""
+	}
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// token returns the Vault token to use. A static token or token file takes precedence over AppRole.
+func (v *vaultStore) token() (string, error) {
+	if v.store.Token != "" {
+		return v.store.Token, nil
+	}
+	if v.store.TokenFile != "" {
+		data, err := os.ReadFile(v.store.TokenFile)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	if v.store.RoleID == "" {
+		return "", errs.New(errs.ErrMissingParam, "credentials_store token, token_file, or role_id")
+	}
+
+	secretID := v.store.SecretID
+	if v.store.SecretIDFile != "" {
+		data, err := os.ReadFile(v.store.SecretIDFile)
+		if err != nil {
+			return "", err
+		}
+		secretID = strings.TrimSpace(string(data))
+	}
+
+	payload, err := json.Marshal(map[string]string{
+		"role_id":   v.store.RoleID,
+		"secret_id": secretID,
+	})
+	if err != nil {
+		return "", err
+	}
+	appRoleMount := strings.Trim(keyOrDefault(v.store.AppRoleMount, defaultAppRoleMount), "/")
+	body, err := v.do(http.MethodPost, "/v1/auth/"+appRoleMount+"/login", "", payload)
+	if err != nil {
+		return "", err
+	}
+	var login vaultLoginResponse
+	if err := json.Unmarshal(body, &login); err != nil {
+		return "", fmt.Errorf("failed to parse vault login response: %w", err)
+	}
+	if login.Auth.ClientToken == "" {
+		return "", errors.New("vault login response does not contain a client token")
+	}
+	return login.Auth.ClientToken, nil
+}
+
+func (v *vaultStore) do(method string, path string, token string, payload []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), v.timeout)
+	defer cancel()
+
+	u := strings.TrimSuffix(v.store.Addr, "/") + path
+	request, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		request.Header.Set(vaultTokenHeader, token)
+	}
+	if v.store.Namespace != "" {
+		request.Header.Set(vaultNSHeader, v.store.Namespace)
+	}
+	if payload != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := v.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("vault request failed path=%s err=%w", path, err)
+	}
+	//goland:noinspection GoUnhandledErrorResult
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault request failed path=%s status=%s", path, response.Status)
+	}
+	return body, nil
+}
+
+func (v *vaultStore) httpClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if v.store.CaCertPath != "" {
+		caCert, err := os.ReadFile(v.store.CaCertPath)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("failed to append CA certificate caCertPath=%s", v.store.CaCertPath)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return &http.Client{
+		Timeout: v.timeout,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}, nil
+}
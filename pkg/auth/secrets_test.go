@@ -0,0 +1,261 @@
+package auth
+
+import (
+	"encoding/json"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestCredentials(t *testing.T, yaml string) *Credentials {
+	t.Helper()
+	conf.Config.Defaults = nil
+	err := conf.DecodeConfig([]byte(yaml))
+	if err != nil {
+		t.Fatalf("expected no error got %+v", err)
+	}
+	poller, err := conf.PollerNamed("test")
+	if err != nil {
+		t.Fatalf("expected no error got %+v", err)
+	}
+	return NewCredentials(poller, slog.Default())
+}
+
+func TestCredentialsStore_Env(t *testing.T) {
+	t.Setenv("HARVEST_TEST_USER", "env-user")
+	t.Setenv("HARVEST_TEST_PASS", "env-pass")
+
+	c := newTestCredentials(t, `
+Pollers:
+  test:
+    addr: a.b.c
+    credentials_store:
+      type: env
+      username_env: HARVEST_TEST_USER
+      password_env: HARVEST_TEST_PASS
+`)
+	got, err := c.GetPollerAuth()
+	if err != nil {
+		t.Fatalf("expected no error got %+v", err)
+	}
+	if got.Username != "env-user" || got.Password != "env-pass" || !got.HasCredentialStore {
+		t.Errorf("got username=[%s] password=[%s] store=[%t]", got.Username, got.Password, got.HasCredentialStore)
+	}
+
+	// Cached until expired
+	t.Setenv("HARVEST_TEST_PASS", "env-pass2")
+	got, _ = c.GetPollerAuth()
+	if got.Password != "env-pass" {
+		t.Errorf("got password=[%s], want cached password=[env-pass]", got.Password)
+	}
+	c.Expire()
+	got, _ = c.GetPollerAuth()
+	if got.Password != "env-pass2" {
+		t.Errorf("got password=[%s], want password=[env-pass2]", got.Password)
+	}
+}
+
+func TestCredentialsStore_EnvMissing(t *testing.T) {
+	c := newTestCredentials(t, `
+Pollers:
+  test:
+    addr: a.b.c
+    username: username
+    credentials_store:
+      type: env
+      password_env: HARVEST_TEST_MISSING
+`)
+	_, err := c.GetPollerAuth()
+	if err == nil {
+		t.Errorf("expected error when environment variable is not set")
+	}
+}
+
+func TestCredentialsStore_Kubernetes(t *testing.T) {
+	dir := t.TempDir()
+	writeSecret(t, dir, "user", "k8s-user")
+	writeSecret(t, dir, "password", "k8s-pass")
+
+	c := newTestCredentials(t, `
+Pollers:
+  test:
+    addr: a.b.c
+    credentials_store:
+      type: kubernetes
+      path: `+dir+`
+      username_key: user
+`)
+	got, err := c.GetPollerAuth()
+	if err != nil {
+		t.Fatalf("expected no error got %+v", err)
+	}
+	if got.Username != "k8s-user" || got.Password != "k8s-pass" {
+		t.Errorf("got username=[%s] password=[%s]", got.Username, got.Password)
+	}
+
+	// A changed secret is reloaded without waiting for the schedule
+	writeSecret(t, dir, "password", "k8s-pass2")
+	future := time.Now().Add(time.Minute)
+	err = os.Chtimes(filepath.Join(dir, "password"), future, future)
+	if err != nil {
+		t.Fatalf("failed to touch secret err=%v", err)
+	}
+	got, err = c.GetPollerAuth()
+	if err != nil {
+		t.Fatalf("expected no error got %+v", err)
+	}
+	if got.Password != "k8s-pass2" {
+		t.Errorf("got password=[%s], want password=[k8s-pass2]", got.Password)
+	}
+}
+
+func writeSecret(t *testing.T, dir string, name string, value string) {
+	t.Helper()
+	err := os.WriteFile(filepath.Join(dir, name), []byte(value+"\n"), 0600)
+	if err != nil {
+		t.Fatalf("failed to write secret err=%v", err)
+	}
+}
+
+func newFakeVault(t *testing.T, password *atomic.Value, logins *atomic.Int32) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/approle/login":
+			var body map[string]string
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			if body["role_id"] != "role" || body["secret_id"] != "secret" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			logins.Add(1)
+			_, _ = w.Write([]byte(`{"auth":{"client_token":"approle-token","lease_duration":3600}}`))
+		case "/v1/kv/data/harvest/cluster1":
+			token := r.Header.Get(vaultTokenHeader)
+			if token != "static-token" && token != "approle-token" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			if r.Header.Get(vaultNSHeader) != "team" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write([]byte(`{"data":{"data":{"username":"vault-user","password":"` +
+				password.Load().(string) + `"},"metadata":{"version":1}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestCredentialsStore_Vault(t *testing.T) {
+	password := &atomic.Value{}
+	password.Store("vault-pass")
+	logins := &atomic.Int32{}
+	server := newFakeVault(t, password, logins)
+	defer server.Close()
+
+	type test struct {
+		name       string
+		auth       string
+		wantLogins int32
+		wantErr    bool
+	}
+	tests := []test{
+		{name: "token", auth: "token: static-token"},
+		{name: "approle", auth: "role_id: role\n      secret_id: secret", wantLogins: 1},
+		{name: "bad token", auth: "token: nope", wantErr: true},
+		{name: "no auth", auth: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logins.Store(0)
+			c := newTestCredentials(t, `
+Pollers:
+  test:
+    addr: a.b.c
+    credentials_store:
+      type: vault
+      addr: `+server.URL+`
+      mount: kv
+      path: harvest/cluster1
+      namespace: team
+      `+tt.auth+`
+`)
+			got, err := c.GetPollerAuth()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetPollerAuth() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Username != "vault-user" || got.Password != "vault-pass" || !got.HasCredentialStore {
+				t.Errorf("got username=[%s] password=[%s] store=[%t]", got.Username, got.Password, got.HasCredentialStore)
+			}
+			if logins.Load() != tt.wantLogins {
+				t.Errorf("got logins=%d, want logins=%d", logins.Load(), tt.wantLogins)
+			}
+		})
+	}
+}
+
+func TestCredentialsStore_VaultExpire(t *testing.T) {
+	password := &atomic.Value{}
+	password.Store("vault-pass")
+	server := newFakeVault(t, password, &atomic.Int32{})
+	defer server.Close()
+
+	c := newTestCredentials(t, `
+Pollers:
+  test:
+    addr: a.b.c
+    credentials_store:
+      type: vault
+      addr: `+server.URL+`
+      mount: kv
+      path: harvest/cluster1
+      namespace: team
+      token: static-token
+`)
+	got, err := c.GetPollerAuth()
+	if err != nil {
+		t.Fatalf("expected no error got %+v", err)
+	}
+	if got.Password != "vault-pass" {
+		t.Errorf("got password=[%s], want password=[vault-pass]", got.Password)
+	}
+
+	// Rotated secret is only seen after the credentials expire, e.g., after a 401
+	password.Store("rotated")
+	got, _ = c.GetPollerAuth()
+	if got.Password != "vault-pass" {
+		t.Errorf("got password=[%s], want cached password=[vault-pass]", got.Password)
+	}
+	c.Expire()
+	got, _ = c.GetPollerAuth()
+	if got.Password != "rotated" {
+		t.Errorf("got password=[%s], want password=[rotated]", got.Password)
+	}
+}
+
+func TestCredentialsStore_InvalidType(t *testing.T) {
+	c := newTestCredentials(t, `
+Pollers:
+  test:
+    addr: a.b.c
+    credentials_store:
+      type: keychain
+`)
+	_, err := c.GetPollerAuth()
+	if err == nil || !strings.Contains(err.Error(), "keychain") {
+		t.Errorf("expected invalid type error got %v", err)
+	}
+}
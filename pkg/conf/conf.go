@@ -39,6 +39,10 @@ const (
 	HarvestYML        = "harvest.yml"
 	BasicAuth         = "basic_auth"
 	CertificateAuth   = "certificate_auth"
+	OAuth2Auth        = "oauth2"
+	VaultStore        = "vault"
+	KubernetesStore   = "kubernetes"
+	EnvStore          = "env"
 	HomeEnvVar        = "HARVEST_CONF"
 )
 
@@ -516,11 +520,59 @@ type CredentialsScript struct {
 	Timeout  string `yaml:"timeout,omitempty"`
 }
 
+// CounterState configures where perf collectors save their previous poll, so they can calculate rates
+// on the first poll after a restart
+type CounterState struct {
+	Dir    string `yaml:"dir,omitempty"`
+	MaxAge string `yaml:"max_age,omitempty"`
+}
+
 type CertificateScript struct {
 	Path    string `yaml:"path,omitempty"`
 	Timeout string `yaml:"timeout,omitempty"`
 }
 
+// CredentialsStore describes a built-in secret provider that Harvest uses to fetch a poller's credentials.
+// Type is one of VaultStore, KubernetesStore, or EnvStore. The remaining fields are provider specific.
+type CredentialsStore struct {
+	Type     string `yaml:"type,omitempty"`
+	Schedule string `yaml:"schedule,omitempty"`
+	Timeout  string `yaml:"timeout,omitempty"`
+
+	// Vault KV v2 and Kubernetes
+	UsernameKey string `yaml:"username_key,omitempty"`
+	PasswordKey string `yaml:"password_key,omitempty"`
+	Path        string `yaml:"path,omitempty"`
+
+	// Vault KV v2
+	Addr         string `yaml:"addr,omitempty"`
+	Mount        string `yaml:"mount,omitempty"`
+	Namespace    string `yaml:"namespace,omitempty"`
+	CaCertPath   string `yaml:"ca_cert,omitempty"`
+	Token        string `yaml:"token,omitempty"`
+	TokenFile    string `yaml:"token_file,omitempty"`
+	AppRoleMount string `yaml:"approle_mount,omitempty"`
+	RoleID       string `yaml:"role_id,omitempty"`
+	SecretID     string `yaml:"secret_id,omitempty"`
+	SecretIDFile string `yaml:"secret_id_file,omitempty"`
+
+	// Environment variables
+	UsernameEnv string `yaml:"username_env,omitempty"`
+	PasswordEnv string `yaml:"password_env,omitempty"`
+}
+
+// OAuth2 describes how Harvest obtains OAuth 2.0 access tokens with the client credentials grant.
+// The client secret is resolved like a basic auth password, e.g., password, credentials_script, or credentials_store.
+type OAuth2 struct {
+	TokenURL   string   `yaml:"token_url,omitempty"`
+	ClientID   string   `yaml:"client_id,omitempty"`
+	Scopes     []string `yaml:"scopes,omitempty"`
+	Audience   string   `yaml:"audience,omitempty"`
+	CaCertPath string   `yaml:"ca_cert,omitempty"`
+	MTLS       bool     `yaml:"mtls,omitempty"`
+	Timeout    string   `yaml:"timeout,omitempty"`
+}
+
 type ExporterDef struct {
 	Name string
 	Exporter
@@ -556,8 +608,10 @@ type Poller struct {
 	ClientTimeout     string               `yaml:"client_timeout,omitempty"`
 	Collectors        []Collector          `yaml:"collectors,omitempty"`
 	ConfPath          string               `yaml:"conf_path,omitempty"`
+	CounterState      CounterState         `yaml:"counter_state,omitempty"`
 	CredentialsFile   string               `yaml:"credentials_file,omitempty"`
 	CredentialsScript CredentialsScript    `yaml:"credentials_script,omitempty"`
+	CredentialsStore  CredentialsStore     `yaml:"credentials_store,omitempty"`
 	Datacenter        string               `yaml:"datacenter,omitempty"`
 	IsDisabled        bool                 `yaml:"disabled,omitempty"`
 	ExporterDefs      []ExporterDef        `yaml:"exporters,omitempty"`
@@ -567,6 +621,7 @@ type Poller struct {
 	LogMaxBytes       int64                `yaml:"log_max_bytes,omitempty"`
 	LogMaxFiles       int                  `yaml:"log_max_files,omitempty"`
 	LogSet            *[]string            `yaml:"log,omitempty"`
+	OAuth2            OAuth2               `yaml:"oauth2,omitempty"`
 	Password          string               `yaml:"password,omitempty"`
 	PollerLogSchedule string               `yaml:"poller_log_schedule,omitempty"`
 	PollerSchedule    string               `yaml:"poller_schedule,omitempty"`
@@ -606,6 +661,7 @@ func (p *Poller) Union(defaults *Poller) {
 	pAuthStyle := p.AuthStyle
 	pCredentialsFile := p.CredentialsFile
 	pCredentialsScript := p.CredentialsScript.Path
+	pCredentialsStore := p.CredentialsStore.Type
 
 	_ = mergo.Merge(p, defaults)
 
@@ -619,6 +675,7 @@ func (p *Poller) Union(defaults *Poller) {
 	p.AuthStyle = pAuthStyle
 	p.CredentialsFile = pCredentialsFile
 	p.CredentialsScript.Path = pCredentialsScript
+	p.CredentialsStore.Type = pCredentialsStore
 }
 
 func (p *Poller) IsRecording() bool {
@@ -682,6 +739,41 @@ func ZapiPoller(n *node.Node) *Poller {
 		p.CredentialsScript.Schedule = credentialsScriptNode.GetChildContentS("schedule")
 		p.CredentialsScript.Timeout = credentialsScriptNode.GetChildContentS("timeout")
 	}
+	if credentialsStoreNode := n.GetChildS("credentials_store"); credentialsStoreNode != nil {
+		p.CredentialsStore = CredentialsStore{
+			Type:         credentialsStoreNode.GetChildContentS("type"),
+			Schedule:     credentialsStoreNode.GetChildContentS("schedule"),
+			Timeout:      credentialsStoreNode.GetChildContentS("timeout"),
+			UsernameKey:  credentialsStoreNode.GetChildContentS("username_key"),
+			PasswordKey:  credentialsStoreNode.GetChildContentS("password_key"),
+			Path:         credentialsStoreNode.GetChildContentS("path"),
+			Addr:         credentialsStoreNode.GetChildContentS("addr"),
+			Mount:        credentialsStoreNode.GetChildContentS("mount"),
+			Namespace:    credentialsStoreNode.GetChildContentS("namespace"),
+			CaCertPath:   credentialsStoreNode.GetChildContentS("ca_cert"),
+			Token:        credentialsStoreNode.GetChildContentS("token"),
+			TokenFile:    credentialsStoreNode.GetChildContentS("token_file"),
+			AppRoleMount: credentialsStoreNode.GetChildContentS("approle_mount"),
+			RoleID:       credentialsStoreNode.GetChildContentS("role_id"),
+			SecretID:     credentialsStoreNode.GetChildContentS("secret_id"),
+			SecretIDFile: credentialsStoreNode.GetChildContentS("secret_id_file"),
+			UsernameEnv:  credentialsStoreNode.GetChildContentS("username_env"),
+			PasswordEnv:  credentialsStoreNode.GetChildContentS("password_env"),
+		}
+	}
+	if oauth2Node := n.GetChildS("oauth2"); oauth2Node != nil {
+		p.OAuth2 = OAuth2{
+			TokenURL:   oauth2Node.GetChildContentS("token_url"),
+			ClientID:   oauth2Node.GetChildContentS("client_id"),
+			Audience:   oauth2Node.GetChildContentS("audience"),
+			CaCertPath: oauth2Node.GetChildContentS("ca_cert"),
+			MTLS:       oauth2Node.GetChildContentS("mtls") == "true",
+			Timeout:    oauth2Node.GetChildContentS("timeout"),
+		}
+		if scopes := oauth2Node.GetChildS("scopes"); scopes != nil {
+			p.OAuth2.Scopes = scopes.GetAllChildContentS()
+		}
+	}
 	if certificateScriptNode := n.GetChildS("certificate_script"); certificateScriptNode != nil {
 		p.CertificateScript.Path = certificateScriptNode.GetChildContentS("path")
 		p.CertificateScript.Timeout = certificateScriptNode.GetChildContentS("timeout")
@@ -709,17 +801,19 @@ func ZapiPoller(n *node.Node) *Poller {
 }
 
 type Exporter struct {
-	Port              *int      `yaml:"port,omitempty"`
-	PortRange         *IntRange `yaml:"port_range,omitempty"`
-	Type              string    `yaml:"exporter,omitempty"`
-	Addr              *string   `yaml:"addr,omitempty"`
-	URL               *string   `yaml:"url,omitempty"`
-	LocalHTTPAddr     string    `yaml:"local_http_addr,omitempty"`
-	GlobalPrefix      *string   `yaml:"global_prefix,omitempty"`
-	AllowedAddrs      *[]string `yaml:"allow_addrs,omitempty"`
-	AllowedAddrsRegex *[]string `yaml:"allow_addrs_regex,omitempty"`
-	CacheMaxKeep      *string   `yaml:"cache_max_keep,omitempty"`
-	ShouldAddMetaTags *bool     `yaml:"add_meta_tags,omitempty"`
+	Port              *int         `yaml:"port,omitempty"`
+	PortRange         *IntRange    `yaml:"port_range,omitempty"`
+	Type              string       `yaml:"exporter,omitempty"`
+	Addr              *string      `yaml:"addr,omitempty"`
+	URL               *string      `yaml:"url,omitempty"`
+	LocalHTTPAddr     string       `yaml:"local_http_addr,omitempty"`
+	GlobalPrefix      *string      `yaml:"global_prefix,omitempty"`
+	AllowedAddrs      *[]string    `yaml:"allow_addrs,omitempty"`
+	AllowedAddrsRegex *[]string    `yaml:"allow_addrs_regex,omitempty"`
+	CacheMaxKeep      *string      `yaml:"cache_max_keep,omitempty"`
+	ShouldAddMetaTags *bool        `yaml:"add_meta_tags,omitempty"`
+	RelabelConfigs    []Relabel    `yaml:"relabel_configs,omitempty"`
+	Cardinality       *Cardinality `yaml:"cardinality,omitempty"`
 
 	// Prometheus specific
 	HeartBeatURL string `yaml:"heart_beat_url,omitempty"`
@@ -738,6 +832,41 @@ type Exporter struct {
 	IsEmbedded bool // true when the exporter is embedded in a poller
 }
 
+// Relabel is an exporter-level relabeling rule, modeled on Prometheus' relabel_config.
+// Rules are applied in order to every series before it is rendered.
+type Relabel struct {
+	SourceLabels []string `yaml:"source_labels,omitempty"`
+	Separator    *string  `yaml:"separator,omitempty"`
+	Regex        *string  `yaml:"regex,omitempty"`
+	Modulus      uint64   `yaml:"modulus,omitempty"`
+	TargetLabel  string   `yaml:"target_label,omitempty"`
+	Replacement  *string  `yaml:"replacement,omitempty"`
+	Action       string   `yaml:"action,omitempty"`
+}
+
+// CardinalityBudget limits the number of instances and series exported per object.
+// Instances over budget are ranked by sort_by and combined into an "other" instance, unless other is false.
+type CardinalityBudget struct {
+	MaxInstances int    `yaml:"max_instances,omitempty"`
+	MaxSeries    int    `yaml:"max_series,omitempty"`
+	SortBy       string `yaml:"sort_by,omitempty"`
+	Other        *bool  `yaml:"other,omitempty"`
+}
+
+// Cardinality is an exporter's default budget for every object, and optional per-object budgets
+type Cardinality struct {
+	CardinalityBudget `yaml:",inline"`
+	Objects           map[string]CardinalityBudget `yaml:"objects,omitempty"`
+}
+
+// Budget returns the budget of the given object
+func (c *Cardinality) Budget(object string) CardinalityBudget {
+	if b, ok := c.Objects[object]; ok {
+		return b
+	}
+	return c.CardinalityBudget
+}
+
 type Pollers struct {
 	namesInOrder []string
 }
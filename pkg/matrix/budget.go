@@ -0,0 +1,231 @@
+package matrix
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+)
+
+// OtherInstance is the key and label value of the instance that combines the instances that did not fit in a Budget
+const OtherInstance = "other"
+
+// Budget limits the cardinality of a matrix.
+// When the matrix is over budget, instances are ranked by the SortBy metric, highest first, or by instance key
+// when SortBy is empty. The top ranked instances are kept and the rest are combined into an "other" instance,
+// or dropped when DropOther is true. Averages and percents of the other instance are weighted by their base counter,
+// other metrics are summed.
+type Budget struct {
+	MaxInstances int
+	MaxSeries    int
+	SortBy       string
+	DropOther    bool
+}
+
+// Overflow counts the instances that did not fit in a Budget and the series of those instances
+type Overflow struct {
+	Instances int
+	Series    int
+}
+
+func (b Budget) IsZero() bool {
+	return b.MaxInstances <= 0 && b.MaxSeries <= 0
+}
+
+type rankedInstance struct {
+	key      string
+	instance *Instance
+	series   int
+	value    float64
+	hasValue bool
+}
+
+// ApplyBudget returns m unchanged when it fits in the budget.
+// Otherwise, it returns a truncated copy of m, since m is reused by the collector on the next poll,
+// and how many instances and series did not fit.
+func (m *Matrix) ApplyBudget(b Budget) (*Matrix, Overflow) {
+	if b.IsZero() {
+		return m, Overflow{}
+	}
+
+	var exportable []*Metric
+	for _, metric := range m.GetMetrics() {
+		if metric.IsExportable() {
+			exportable = append(exportable, metric)
+		}
+	}
+
+	sortBy := m.DisplayMetric(b.SortBy)
+	if sortBy == nil {
+		sortBy = m.GetMetric(b.SortBy)
+	}
+
+	ranked := make([]rankedInstance, 0, len(m.instances))
+	total := 0
+	for key, instance := range m.instances {
+		if !instance.IsExportable() {
+			continue
+		}
+		r := rankedInstance{key: key, instance: instance}
+		for _, metric := range exportable {
+			if _, ok := metric.GetValueFloat64(instance); ok {
+				r.series++
+			}
+		}
+		if sortBy != nil {
+			r.value, r.hasValue = sortBy.GetValueFloat64(instance)
+		}
+		total += r.series
+		ranked = append(ranked, r)
+	}
+
+	if (b.MaxInstances <= 0 || len(ranked) <= b.MaxInstances) && (b.MaxSeries <= 0 || total <= b.MaxSeries) {
+		return m, Overflow{}
+	}
+
+	slices.SortFunc(ranked, func(a, b rankedInstance) int {
+		if a.hasValue != b.hasValue {
+			if a.hasValue {
+				return -1
+			}
+			return 1
+		}
+		if c := cmp.Compare(b.value, a.value); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.key, b.key)
+	})
+
+	// Reserve room for the other instance
+	maxInstances := b.MaxInstances
+	maxSeries := b.MaxSeries
+	if !b.DropOther {
+		maxInstances--
+		maxSeries -= len(exportable)
+	}
+
+	kept := 0
+	series := 0
+	for _, r := range ranked {
+		if b.MaxInstances > 0 && kept >= maxInstances {
+			break
+		}
+		if b.MaxSeries > 0 && series+r.series > maxSeries {
+			break
+		}
+		kept++
+		series += r.series
+	}
+
+	overflow := Overflow{Instances: len(ranked) - kept, Series: total - series}
+	if overflow.Instances == 0 {
+		return m, Overflow{}
+	}
+
+	truncated := m.Clone(With{Metrics: true})
+	for _, r := range ranked[:kept] {
+		instance, _ := truncated.NewInstance(r.key)
+		instance.SetLabels(r.instance.Copy())
+		copyValues(m, truncated, r.instance, instance)
+	}
+
+	if !b.DropOther {
+		key := OtherInstance
+		for truncated.GetInstance(key) != nil {
+			key = "_" + key
+		}
+		other, _ := truncated.NewInstance(key)
+		for _, label := range m.otherLabels(ranked[kept:]) {
+			other.SetLabel(label, OtherInstance)
+		}
+		for key, metric := range m.GetMetrics() {
+			if v, ok := m.combine(metric, ranked[kept:]); ok {
+				_ = truncated.GetMetric(key).SetValueFloat64(other, v)
+			}
+		}
+	}
+
+	return truncated, overflow
+}
+
+// combine returns the value of metric for the other instance. Averages and percents are weighted by their base
+// counter, e.g. latency by ops, or averaged when the base counter is unknown, like the Aggregator plugin does.
+// Other metrics are summed
+func (m *Matrix) combine(metric *Metric, overflow []rankedInstance) (float64, bool) {
+	if !isAverage(metric) {
+		sum := 0.0
+		found := false
+		for _, r := range overflow {
+			if v, ok := metric.GetValueFloat64(r.instance); ok {
+				sum += v
+				found = true
+			}
+		}
+		return sum, found
+	}
+
+	base := m.GetMetric(metric.GetComment())
+	sum := 0.0
+	weights := 0.0
+	found := false
+	for _, r := range overflow {
+		v, ok := metric.GetValueFloat64(r.instance)
+		if !ok {
+			continue
+		}
+		weight := 1.0
+		if base != nil {
+			if weight, ok = base.GetValueFloat64(r.instance); !ok {
+				continue
+			}
+		}
+		sum += v * weight
+		weights += weight
+		found = true
+	}
+	// if no ops happened
+	if weights == 0 {
+		return 0, found
+	}
+	return sum / weights, found
+}
+
+// isAverage returns true for metrics that can not be summed, e.g. latencies and percents
+func isAverage(metric *Metric) bool {
+	name := metric.GetName()
+	switch {
+	case metric.GetProperty() == "average" || metric.GetProperty() == "percent":
+		return true
+	case strings.Contains(name, "average_") || strings.Contains(name, "avg_"):
+		return true
+	case !metric.IsHistogram() && strings.Contains(name, "_latency"):
+		return true
+	}
+	return false
+}
+
+func copyValues(from *Matrix, to *Matrix, fromInstance *Instance, toInstance *Instance) {
+	for key, metric := range from.GetMetrics() {
+		if v, ok := metric.GetValueFloat64(fromInstance); ok {
+			_ = to.GetMetric(key).SetValueFloat64(toInstance, v)
+		}
+	}
+}
+
+// otherLabels returns the labels of the other instance, which are the exported instance keys,
+// or, when all labels are exported, the labels of the overflow instances
+func (m *Matrix) otherLabels(overflow []rankedInstance) []string {
+	options := m.GetExportOptions()
+	if options.GetChildContentS("include_all_labels") != "true" {
+		if keys := options.GetChildS("instance_keys"); keys != nil {
+			return keys.GetAllChildContentS()
+		}
+	}
+	var labels []string
+	for _, r := range overflow {
+		for label := range r.instance.Labels() {
+			labels = append(labels, label)
+		}
+	}
+	slices.Sort(labels)
+	return slices.Compact(labels)
+}
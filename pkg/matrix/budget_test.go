@@ -0,0 +1,147 @@
+package matrix
+
+import (
+	"slices"
+	"testing"
+)
+
+func setUpQtrees() *Matrix {
+	m := New("qtree", "qtree", "qtree")
+	options := DefaultExportOptions()
+	options.PopChildS("include_all_labels")
+	options.NewChildS("instance_keys", "").NewChildS("", "qtree")
+	m.SetExportOptions(options)
+
+	used, _ := m.NewMetricFloat64("disk_used")
+	files, _ := m.NewMetricFloat64("files_used")
+	for i, name := range []string{"q1", "q2", "q3", "q4", "q5"} {
+		instance, _ := m.NewInstance(name)
+		instance.SetLabel("qtree", name)
+		_ = used.SetValueFloat64(instance, float64((i*7)%5*10)) // 0, 20, 40, 10, 30
+		_ = files.SetValueFloat64(instance, 1)
+	}
+	return m
+}
+
+func budgetLabels(m *Matrix) []string {
+	var labels []string
+	for _, instance := range m.GetInstances() {
+		labels = append(labels, instance.GetLabel("qtree"))
+	}
+	slices.Sort(labels)
+	return labels
+}
+
+func TestApplyBudget(t *testing.T) {
+	tests := []struct {
+		name      string
+		budget    Budget
+		want      []string
+		wantOther float64
+		wantFlow  Overflow
+		unchanged bool
+	}{
+		{name: "no budget", budget: Budget{}, unchanged: true},
+		{name: "fits", budget: Budget{MaxInstances: 5, MaxSeries: 10}, unchanged: true},
+		{
+			name:      "top instances by metric",
+			budget:    Budget{MaxInstances: 3, SortBy: "disk_used"},
+			want:      []string{"other", "q3", "q5"},
+			wantOther: 30, // q2 + q4 + q1
+			wantFlow:  Overflow{Instances: 3, Series: 6},
+		},
+		{
+			name:     "drop by key order",
+			budget:   Budget{MaxInstances: 2, DropOther: true},
+			want:     []string{"q1", "q2"},
+			wantFlow: Overflow{Instances: 3, Series: 6},
+		},
+		{
+			name:      "series budget",
+			budget:    Budget{MaxSeries: 6, SortBy: "disk_used"},
+			want:      []string{"other", "q3", "q5"},
+			wantOther: 30,
+			wantFlow:  Overflow{Instances: 3, Series: 6},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := setUpQtrees()
+			got, overflow := m.ApplyBudget(tt.budget)
+			if tt.unchanged {
+				if got != m || overflow != (Overflow{}) {
+					t.Errorf("expected the matrix to be returned unchanged")
+				}
+				return
+			}
+			if overflow != tt.wantFlow {
+				t.Errorf("got overflow=%+v, want %+v", overflow, tt.wantFlow)
+			}
+			if labels := budgetLabels(got); !slices.Equal(labels, tt.want) {
+				t.Errorf("got instances=%v, want %v", labels, tt.want)
+			}
+			if len(m.GetInstances()) != 5 {
+				t.Errorf("expected the original matrix to be unchanged")
+			}
+			if other := got.GetInstance(OtherInstance); other != nil {
+				v, _ := got.GetMetric("disk_used").GetValueFloat64(other)
+				if v != tt.wantOther {
+					t.Errorf("got other disk_used=%f, want %f", v, tt.wantOther)
+				}
+			}
+			// Kept instances keep their values
+			if q3 := got.GetInstance("q3"); q3 != nil {
+				if v, _ := got.GetMetric("disk_used").GetValueFloat64(q3); v != 40 {
+					t.Errorf("got q3 disk_used=%f, want 40", v)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyBudgetAverages(t *testing.T) {
+	m := New("volume", "volume", "volume")
+	ops, _ := m.NewMetricFloat64("read_ops")
+	ops.SetProperty("rate")
+	latency, _ := m.NewMetricFloat64("read_latency")
+	latency.SetProperty("average")
+	latency.SetComment("read_ops")
+	busy, _ := m.NewMetricFloat64("cpu_busy")
+	busy.SetProperty("percent")
+
+	values := []struct {
+		name               string
+		ops, latency, busy float64
+	}{
+		{"v1", 1000, 5, 90},
+		{"v2", 100, 10, 20},
+		{"v3", 300, 20, 40},
+		{"v4", 0, 0, 0},
+	}
+	for _, v := range values {
+		instance, _ := m.NewInstance(v.name)
+		instance.SetLabel("volume", v.name)
+		_ = ops.SetValueFloat64(instance, v.ops)
+		_ = latency.SetValueFloat64(instance, v.latency)
+		_ = busy.SetValueFloat64(instance, v.busy)
+	}
+
+	got, _ := m.ApplyBudget(Budget{MaxInstances: 2, SortBy: "read_ops"})
+	other := got.GetInstance(OtherInstance)
+	if other == nil {
+		t.Fatalf("expected an other instance")
+	}
+
+	want := map[string]float64{
+		"read_ops":     400,                       // v3 + v2 + v4
+		"read_latency": (100*10 + 300*20) / 400.0, // weighted by read_ops
+		"cpu_busy":     (20 + 40 + 0) / 3.0,       // no base counter, averaged
+	}
+	for name, w := range want {
+		v, _ := got.GetMetric(name).GetValueFloat64(other)
+		if v != w {
+			t.Errorf("got other %s=%f, want %f", name, v, w)
+		}
+	}
+}
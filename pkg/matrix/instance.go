@@ -5,47 +5,102 @@
 package matrix
 
 import (
-	"maps"
+	"iter"
+	"unique"
 )
 
 // Instance struct and related methods
 
+// Instance labels are stored as interned values in a slice indexed by the slot of the label's key in a dictionary
+// shared with the instance's matrix, see labelKeys
 type Instance struct {
 	index      int
-	labels     map[string]string
+	keys       *labelKeys
+	values     []unique.Handle[string]
 	exportable bool
 	partial    bool
 }
 
+// NewInstance creates an instance with its own label dictionary. Matrix.NewInstance shares the matrix's dictionary
 func NewInstance(index int) *Instance {
-	me := &Instance{index: index}
-	me.labels = make(map[string]string)
-	me.exportable = true
-	return me
+	return newInstance(index, newLabelKeys())
+}
+
+func newInstance(index int, keys *labelKeys) *Instance {
+	return &Instance{index: index, keys: keys, exportable: true}
 }
 
 func (i *Instance) GetLabel(key string) string {
-	return i.labels[key]
+	value, _ := i.label(key)
+	return value
+}
+
+func (i *Instance) label(key string) (string, bool) {
+	s, ok := i.keys.slot(key)
+	if !ok || s >= len(i.values) || i.values[s] == absent {
+		return "", false
+	}
+	return i.values[s].Value(), true
 }
 
 func (i *Instance) GetIndex() int {
 	return i.index
 }
 
+// GetLabels returns a copy of the instance's labels. Use SetLabel to change a label
 func (i *Instance) GetLabels() map[string]string {
-	return i.labels
+	labels := make(map[string]string, len(i.values))
+	for key, value := range i.Labels() {
+		labels[key] = value
+	}
+	return labels
+}
+
+// Labels iterates over the instance's labels without allocating a map
+func (i *Instance) Labels() iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		names := i.keys.names()
+		for s, value := range i.values {
+			if value == absent {
+				continue
+			}
+			if !yield(names[s], value.Value()) {
+				return
+			}
+		}
+	}
+}
+
+// NumLabels returns the number of labels of the instance
+func (i *Instance) NumLabels() int {
+	n := 0
+	for _, value := range i.values {
+		if value != absent {
+			n++
+		}
+	}
+	return n
 }
 
 func (i *Instance) ClearLabels() {
-	clear(i.labels)
+	clear(i.values)
+	i.values = i.values[:0]
 }
 
 func (i *Instance) SetLabel(key, value string) {
-	i.labels[key] = value
+	s := i.keys.add(key)
+	if s >= len(i.values) {
+		i.values = append(i.values, make([]unique.Handle[string], s+1-len(i.values))...)
+	}
+	i.values[s] = intern(value)
 }
 
+// SetLabels replaces the instance's labels with a copy of labels
 func (i *Instance) SetLabels(labels map[string]string) {
-	i.labels = labels
+	i.ClearLabels()
+	for key, value := range labels {
+		i.SetLabel(key, value)
+	}
 }
 
 func (i *Instance) IsExportable() bool {
@@ -65,19 +120,27 @@ func (i *Instance) SetPartial(b bool) {
 }
 
 func (i *Instance) Clone(isExportable bool, labels ...string) *Instance {
-	clone := NewInstance(i.index)
-	clone.labels = i.Copy(labels...)
+	clone := newInstance(i.index, i.keys)
+	if len(labels) == 0 {
+		// Values are immutable handles, so a shallow copy is enough
+		clone.values = make([]unique.Handle[string], len(i.values))
+		copy(clone.values, i.values)
+	} else {
+		for _, k := range labels {
+			clone.SetLabel(k, i.GetLabel(k))
+		}
+	}
 	clone.exportable = isExportable
 	return clone
 }
 
 func (i *Instance) Copy(labels ...string) map[string]string {
 	if len(labels) == 0 {
-		return maps.Clone(i.labels)
+		return i.GetLabels()
 	}
 	m := make(map[string]string, len(labels))
 	for _, k := range labels {
-		m[k] = i.labels[k]
+		m[k] = i.GetLabel(k)
 	}
 	return m
 }
@@ -89,11 +152,11 @@ func (i *Instance) CompareDiffs(prev *Instance, compareKeys []string) (map[strin
 	old := make(map[string]string)
 
 	for _, compareKey := range compareKeys {
-		val1, ok1 := i.GetLabels()[compareKey]
+		val1, ok1 := i.label(compareKey)
 		if !ok1 {
 			continue
 		}
-		val2, ok2 := prev.GetLabels()[compareKey]
+		val2, ok2 := prev.label(compareKey)
 		if !ok2 || val1 != val2 {
 			cur[compareKey] = val1
 			old[compareKey] = val2
@@ -0,0 +1,65 @@
+package matrix
+
+import (
+	"maps"
+	"sync"
+	"sync/atomic"
+	"unique"
+)
+
+// labelKeys is a dictionary of label keys that is shared by the instances of a matrix and the matrix's clones.
+// Instead of a map per instance, each instance stores its label values in a slice indexed by the key's slot.
+// Label values are interned, so a value such as an SVM or node name is stored once, no matter how many instances use it.
+//
+// Slots are never removed and keys are rarely added, so readers load an immutable snapshot of the dictionary
+// without locking and writers replace the snapshot.
+type labelKeys struct {
+	mu   sync.Mutex
+	dict atomic.Pointer[labelDict]
+}
+
+type labelDict struct {
+	slots map[string]int
+	names []string
+}
+
+// absent is the value of a label that is not set. It is the zero handle, which is never returned by unique.Make
+var absent unique.Handle[string]
+
+func newLabelKeys() *labelKeys {
+	k := &labelKeys{}
+	k.dict.Store(&labelDict{slots: make(map[string]int)})
+	return k
+}
+
+// slot returns the slot of key, or false when no instance has used the key
+func (k *labelKeys) slot(key string) (int, bool) {
+	s, ok := k.dict.Load().slots[key]
+	return s, ok
+}
+
+// add returns the slot of key, adding key to the dictionary when it is missing
+func (k *labelKeys) add(key string) int {
+	if s, ok := k.slot(key); ok {
+		return s
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	d := k.dict.Load()
+	if s, ok := d.slots[key]; ok {
+		return s
+	}
+	next := &labelDict{slots: maps.Clone(d.slots), names: append(d.names[:len(d.names):len(d.names)], key)}
+	s := len(d.names)
+	next.slots[key] = s
+	k.dict.Store(next)
+	return s
+}
+
+func (k *labelKeys) names() []string {
+	return k.dict.Load().names
+}
+
+func intern(value string) unique.Handle[string] {
+	return unique.Make(value)
+}
@@ -0,0 +1,115 @@
+package matrix_test
+
+import (
+	"github.com/netapp/harvest/v2/cmd/exporters/influxdb"
+	"github.com/netapp/harvest/v2/cmd/exporters/prometheus"
+	"github.com/netapp/harvest/v2/cmd/poller/exporter"
+	"github.com/netapp/harvest/v2/cmd/poller/options"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/matrix"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"strconv"
+	"testing"
+)
+
+// The benchmarks use a matrix shaped like a large cluster's volumes, where a few SVMs, nodes, and aggregates
+// are shared by many instances. Run them with
+// go test -run=^$ -bench=. -benchmem ./pkg/matrix/
+const benchInstances = 100_000
+
+func newBenchMatrix() *matrix.Matrix {
+	m := matrix.New("Rest", "volume", "volume")
+	options := node.NewS("export_options")
+	keys := options.NewChildS("instance_keys", "")
+	for _, key := range []string{"volume", "svm", "node", "aggr"} {
+		keys.NewChildS("", key)
+	}
+	labels := options.NewChildS("instance_labels", "")
+	for _, label := range []string{"style", "state", "type"} {
+		labels.NewChildS("", label)
+	}
+	m.SetExportOptions(options)
+	m.SetGlobalLabel("cluster", "cluster1")
+
+	size, _ := m.NewMetricFloat64("size")
+	used, _ := m.NewMetricFloat64("size_used")
+	for i := range benchInstances {
+		name := "vol" + strconv.Itoa(i)
+		instance, _ := m.NewInstance(name)
+		instance.SetLabel("volume", name)
+		instance.SetLabel("svm", "svm"+strconv.Itoa(i%20))
+		instance.SetLabel("node", "node"+strconv.Itoa(i%8))
+		instance.SetLabel("aggr", "aggr"+strconv.Itoa(i%16))
+		instance.SetLabel("style", "flexvol")
+		instance.SetLabel("state", "online")
+		instance.SetLabel("type", "rw")
+		_ = size.SetValueFloat64(instance, float64(i))
+		_ = used.SetValueFloat64(instance, float64(i/2))
+	}
+	return m
+}
+
+func BenchmarkLabels_Build(b *testing.B) {
+	b.ReportAllocs()
+	for range b.N {
+		newBenchMatrix()
+	}
+}
+
+func BenchmarkLabels_Clone(b *testing.B) {
+	m := newBenchMatrix()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		m.Clone(matrix.With{Data: true, Metrics: true, Instances: true, ExportInstances: true})
+	}
+}
+
+func BenchmarkLabels_Iterate(b *testing.B) {
+	m := newBenchMatrix()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		n := 0
+		for _, instance := range m.GetInstances() {
+			for _, value := range instance.Labels() {
+				n += len(value)
+			}
+		}
+	}
+}
+
+func BenchmarkLabels_PrometheusRender(b *testing.B) {
+	absExp := exporter.New("Prometheus", "prom1", &options.Options{PromPort: 1}, conf.Exporter{IsTest: true}, nil)
+	p := prometheus.New(absExp)
+	if err := p.Init(); err != nil {
+		b.Fatal(err)
+	}
+	m := newBenchMatrix()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		if _, err := p.Export(m); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLabels_InfluxDBRender(b *testing.B) {
+	opts := options.New()
+	opts.IsTest = true
+	url := "http://localhost:8086/api/v2/write?org=harvest&bucket=harvest&precision=s"
+	token := "token"
+	influx := &influxdb.InfluxDB{AbstractExporter: exporter.New("InfluxDB", "influx1", opts, conf.Exporter{URL: &url, Token: &token}, nil)}
+	if err := influx.Init(); err != nil {
+		b.Fatal(err)
+	}
+	m := newBenchMatrix()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		if _, _, err := influx.Render(m); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
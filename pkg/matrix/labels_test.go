@@ -0,0 +1,73 @@
+package matrix
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestInstance_Labels(t *testing.T) {
+	m := New("Test", "volume", "volume")
+	a, _ := m.NewInstance("A")
+	b, _ := m.NewInstance("B")
+	a.SetLabel("svm", "svm1")
+	a.SetLabel("volume", "vol1")
+	b.SetLabel("node", "node1")
+	b.SetLabel("svm", "svm1")
+
+	if got := a.GetLabels(); !maps.Equal(got, map[string]string{"svm": "svm1", "volume": "vol1"}) {
+		t.Errorf("got A labels=%v", got)
+	}
+	if got := maps.Collect(b.Labels()); !maps.Equal(got, map[string]string{"node": "node1", "svm": "svm1"}) {
+		t.Errorf("got B labels=%v", got)
+	}
+	if a.GetLabel("node") != "" || a.NumLabels() != 2 {
+		t.Errorf("expected A to have two labels and no node, got %v", a.GetLabels())
+	}
+
+	// GetLabels returns a copy
+	a.GetLabels()["svm"] = "svm2"
+	if a.GetLabel("svm") != "svm1" {
+		t.Errorf("expected GetLabels to return a copy")
+	}
+
+	// An empty label is different from a missing one
+	a.SetLabel("node", "")
+	if _, ok := a.GetLabels()["node"]; !ok || a.NumLabels() != 3 {
+		t.Errorf("expected an empty node label, got %v", a.GetLabels())
+	}
+
+	a.SetLabels(map[string]string{"aggr": "aggr1"})
+	if got := a.GetLabels(); !maps.Equal(got, map[string]string{"aggr": "aggr1"}) {
+		t.Errorf("got A labels=%v after SetLabels", got)
+	}
+	a.ClearLabels()
+	if a.NumLabels() != 0 {
+		t.Errorf("expected no labels after ClearLabels, got %v", a.GetLabels())
+	}
+}
+
+func TestInstance_CloneLabels(t *testing.T) {
+	m := New("Test", "volume", "volume")
+	a, _ := m.NewInstance("A")
+	a.SetLabel("svm", "svm1")
+	a.SetLabel("volume", "vol1")
+
+	clone := m.Clone(With{Data: true, Metrics: true, Instances: true})
+	ca := clone.GetInstance("A")
+	ca.SetLabel("svm", "svm2")
+	ca.SetLabel("style", "flexvol")
+	if a.GetLabel("svm") != "svm1" || a.GetLabel("style") != "" {
+		t.Errorf("expected the clone's labels to be independent, got %v", a.GetLabels())
+	}
+
+	partial := a.Clone(true, "volume", "aggr")
+	if got := partial.GetLabels(); !maps.Equal(got, map[string]string{"volume": "vol1", "aggr": ""}) {
+		t.Errorf("got clone labels=%v", got)
+	}
+
+	standalone := NewInstance(0)
+	standalone.SetLabel("node", "node1")
+	if standalone.GetLabel("node") != "node1" || a.GetLabel("node") != "" {
+		t.Errorf("expected standalone instances to have their own keys")
+	}
+}
@@ -30,6 +30,7 @@ type Matrix struct {
 	displayMetrics map[string]string  // display name of metric to => metric name (in templates, this is right side)
 	exportOptions  *node.Node
 	exportable     bool
+	labelKeys      *labelKeys // label key dictionary shared by the instances of this matrix and its clones
 }
 
 type With struct {
@@ -49,6 +50,7 @@ func New(uuid, object string, identifier string) *Matrix {
 	me.metrics = make(map[string]*Metric)
 	me.displayMetrics = make(map[string]string)
 	me.exportable = true
+	me.labelKeys = newLabelKeys()
 	return &me
 }
 
@@ -79,10 +81,11 @@ func (m *Matrix) SetExportable(b bool) {
 
 func (m *Matrix) Clone(with With) *Matrix {
 	clone := &Matrix{UUID: m.UUID, Object: m.Object, Identifier: m.Identifier}
-	clone.globalLabels = m.globalLabels
+	clone.globalLabels = maps.Clone(m.globalLabels)
 	clone.exportOptions = m.exportOptions
 	clone.exportable = m.exportable
 	clone.displayMetrics = make(map[string]string)
+	clone.labelKeys = m.labelKeys
 
 	if with.Instances {
 		clone.instances = make(map[string]*Instance, len(m.GetInstances()))
@@ -268,7 +271,7 @@ func (m *Matrix) NewInstance(key string) (*Instance, error) {
 		return nil, errs.New(ErrDuplicateInstanceKey, key)
 	}
 
-	instance = NewInstance(len(m.instances)) // index is current count of instances
+	instance = newInstance(len(m.instances), m.labelKeys) // index is current count of instances
 
 	for _, metric := range m.GetMetrics() {
 		metric.Append()
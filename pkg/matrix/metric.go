@@ -24,6 +24,7 @@ type Metric struct {
 	comment    string
 	array      bool
 	histogram  bool
+	counter    bool
 	exportable bool
 	labels     map[string]string
 	buckets    *[]string
@@ -40,6 +41,7 @@ func (m *Metric) Clone(deep bool) *Metric {
 		exportable: m.exportable,
 		array:      m.array,
 		histogram:  m.histogram,
+		counter:    m.counter,
 		buckets:    m.buckets,
 	}
 	clone.labels = maps.Clone(m.labels)
@@ -111,6 +113,15 @@ func (m *Metric) IsHistogram() bool {
 	return m.histogram
 }
 
+// IsCounter returns true when the metric holds the raw value of a monotonically increasing counter
+func (m *Metric) IsCounter() bool {
+	return m.counter
+}
+
+func (m *Metric) SetCounter(b bool) {
+	m.counter = b
+}
+
 func (m *Metric) Buckets() *[]string {
 	return m.buckets
 }
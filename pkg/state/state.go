@@ -0,0 +1,109 @@
+// Package state persists the state of collectors and plugins, like the previous poll or the history of a metric,
+// across poller restarts
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/netapp/harvest/v2/pkg/conf"
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// Key is the section of the poller or collector parameters whose dir is where state is stored
+const Key = "counter_state"
+
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// Dir returns the directory of the counter_state section of params, resolved like other Harvest paths.
+// It returns "" when state is not configured, in which case nothing is persisted.
+func Dir(params *node.Node) string {
+	if params == nil {
+		return ""
+	}
+	section := params.GetChildS(Key)
+	if section == nil {
+		return ""
+	}
+	dir := section.GetChildContentS("dir")
+	if dir == "" {
+		return ""
+	}
+	return conf.Path(dir)
+}
+
+// Path returns the path of the state file <dir>/<poller>/<name>.json, or "" when dir is empty.
+// The poller and name are sanitized, so object names like disk:constituent, or a poller name with / or ..,
+// are valid file names inside dir.
+func Path(dir string, poller string, name string) string {
+	if dir == "" {
+		return ""
+	}
+	if poller == "" {
+		poller = "default"
+	}
+	return filepath.Join(dir, sanitize(poller), sanitize(name)+".json")
+}
+
+func sanitize(name string) string {
+	name = unsafeFileChars.ReplaceAllString(name, "_")
+	if name == "." || name == ".." {
+		return "_"
+	}
+	return name
+}
+
+// Load reads the state stored in path into v.
+// An empty path, or a missing file, is not an error and leaves v unchanged.
+func Load(path string, v any) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to parse state %s: %w", path, err)
+	}
+	return nil
+}
+
+// Save stores v in path, and does nothing when path is empty.
+// The file is replaced atomically, so a crash while saving leaves the previous state.
+func Save(path string, v any) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
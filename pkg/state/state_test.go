@@ -0,0 +1,81 @@
+package state
+
+import (
+	"github.com/netapp/harvest/v2/pkg/tree/node"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPath(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name   string
+		poller string
+		object string
+		want   string
+	}{
+		{name: "object", poller: "dc1", object: "zapiperf_disk:constituent", want: filepath.Join(dir, "dc1", "zapiperf_disk_constituent.json")},
+		{name: "poller with slash", poller: "../dc1/x", object: "volume", want: filepath.Join(dir, ".._dc1_x", "volume.json")},
+		{name: "dot dot", poller: "..", object: "..", want: filepath.Join(dir, "_", "_.json")},
+		{name: "no poller", poller: "", object: "volume", want: filepath.Join(dir, "default", "volume.json")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Path(dir, tt.poller, tt.object)
+			if got != tt.want {
+				t.Errorf("Path() got %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if got := Path("", "dc1", "volume"); got != "" {
+		t.Errorf("Path() without dir got %s, want empty", got)
+	}
+}
+
+func TestDir(t *testing.T) {
+	dir := t.TempDir()
+	params := node.NewS("Rest")
+	if got := Dir(params); got != "" {
+		t.Errorf("Dir() without counter_state got %s, want empty", got)
+	}
+	params.NewChildS(Key, "").NewChildS("dir", dir)
+	if got := Dir(params); got != dir {
+		t.Errorf("Dir() got %s, want %s", got, dir)
+	}
+	if got := Dir(nil); got != "" {
+		t.Errorf("Dir(nil) got %s, want empty", got)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := Path(t.TempDir(), "dc1", "volume")
+
+	var missing map[string]int
+	if err := Load(path, &missing); err != nil || missing != nil {
+		t.Fatalf("Load() of a missing file got %v %v, want no error and no state", missing, err)
+	}
+
+	if err := Save(path, map[string]int{"a": 1}); err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]int
+	if err := Load(path, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["a"] != 1 {
+		t.Errorf("Load() got %v, want a=1", got)
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Load(path, &got); err == nil {
+		t.Error("Load() of a corrupt file got no error")
+	}
+
+	if err := Save("", got); err != nil {
+		t.Errorf("Save() without path got %v, want no error", err)
+	}
+}
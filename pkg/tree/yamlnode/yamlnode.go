@@ -0,0 +1,41 @@
+// Package yamlnode edits the mapping nodes of a yaml.Node document in place,
+// so that rewritten files keep their comments and key order.
+package yamlnode
+
+import (
+	"gopkg.in/yaml.v3"
+	"slices"
+)
+
+// Value returns the value of key in the mapping node n, or nil when n is nil or does not have key
+func Value(n *yaml.Node, key string) *yaml.Node {
+	if n == nil {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// Set replaces the value of key in the mapping node n with a scalar, or appends key when n does not have it
+func Set(n *yaml.Node, key string, value string) {
+	if v := Value(n, key); v != nil {
+		*v = yaml.Node{Kind: yaml.ScalarNode, Value: value}
+		return
+	}
+	n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key},
+		&yaml.Node{Kind: yaml.ScalarNode, Value: value})
+}
+
+// Delete removes key and its value from the mapping node n
+func Delete(n *yaml.Node, key string) {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			n.Content = slices.Delete(n.Content, i, i+2)
+			return
+		}
+	}
+}
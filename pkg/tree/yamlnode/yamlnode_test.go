@@ -0,0 +1,52 @@
+package yamlnode
+
+import (
+	"gopkg.in/yaml.v3"
+	"testing"
+)
+
+func TestEdit(t *testing.T) {
+	input := `Pollers:
+  # the first cluster
+  sar:
+    addr: 10.0.1.1
+    password: secret
+    collectors:
+      - Rest
+`
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte(input), &root); err != nil {
+		t.Fatal(err)
+	}
+	poller := Value(Value(root.Content[0], "Pollers"), "sar")
+	if poller == nil {
+		t.Fatal("expected to find poller sar")
+	}
+	if Value(Value(root.Content[0], "Pollers"), "missing") != nil {
+		t.Errorf("expected no value for a missing poller")
+	}
+	if Value(Value(root.Content[0], "Missing"), "sar") != nil {
+		t.Errorf("expected no value below a missing key")
+	}
+
+	Set(poller, "addr", "10.0.1.2")
+	Set(poller, "username", "harvest")
+	Delete(poller, "password")
+	Delete(poller, "missing")
+
+	out, err := yaml.Marshal(&root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `Pollers:
+    # the first cluster
+    sar:
+        addr: 10.0.1.2
+        collectors:
+            - Rest
+        username: harvest
+`
+	if string(out) != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
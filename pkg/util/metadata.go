@@ -4,10 +4,16 @@ type Metadata struct {
 	BytesRx         uint64
 	NumCalls        uint64
 	PluginInstances uint64
+	JoinHits        uint64 // instances the Join plugin enriched
+	JoinMisses      uint64 // instances without a match in the joined object
+	JoinStale       uint64 // instances not enriched because the joined object is stale or missing
 }
 
 func (m *Metadata) Reset() {
 	m.BytesRx = 0
 	m.NumCalls = 0
 	m.PluginInstances = 0
+	m.JoinHits = 0
+	m.JoinMisses = 0
+	m.JoinStale = 0
 }
@@ -43,6 +43,7 @@ var IsCollector = map[string]struct{}{
 	"RestPerf":    {},
 	"KeyPerf":     {},
 	"Ems":         {},
+	"ESeries":     {},
 	"StorageGrid": {},
 	"Unix":        {},
 	"Simple":      {},